@@ -0,0 +1,145 @@
+package dmg
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/ironpark/zapp/pkg/mactools/dmg"
+	"github.com/ironpark/zapp/pkg/mactools/hdiutil"
+	"github.com/samber/lo"
+	"github.com/urfave/cli/v2"
+)
+
+// applyFlags merges the flags into a config loaded from a file. Flags given
+// on the command line always win; the others only fill in values the file
+// left empty, so their defaults still apply.
+func applyFlags(c *cli.Context, config *dmg.Config) error {
+	use := func(name string, empty bool) bool {
+		return c.IsSet(name) || empty
+	}
+	if use("out", config.FileName == "") {
+		config.FileName = out
+	}
+	if use("title", config.Title == "") {
+		config.Title = title
+	}
+	if use("icon", config.Icon == "") {
+		config.Icon = icon
+	}
+	if use("background", config.Background == "") {
+		config.Background = background
+	}
+	if c.IsSet("background") {
+		// An explicit background replaces the one of the config file.
+		config.BackgroundColor = ""
+		config.Background2x = ""
+		config.GeneratedBackground = nil
+	}
+	if c.IsSet("background-2x") {
+		config.Background2x = c.String("background-2x")
+	}
+	if use("window-width", config.WindowWidth == 0) {
+		config.WindowWidth = windowWidth
+	}
+	if use("window-height", config.WindowHeight == 0) {
+		config.WindowHeight = windowHeight
+	}
+	if use("label-size", config.LabelSize == 0) {
+		config.LabelSize = labelSize
+	}
+	if use("contents-icon-size", config.ContentsIconSize == 0) {
+		config.ContentsIconSize = contentsIconSize
+	}
+	if use("format", config.Format == "") {
+		config.Format = hdiutil.Format(dmgFormat)
+	}
+	if use("compression-level", config.CompressionLevel == "") {
+		config.CompressionLevel = compressionLevel
+	}
+	if use("chunk-size", config.ChunkSize == 0) {
+		config.ChunkSize = chunkSize
+	}
+	if use("compression-threads", config.CompressionThreads == 0) {
+		config.CompressionThreads = compressionThreads
+	}
+	if use("window-x", config.WindowX == 0) {
+		config.WindowX = windowX
+	}
+	if use("window-y", config.WindowY == 0) {
+		config.WindowY = windowY
+	}
+	if use("sidebar-width", config.SidebarWidth == 0) {
+		config.SidebarWidth = sidebarWidth
+	}
+	if use("grid-spacing", config.GridSpacing == 0) {
+		config.GridSpacing = gridSpacing
+	}
+	if use("grid-offset-x", config.GridOffsetX == 0) {
+		config.GridOffsetX = gridOffsetX
+	}
+	if use("grid-offset-y", config.GridOffsetY == 0) {
+		config.GridOffsetY = gridOffsetY
+	}
+	if use("arrange-by", config.ArrangeBy == "") {
+		config.ArrangeBy = arrangeBy
+	}
+	if use("label-position", config.LabelPosition == "") {
+		config.LabelPosition = labelPosition
+	}
+	for name, option := range map[string]**bool{
+		"show-toolbar":      &config.ShowToolbar,
+		"show-sidebar":      &config.ShowSidebar,
+		"show-pathbar":      &config.ShowPathbar,
+		"show-statusbar":    &config.ShowStatusBar,
+		"show-item-info":    &config.ShowItemInfo,
+		"show-icon-preview": &config.ShowIconPreview,
+	} {
+		if c.IsSet(name) {
+			*option = lo.ToPtr(c.Bool(name))
+		}
+	}
+	if c.IsSet("use-hard-links") {
+		config.UseHardLinks = useHardLinks
+	}
+	if c.IsSet("optimize-app-size") {
+		config.OptimizeAppSize = optimizeAppSize
+	}
+	if c.IsSet("alias-links") {
+		config.AliasLinks = aliasLinks
+	}
+	if c.IsSet("reproducible") {
+		config.Reproducible = reproducible
+	}
+	if c.IsSet("license") {
+		config.Licenses = nil
+		for _, arg := range c.StringSlice("license") {
+			license, err := dmg.ParseLicense(arg)
+			if err != nil {
+				return err
+			}
+			config.Licenses = append(config.Licenses, license)
+		}
+	}
+
+	// Any of the background generator flags turns the generator on.
+	if c.IsSet("background-gradient") || c.IsSet("background-arrow") || c.IsSet("background-caption") {
+		if config.GeneratedBackground == nil {
+			config.GeneratedBackground = &dmg.GeneratedBackground{}
+		}
+		generated := config.GeneratedBackground
+		if c.IsSet("background-gradient") {
+			top, bottom, ok := strings.Cut(c.String("background-gradient"), ",")
+			if !ok {
+				return fmt.Errorf("invalid background-gradient %q, expected \"#top,#bottom\"", c.String("background-gradient"))
+			}
+			generated.Top, generated.Bottom = strings.TrimSpace(top), strings.TrimSpace(bottom)
+		}
+		if c.IsSet("background-arrow") {
+			generated.Arrow = c.Bool("background-arrow")
+		}
+		if c.IsSet("background-caption") {
+			generated.Caption = c.String("background-caption")
+		}
+	}
+	return nil
+}
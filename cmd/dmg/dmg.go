@@ -4,14 +4,16 @@ import (
 	"fmt"
 	"github.com/ironpark/zapp/cmd"
 	"github.com/ironpark/zapp/pkg/mactools/dmg"
+	"github.com/ironpark/zapp/pkg/mactools/dmg/udif"
 	"os"
 	"path/filepath"
+	"runtime"
+	"slices"
 	"strings"
 
 	_ "embed"
 
 	"github.com/urfave/cli/v2"
-	"github.com/ironpark/zapp/pkg/mactools/hdiutil"
 )
 
 //go:embed iconfile.icns
@@ -25,14 +27,28 @@ var (
 	icon                      string
 	background                string
 	windowWidth, windowHeight int
+	windowX, windowY          int
+	sidebarWidth              int
+	gridSpacing               float64
+	gridOffsetX, gridOffsetY  float64
+	arrangeBy                 string
+	labelPosition             string
 	labelSize                 int
 	contentsIconSize          int
 	dmgFormat                 string
 	compressionLevel          string
+	chunkSize                 int
+	compressionThreads        int
 	useHardLinks              bool
 	optimizeAppSize           bool
+	aliasLinks                bool
 	useDirectMethod           bool
 	useLegacyMethod           bool
+	useNativeMethod           bool
+	reproducible              bool
+	layoutFrom                string
+	configFile                string
+	layoutRename              cli.StringSlice
 )
 
 var Command = &cli.Command{
@@ -42,7 +58,41 @@ var Command = &cli.Command{
 	Description: "",
 	Args:        true,
 	ArgsUsage:   " <path of app-bundle>",
+	Subcommands: []*cli.Command{
+		lsCommand,
+		extractCommand,
+		infoCommand,
+		verifyCommand,
+	},
 	Action: func(c *cli.Context) error {
+		var config dmg.Config
+		if configFile != "" {
+			var err error
+			if config, err = dmg.LoadConfig(configFile); err != nil {
+				return err
+			}
+		}
+		// The app flag replaces the app bundle listed in the config file.
+		if appItem := config.AppItem(); appItem != nil {
+			if appDir == "" {
+				appDir = appItem.Path
+			} else {
+				appItem.Path = appDir
+			}
+		}
+		if appDir == "" {
+			return fmt.Errorf("required flag \"app\" not set")
+		}
+		if err := applyFlags(c, &config); err != nil {
+			return err
+		}
+		// The native builder only writes the formats it can compress; reject
+		// the others before any work is done.
+		native := useNativeMethod || config.Reproducible || runtime.GOOS != "darwin"
+		if _, err := udif.ChunkTypeFor(config.Format); native && err != nil {
+			return fmt.Errorf("format %s is not supported by the native method, expected UDRO, UDZO, UDBZ, ULFO or ULMO", config.Format)
+		}
+
 		logger := cmd.NewAppLogger(c.App)
 		// Create a temporary working directory
 		tempDir, err := os.MkdirTemp("", "*-zapp-dmg")
@@ -53,7 +103,7 @@ var Command = &cli.Command{
 
 		logger.Printf("Start Creating DMG file for %s\n", filepath.Base(appDir))
 
-		if icon == "" {
+		if config.Icon == "" {
 			logger.Println("Icon file not provided")
 			logger.Println("Create dmg disk file icon using app icon")
 			tempDirForIcon, err := os.MkdirTemp("", "*-zapp-dmg-icon")
@@ -61,72 +111,98 @@ var Command = &cli.Command{
 				return fmt.Errorf("error creating temporary directory: %v", err)
 			}
 			defer os.RemoveAll(tempDir)
-			icon = filepath.Join(tempDirForIcon, "icon.icns")
-			err = createIconSet(appDir, icon, !c.Bool("use-original-icon"))
+			config.Icon = filepath.Join(tempDirForIcon, "icon.icns")
+			err = createIconSet(appDir, config.Icon, !c.Bool("use-original-icon"))
 			if err != nil {
 				return err
 			}
+		} else if filepath.Ext(config.Icon) != ".icns" {
+			// The volume icon must be an icns file; convert other images
+			// before anything is built.
+			logger.Println("Convert dmg disk file icon to icns")
+			iconFile := filepath.Join(tempDir, "icon.icns")
+			if err := createIconSet(config.Icon, iconFile, false); err != nil {
+				return err
+			}
+			config.Icon = iconFile
 		}
-		if out == "" {
-			out = filepath.Base(appDir)
-			out = strings.TrimSuffix(out, filepath.Ext(out))
-			out = out + ".dmg"
+		if config.FileName == "" {
+			config.FileName = filepath.Base(appDir)
+			config.FileName = strings.TrimSuffix(config.FileName, filepath.Ext(config.FileName))
+			config.FileName = config.FileName + ".dmg"
 		}
-		if title == "" {
-			title = filepath.Base(appDir)
-			title = strings.TrimSuffix(title, filepath.Ext(title))
+		if config.Title == "" {
+			config.Title = filepath.Base(appDir)
+			config.Title = strings.TrimSuffix(config.Title, filepath.Ext(config.Title))
 		}
 
-		centerY := int(float64(windowHeight)/2-float64(contentsIconSize)/2) + labelSize
-		defaultConfig := dmg.Config{
-			FileName:         out,
-			Title:            title,
-			Icon:             icon,
-			LabelSize:        labelSize,
-			ContentsIconSize: contentsIconSize,
-			WindowWidth:      windowWidth,
-			WindowHeight:     windowHeight,
-			Background:       background,
-			Format:           hdiutil.Format(dmgFormat),
-			CompressionLevel: compressionLevel,
-			UseHardLinks:     useHardLinks,
-			OptimizeAppSize:  optimizeAppSize,
-			Contents: []dmg.Item{
-				{X: int(float64(windowWidth)/3*1 - float64(contentsIconSize)/2), Y: centerY, Type: dmg.Dir, Path: appDir},
-				{X: int(float64(windowWidth)/3*2 + float64(contentsIconSize)/2), Y: centerY, Type: dmg.Link, Path: "/Applications"},
-			},
+		if len(config.Contents) == 0 {
+			centerY := int(float64(config.WindowHeight)/2-float64(config.ContentsIconSize)/2) + config.LabelSize
+			config.Contents = []dmg.Item{
+				{X: int(float64(config.WindowWidth)/3*1 - float64(config.ContentsIconSize)/2), Y: centerY, Type: dmg.Dir, Path: appDir},
+				{X: int(float64(config.WindowWidth)/3*2 + float64(config.ContentsIconSize)/2), Y: centerY, Type: dmg.Link, Path: "/Applications"},
+			}
+		}
+		if err := dmg.PrepareBackground(&config, tempDir); err != nil {
+			return err
+		}
+		if layoutFrom != "" {
+			config.Layout, err = dmg.LoadLayout(layoutFrom, tempDir)
+			if err != nil {
+				return fmt.Errorf("failed to load layout: %w", err)
+			}
+			config.Layout.Rename = map[string]string{}
+			for _, rename := range layoutRename.Value() {
+				from, to, ok := strings.Cut(rename, "=")
+				if !ok {
+					return fmt.Errorf("invalid layout rename %q, expected old=new", rename)
+				}
+				config.Layout.Rename[from] = to
+			}
+			if config.Background == "" && config.BackgroundColor == "" {
+				config.Background = config.Layout.Background
+			}
 		}
-		logger.PrintValue("Title", title)
-		logger.PrintValue("Icon", icon)
-		logger.PrintValue("labelSize", labelSize)
+
+		logger.PrintValue("Title", config.Title)
+		logger.PrintValue("Icon", config.Icon)
+		logger.PrintValue("labelSize", config.LabelSize)
 		logger.PrintValue("AppPath", appDir)
-		logger.PrintValue("OutputPath", out)
-		logger.PrintValue("ContentsIconSize", contentsIconSize)
-		logger.PrintValue("WindowWidth", windowWidth)
-		logger.PrintValue("WindowHeight", windowHeight)
-		logger.PrintValue("Background", background)
-		logger.PrintValue("DMG Format", dmgFormat)
-		logger.PrintValue("Compression Level", compressionLevel)
-		logger.PrintValue("Use Hard Links", useHardLinks)
+		logger.PrintValue("OutputPath", config.FileName)
+		logger.PrintValue("ContentsIconSize", config.ContentsIconSize)
+		logger.PrintValue("WindowWidth", config.WindowWidth)
+		logger.PrintValue("WindowHeight", config.WindowHeight)
+		logger.PrintValue("Background", config.Background)
+		logger.PrintValue("BackgroundColor", config.BackgroundColor)
+		logger.PrintValue("Config", configFile)
+		logger.PrintValue("Layout", layoutFrom)
+		logger.PrintValue("DMG Format", config.Format)
+		logger.PrintValue("Compression Level", config.CompressionLevel)
+		logger.PrintValue("Use Hard Links", config.UseHardLinks)
+		for _, item := range config.Contents {
+			logger.PrintValue("Item", fmt.Sprintf("%s %s (%d, %d)", item.Type, item.Path, item.X, item.Y))
+		}
 		logger.Println("Creating optimized DMG file...")
 		var createErr error
-		if useLegacyMethod {
-			createErr = dmg.CreateDMG(defaultConfig, tempDir)
+		if native {
+			createErr = dmg.CreateDMGNative(config)
+		} else if useLegacyMethod {
+			createErr = dmg.CreateDMG(config, tempDir)
 		} else if useDirectMethod {
-			createErr = dmg.CreateDMGDirect(defaultConfig)
+			createErr = dmg.CreateDMGDirect(config)
 		} else {
-			createErr = dmg.CreateDMGOptimal(defaultConfig)
+			createErr = dmg.CreateDMGOptimal(config)
 		}
 		if createErr != nil {
 			return createErr
 		}
 		logger.Success("DMG file created successfully!")
-		err = cmd.RunSignCmd(c, out)
+		err = cmd.RunSignCmd(c, config.FileName)
 		if err != nil {
 			return fmt.Errorf("failed to sign PKG: %v", err)
 		}
 
-		err = cmd.RunNotarizeCmd(c, out)
+		err = cmd.RunNotarizeCmd(c, config.FileName)
 		if err != nil {
 			return fmt.Errorf("failed to notarize PKG: %v", err)
 		}
@@ -135,10 +211,41 @@ var Command = &cli.Command{
 	Flags: append([]cli.Flag{
 		&cli.StringFlag{
 			Name:        "background",
-			Usage:       "Path to the background image file",
+			Usage:       "Path to the background image file, or a solid color such as \"#f0f0f0\"",
 			Aliases:     []string{"bg"},
 			Destination: &background,
 		},
+		&cli.StringFlag{
+			Name:  "background-2x",
+			Usage: "Path to the Retina background image, twice the window size (default: <background>@2x.png when present)",
+		},
+		&cli.StringFlag{
+			Name:  "background-gradient",
+			Usage: "Generate a background with a vertical gradient between two colors (\"#top,#bottom\")",
+		},
+		&cli.BoolFlag{
+			Name:  "background-arrow",
+			Usage: "Draw an arrow from the app to Applications on a generated background",
+		},
+		&cli.StringFlag{
+			Name:  "background-caption",
+			Usage: "Caption text drawn below the icons on a generated background",
+		},
+		&cli.StringFlag{
+			Name:        "config",
+			Usage:       "Path to a JSON or YAML file describing the DMG (flags override its values)",
+			Destination: &configFile,
+		},
+		&cli.StringFlag{
+			Name:        "layout-from",
+			Usage:       "Copy the Finder window layout from an existing .dmg or .DS_Store file (overrides window, icon and label sizes)",
+			Destination: &layoutFrom,
+		},
+		&cli.StringSliceFlag{
+			Name:        "layout-rename",
+			Usage:       "Map an item name of the --layout-from reference to a new name (old=new)",
+			Destination: &layoutRename,
+		},
 		&cli.StringFlag{
 			Name:        "title",
 			Usage:       "The title displayed when the DMG file is mounted",
@@ -149,7 +256,6 @@ var Command = &cli.Command{
 			Name:        "app",
 			Usage:       "App bundle path",
 			Destination: &appDir,
-			Required:    true,
 			Action: func(c *cli.Context, app string) error {
 				if !strings.HasSuffix(app, ".app") {
 					return fmt.Errorf("not valid app bundle extension")
@@ -190,6 +296,84 @@ var Command = &cli.Command{
 			Destination: &windowHeight,
 			Value:       480,
 		},
+		&cli.IntFlag{
+			Name:        "window-x",
+			Usage:       "Horizontal position of the Finder window on screen",
+			Aliases:     []string{"wx"},
+			Destination: &windowX,
+		},
+		&cli.IntFlag{
+			Name:        "window-y",
+			Usage:       "Vertical position of the Finder window on screen",
+			Aliases:     []string{"wy"},
+			Destination: &windowY,
+		},
+		&cli.BoolFlag{
+			Name:  "show-toolbar",
+			Usage: "Show the toolbar of the Finder window",
+		},
+		&cli.BoolFlag{
+			Name:  "show-sidebar",
+			Usage: "Show the sidebar of the Finder window (default: true)",
+		},
+		&cli.BoolFlag{
+			Name:  "show-pathbar",
+			Usage: "Show the path bar of the Finder window",
+		},
+		&cli.BoolFlag{
+			Name:  "show-statusbar",
+			Usage: "Show the status bar of the Finder window",
+		},
+		&cli.IntFlag{
+			Name:        "sidebar-width",
+			Usage:       "Width of the sidebar of the Finder window",
+			Destination: &sidebarWidth,
+		},
+		&cli.BoolFlag{
+			Name:  "show-item-info",
+			Usage: "Show item info below the icons (default: true)",
+		},
+		&cli.BoolFlag{
+			Name:  "show-icon-preview",
+			Usage: "Show file contents as icon previews (default: true)",
+		},
+		&cli.Float64Flag{
+			Name:        "grid-spacing",
+			Usage:       "Spacing of the icon grid",
+			Destination: &gridSpacing,
+		},
+		&cli.Float64Flag{
+			Name:        "grid-offset-x",
+			Usage:       "Horizontal offset of the icon grid",
+			Destination: &gridOffsetX,
+		},
+		&cli.Float64Flag{
+			Name:        "grid-offset-y",
+			Usage:       "Vertical offset of the icon grid",
+			Destination: &gridOffsetY,
+		},
+		&cli.StringFlag{
+			Name:        "arrange-by",
+			Usage:       "Icon arrangement (" + strings.Join(dmg.ArrangeByValues, ", ") + ")",
+			Destination: &arrangeBy,
+			Action: func(c *cli.Context, value string) error {
+				if !slices.Contains(dmg.ArrangeByValues, value) {
+					return fmt.Errorf("invalid arrange-by: %s. Valid values: %s", value, strings.Join(dmg.ArrangeByValues, ", "))
+				}
+				return nil
+			},
+		},
+		&cli.StringFlag{
+			Name:        "label-position",
+			Usage:       "Position of the icon labels (bottom, right)",
+			Destination: &labelPosition,
+			Action: func(c *cli.Context, value string) error {
+				if value != dmg.LabelBottom && value != dmg.LabelRight {
+					return fmt.Errorf("invalid label-position: %s. Valid values: bottom, right", value)
+				}
+				return nil
+			},
+		},
 		&cli.IntFlag{
 			Name:        "label-size",
 			Usage:       "Size of the label text in the Finder window (10-16)",
@@ -223,7 +407,7 @@ var Command = &cli.Command{
 		},
 		&cli.StringFlag{
 			Name:        "format",
-			Usage:       "DMG format (UDRO, UDRW, UDZO, UDBZ) - UDZO recommended for best compression",
+			Usage:       "DMG format (UDRO, UDZO, UDBZ, ULFO, ULMO, or UDRW with hdiutil on macOS) - UDZO recommended for compatibility, ULMO for the smallest images",
 			Aliases:     []string{"f"},
 			Destination: &dmgFormat,
 			Value:       "UDZO",
@@ -233,16 +417,18 @@ var Command = &cli.Command{
 					"UDRW": true,
 					"UDZO": true,
 					"UDBZ": true,
+					"ULFO": true,
+					"ULMO": true,
 				}
 				if !validFormats[format] {
-					return fmt.Errorf("invalid format: %s. Valid formats: UDRO, UDRW, UDZO, UDBZ", format)
+					return fmt.Errorf("invalid format: %s. Valid formats: UDRO, UDRW, UDZO, UDBZ, ULFO, ULMO", format)
 				}
 				return nil
 			},
 		},
 		&cli.StringFlag{
 			Name:        "compression-level",
-			Usage:       "Compression level for UDZO format (1-9, where 9 is best compression)",
+			Usage:       "Compression level for UDZO, UDBZ and ULMO formats (1-9, where 9 is best compression)",
 			Aliases:     []string{"cl"},
 			Destination: &compressionLevel,
 			Value:       "9",
@@ -259,6 +445,18 @@ var Command = &cli.Command{
 				return nil
 			},
 		},
+		&cli.IntFlag{
+			Name:        "chunk-size",
+			Usage:       "Uncompressed size of the image chunks in KiB, compressed independently (native method only)",
+			Destination: &chunkSize,
+			Value:       1024,
+		},
+		&cli.IntFlag{
+			Name:        "compression-threads",
+			Usage:       "Number of chunks compressed in parallel, 0 for every CPU core (native method only)",
+			Destination: &compressionThreads,
+			Value:       0,
+		},
 		&cli.BoolFlag{
 			Name:        "use-hard-links",
 			Usage:       "Use hard links instead of copying files (reduces temporary disk usage, now safe for Gatekeeper)",
@@ -273,6 +471,17 @@ var Command = &cli.Command{
 			Destination: &optimizeAppSize,
 			Value:       false,
 		},
+		&cli.StringSliceFlag{
+			Name:    "license",
+			Usage:   "License agreement shown when the DMG is opened (format: lang:path, e.g., en:en_eula.txt,ko:ko_eula.txt)",
+			Aliases: []string{"eula"},
+		},
+		&cli.BoolFlag{
+			Name:        "alias-links",
+			Usage:       "Create the Applications shortcut and other links as Finder alias files instead of symbolic links",
+			Destination: &aliasLinks,
+			Value:       false,
+		},
 		&cli.BoolFlag{
 			Name:        "use-direct-method",
 			Usage:       "Use direct method for creating DMG (faster, but less reliable)",
@@ -287,6 +496,19 @@ var Command = &cli.Command{
 			Destination: &useLegacyMethod,
 			Value:       false,
 		},
+		&cli.BoolFlag{
+			Name:        "use-native-method",
+			Usage:       "Build the DMG in Go without hdiutil (always used on non-macOS systems)",
+			Aliases:     []string{"unm"},
+			Destination: &useNativeMethod,
+			Value:       false,
+		},
+		&cli.BoolFlag{
+			Name:        "reproducible",
+			Usage:       "Build the same bytes from the same inputs, dating everything SOURCE_DATE_EPOCH (implies --use-native-method)",
+			Destination: &reproducible,
+			Value:       false,
+		},
 	}, cmd.CreateSubTaskFlags()...),
 	HelpName:           "",
 	CustomHelpTemplate: "",
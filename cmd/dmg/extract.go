@@ -0,0 +1,57 @@
+package dmg
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/ironpark/zapp/cmd"
+	"github.com/urfave/cli/v2"
+)
+
+var extractCommand = &cli.Command{
+	Name:      "extract",
+	Usage:     "Extract files from a DMG without mounting it",
+	ArgsUsage: "<path of dmg> [path inside dmg...]",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:    "out",
+			Usage:   "Output directory (default: DMG file name without extension)",
+			Aliases: []string{"o"},
+		},
+	},
+	Action: func(c *cli.Context) error {
+		if c.NArg() < 1 {
+			return fmt.Errorf("dmg path is required")
+		}
+		logger := cmd.NewAppLogger(c.App)
+		dmgPath := c.Args().First()
+		outDir := c.String("out")
+		if outDir == "" {
+			outDir = strings.TrimSuffix(filepath.Base(dmgPath), filepath.Ext(dmgPath))
+		}
+		img, vol, err := openVolume(dmgPath)
+		if err != nil {
+			return err
+		}
+		defer img.Close()
+
+		paths := c.Args().Tail()
+		if len(paths) == 0 {
+			paths = []string{""}
+		}
+		logger.Printf("Extracting %s\n", filepath.Base(dmgPath))
+		logger.PrintValue("Volume", vol.VolumeName())
+		logger.PrintValue("OutputPath", outDir)
+		total := 0
+		for _, p := range paths {
+			n, err := vol.Extract(p, outDir)
+			total += n
+			if err != nil {
+				return err
+			}
+		}
+		logger.Success("Extracted %d entries", total)
+		return nil
+	},
+}
@@ -0,0 +1,99 @@
+package dmg
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/ironpark/zapp/pkg/mactools/dmg/udif"
+	"github.com/urfave/cli/v2"
+)
+
+var infoCommand = &cli.Command{
+	Name:      "info",
+	Usage:     "Print the format, sizes, partitions and checksums of a DMG",
+	ArgsUsage: "<path of dmg>",
+	Action: func(c *cli.Context) error {
+		if c.NArg() < 1 {
+			return fmt.Errorf("dmg path is required")
+		}
+		img, err := udif.Open(c.Args().First())
+		if err != nil {
+			return fmt.Errorf("failed to open DMG: %w", err)
+		}
+		defer img.Close()
+		printInfo(c.App.Writer, img)
+		return nil
+	},
+}
+
+func printInfo(w io.Writer, img *udif.Image) {
+	k := img.Koly
+	format := string(img.Format())
+	if format == "" {
+		format = "unknown"
+	}
+	var size, compressed int64
+	for i := range img.Partitions {
+		size += img.Partitions[i].Size()
+		compressed += img.Partitions[i].CompressedSize()
+	}
+	id := k.SegmentID
+	fmt.Fprintf(w, "Format:            %s\n", format)
+	fmt.Fprintf(w, "Version:           %d, flags 0x%08x, variant %d\n", k.Version, k.Flags, k.ImageVariant)
+	fmt.Fprintf(w, "Segment:           %d of %d, ID %x-%x-%x-%x-%x\n", k.SegmentNumber, k.SegmentCount, id[0:4], id[4:6], id[6:8], id[8:10], id[10:16])
+	fmt.Fprintf(w, "Sectors:           %d\n", k.SectorCount)
+	fmt.Fprintf(w, "Uncompressed size: %d bytes\n", size)
+	fmt.Fprintf(w, "Compressed size:   %d bytes (%s)\n", compressed, ratio(compressed, size))
+	fmt.Fprintf(w, "Data fork:         %d bytes at %d\n", k.DataForkLength, k.DataForkOffset)
+	fmt.Fprintf(w, "Resource plist:    %d bytes at %d\n", k.XMLLength, k.XMLOffset)
+	fmt.Fprintf(w, "Data checksum:     %s\n", formatChecksum(k.DataChecksum))
+	fmt.Fprintf(w, "Master checksum:   %s\n", formatChecksum(k.MasterChecksum))
+	fmt.Fprintf(w, "Partitions:\n")
+	for i := range img.Partitions {
+		p := &img.Partitions[i]
+		fmt.Fprintf(w, "  %3d %q\n", p.ID, p.Name)
+		fmt.Fprintf(w, "      sectors %d-%d, %d bytes, %d compressed (%s)\n", p.Table.SectorNumber, p.Table.SectorNumber+p.Table.SectorCount, p.Size(), p.CompressedSize(), ratio(p.CompressedSize(), p.Size()))
+		fmt.Fprintf(w, "      chunks %s, checksum %s\n", chunkSummary(p.Table.Chunks), formatChecksum(p.Table.Checksum))
+	}
+}
+
+// chunkSummary counts the chunks of each type, in order of appearance.
+func chunkSummary(chunks []udif.Chunk) string {
+	var types []udif.ChunkType
+	counts := map[udif.ChunkType]int{}
+	for _, c := range chunks {
+		if c.Type == udif.ChunkTerminator || c.Type == udif.ChunkComment {
+			continue
+		}
+		if counts[c.Type] == 0 {
+			types = append(types, c.Type)
+		}
+		counts[c.Type]++
+	}
+	parts := make([]string, len(types))
+	for i, t := range types {
+		parts[i] = fmt.Sprintf("%d %s", counts[t], t)
+	}
+	if len(parts) == 0 {
+		return "none"
+	}
+	return strings.Join(parts, ", ")
+}
+
+func ratio(compressed, size int64) string {
+	if size == 0 {
+		return "-"
+	}
+	return fmt.Sprintf("%.1f%%", float64(compressed)*100/float64(size))
+}
+
+func formatChecksum(c udif.Checksum) string {
+	switch c.Type {
+	case 0:
+		return "none"
+	case udif.ChecksumCRC32:
+		return fmt.Sprintf("CRC32 %08x", c.Data[0])
+	}
+	return fmt.Sprintf("type %d", c.Type)
+}
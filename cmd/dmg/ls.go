@@ -0,0 +1,44 @@
+package dmg
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/ironpark/zapp/pkg/mactools/hfsplus"
+	"github.com/urfave/cli/v2"
+)
+
+var lsCommand = &cli.Command{
+	Name:      "ls",
+	Usage:     "List the files inside a DMG without mounting it",
+	ArgsUsage: "<path of dmg> [path inside dmg]",
+	Action: func(c *cli.Context) error {
+		if c.NArg() < 1 {
+			return fmt.Errorf("dmg path is required")
+		}
+		img, vol, err := openVolume(c.Args().First())
+		if err != nil {
+			return err
+		}
+		defer img.Close()
+
+		return vol.Walk(c.Args().Get(1), func(p string, e *hfsplus.Entry) error {
+			if p == "" {
+				return nil
+			}
+			suffix := ""
+			switch {
+			case e.IsDir():
+				suffix = "/"
+			case e.Mode&os.ModeSymlink != 0:
+				target, err := vol.ReadLink(e)
+				if err != nil {
+					return err
+				}
+				suffix = " -> " + target
+			}
+			fmt.Fprintf(c.App.Writer, "%s %12d %s %s%s\n", e.Mode, e.Size, e.ModTime.Format("2006-01-02 15:04"), p, suffix)
+			return nil
+		})
+	},
+}
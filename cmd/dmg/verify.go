@@ -0,0 +1,44 @@
+package dmg
+
+import (
+	"fmt"
+
+	"github.com/ironpark/zapp/pkg/mactools/dmg/udif"
+	"github.com/urfave/cli/v2"
+)
+
+var verifyCommand = &cli.Command{
+	Name:      "verify",
+	Usage:     "Verify the checksums of a DMG without mounting it",
+	ArgsUsage: "<path of dmg>",
+	Action: func(c *cli.Context) error {
+		if c.NArg() < 1 {
+			return fmt.Errorf("dmg path is required")
+		}
+		img, err := udif.Open(c.Args().First())
+		if err != nil {
+			return fmt.Errorf("failed to open DMG: %w", err)
+		}
+		defer img.Close()
+		checks, err := img.Verify()
+		if err != nil {
+			return err
+		}
+		failed := 0
+		for _, check := range checks {
+			switch {
+			case check.Skipped:
+				fmt.Fprintf(c.App.Writer, "SKIP %s checksum: type %d is not supported\n", check.Name, check.Type)
+			case check.OK():
+				fmt.Fprintf(c.App.Writer, "OK   %s checksum: CRC32 %08x\n", check.Name, check.Stored)
+			default:
+				failed++
+				fmt.Fprintf(c.App.Writer, "FAIL %s checksum: CRC32 %08x, computed %08x\n", check.Name, check.Stored, check.Computed)
+			}
+		}
+		if failed > 0 {
+			return fmt.Errorf("%d of %d checksums do not match", failed, len(checks))
+		}
+		return nil
+	},
+}
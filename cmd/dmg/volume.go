@@ -0,0 +1,28 @@
+package dmg
+
+import (
+	"fmt"
+
+	"github.com/ironpark/zapp/pkg/mactools/dmg/udif"
+	"github.com/ironpark/zapp/pkg/mactools/hfsplus"
+)
+
+// openVolume opens the HFS+ volume inside a DMG without mounting it.
+// The returned image must be closed by the caller.
+func openVolume(dmgPath string) (*udif.Image, *hfsplus.Volume, error) {
+	img, err := udif.Open(dmgPath)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open DMG: %w", err)
+	}
+	part, err := img.FilesystemPartition()
+	if err != nil {
+		img.Close()
+		return nil, nil, err
+	}
+	vol, err := hfsplus.Open(img.PartitionReader(part))
+	if err != nil {
+		img.Close()
+		return nil, nil, fmt.Errorf("failed to read volume in partition %q: %w", part.Name, err)
+	}
+	return img, vol, nil
+}
@@ -0,0 +1,67 @@
+package dsstore
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/ironpark/zapp/pkg/mactools/alias"
+	"github.com/ironpark/zapp/pkg/mactools/dsstore"
+	"github.com/ironpark/zapp/pkg/mactools/dsstore/entry"
+	"github.com/urfave/cli/v2"
+)
+
+var aliasCommand = &cli.Command{
+	Name:      "alias",
+	Usage:     "Print the background picture alias of a .DS_Store file",
+	ArgsUsage: "<path of .DS_Store file> or <path of directory>",
+	Action: func(c *cli.Context) error {
+		if c.NArg() < 1 {
+			return fmt.Errorf("path is required")
+		}
+		path, err := findDSStorePath(c.Args().First())
+		if err != nil {
+			return err
+		}
+		store, err := dsstore.Read(path)
+		if err != nil {
+			return err
+		}
+		found := false
+		for _, e := range store.Entries {
+			icvp, ok := e.(*entry.IconViewPreferencesEntry)
+			if !ok || icvp.BackgroundImageAlias == nil {
+				continue
+			}
+			info, err := alias.Decode(icvp.BackgroundImageAlias)
+			if err != nil {
+				return fmt.Errorf("failed to decode the background alias of %q: %w", e.Filename(), err)
+			}
+			found = true
+			printAlias(c.App.Writer, e.Filename(), info)
+		}
+		if !found {
+			return fmt.Errorf("no background picture alias in %s", path)
+		}
+		return nil
+	},
+}
+
+func printAlias(w io.Writer, filename string, info alias.Info) {
+	fmt.Fprintf(w, "Folder:          %q\n", filename)
+	fmt.Fprintf(w, "Target:          %s %q (ID %d, created %s)\n", info.Target.Type, info.Filename(), info.Target.ID, formatDate(info.Target.Created))
+	fmt.Fprintf(w, "Parent:          %q (ID %d)\n", info.Parent.Name, info.Parent.ID)
+	fmt.Fprintf(w, "Volume:          %q (%s, %s, created %s)\n", info.VolumeName(), info.Volume.Signature, info.Volume.Type, formatDate(info.Volume.Created))
+	fmt.Fprintf(w, "Path:            %q\n", info.Path())
+	fmt.Fprintf(w, "Volume path:     %q\n", info.VolumePath())
+	for _, e := range info.Extra {
+		fmt.Fprintf(w, "Extra %-3d        %d bytes % x\n", e.Type, e.Length, e.Data)
+	}
+}
+
+func formatDate(t time.Time) string {
+	if t.IsZero() {
+		return "unknown"
+	}
+	return t.Format(time.RFC3339)
+}
@@ -0,0 +1,35 @@
+package dsstore
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/urfave/cli/v2"
+)
+
+// findDSStorePath resolves a directory to the .DS_Store file inside it.
+func findDSStorePath(path string) (string, error) {
+	fileInfo, err := os.Stat(path)
+	if err != nil {
+		return "", fmt.Errorf("error accessing path: %w", err)
+	}
+	if fileInfo.IsDir() {
+		path = filepath.Join(path, ".DS_Store")
+		if _, err := os.Stat(path); err != nil {
+			return "", fmt.Errorf(".DS_Store not found: %w", err)
+		}
+	}
+	return path, nil
+}
+
+var Command = &cli.Command{
+	Name:        "dsstore",
+	Usage:       "Inspect .DS_Store files",
+	UsageText:   "zapp dsstore [command] [arguments...]",
+	Description: "Decode the Finder view settings stored in .DS_Store files",
+	Subcommands: []*cli.Command{
+		dumpCommand,
+		aliasCommand,
+	},
+}
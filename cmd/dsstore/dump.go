@@ -0,0 +1,51 @@
+package dsstore
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/ironpark/zapp/pkg/mactools/dsstore"
+	"github.com/ironpark/zapp/pkg/mactools/dsstore/entry"
+	"github.com/urfave/cli/v2"
+)
+
+type record struct {
+	Filename string      `json:"filename"`
+	Type     string      `json:"type"`
+	DataType string      `json:"dataType"`
+	Value    entry.Entry `json:"value"`
+}
+
+var dumpCommand = &cli.Command{
+	Name:      "dump",
+	Usage:     "Print the records of a .DS_Store file as JSON",
+	ArgsUsage: "<path of .DS_Store file> or <path of directory>",
+	Action: func(c *cli.Context) error {
+		if c.NArg() < 1 {
+			return fmt.Errorf("path is required")
+		}
+		path, err := findDSStorePath(c.Args().First())
+		if err != nil {
+			return err
+		}
+		store, err := dsstore.Read(path)
+		if err != nil {
+			return err
+		}
+		records := make([]record, len(store.Entries))
+		for i, e := range store.Entries {
+			records[i] = record{
+				Filename: e.Filename(),
+				Type:     e.EntryType(),
+				DataType: e.DataType(),
+				Value:    e,
+			}
+		}
+		out, err := json.MarshalIndent(records, "", "  ")
+		if err != nil {
+			return fmt.Errorf("failed to encode records: %w", err)
+		}
+		fmt.Fprintln(c.App.Writer, string(out))
+		return nil
+	},
+}
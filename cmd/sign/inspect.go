@@ -0,0 +1,130 @@
+package sign
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/ironpark/zapp/pkg/mactools/codesign"
+	"github.com/urfave/cli/v2"
+)
+
+var inspectCommand = &cli.Command{
+	Name:      "inspect",
+	Usage:     "Print the code signatures of a Mach-O binary or of every binary in a bundle",
+	ArgsUsage: "<path of binary or bundle>",
+	Flags: []cli.Flag{
+		&cli.BoolFlag{
+			Name:  "entitlements",
+			Usage: "Print the entitlements plist of each signature",
+		},
+	},
+	Action: func(c *cli.Context) error {
+		if c.NArg() < 1 {
+			return fmt.Errorf("binary or bundle path is required")
+		}
+		root := c.Args().First()
+		files, err := codesign.Inspect(root)
+		if err != nil {
+			return err
+		}
+		invalid, slices := 0, 0
+		for _, f := range files {
+			name := f.Path
+			if rel, err := filepath.Rel(root, f.Path); err == nil && rel != "." {
+				name = rel
+			}
+			for _, s := range f.Slices {
+				slices++
+				printSlice(c.App.Writer, name, s, c.Bool("entitlements"))
+				if s.VerifyErr != nil {
+					invalid++
+				}
+			}
+		}
+		if invalid > 0 {
+			return fmt.Errorf("%d of %d signatures do not match their code", invalid, slices)
+		}
+		if info, err := os.Stat(root); err == nil && info.IsDir() {
+			return verifyResources(c.App.Writer, root)
+		}
+		return nil
+	},
+}
+
+// verifyResources prints the files of a bundle that changed since its
+// resources were sealed.
+func verifyResources(w io.Writer, bundle string) error {
+	changes, err := codesign.VerifyResources(bundle)
+	if errors.Is(err, fs.ErrNotExist) {
+		fmt.Fprintf(w, "Resources: not sealed\n")
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if len(changes) == 0 {
+		fmt.Fprintf(w, "Resources: valid\n")
+		return nil
+	}
+	fmt.Fprintf(w, "Resources: INVALID\n")
+	for _, change := range changes {
+		fmt.Fprintf(w, "  %s\n", change)
+	}
+	return fmt.Errorf("%d files changed since the resources were sealed", len(changes))
+}
+
+func printSlice(w io.Writer, name string, s codesign.Slice, entitlements bool) {
+	fmt.Fprintf(w, "%s (%s)\n", name, s.Arch)
+	sig := s.Signature
+	if sig == nil {
+		fmt.Fprintf(w, "  not signed\n")
+		return
+	}
+	cd := sig.CodeDirectories[0]
+	team := cd.TeamID
+	if team == "" {
+		team = "not set"
+	}
+	fmt.Fprintf(w, "  Identifier:    %s\n", cd.Identifier)
+	fmt.Fprintf(w, "  Team ID:       %s\n", team)
+	fmt.Fprintf(w, "  Format:        v=%x flags=%s hashes=%d+%d page size %d\n", cd.Version, codesign.FlagString(cd.Flags), len(cd.SpecialSlots), len(cd.CodeSlots), cd.PageSize)
+	for _, cd := range sig.CodeDirectories {
+		fmt.Fprintf(w, "  CDHash:        %s %s\n", cd.HashType, hex.EncodeToString(cd.CDHash()))
+	}
+	if cd.Runtime != 0 {
+		fmt.Fprintf(w, "  Runtime:       %d.%d.%d\n", cd.Runtime>>16, cd.Runtime>>8&0xff, cd.Runtime&0xff)
+	}
+	if cms := sig.CMS; cms != nil {
+		for _, cert := range cms.Certificates {
+			fmt.Fprintf(w, "  Authority:     %s\n", cert.Subject.CommonName)
+		}
+		if !cms.SigningTime.IsZero() {
+			fmt.Fprintf(w, "  Signed:        %s\n", cms.SigningTime.Local().Format(time.RFC1123))
+		}
+		if !cms.Timestamp.IsZero() {
+			fmt.Fprintf(w, "  Timestamp:     %s\n", cms.Timestamp.Local().Format(time.RFC1123))
+		}
+	} else {
+		fmt.Fprintf(w, "  Signature:     adhoc\n")
+	}
+	for _, r := range sig.Requirements {
+		fmt.Fprintf(w, "  Requirement:   %s\n", r)
+	}
+	if sig.Entitlements != nil || sig.EntitlementsDER != nil {
+		fmt.Fprintf(w, "  Entitlements:  %d bytes XML, %d bytes DER\n", len(sig.Entitlements), len(sig.EntitlementsDER))
+		if entitlements && sig.Entitlements != nil {
+			fmt.Fprintf(w, "%s\n", sig.Entitlements)
+		}
+	}
+	if s.VerifyErr != nil {
+		fmt.Fprintf(w, "  Code:          INVALID: %v\n", s.VerifyErr)
+	} else {
+		fmt.Fprintf(w, "  Code:          valid\n")
+	}
+}
@@ -1,8 +1,10 @@
 package sign
 
 import (
+	"encoding/base64"
 	"fmt"
 	"github.com/ironpark/zapp/cmd"
+	"io"
 	"os"
 	"os/exec"
 	"path/filepath"
@@ -14,8 +16,16 @@ import (
 )
 
 var (
-	identity string
-	target   string
+	identity     string
+	target       string
+	adhoc        bool
+	entitlements string
+	p12Path      string
+	p12Base64    string
+	p12Password  string
+	timestampURL string
+	signConfig   string
+	dryRun       bool
 )
 
 func getIdentity(c *cli.Context, prioritys ...string) (security.Identity, error) {
@@ -44,11 +54,41 @@ var Command = &cli.Command{
 	Description: "",
 	Args:        true,
 	ArgsUsage:   "",
+	Subcommands: []*cli.Command{
+		inspectCommand,
+	},
 	Action: func(c *cli.Context) error {
+		if target == "" {
+			return fmt.Errorf("target is required")
+		}
 		logger := cmd.NewAppLogger(c.App)
+		if adhoc {
+			return signNative(c, logger, nil)
+		}
+		if p12Path != "" || p12Base64 != "" {
+			id, err := loadP12()
+			if err != nil {
+				return err
+			}
+			return signNative(c, logger, id)
+		}
+		targetExt := filepath.Ext(target)
+		var plan *codesign.SigningPlan
+		if targetExt == ".app" {
+			ents, err := readEntitlements()
+			if err != nil {
+				return err
+			}
+			if plan, err = planBundle(codesign.SignOptions{Flags: codesign.FlagRuntime, Entitlements: ents}); err != nil {
+				return err
+			}
+			if dryRun {
+				printPlan(c.App.Writer, plan)
+				return nil
+			}
+		}
 		var idt security.Identity
 		var err error
-		targetExt := filepath.Ext(target)
 		switch targetExt {
 		case ".app":
 			idt, err = getIdentity(c, "Developer ID Application")
@@ -72,9 +112,16 @@ var Command = &cli.Command{
 		if targetExt == ".pkg" {
 			logger.Println("Product sign (pkg)..")
 			err = signPKG(target, idt.String())
+		} else if plan != nil {
+			logger.Println("Codesign (app), nested code first..")
+			err = codesign.CodeSignPlan(c.Context, idt.Fingerprint, plan)
 		} else {
-			logger.Println("Codesign (app/dmg)..")
-			err = codesign.CodeSign(c.Context, idt.Fingerprint, target)
+			logger.Println("Codesign (dmg)..")
+			var opts []codesign.Option
+			if entitlements != "" {
+				opts = append(opts, codesign.WithEntitlements(entitlements))
+			}
+			err = codesign.CodeSign(c.Context, idt.Fingerprint, target, opts...)
 		}
 		if err != nil {
 			return err
@@ -87,9 +134,19 @@ var Command = &cli.Command{
 			Name:        "target",
 			Usage:       "Path to the target(app,dmg,pkg) file",
 			Destination: &target,
-			Required:    true,
 			Action: func(c *cli.Context, target string) error {
 				ext := strings.ToLower(filepath.Ext(target))
+				if adhoc || p12Path != "" || p12Base64 != "" {
+					// Native signing takes app bundles and Mach-O binaries.
+					fileInfo, err := os.Stat(target)
+					if err != nil {
+						return fmt.Errorf("error accessing target: %v", err)
+					}
+					if fileInfo.IsDir() != (ext == ".app") {
+						return fmt.Errorf("native signing needs an app bundle or a Mach-O binary")
+					}
+					return nil
+				}
 				switch ext {
 				case ".app", ".dmg", ".pkg":
 				default:
@@ -119,10 +176,149 @@ var Command = &cli.Command{
 			Usage:       "Identity to use for signing",
 			Destination: &identity,
 		},
+		&cli.BoolFlag{
+			Name:        "adhoc",
+			Usage:       "Sign an app bundle or Mach-O binary ad hoc, without an identity or codesign",
+			Destination: &adhoc,
+		},
+		&cli.StringFlag{
+			Name:        "entitlements",
+			Usage:       "Path to the entitlements plist to sign with",
+			Destination: &entitlements,
+		},
+		&cli.StringFlag{
+			Name:        "p12",
+			Usage:       "Path to a PKCS#12 (.p12) file with the signing certificate and key, to sign without a keychain",
+			Destination: &p12Path,
+		},
+		&cli.StringFlag{
+			Name:        "p12-base64",
+			Usage:       "Base64-encoded PKCS#12 file, in place of --p12",
+			EnvVars:     []string{"ZAPP_P12_BASE64"},
+			Destination: &p12Base64,
+		},
+		&cli.StringFlag{
+			Name:        "p12-password",
+			Usage:       "Password of the PKCS#12 file",
+			EnvVars:     []string{"ZAPP_P12_PASSWORD"},
+			Destination: &p12Password,
+		},
+		&cli.StringFlag{
+			Name:        "timestamp-url",
+			Usage:       "RFC 3161 timestamp server for signatures made with a PKCS#12 file, empty for none",
+			Value:       codesign.AppleTimestampURL,
+			Destination: &timestampURL,
+		},
+		&cli.StringFlag{
+			Name:        "sign-config",
+			Usage:       "Path to a JSON or YAML file with the identifier, entitlements and options of each component of an app bundle",
+			Destination: &signConfig,
+		},
+		&cli.BoolFlag{
+			Name:        "dry-run",
+			Usage:       "Print the order and options an app bundle's code would be signed with, without signing",
+			Destination: &dryRun,
+		},
 	},
 	SkipFlagParsing: false,
 }
 
+func loadP12() (*codesign.Identity, error) {
+	if p12Path != "" {
+		return codesign.LoadPKCS12File(p12Path, p12Password)
+	}
+	data, err := base64.StdEncoding.DecodeString(strings.TrimSpace(p12Base64))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode base64 PKCS#12: %w", err)
+	}
+	return codesign.LoadPKCS12(data, p12Password)
+}
+
+// signNative signs target without codesign, with id or ad hoc when id is
+// nil.
+func signNative(c *cli.Context, logger *cmd.AppLogger, id *codesign.Identity) error {
+	opts := codesign.SignOptions{Identity: id}
+	if id != nil {
+		opts.Flags = codesign.FlagRuntime
+		opts.TimestampURL = timestampURL
+	}
+	var err error
+	if opts.Entitlements, err = readEntitlements(); err != nil {
+		return err
+	}
+	var plan *codesign.SigningPlan
+	if strings.ToLower(filepath.Ext(target)) == ".app" {
+		if plan, err = planBundle(opts); err != nil {
+			return err
+		}
+		if dryRun {
+			printPlan(c.App.Writer, plan)
+			return nil
+		}
+	}
+	if id == nil {
+		logger.Println("Start ad-hoc signing")
+	} else {
+		logger.Println("Start signing")
+		logger.PrintValue("Certificate", id.Certificate.Subject.CommonName)
+	}
+	logger.PrintValue("Target", target)
+	if plan != nil {
+		err = plan.Sign()
+	} else {
+		err = codesign.SignFile(target, opts)
+	}
+	if err != nil {
+		return err
+	}
+	if id == nil {
+		logger.Success("%s signed ad hoc successfully!", target)
+	} else {
+		logger.Success("%s signed successfully!", target)
+	}
+	return nil
+}
+
+func readEntitlements() ([]byte, error) {
+	if entitlements == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(entitlements)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read entitlements: %w", err)
+	}
+	return data, nil
+}
+
+// planBundle plans the signing of the target bundle, which gets opts, with
+// the components configured by --sign-config.
+func planBundle(opts codesign.SignOptions) (*codesign.SigningPlan, error) {
+	var config codesign.SignConfig
+	if signConfig != "" {
+		var err error
+		if config, err = codesign.LoadSignConfig(signConfig); err != nil {
+			return nil, err
+		}
+	}
+	return codesign.PlanBundle(target, opts, config)
+}
+
+func printPlan(w io.Writer, plan *codesign.SigningPlan) {
+	fmt.Fprintf(w, "Signing plan for %s, nested code first:\n", plan.Bundle)
+	for i, c := range plan.Components {
+		kind := "binary"
+		if c.Bundle {
+			kind = "bundle"
+		}
+		fmt.Fprintf(w, "%3d. %s (%s)\n", i+1, c.Path, kind)
+		fmt.Fprintf(w, "     Identifier:    %s\n", c.Options.Identifier)
+		fmt.Fprintf(w, "     Flags:         %s\n", codesign.FlagString(c.Options.Flags))
+		if c.Options.Entitlements != nil {
+			fmt.Fprintf(w, "     Entitlements:  %d bytes\n", len(c.Options.Entitlements))
+		}
+	}
+}
+
 func signPKG(path, identity string) error {
 	tempDir, err := os.MkdirTemp("", "pkg-signing-")
 	if err != nil {
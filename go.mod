@@ -7,9 +7,12 @@ require (
 	github.com/nfnt/resize v0.0.0-20180221191011-83c6a9932646
 	github.com/samber/lo v1.47.0
 	github.com/urfave/cli/v2 v2.27.5
+	golang.org/x/image v0.21.0
+	golang.org/x/sys v0.18.0
 	golang.org/x/text v0.19.0
 	gopkg.in/yaml.v3 v3.0.1
 	howett.net/plist v1.0.1
+	software.sslmate.com/src/go-pkcs12 v0.4.0
 	yrh.dev/icns v0.0.0-20210608051050-de3ea6a57b33
 )
 
@@ -19,5 +22,5 @@ require (
 	github.com/mattn/go-isatty v0.0.20 // indirect
 	github.com/russross/blackfriday/v2 v2.1.0 // indirect
 	github.com/xrash/smetrics v0.0.0-20240521201337-686a1a2994c1 // indirect
-	golang.org/x/sys v0.18.0 // indirect
+	golang.org/x/crypto v0.11.0 // indirect
 )
@@ -3,6 +3,7 @@ package main
 import (
 	"github.com/ironpark/zapp/cmd/dep"
 	"github.com/ironpark/zapp/cmd/dmg"
+	"github.com/ironpark/zapp/cmd/dsstore"
 	"github.com/ironpark/zapp/cmd/info"
 	"github.com/ironpark/zapp/cmd/notarize"
 	"github.com/ironpark/zapp/cmd/pkg"
@@ -19,6 +20,7 @@ func main() {
 		Commands: []*cli.Command{
 			info.Command,
 			dmg.Command,
+			dsstore.Command,
 			pkg.Command,
 			sign.Command,
 			plist.Command,
@@ -0,0 +1,52 @@
+// Package adc implements a decompressor for Apple Data Compression, the
+// LZSS variant used by UDCO disk images.
+package adc
+
+import "errors"
+
+// ErrCorrupt is returned when the input is truncated or references data
+// before the start of the output.
+var ErrCorrupt = errors.New("adc: corrupt input")
+
+// Decode decompresses src. sizeHint is used to preallocate the output and may
+// be zero.
+func Decode(src []byte, sizeHint int) ([]byte, error) {
+	dst := make([]byte, 0, sizeHint)
+	for i := 0; i < len(src); {
+		b := src[i]
+		var length, offset int
+		switch {
+		case b&0x80 != 0: // literal run
+			length = int(b&0x7f) + 1
+			if i+1+length > len(src) {
+				return nil, ErrCorrupt
+			}
+			dst = append(dst, src[i+1:i+1+length]...)
+			i += 1 + length
+			continue
+		case b&0x40 != 0: // three byte match
+			if i+3 > len(src) {
+				return nil, ErrCorrupt
+			}
+			length = int(b&0x3f) + 4
+			offset = int(src[i+1])<<8 | int(src[i+2])
+			i += 3
+		default: // two byte match
+			if i+2 > len(src) {
+				return nil, ErrCorrupt
+			}
+			length = int(b&0x3f)>>2 + 3
+			offset = int(b&0x03)<<8 | int(src[i+1])
+			i += 2
+		}
+		from := len(dst) - offset - 1
+		if from < 0 {
+			return nil, ErrCorrupt
+		}
+		// Matches may overlap the bytes they produce.
+		for j := 0; j < length; j++ {
+			dst = append(dst, dst[from+j])
+		}
+	}
+	return dst, nil
+}
@@ -0,0 +1,24 @@
+package adc
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestDecode(t *testing.T) {
+	src := []byte{
+		0x83, 'a', 'b', 'c', 'd', // literal "abcd"
+		0x04, 0x03, // two byte match: length 4, offset 3 -> "abcd"
+		0x40, 0x00, 0x00, // three byte match: length 4, offset 0 -> "dddd"
+	}
+	got, err := Decode(src, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []byte("abcdabcddddd"); !bytes.Equal(got, want) {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+	if _, err := Decode([]byte{0x04, 0x10}, 0); err != ErrCorrupt {
+		t.Fatalf("expected ErrCorrupt, got %v", err)
+	}
+}
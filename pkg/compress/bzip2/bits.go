@@ -0,0 +1,47 @@
+package bzip2
+
+import "io"
+
+// bitWriter writes MSB-first bit sequences as used by bzip2.
+type bitWriter struct {
+	w     io.Writer
+	acc   uint64
+	nbits uint
+	buf   []byte
+	err   error
+}
+
+func (b *bitWriter) writeBits(v uint32, n uint) {
+	b.acc = b.acc<<n | uint64(v)&(1<<n-1)
+	b.nbits += n
+	for b.nbits >= 8 {
+		b.nbits -= 8
+		b.buf = append(b.buf, byte(b.acc>>b.nbits))
+	}
+	if len(b.buf) >= 4096 {
+		b.drain()
+	}
+}
+
+func (b *bitWriter) writeBits64(v uint64, n uint) {
+	if n > 32 {
+		b.writeBits(uint32(v>>32), n-32)
+		n = 32
+	}
+	b.writeBits(uint32(v), n)
+}
+
+// flush pads the final byte with zero bits and writes everything buffered.
+func (b *bitWriter) flush() {
+	if b.nbits > 0 {
+		b.writeBits(0, 8-b.nbits)
+	}
+	b.drain()
+}
+
+func (b *bitWriter) drain() {
+	if b.err == nil && len(b.buf) > 0 {
+		_, b.err = b.w.Write(b.buf)
+	}
+	b.buf = b.buf[:0]
+}
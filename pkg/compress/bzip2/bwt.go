@@ -0,0 +1,65 @@
+package bzip2
+
+// sortRotations returns the starting offsets of all cyclic rotations of data
+// in sorted order. It uses prefix doubling with counting sorts, so highly
+// repetitive input costs O(n log n) rather than degrading like a comparison
+// sort would.
+func sortRotations(data []byte) []int32 {
+	n := len(data)
+	p := make([]int32, n)
+	c := make([]int32, n)
+	cnt := make([]int32, max(256, n))
+
+	for _, b := range data {
+		cnt[b]++
+	}
+	for i := 1; i < 256; i++ {
+		cnt[i] += cnt[i-1]
+	}
+	for i := n - 1; i >= 0; i-- {
+		cnt[data[i]]--
+		p[cnt[data[i]]] = int32(i)
+	}
+	classes := int32(1)
+	for i := 1; i < n; i++ {
+		if data[p[i]] != data[p[i-1]] {
+			classes++
+		}
+		c[p[i]] = classes - 1
+	}
+
+	pn := make([]int32, n)
+	cn := make([]int32, n)
+	for h := 1; h < n && int(classes) < n; h <<= 1 {
+		for i := range pn {
+			v := int(p[i]) - h
+			if v < 0 {
+				v += n
+			}
+			pn[i] = int32(v)
+		}
+		clear(cnt[:classes])
+		for i := range pn {
+			cnt[c[pn[i]]]++
+		}
+		for i := int32(1); i < classes; i++ {
+			cnt[i] += cnt[i-1]
+		}
+		for i := n - 1; i >= 0; i-- {
+			cnt[c[pn[i]]]--
+			p[cnt[c[pn[i]]]] = pn[i]
+		}
+		cn[p[0]] = 0
+		classes = 1
+		for i := 1; i < n; i++ {
+			cur0, prev0 := c[p[i]], c[p[i-1]]
+			cur1, prev1 := c[(int(p[i])+h)%n], c[(int(p[i-1])+h)%n]
+			if cur0 != prev0 || cur1 != prev1 {
+				classes++
+			}
+			cn[p[i]] = classes - 1
+		}
+		c, cn = cn, c
+	}
+	return p
+}
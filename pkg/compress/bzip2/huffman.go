@@ -0,0 +1,309 @@
+package bzip2
+
+import "sort"
+
+const (
+	groupSize    = 50
+	maxCodeLen   = 17
+	refineRounds = 4
+)
+
+// writeSymbols performs the move-to-front and zero run-length stages on the
+// BWT output and writes the symbol map, Huffman tables, selectors and the
+// coded symbols.
+func (z *Writer) writeSymbols(bwt []byte) {
+	var inUse [256]bool
+	for _, b := range bwt {
+		inUse[b] = true
+	}
+	var unseqToSeq [256]byte
+	nInUse := 0
+	for i, used := range inUse {
+		if used {
+			unseqToSeq[i] = byte(nInUse)
+			nInUse++
+		}
+	}
+	alphaSize := nInUse + 2
+	eob := uint16(nInUse + 1)
+
+	var mtf [256]byte
+	for i := range mtf {
+		mtf[i] = byte(i)
+	}
+	symbols := make([]uint16, 0, len(bwt)+1)
+	freq := make([]int, alphaSize)
+	emit := func(s uint16) {
+		symbols = append(symbols, s)
+		freq[s]++
+	}
+	zeros := 0
+	flushZeros := func() {
+		zeros--
+		for {
+			emit(uint16(zeros & 1)) // RUNA or RUNB
+			if zeros < 2 {
+				break
+			}
+			zeros = (zeros - 2) / 2
+		}
+		zeros = 0
+	}
+	for _, b := range bwt {
+		v := unseqToSeq[b]
+		if mtf[0] == v {
+			zeros++
+			continue
+		}
+		if zeros > 0 {
+			flushZeros()
+		}
+		j := 1
+		for mtf[j] != v {
+			j++
+		}
+		copy(mtf[1:j+1], mtf[:j])
+		mtf[0] = v
+		emit(uint16(j + 1))
+	}
+	if zeros > 0 {
+		flushZeros()
+	}
+	emit(eob)
+
+	nGroups := 6
+	switch n := len(symbols); {
+	case n < 200:
+		nGroups = 2
+	case n < 600:
+		nGroups = 3
+	case n < 1200:
+		nGroups = 4
+	case n < 2400:
+		nGroups = 5
+	}
+
+	lengths := initialLengths(freq, nGroups)
+	nSelectors := (len(symbols) + groupSize - 1) / groupSize
+	selectors := make([]byte, nSelectors)
+	for round := 0; round < refineRounds; round++ {
+		groupFreq := make([][]int, nGroups)
+		for t := range groupFreq {
+			groupFreq[t] = make([]int, alphaSize)
+		}
+		for s := 0; s < nSelectors; s++ {
+			group := symbols[s*groupSize : min((s+1)*groupSize, len(symbols))]
+			best, bestCost := 0, -1
+			for t := 0; t < nGroups; t++ {
+				cost := 0
+				for _, sym := range group {
+					cost += int(lengths[t][sym])
+				}
+				if bestCost < 0 || cost < bestCost {
+					best, bestCost = t, cost
+				}
+			}
+			selectors[s] = byte(best)
+			for _, sym := range group {
+				groupFreq[best][sym]++
+			}
+		}
+		for t := range lengths {
+			lengths[t] = codeLengths(groupFreq[t], maxCodeLen)
+		}
+	}
+
+	// Symbol map.
+	var inUse16 uint32
+	for i := 0; i < 16; i++ {
+		for j := 0; j < 16; j++ {
+			if inUse[i*16+j] {
+				inUse16 |= 1 << (15 - i)
+				break
+			}
+		}
+	}
+	z.bw.writeBits(inUse16, 16)
+	for i := 0; i < 16; i++ {
+		if inUse16&(1<<(15-i)) == 0 {
+			continue
+		}
+		var bits uint32
+		for j := 0; j < 16; j++ {
+			if inUse[i*16+j] {
+				bits |= 1 << (15 - j)
+			}
+		}
+		z.bw.writeBits(bits, 16)
+	}
+
+	// Selectors, move-to-front coded in unary.
+	z.bw.writeBits(uint32(nGroups), 3)
+	z.bw.writeBits(uint32(nSelectors), 15)
+	order := []byte{0, 1, 2, 3, 4, 5}
+	for _, sel := range selectors {
+		j := 0
+		for order[j] != sel {
+			j++
+		}
+		copy(order[1:j+1], order[:j])
+		order[0] = sel
+		for ; j > 0; j-- {
+			z.bw.writeBits(1, 1)
+		}
+		z.bw.writeBits(0, 1)
+	}
+
+	// Delta-coded code lengths.
+	codes := make([][]uint32, nGroups)
+	for t, lens := range lengths {
+		cur := lens[0]
+		z.bw.writeBits(uint32(cur), 5)
+		for _, l := range lens {
+			for cur < l {
+				z.bw.writeBits(2, 2)
+				cur++
+			}
+			for cur > l {
+				z.bw.writeBits(3, 2)
+				cur--
+			}
+			z.bw.writeBits(0, 1)
+		}
+		codes[t] = canonicalCodes(lens)
+	}
+
+	for s, sel := range selectors {
+		group := symbols[s*groupSize : min((s+1)*groupSize, len(symbols))]
+		for _, sym := range group {
+			z.bw.writeBits(codes[sel][sym], uint(lengths[sel][sym]))
+		}
+	}
+}
+
+// initialLengths seeds each table with cheap codes for a contiguous slice of
+// the alphabet holding roughly an equal share of the symbol frequency, the
+// same starting point the reference encoder uses.
+func initialLengths(freq []int, nGroups int) [][]uint8 {
+	alphaSize := len(freq)
+	total := 0
+	for _, f := range freq {
+		total += f
+	}
+	lengths := make([][]uint8, nGroups)
+	remaining := total
+	start := 0
+	for part := nGroups; part > 0; part-- {
+		target := remaining / part
+		end := start - 1
+		acc := 0
+		for acc < target && end < alphaSize-1 {
+			end++
+			acc += freq[end]
+		}
+		if end > start && part != nGroups && part != 1 && (nGroups-part)%2 == 1 {
+			acc -= freq[end]
+			end--
+		}
+		lens := make([]uint8, alphaSize)
+		for v := range lens {
+			if v >= start && v <= end {
+				lens[v] = 0
+			} else {
+				lens[v] = 15
+			}
+		}
+		lengths[part-1] = lens
+		start = end + 1
+		remaining -= acc
+	}
+	return lengths
+}
+
+// codeLengths builds Huffman code lengths limited to maxLen bits. Unused
+// symbols still get a code since bzip2 tables cover the whole alphabet.
+func codeLengths(freq []int, maxLen uint8) []uint8 {
+	n := len(freq)
+	weights := make([]int, n)
+	for i, f := range freq {
+		weights[i] = max(f, 1)
+	}
+	for {
+		lens := huffmanLengths(weights)
+		longest := uint8(0)
+		for _, l := range lens {
+			longest = max(longest, l)
+		}
+		if longest <= maxLen {
+			return lens
+		}
+		for i := range weights {
+			weights[i] = 1 + weights[i]/2
+		}
+	}
+}
+
+func huffmanLengths(weights []int) []uint8 {
+	n := len(weights)
+	type node struct {
+		weight int
+		parent int
+	}
+	nodes := make([]node, n, 2*n)
+	leaves := make([]int, n)
+	for i, w := range weights {
+		nodes[i] = node{weight: w, parent: -1}
+		leaves[i] = i
+	}
+	sort.SliceStable(leaves, func(a, b int) bool {
+		return weights[leaves[a]] < weights[leaves[b]]
+	})
+
+	// Two-queue construction: leaves in weight order, internal nodes are
+	// created in non-decreasing weight order.
+	var internal []int
+	li, ii := 0, 0
+	pop := func() int {
+		if li < n && (ii >= len(internal) || nodes[leaves[li]].weight <= nodes[internal[ii]].weight) {
+			li++
+			return leaves[li-1]
+		}
+		ii++
+		return internal[ii-1]
+	}
+	for (n-li)+(len(internal)-ii) > 1 {
+		a, b := pop(), pop()
+		nodes = append(nodes, node{weight: nodes[a].weight + nodes[b].weight, parent: -1})
+		idx := len(nodes) - 1
+		nodes[a].parent = idx
+		nodes[b].parent = idx
+		internal = append(internal, idx)
+	}
+
+	lens := make([]uint8, n)
+	depth := make([]uint8, len(nodes))
+	for i := len(nodes) - 1; i >= 0; i-- {
+		if p := nodes[i].parent; p >= 0 {
+			depth[i] = depth[p] + 1
+		}
+	}
+	copy(lens, depth[:n])
+	return lens
+}
+
+// canonicalCodes assigns codes in order of increasing length and, within a
+// length, increasing symbol value, which is what decoders reconstruct.
+func canonicalCodes(lens []uint8) []uint32 {
+	codes := make([]uint32, len(lens))
+	code := uint32(0)
+	for l := uint8(1); l <= 20; l++ {
+		for sym, sl := range lens {
+			if sl == l {
+				codes[sym] = code
+				code++
+			}
+		}
+		code <<= 1
+	}
+	return codes
+}
@@ -0,0 +1,168 @@
+// Package bzip2 implements a bzip2 compressor.
+// The standard library only ships a decompressor (compress/bzip2), which is
+// what readers in this module use; this package covers the writing side.
+package bzip2
+
+import (
+	"errors"
+	"io"
+)
+
+const (
+	// BestSpeed uses 100k blocks.
+	BestSpeed = 1
+	// BestCompression uses 900k blocks.
+	BestCompression = 9
+	// DefaultCompression matches the bzip2 command line default.
+	DefaultCompression = BestCompression
+
+	blockMagic = 0x314159265359
+	eosMagic   = 0x177245385090
+)
+
+// Writer compresses data written to it and writes the bzip2 stream to the
+// underlying writer. Close must be called to flush the final block.
+type Writer struct {
+	w     io.Writer
+	bw    bitWriter
+	level int
+
+	maxBlock    int
+	block       []byte
+	blockCRC    uint32
+	combinedCRC uint32
+
+	runByte byte
+	runLen  int
+
+	wroteHeader bool
+	closed      bool
+	err         error
+}
+
+// NewWriter returns a Writer that compresses with DefaultCompression.
+func NewWriter(w io.Writer) *Writer {
+	z, _ := NewWriterLevel(w, DefaultCompression)
+	return z
+}
+
+// NewWriterLevel returns a Writer using the given block size level (1-9).
+func NewWriterLevel(w io.Writer, level int) (*Writer, error) {
+	if level < BestSpeed || level > BestCompression {
+		return nil, errors.New("bzip2: invalid compression level")
+	}
+	z := &Writer{
+		w:        w,
+		level:    level,
+		maxBlock: level*100000 - 19,
+		blockCRC: 0xffffffff,
+	}
+	z.bw.w = w
+	z.block = make([]byte, 0, z.maxBlock)
+	return z, nil
+}
+
+// Write compresses p.
+func (z *Writer) Write(p []byte) (int, error) {
+	if z.closed {
+		return 0, errors.New("bzip2: write to closed writer")
+	}
+	if z.err != nil {
+		return 0, z.err
+	}
+	for _, b := range p {
+		if z.runLen > 0 && (b != z.runByte || z.runLen == 255) {
+			z.flushRun()
+		}
+		z.runByte = b
+		z.runLen++
+	}
+	return len(p), z.err
+}
+
+// Close flushes pending data and writes the end-of-stream marker. It does
+// not close the underlying writer.
+func (z *Writer) Close() error {
+	if z.closed {
+		return z.err
+	}
+	z.closed = true
+	if z.runLen > 0 {
+		z.flushRun()
+	}
+	if len(z.block) > 0 {
+		z.writeBlock()
+	}
+	if z.err != nil {
+		return z.err
+	}
+	z.writeHeader()
+	z.bw.writeBits64(eosMagic, 48)
+	z.bw.writeBits(z.combinedCRC, 32)
+	z.bw.flush()
+	z.err = z.bw.err
+	return z.err
+}
+
+func (z *Writer) writeHeader() {
+	if z.wroteHeader {
+		return
+	}
+	z.wroteHeader = true
+	z.bw.writeBits('B', 8)
+	z.bw.writeBits('Z', 8)
+	z.bw.writeBits('h', 8)
+	z.bw.writeBits(uint32('0'+z.level), 8)
+}
+
+// flushRun applies the initial run-length encoding to the pending run and
+// appends it to the current block, starting a new block when it is full.
+func (z *Writer) flushRun() {
+	if len(z.block)+5 > z.maxBlock {
+		z.writeBlock()
+	}
+	for i := 0; i < z.runLen; i++ {
+		z.blockCRC = crcUpdate(z.blockCRC, z.runByte)
+	}
+	if z.runLen < 4 {
+		for i := 0; i < z.runLen; i++ {
+			z.block = append(z.block, z.runByte)
+		}
+	} else {
+		z.block = append(z.block, z.runByte, z.runByte, z.runByte, z.runByte, byte(z.runLen-4))
+	}
+	z.runLen = 0
+}
+
+func (z *Writer) writeBlock() {
+	if z.err != nil {
+		return
+	}
+	z.writeHeader()
+	crc := ^z.blockCRC
+	z.combinedCRC = (z.combinedCRC<<1 | z.combinedCRC>>31) ^ crc
+
+	data := z.block
+	n := len(data)
+	ptr := sortRotations(data)
+	bwt := make([]byte, n)
+	origPtr := 0
+	for i, p := range ptr {
+		if p == 0 {
+			origPtr = i
+			bwt[i] = data[n-1]
+		} else {
+			bwt[i] = data[p-1]
+		}
+	}
+
+	z.bw.writeBits64(blockMagic, 48)
+	z.bw.writeBits(crc, 32)
+	z.bw.writeBits(0, 1) // not randomized
+	z.bw.writeBits(uint32(origPtr), 24)
+	z.writeSymbols(bwt)
+
+	z.block = z.block[:0]
+	z.blockCRC = 0xffffffff
+	z.err = z.bw.err
+}
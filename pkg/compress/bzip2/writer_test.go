@@ -0,0 +1,51 @@
+package bzip2
+
+import (
+	"bytes"
+	"compress/bzip2"
+	"io"
+	"math/rand"
+	"testing"
+)
+
+func TestWriterRoundTrip(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	random := make([]byte, 300000)
+	rnd.Read(random)
+	text := make([]byte, 0, 1200000)
+	for len(text) < 1100000 {
+		text = append(text, "the quick brown fox jumps over the lazy dog "[rnd.Intn(20):]...)
+	}
+
+	cases := map[string][]byte{
+		"empty":    nil,
+		"single":   []byte("a"),
+		"short":    []byte("banana"),
+		"zeros":    make([]byte, 1<<20),
+		"periodic": bytes.Repeat([]byte("abcabcabd"), 50000),
+		"random":   random,
+		"text":     text,
+	}
+	for name, data := range cases {
+		t.Run(name, func(t *testing.T) {
+			var buf bytes.Buffer
+			w, err := NewWriterLevel(&buf, 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := w.Write(data); err != nil {
+				t.Fatal(err)
+			}
+			if err := w.Close(); err != nil {
+				t.Fatal(err)
+			}
+			out, err := io.ReadAll(bzip2.NewReader(&buf))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(out, data) {
+				t.Fatalf("round trip mismatch: got %d bytes, want %d", len(out), len(data))
+			}
+		})
+	}
+}
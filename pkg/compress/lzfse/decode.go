@@ -0,0 +1,161 @@
+package lzfse
+
+import "encoding/binary"
+
+// Decode decompresses src. sizeHint is used to preallocate the output and may
+// be zero.
+func Decode(src []byte, sizeHint int) ([]byte, error) {
+	dst := make([]byte, 0, sizeHint)
+	for {
+		if len(src) < 4 {
+			return nil, ErrCorrupt
+		}
+		var (
+			n   int
+			err error
+		)
+		switch string(src[:4]) {
+		case magicEnd:
+			return dst, nil
+		case magicUncompressed:
+			if len(src) < 8 {
+				return nil, ErrCorrupt
+			}
+			n = 8 + int(binary.LittleEndian.Uint32(src[4:]))
+			if n > len(src) {
+				return nil, ErrCorrupt
+			}
+			dst = append(dst, src[8:n]...)
+		case magicLZVN:
+			if len(src) < 12 {
+				return nil, ErrCorrupt
+			}
+			nRaw := int(binary.LittleEndian.Uint32(src[4:]))
+			n = 12 + int(binary.LittleEndian.Uint32(src[8:]))
+			if n > len(src) {
+				return nil, ErrCorrupt
+			}
+			dst, err = decodeLZVN(dst, src[12:n], nRaw)
+		case magicCompressedV2:
+			dst, n, err = decodeBlock(dst, src)
+		default:
+			return nil, ErrCorrupt
+		}
+		if err != nil {
+			return nil, err
+		}
+		src = src[n:]
+	}
+}
+
+// decodeBlock decodes the compressed block at the start of src and returns
+// the extended buffer and the size of the block.
+func decodeBlock(dst, src []byte) ([]byte, int, error) {
+	if len(src) < 32 {
+		return nil, 0, ErrCorrupt
+	}
+	field := func(v uint64, offset, n uint) int {
+		return int(v >> offset & (1<<n - 1))
+	}
+	nRaw := int(binary.LittleEndian.Uint32(src[4:]))
+	v0 := binary.LittleEndian.Uint64(src[8:])
+	v1 := binary.LittleEndian.Uint64(src[16:])
+	v2 := binary.LittleEndian.Uint64(src[24:])
+
+	nLiterals := field(v0, 0, 20)
+	nLiteralPayload := field(v0, 20, 20)
+	nMatches := field(v0, 40, 20)
+	literalBits := field(v0, 60, 3) - 7
+	litState := [4]int{field(v1, 0, 10), field(v1, 10, 10), field(v1, 20, 10), field(v1, 30, 10)}
+	nLMDPayload := field(v1, 40, 20)
+	lmdBits := field(v1, 60, 3) - 7
+	headerSize := field(v2, 0, 32)
+	lState, mState, dState := field(v2, 32, 10), field(v2, 42, 10), field(v2, 52, 10)
+
+	size := headerSize + nLiteralPayload + nLMDPayload
+	if headerSize < 32 || size > len(src) || nLiterals > literalsPerBlock || nMatches > matchesPerBlock ||
+		lState >= lStates || mState >= mStates || dState >= dStates {
+		return nil, 0, ErrCorrupt
+	}
+	var (
+		litFreq [literalSymbols]uint16
+		lFreq   [lSymbols]uint16
+		mFreq   [mSymbols]uint16
+		dFreq   [dSymbols]uint16
+	)
+	if err := readFreq(src[32:headerSize], lFreq[:], mFreq[:], dFreq[:], litFreq[:]); err != nil {
+		return nil, 0, err
+	}
+
+	litTable, err := decoderTable(literalStates, litFreq[:])
+	if err != nil {
+		return nil, 0, err
+	}
+	literals := make([]byte, (nLiterals+3)&^3)
+	in, err := newInStream(src[:headerSize+nLiteralPayload], literalBits)
+	if err != nil {
+		return nil, 0, err
+	}
+	for i := 0; i < len(literals); i += 4 {
+		if err := in.refill(); err != nil {
+			return nil, 0, err
+		}
+		for j := range litState {
+			literals[i+j] = in.decode(&litState[j], litTable)
+		}
+	}
+
+	lTable, err := valueDecoderTable(lStates, lFreq[:], lExtraBits[:], lBaseValue)
+	if err != nil {
+		return nil, 0, err
+	}
+	mTable, err := valueDecoderTable(mStates, mFreq[:], mExtraBits[:], mBaseValue)
+	if err != nil {
+		return nil, 0, err
+	}
+	dTable, err := valueDecoderTable(dStates, dFreq[:], dExtraBits[:], dBaseValue)
+	if err != nil {
+		return nil, 0, err
+	}
+	if in, err = newInStream(src[:size], lmdBits); err != nil {
+		return nil, 0, err
+	}
+	end := len(dst) + nRaw
+	lits := literals[:nLiterals]
+	d := 0
+	for i := 0; i < nMatches; i++ {
+		if err := in.refill(); err != nil {
+			return nil, 0, err
+		}
+		l := in.decodeValue(&lState, lTable)
+		m := in.decodeValue(&mState, mTable)
+		if nd := in.decodeValue(&dState, dTable); nd != 0 {
+			d = nd
+		}
+		if l > len(lits) || len(dst)+l+m > end {
+			return nil, 0, ErrCorrupt
+		}
+		dst = append(dst, lits[:l]...)
+		lits = lits[l:]
+		if m > 0 {
+			if dst, err = copyMatch(dst, d, m); err != nil {
+				return nil, 0, err
+			}
+		}
+	}
+	if len(dst) != end {
+		return nil, 0, ErrCorrupt
+	}
+	return dst, size, nil
+}
+
+// copyMatch appends m bytes copied from d bytes back.
+func copyMatch(dst []byte, d, m int) ([]byte, error) {
+	if d <= 0 || d > len(dst) {
+		return nil, ErrCorrupt
+	}
+	for i := 0; i < m; i++ {
+		dst = append(dst, dst[len(dst)-d])
+	}
+	return dst, nil
+}
@@ -0,0 +1,262 @@
+package lzfse
+
+import (
+	"encoding/binary"
+	"sort"
+)
+
+const (
+	hashBits   = 15
+	minMatch   = 4
+	chainDepth = 16
+	niceMatch  = 128
+)
+
+// lmd is one step of a block: L literals followed by a match of M bytes at
+// distance D. D is 0 when the match reuses the previous distance.
+type lmd struct {
+	l, m, d int32
+}
+
+type encoder struct {
+	src  []byte
+	out  []byte
+	head []int32
+	prev []int32
+
+	// The block being collected: it covers src[blockStart:blockEnd].
+	blockStart, blockEnd int
+	literals             []byte
+	lmds                 []lmd
+	prevD                int
+}
+
+// Encode compresses src into an LZFSE stream.
+func Encode(src []byte) []byte {
+	e := &encoder{
+		src:  src,
+		head: make([]int32, 1<<hashBits),
+		prev: make([]int32, len(src)),
+	}
+	for i := range e.head {
+		e.head[i] = -1
+	}
+
+	litStart := 0
+	for pos := 0; pos+minMatch <= len(src); {
+		m, d := e.findMatch(pos)
+		if m == 0 {
+			pos++
+			continue
+		}
+		e.push(src[litStart:pos], m, d)
+		pos += m
+		litStart = pos
+	}
+	e.push(src[litStart:], 0, 0)
+	e.flushBlock()
+	return append(e.out, magicEnd...)
+}
+
+func hash4(b []byte) uint32 {
+	return (uint32(b[0]) | uint32(b[1])<<8 | uint32(b[2])<<16 | uint32(b[3])<<24) * 2654435761 >> (32 - hashBits)
+}
+
+// findMatch adds pos to the hash chains and returns the longest earlier
+// match of at least minMatch bytes at pos and its distance.
+func (e *encoder) findMatch(pos int) (length, dist int) {
+	h := hash4(e.src[pos:])
+	cand := e.head[h]
+	e.prev[pos] = cand
+	e.head[h] = int32(pos)
+
+	limit := len(e.src) - pos
+	for depth := chainDepth; cand >= 0 && depth > 0; depth-- {
+		d := pos - int(cand)
+		if d > maxDValue {
+			break
+		}
+		if e.src[int(cand)+length] == e.src[pos+length] {
+			n := 0
+			for n < limit && e.src[int(cand)+n] == e.src[pos+n] {
+				n++
+			}
+			if n > length {
+				length, dist = n, d
+				if n >= niceMatch || n == limit {
+					break
+				}
+			}
+		}
+		cand = e.prev[cand]
+	}
+	if length < minMatch {
+		return 0, 0
+	}
+	// Positions inside the match are still added to the chains, so that
+	// later matches can start there.
+	for p := pos + 1; p < pos+length && p+minMatch <= len(e.src); p++ {
+		h := hash4(e.src[p:])
+		e.prev[p] = e.head[h]
+		e.head[h] = int32(p)
+	}
+	return length, dist
+}
+
+// push adds literals followed by a match of m bytes at distance d to the
+// block, splitting them into steps the coders can represent.
+func (e *encoder) push(lits []byte, m, d int) {
+	for len(lits) > maxLValue {
+		e.pushLMD(lits[:maxLValue], 0, 0)
+		lits = lits[maxLValue:]
+	}
+	for len(lits) > 0 || m > 0 {
+		n := min(m, maxMValue)
+		e.pushLMD(lits, n, d)
+		lits, m = nil, m-n
+	}
+}
+
+func (e *encoder) pushLMD(lits []byte, m, d int) {
+	if len(e.lmds) == matchesPerBlock || len(e.literals)+len(lits) > literalsPerBlock {
+		e.flushBlock()
+	}
+	switch {
+	case m == 0 && e.prevD != 0:
+		d = 0
+	case m == 0:
+		// The decoder checks the distance even without a match, so a
+		// block does not start with a reference to a previous one.
+		d, e.prevD = 1, 1
+	case d == e.prevD:
+		d = 0
+	default:
+		e.prevD = d
+	}
+	e.literals = append(e.literals, lits...)
+	e.lmds = append(e.lmds, lmd{int32(len(lits)), int32(m), int32(d)})
+	e.blockEnd += len(lits) + m
+}
+
+// flushBlock writes the collected block, as an uncompressed block when
+// compression does not make it smaller.
+func (e *encoder) flushBlock() {
+	if len(e.lmds) == 0 {
+		return
+	}
+	raw := e.src[e.blockStart:e.blockEnd]
+	block := encodeBlock(len(raw), e.literals, e.lmds)
+	if len(block) >= len(raw)+8 {
+		e.out = append(e.out, magicUncompressed...)
+		e.out = binary.LittleEndian.AppendUint32(e.out, uint32(len(raw)))
+		e.out = append(e.out, raw...)
+	} else {
+		e.out = append(e.out, block...)
+	}
+	e.blockStart = e.blockEnd
+	e.literals = e.literals[:0]
+	e.lmds = e.lmds[:0]
+	e.prevD = 0
+}
+
+// symbolOf returns the symbol whose range of values holds v.
+func symbolOf(base []int32, v int32) int {
+	return sort.Search(len(base), func(i int) bool { return base[i] > v }) - 1
+}
+
+// encodeBlock returns a compressed (bvx2) block of nRaw bytes.
+func encodeBlock(nRaw int, literals []byte, lmds []lmd) []byte {
+	// Literals are coded four at a time.
+	for len(literals)%4 != 0 {
+		literals = append(literals, 0)
+	}
+
+	var (
+		litCounts [literalSymbols]uint32
+		lCounts   [lSymbols]uint32
+		mCounts   [mSymbols]uint32
+		dCounts   [dSymbols]uint32
+	)
+	for _, b := range literals {
+		litCounts[b]++
+	}
+	for _, x := range lmds {
+		lCounts[symbolOf(lBaseValue, x.l)]++
+		mCounts[symbolOf(mBaseValue, x.m)]++
+		dCounts[symbolOf(dBaseValue, x.d)]++
+	}
+	var (
+		litFreq [literalSymbols]uint16
+		lFreq   [lSymbols]uint16
+		mFreq   [mSymbols]uint16
+		dFreq   [dSymbols]uint16
+	)
+	normalizeFreq(litCounts[:], literalStates, litFreq[:])
+	normalizeFreq(lCounts[:], lStates, lFreq[:])
+	normalizeFreq(mCounts[:], mStates, mFreq[:])
+	normalizeFreq(dCounts[:], dStates, dFreq[:])
+
+	// Both payloads are written back to front so that they decode front
+	// to back.
+	litTable := encoderTable(literalStates, litFreq[:])
+	lits := &outStream{}
+	var litState [4]int
+	for i := len(literals) - 4; i >= 0; i -= 4 {
+		for j := 3; j >= 0; j-- {
+			lits.encode(&litState[j], litTable, int(literals[i+j]))
+		}
+		lits.flush()
+	}
+	literalBits := lits.finish()
+
+	lTable := encoderTable(lStates, lFreq[:])
+	mTable := encoderTable(mStates, mFreq[:])
+	dTable := encoderTable(dStates, dFreq[:])
+	lmdOut := &outStream{buf: make([]byte, 8)}
+	var lState, mState, dState int
+	for i := len(lmds) - 1; i >= 0; i-- {
+		x := lmds[i]
+		for _, v := range []struct {
+			value int32
+			state *int
+			table []encoderEntry
+			base  []int32
+			extra []uint8
+		}{
+			{x.d, &dState, dTable, dBaseValue, dExtraBits[:]},
+			{x.m, &mState, mTable, mBaseValue, mExtraBits[:]},
+			{x.l, &lState, lTable, lBaseValue, lExtraBits[:]},
+		} {
+			sym := symbolOf(v.base, v.value)
+			lmdOut.push(int(v.extra[sym]), uint64(v.value-v.base[sym]))
+			lmdOut.encode(v.state, v.table, sym)
+		}
+		lmdOut.flush()
+	}
+	lmdBits := lmdOut.finish()
+
+	freq := appendFreq(nil, lFreq[:], mFreq[:], dFreq[:], litFreq[:])
+	headerSize := 32 + len(freq)
+	block := append([]byte(magicCompressedV2), 0, 0, 0, 0)
+	binary.LittleEndian.PutUint32(block[4:], uint32(nRaw))
+	block = binary.LittleEndian.AppendUint64(block,
+		uint64(len(literals))|
+			uint64(len(lits.buf))<<20|
+			uint64(len(lmds))<<40|
+			uint64(literalBits+7)<<60)
+	block = binary.LittleEndian.AppendUint64(block,
+		uint64(litState[0])|
+			uint64(litState[1])<<10|
+			uint64(litState[2])<<20|
+			uint64(litState[3])<<30|
+			uint64(len(lmdOut.buf))<<40|
+			uint64(lmdBits+7)<<60)
+	block = binary.LittleEndian.AppendUint64(block,
+		uint64(headerSize)|
+			uint64(lState)<<32|
+			uint64(mState)<<42|
+			uint64(dState)<<52)
+	block = append(block, freq...)
+	block = append(block, lits.buf...)
+	return append(block, lmdOut.buf...)
+}
@@ -0,0 +1,307 @@
+package lzfse
+
+import (
+	"encoding/binary"
+	"math/bits"
+)
+
+// normalizeFreq scales the symbol counts so that they add up to nstates,
+// keeping every used symbol at a frequency of at least one.
+func normalizeFreq(counts []uint32, nstates int, freq []uint16) {
+	var total uint64
+	for _, c := range counts {
+		total += uint64(c)
+	}
+	clear(freq)
+	if total == 0 {
+		freq[0] = uint16(nstates)
+		return
+	}
+	remaining, largest := nstates, 0
+	for i, c := range counts {
+		if c == 0 {
+			continue
+		}
+		f := int((uint64(c)*uint64(nstates)*2/total + 1) / 2)
+		f = max(f, 1)
+		freq[i] = uint16(f)
+		remaining -= f
+		if freq[i] > freq[largest] {
+			largest = i
+		}
+	}
+	// Rounding can hand out too many states; take them back from the most
+	// frequent symbols.
+	for remaining < 0 {
+		i := 0
+		for j := range freq {
+			if freq[j] > freq[i] {
+				i = j
+			}
+		}
+		take := min(-remaining, (int(freq[i])+1)/2)
+		freq[i] -= uint16(take)
+		remaining += take
+	}
+	freq[largest] += uint16(remaining)
+}
+
+// encoderEntry holds what is needed to code a symbol from any state.
+type encoderEntry struct {
+	s0, k, delta0, delta1 int
+}
+
+func encoderTable(nstates int, freq []uint16) []encoderEntry {
+	t := make([]encoderEntry, len(freq))
+	offset := 0
+	for i, f16 := range freq {
+		f := int(f16)
+		if f == 0 {
+			continue
+		}
+		k := bits.Len(uint(nstates)) - bits.Len(uint(f))
+		t[i] = encoderEntry{
+			s0:     f<<k - nstates,
+			k:      k,
+			delta0: offset - f + nstates>>k,
+		}
+		if k > 0 {
+			t[i].delta1 = offset - f + nstates>>(k-1)
+		}
+		offset += f
+	}
+	return t
+}
+
+// decoderEntry is the state transition of a state: the symbol it decodes
+// to, the number of bits to read and the base of the next state.
+type decoderEntry struct {
+	k      uint8
+	symbol uint8
+	delta  int16
+}
+
+func decoderTable(nstates int, freq []uint16) ([]decoderEntry, error) {
+	t := make([]decoderEntry, 0, nstates)
+	for i, f16 := range freq {
+		f := int(f16)
+		if f == 0 {
+			continue
+		}
+		if len(t)+f > nstates {
+			return nil, ErrCorrupt
+		}
+		k := bits.Len(uint(nstates)) - bits.Len(uint(f))
+		j0 := (2*nstates)>>k - f
+		for j := 0; j < f; j++ {
+			if j < j0 {
+				t = append(t, decoderEntry{uint8(k), uint8(i), int16((f+j)<<k - nstates)})
+			} else {
+				t = append(t, decoderEntry{uint8(k - 1), uint8(i), int16((j - j0) << (k - 1))})
+			}
+		}
+	}
+	// States no symbol was given to can only be reached by corrupt input.
+	return t[:nstates], nil
+}
+
+// valueEntry is a decoderEntry that also reads the extra bits of a value.
+type valueEntry struct {
+	totalBits uint8
+	valueBits uint8
+	delta     int16
+	vbase     int32
+}
+
+func valueDecoderTable(nstates int, freq []uint16, extraBits []uint8, base []int32) ([]valueEntry, error) {
+	dt, err := decoderTable(nstates, freq)
+	if err != nil {
+		return nil, err
+	}
+	t := make([]valueEntry, nstates)
+	for i, e := range dt {
+		vb := extraBits[e.symbol]
+		t[i] = valueEntry{e.k + vb, vb, e.delta, base[e.symbol]}
+	}
+	return t, nil
+}
+
+// outStream collects the bits of a block payload. Bits are added above the
+// ones already written and stored little-endian, so the decoder reads them
+// backwards from the end of the payload.
+type outStream struct {
+	accum uint64
+	nbits int
+	buf   []byte
+}
+
+func (s *outStream) push(n int, v uint64) {
+	s.accum |= (v & (1<<n - 1)) << s.nbits
+	s.nbits += n
+}
+
+// flush writes the complete bytes of the accumulator.
+func (s *outStream) flush() {
+	for s.nbits >= 8 {
+		s.buf = append(s.buf, byte(s.accum))
+		s.accum >>= 8
+		s.nbits -= 8
+	}
+}
+
+// finish writes the last partial byte and returns the number of unused bits
+// of it, negated, as stored in the block header.
+func (s *outStream) finish() int {
+	for s.nbits > 0 {
+		s.buf = append(s.buf, byte(s.accum))
+		s.accum >>= 8
+		s.nbits -= 8
+	}
+	return s.nbits
+}
+
+func (s *outStream) encode(state *int, t []encoderEntry, symbol int) {
+	e := t[symbol]
+	nbits, delta := e.k, e.delta0
+	if *state < e.s0 {
+		nbits, delta = e.k-1, e.delta1
+	}
+	s.push(nbits, uint64(*state))
+	*state = delta + *state>>nbits
+}
+
+// inStream reads the bits of a payload backwards. src holds all data before
+// the end of the payload, which the stream may read into while filling the
+// accumulator.
+type inStream struct {
+	accum uint64
+	nbits int
+	src   []byte
+	pos   int
+}
+
+func newInStream(src []byte, n int) (*inStream, error) {
+	s := &inStream{src: src, pos: len(src)}
+	size := 8
+	if n == 0 {
+		size = 7
+	}
+	if n < -7 || n > 0 || s.pos < size {
+		return nil, ErrCorrupt
+	}
+	s.pos -= size
+	var b [8]byte
+	copy(b[:], src[s.pos:s.pos+size])
+	s.accum = binary.LittleEndian.Uint64(b[:])
+	s.nbits = n + size*8
+	if s.accum>>s.nbits != 0 {
+		return nil, ErrCorrupt
+	}
+	return s, nil
+}
+
+// refill loads whole bytes until the accumulator holds at least 56 bits.
+func (s *inStream) refill() error {
+	n := (63 - s.nbits) / 8
+	if s.pos < n {
+		return ErrCorrupt
+	}
+	for i := 0; i < n; i++ {
+		s.pos--
+		s.accum = s.accum<<8 | uint64(s.src[s.pos])
+	}
+	s.nbits += n * 8
+	return nil
+}
+
+func (s *inStream) pull(n uint8) uint64 {
+	s.nbits -= int(n)
+	v := s.accum >> s.nbits
+	s.accum &= 1<<s.nbits - 1
+	return v
+}
+
+func (s *inStream) decode(state *int, t []decoderEntry) byte {
+	e := t[*state]
+	*state = int(e.delta) + int(s.pull(e.k))
+	return e.symbol
+}
+
+func (s *inStream) decodeValue(state *int, t []valueEntry) int {
+	e := t[*state]
+	v := s.pull(e.totalBits)
+	*state = int(e.delta) + int(v>>e.valueBits)
+	return int(e.vbase) + int(v&(1<<e.valueBits-1))
+}
+
+// appendFreq appends the frequency tables of a block header. Each frequency
+// takes 2 to 14 bits, least significant bit first.
+func appendFreq(b []byte, freqs ...[]uint16) []byte {
+	var accum uint32
+	var nbits int
+	for _, freq := range freqs {
+		for _, f := range freq {
+			v, n := encodeFreq(int(f))
+			accum |= v << nbits
+			nbits += n
+			for ; nbits >= 8; nbits -= 8 {
+				b = append(b, byte(accum))
+				accum >>= 8
+			}
+		}
+	}
+	if nbits > 0 {
+		b = append(b, byte(accum))
+	}
+	return b
+}
+
+func encodeFreq(f int) (uint32, int) {
+	switch {
+	case f < 8:
+		return [8]uint32{0, 2, 1, 5, 3, 11, 19, 27}[f], [8]int{2, 2, 3, 3, 5, 5, 5, 5}[f]
+	case f < 24:
+		return 7 | uint32(f-8)<<4, 8
+	default:
+		return 15 | uint32(f-24)<<4, 14
+	}
+}
+
+var (
+	freqNBits = [32]int{2, 3, 2, 5, 2, 3, 2, 8, 2, 3, 2, 5, 2, 3, 2, 14, 2, 3, 2, 5, 2, 3, 2, 8, 2, 3, 2, 5, 2, 3, 2, 14}
+	freqValue = [32]int{0, 2, 1, 4, 0, 3, 1, -1, 0, 2, 1, 5, 0, 3, 1, -1, 0, 2, 1, 6, 0, 3, 1, -1, 0, 2, 1, 7, 0, 3, 1, -1}
+)
+
+// readFreq decodes the frequency tables of a block header from src, which
+// must be used up exactly.
+func readFreq(src []byte, freqs ...[]uint16) error {
+	var accum uint32
+	var nbits int
+	for _, freq := range freqs {
+		for i := range freq {
+			for len(src) > 0 && nbits+8 <= 32 {
+				accum |= uint32(src[0]) << nbits
+				nbits += 8
+				src = src[1:]
+			}
+			n := freqNBits[accum&31]
+			v := freqValue[accum&31]
+			switch n {
+			case 8:
+				v = 8 + int(accum>>4&0xf)
+			case 14:
+				v = 24 + int(accum>>4&0x3ff)
+			}
+			if n > nbits {
+				return ErrCorrupt
+			}
+			freq[i] = uint16(v)
+			accum >>= n
+			nbits -= n
+		}
+	}
+	if nbits >= 8 || len(src) != 0 {
+		return ErrCorrupt
+	}
+	return nil
+}
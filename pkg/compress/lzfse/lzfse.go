@@ -0,0 +1,70 @@
+// Package lzfse implements an encoder and a decoder for LZFSE, the
+// compression used by the chunks of ULFO disk images.
+//
+// An LZFSE stream is a sequence of blocks, each starting with a four byte
+// magic, and ends with the bvx$ magic. The encoder writes compressed (bvx2)
+// and uncompressed (bvx-) blocks; the decoder also reads the LZVN (bvxn)
+// blocks the reference encoder uses for small inputs.
+package lzfse
+
+import "errors"
+
+// ErrCorrupt is returned when the input is truncated, uses an unknown block
+// type or references data before the start of the output.
+var ErrCorrupt = errors.New("lzfse: corrupt input")
+
+// Block magics.
+const (
+	magicEnd          = "bvx$"
+	magicUncompressed = "bvx-"
+	magicCompressedV2 = "bvx2"
+	magicLZVN         = "bvxn"
+)
+
+const (
+	// Number of states of the finite state entropy coders.
+	lStates       = 64
+	mStates       = 64
+	dStates       = 256
+	literalStates = 1024
+
+	// Number of symbols of the coders.
+	lSymbols       = 20
+	mSymbols       = 20
+	dSymbols       = 64
+	literalSymbols = 256
+
+	matchesPerBlock  = 10000
+	literalsPerBlock = 4 * matchesPerBlock
+
+	// Largest values of one literal length, match length and distance.
+	maxLValue = 315
+	maxMValue = 2359
+	maxDValue = 262139
+)
+
+// The number of extra bits of each symbol of the literal length (L), match
+// length (M) and distance (D) coders. A symbol stands for the values from
+// its base to its base plus 1<<extraBits - 1.
+var (
+	lExtraBits = [lSymbols]uint8{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 2, 3, 5, 8}
+	mExtraBits = [mSymbols]uint8{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 3, 5, 8, 11}
+	dExtraBits = [dSymbols]uint8{
+		0, 0, 0, 0, 1, 1, 1, 1, 2, 2, 2, 2, 3, 3, 3, 3,
+		4, 4, 4, 4, 5, 5, 5, 5, 6, 6, 6, 6, 7, 7, 7, 7,
+		8, 8, 8, 8, 9, 9, 9, 9, 10, 10, 10, 10, 11, 11, 11, 11,
+		12, 12, 12, 12, 13, 13, 13, 13, 14, 14, 14, 14, 15, 15, 15, 15,
+	}
+
+	lBaseValue = baseValues(lExtraBits[:])
+	mBaseValue = baseValues(mExtraBits[:])
+	dBaseValue = baseValues(dExtraBits[:])
+)
+
+func baseValues(extraBits []uint8) []int32 {
+	base := make([]int32, len(extraBits))
+	for i := 1; i < len(base); i++ {
+		base[i] = base[i-1] + 1<<extraBits[i-1]
+	}
+	return base
+}
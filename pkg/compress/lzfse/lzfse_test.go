@@ -0,0 +1,169 @@
+package lzfse
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math/rand"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestRoundTrip(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	random := make([]byte, 300000)
+	rnd.Read(random)
+	text := make([]byte, 0, 1200000)
+	for len(text) < 1100000 {
+		text = append(text, "the quick brown fox jumps over the lazy dog "[rnd.Intn(20):]...)
+	}
+	// Few distinct symbols give frequencies that take all the states of a
+	// coder.
+	skewed := make([]byte, 200000)
+	for i := range skewed {
+		skewed[i] = byte(rnd.Intn(2)) * 'a'
+	}
+
+	cases := map[string][]byte{
+		"empty":    nil,
+		"single":   []byte("a"),
+		"short":    []byte("banana"),
+		"zeros":    make([]byte, 1<<20),
+		"periodic": bytes.Repeat([]byte("abcabcabd"), 50000),
+		"random":   random,
+		"text":     text,
+		"skewed":   skewed,
+		"mixed":    append(append(bytes.Repeat([]byte("xyz"), 30000), random[:100000]...), text[:100000]...),
+	}
+	for name, data := range cases {
+		t.Run(name, func(t *testing.T) {
+			enc := Encode(data)
+			out, err := Decode(enc, len(data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(out, data) {
+				t.Fatalf("round trip mismatch: got %d bytes, want %d", len(out), len(data))
+			}
+			if len(data) > 1000 && name != "random" && len(enc) > len(data)/2 {
+				t.Errorf("compressed %d bytes to %d", len(data), len(enc))
+			}
+		})
+	}
+}
+
+func TestDecodeLZVN(t *testing.T) {
+	payload := []byte{
+		0xe3, 'a', 'b', 'c', // small literal: "abc"
+		0x58, 0x04, 'd', // small distance: "d", then 6 bytes at distance 4
+		0x4e, 'x', // previous distance: "x", then 4 bytes at distance 4
+		0xf2,             // small match: 2 bytes at distance 4
+		0xa0, 0x20, 0x00, // medium distance: 3 bytes at distance 8
+		0x0e,             // nop
+		0x07, 0x01, 0x00, // large distance: 3 bytes at distance 1
+		0x06, 0, 0, 0, 0, 0, 0, 0, // end of stream
+	}
+	want := "abcdabcdabxdabxda" + "bxd" + "ddd"
+	src := []byte(magicLZVN)
+	src = binary.LittleEndian.AppendUint32(src, uint32(len(want)))
+	src = binary.LittleEndian.AppendUint32(src, uint32(len(payload)))
+	src = append(append(src, payload...), magicEnd...)
+
+	got, err := Decode(src, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != want {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+
+	// A distance reaching before the start of the output.
+	bad := bytes.Clone(src)
+	bad[12+5] = 0x10
+	if _, err := Decode(bad, 0); err != ErrCorrupt {
+		t.Fatalf("expected ErrCorrupt, got %v", err)
+	}
+}
+
+func TestDecodeCorrupt(t *testing.T) {
+	enc := Encode(bytes.Repeat([]byte("hello, world "), 1000))
+	for _, n := range []int{0, 3, 40, len(enc) - 1} {
+		if _, err := Decode(enc[:n], 0); err != ErrCorrupt {
+			t.Errorf("truncated to %d bytes: expected ErrCorrupt, got %v", n, err)
+		}
+	}
+	bad := bytes.Clone(enc)
+	copy(bad, "bvx9")
+	if _, err := Decode(bad, 0); err != ErrCorrupt {
+		t.Errorf("unknown block: expected ErrCorrupt, got %v", err)
+	}
+}
+
+// referenceTool returns the command line of the reference lzfse command, or
+// of compression_tool, which uses the LZFSE coder of macOS, for encoding or
+// decoding with mode "-encode" or "-decode".
+func referenceTool(mode string) ([]string, bool) {
+	if _, err := exec.LookPath("lzfse"); err == nil {
+		return []string{"lzfse", mode}, true
+	}
+	if _, err := exec.LookPath("compression_tool"); err == nil && runtime.GOOS == "darwin" {
+		return []string{"compression_tool", mode, "-a", "lzfse"}, true
+	}
+	return nil, false
+}
+
+func runReference(t *testing.T, mode string, data []byte) []byte {
+	t.Helper()
+	args, _ := referenceTool(mode)
+	dir := t.TempDir()
+	in, out := filepath.Join(dir, "in"), filepath.Join(dir, "out")
+	if err := os.WriteFile(in, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	args = append(args, "-i", in, "-o", out)
+	if output, err := exec.Command(args[0], args[1:]...).CombinedOutput(); err != nil {
+		t.Fatalf("%v: %v: %s", args, err, output)
+	}
+	result, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return result
+}
+
+// TestReferenceCompatibility checks the encoder and the decoder against the
+// reference lzfse command, or against macOS, when either is available.
+func TestReferenceCompatibility(t *testing.T) {
+	if _, ok := referenceTool("-decode"); !ok {
+		t.Skip("neither lzfse nor compression_tool is installed")
+	}
+	rnd := rand.New(rand.NewSource(2))
+	random := make([]byte, 100000)
+	rnd.Read(random)
+	text := make([]byte, 0, 600000)
+	for len(text) < 500000 {
+		text = append(text, "the quick brown fox jumps over the lazy dog "[rnd.Intn(20):]...)
+	}
+	for name, data := range map[string][]byte{
+		"short":    []byte("banana"),
+		"small":    text[:3000],
+		"zeros":    make([]byte, 1<<20),
+		"periodic": bytes.Repeat([]byte("abcabcabd"), 50000),
+		"random":   random,
+		"text":     text,
+	} {
+		out := runReference(t, "-decode", Encode(data))
+		if !bytes.Equal(out, data) {
+			t.Fatalf("%s: reference decoder returned %d bytes, want %d", name, len(out), len(data))
+		}
+		out, err := Decode(runReference(t, "-encode", data), len(data))
+		if err != nil {
+			t.Fatalf("%s: decoding the reference encoding: %v", name, err)
+		}
+		if !bytes.Equal(out, data) {
+			t.Fatalf("%s: decoding the reference encoding returned %d bytes, want %d", name, len(out), len(data))
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package lzfse
+
+import "encoding/binary"
+
+// decodeLZVN decodes the payload of an LZVN block that expands to nRaw
+// bytes and appends the result to dst.
+//
+// Each opcode is followed by the literals it copies, which come before its
+// match. Opcodes without a distance reuse the previous one.
+func decodeLZVN(dst, src []byte, nRaw int) ([]byte, error) {
+	end := len(dst) + nRaw
+	d := 0
+	for p := 0; ; {
+		if p >= len(src) {
+			return nil, ErrCorrupt
+		}
+		op := int(src[p])
+		var l, m, n int
+		switch {
+		case op == 0x06: // end of stream
+			if len(dst) != end {
+				return nil, ErrCorrupt
+			}
+			return dst, nil
+		case op == 0x0e || op == 0x16: // nop
+			p++
+			continue
+		case op == 0xf0: // large match
+			n, m = 2, 16
+			if p+1 < len(src) {
+				m += int(src[p+1])
+			}
+		case op > 0xf0: // small match
+			n, m = 1, op&0xf
+		case op == 0xe0: // large literal
+			n, l = 2, 16
+			if p+1 < len(src) {
+				l += int(src[p+1])
+			}
+		case op > 0xe0: // small literal
+			n, l = 1, op&0xf
+		case op >= 0xd0 || op >= 0x70 && op < 0x80:
+			return nil, ErrCorrupt
+		case op >= 0xa0 && op < 0xc0: // medium distance
+			if p+3 > len(src) {
+				return nil, ErrCorrupt
+			}
+			w := int(binary.LittleEndian.Uint16(src[p+1:]))
+			n, l, m, d = 3, op>>3&3, (op&7<<2|w&3)+3, w>>2
+		default:
+			l, m = op>>6, op>>3&7+3
+			switch op & 7 {
+			case 6: // previous distance
+				if op < 0x40 {
+					return nil, ErrCorrupt
+				}
+				n = 1
+			case 7: // large distance
+				if p+3 > len(src) {
+					return nil, ErrCorrupt
+				}
+				n, d = 3, int(binary.LittleEndian.Uint16(src[p+1:]))
+			default: // small distance
+				if p+2 > len(src) {
+					return nil, ErrCorrupt
+				}
+				n, d = 2, (op&7)<<8|int(src[p+1])
+			}
+		}
+		if p+n+l > len(src) || len(dst)+l+m > end {
+			return nil, ErrCorrupt
+		}
+		dst = append(dst, src[p+n:p+n+l]...)
+		p += n + l
+		if m > 0 {
+			var err error
+			if dst, err = copyMatch(dst, d, m); err != nil {
+				return nil, err
+			}
+		}
+	}
+}
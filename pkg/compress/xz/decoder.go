@@ -0,0 +1,195 @@
+package xz
+
+// decodeLZMA2 decodes an LZMA2 stream from src and appends the result to
+// dst. It returns the extended buffer and the number of bytes of src that
+// were used.
+func decodeLZMA2(dst, src []byte, dictSize int) ([]byte, int, error) {
+	var (
+		m              model
+		pos            int
+		dictStart      = -1
+		needProps      = true
+		needStateReset = true
+	)
+	for {
+		if pos >= len(src) {
+			return nil, 0, ErrCorrupt
+		}
+		ctrl := src[pos]
+		switch {
+		case ctrl == 0:
+			return dst, pos + 1, nil
+
+		case ctrl == 1 || ctrl == 2:
+			if pos+3 > len(src) {
+				return nil, 0, ErrCorrupt
+			}
+			n := int(src[pos+1])<<8 | int(src[pos+2]) + 1
+			pos += 3
+			if ctrl == 1 {
+				dictStart = len(dst)
+			} else if dictStart < 0 {
+				return nil, 0, ErrCorrupt
+			}
+			if pos+n > len(src) {
+				return nil, 0, ErrCorrupt
+			}
+			dst = append(dst, src[pos:pos+n]...)
+			pos += n
+
+		case ctrl >= 0x80:
+			if pos+5 > len(src) {
+				return nil, 0, ErrCorrupt
+			}
+			unpacked := int(ctrl&0x1f)<<16 | int(src[pos+1])<<8 | int(src[pos+2]) + 1
+			packed := int(src[pos+3])<<8 | int(src[pos+4]) + 1
+			pos += 5
+
+			mode := ctrl >> 5 & 3
+			if mode == 3 {
+				dictStart = len(dst)
+			} else if dictStart < 0 {
+				return nil, 0, ErrCorrupt
+			}
+			if mode >= 2 {
+				if pos >= len(src) {
+					return nil, 0, ErrCorrupt
+				}
+				if err := m.setProps(src[pos]); err != nil {
+					return nil, 0, err
+				}
+				pos++
+				needProps = false
+			} else if needProps {
+				return nil, 0, ErrCorrupt
+			}
+			if mode >= 1 {
+				m.reset()
+				needStateReset = false
+			} else if needStateReset {
+				return nil, 0, ErrCorrupt
+			}
+
+			if pos+packed > len(src) {
+				return nil, 0, ErrCorrupt
+			}
+			var err error
+			if dst, err = decodeLZMAChunk(&m, dst, src[pos:pos+packed], dictStart, dictSize, unpacked); err != nil {
+				return nil, 0, err
+			}
+			pos += packed
+
+		default:
+			return nil, 0, ErrCorrupt
+		}
+	}
+}
+
+// decodeLZMAChunk decodes unpacked bytes of LZMA data from src and appends
+// them to dst. dst[dictStart:] is the dictionary matches may refer to.
+func decodeLZMAChunk(m *model, dst, src []byte, dictStart, dictSize, unpacked int) ([]byte, error) {
+	rc, err := newRangeDecoder(src)
+	if err != nil {
+		return nil, err
+	}
+	end := len(dst) + unpacked
+	for len(dst) < end {
+		pos := uint32(len(dst) - dictStart)
+		ps := int(m.posState(pos))
+
+		if rc.decodeBit(&m.isMatch[m.state<<posBitsMax|ps]) == 0 {
+			var prevByte byte
+			if pos > 0 {
+				prevByte = dst[len(dst)-1]
+			}
+			probs := m.literalProbs(pos, prevByte)
+			sym := uint32(1)
+			if m.state >= 7 {
+				matchByte := uint32(dst[len(dst)-int(m.reps[0])-1])
+				for sym < 0x100 {
+					matchBit := matchByte >> 7 & 1
+					matchByte <<= 1
+					bit := rc.decodeBit(&probs[(1+matchBit)<<8+sym])
+					sym = sym<<1 | bit
+					if matchBit != bit {
+						break
+					}
+				}
+			}
+			for sym < 0x100 {
+				sym = sym<<1 | rc.decodeBit(&probs[sym])
+			}
+			dst = append(dst, byte(sym))
+			m.updateLiteral()
+			continue
+		}
+
+		var length int
+		if rc.decodeBit(&m.isRep[m.state]) == 0 {
+			length = decodeLen(rc, &m.length, uint32(ps))
+			slot := rc.decodeTree(m.posSlot[min(length-matchMinLen, numLenToPosStates-1)][:], 6)
+			dist := slot
+			if slot >= 4 {
+				footerBits := uint(slot>>1 - 1)
+				dist = (2 | slot&1) << footerBits
+				if slot < endPosModelIndex {
+					dist += rc.decodeReverseTree(m.posSpecial[dist-slot:], footerBits)
+				} else {
+					dist += rc.decodeDirect(footerBits-numAlignBits) << numAlignBits
+					dist += rc.decodeReverseTree(m.align[:], numAlignBits)
+				}
+			}
+			m.reps = [4]uint32{dist, m.reps[0], m.reps[1], m.reps[2]}
+			m.updateMatch()
+			if dist == 0xffffffff {
+				// The end marker, which LZMA2 does not use.
+				return nil, ErrCorrupt
+			}
+		} else {
+			if rc.decodeBit(&m.isRepG0[m.state]) == 0 {
+				if rc.decodeBit(&m.isRep0Long[m.state<<posBitsMax|ps]) == 0 {
+					m.updateShortRep()
+					if int(m.reps[0]) >= int(pos) {
+						return nil, ErrCorrupt
+					}
+					dst = append(dst, dst[len(dst)-int(m.reps[0])-1])
+					continue
+				}
+			} else {
+				var rep int
+				if rc.decodeBit(&m.isRepG1[m.state]) == 0 {
+					rep = 1
+				} else {
+					rep = 2 + int(rc.decodeBit(&m.isRepG2[m.state]))
+				}
+				dist := m.reps[rep]
+				copy(m.reps[1:rep+1], m.reps[:rep])
+				m.reps[0] = dist
+			}
+			length = decodeLen(rc, &m.repLength, uint32(ps))
+			m.updateRep()
+		}
+
+		dist := int(m.reps[0]) + 1
+		if dist > int(pos) || dist > dictSize || len(dst)+length > end {
+			return nil, ErrCorrupt
+		}
+		for i := 0; i < length; i++ {
+			dst = append(dst, dst[len(dst)-dist])
+		}
+	}
+	if rc.overrun() {
+		return nil, ErrCorrupt
+	}
+	return dst, nil
+}
+
+func decodeLen(rc *rangeDecoder, c *lenCoder, posState uint32) int {
+	if rc.decodeBit(&c.choice) == 0 {
+		return matchMinLen + int(rc.decodeTree(c.low[posState][:], 3))
+	}
+	if rc.decodeBit(&c.choice2) == 0 {
+		return matchMinLen + 8 + int(rc.decodeTree(c.mid[posState][:], 3))
+	}
+	return matchMinLen + 16 + int(rc.decodeTree(c.high[:], 8))
+}
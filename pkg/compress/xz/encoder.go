@@ -0,0 +1,344 @@
+package xz
+
+const (
+	hashBits = 17
+	// maxChunkUnpacked is the largest amount of data one LZMA2 chunk
+	// decodes to.
+	maxChunkUnpacked = 1 << 21
+	// chunkMargin is room left in a compressed chunk for the next
+	// operation and the final flush of the range coder.
+	chunkMargin = 64
+)
+
+// levelParams are the match finder settings of a compression level: how
+// many earlier positions are tried, the length at which the search stops
+// and whether a match is deferred when the next position has a longer one.
+type levelParams struct {
+	depth   int
+	niceLen int
+	lazy    bool
+}
+
+var levels = [...]levelParams{
+	1: {4, 16, false},
+	2: {8, 24, false},
+	3: {12, 32, true},
+	4: {16, 48, true},
+	5: {24, 64, true},
+	6: {32, 96, true},
+	7: {64, 128, true},
+	8: {128, 192, true},
+	9: {256, matchMaxLen, true},
+}
+
+// lzma2Encoder compresses src as an LZMA2 stream with a single dictionary
+// covering all of src.
+type lzma2Encoder struct {
+	src      []byte
+	dictSize int
+	params   levelParams
+
+	m  model
+	rc *rangeEncoder
+
+	head     []int32
+	prev     []int32
+	inserted int
+
+	out            []byte
+	chunkStart     int
+	needDictReset  bool
+	needProps      bool
+	needStateReset bool
+}
+
+func encodeLZMA2(src []byte, dictSize, level int) []byte {
+	e := &lzma2Encoder{
+		src:           src,
+		dictSize:      dictSize,
+		params:        levels[level],
+		rc:            newRangeEncoder(),
+		head:          make([]int32, 1<<hashBits),
+		prev:          make([]int32, len(src)),
+		needDictReset: true,
+		needProps:     true,
+	}
+	e.m.lc, e.m.lp, e.m.pb = defaultLC, defaultLP, defaultPB
+	e.m.reset()
+	for i := range e.head {
+		e.head[i] = -1
+	}
+
+	for pos := 0; pos < len(src); {
+		pos += e.encodeNext(pos)
+	}
+	e.flushChunk(len(src))
+	return append(e.out, 0)
+}
+
+func hash4(b []byte) uint32 {
+	return (uint32(b[0]) | uint32(b[1])<<8 | uint32(b[2])<<16 | uint32(b[3])<<24) * 2654435761 >> (32 - hashBits)
+}
+
+// insertUpTo adds the positions before end to the hash chains.
+func (e *lzma2Encoder) insertUpTo(end int) {
+	for ; e.inserted < end; e.inserted++ {
+		p := e.inserted
+		if p+4 > len(e.src) {
+			continue
+		}
+		h := hash4(e.src[p:])
+		e.prev[p] = e.head[h]
+		e.head[h] = int32(p)
+	}
+}
+
+func (e *lzma2Encoder) matchLen(pos, dist, limit int) int {
+	n := 0
+	for n < limit && e.src[pos+n] == e.src[pos-dist+n] {
+		n++
+	}
+	return n
+}
+
+// findMatch returns the longest earlier match of at least 4 bytes at pos
+// and its distance.
+func (e *lzma2Encoder) findMatch(pos int) (length, dist int) {
+	if pos+4 > len(e.src) {
+		return 0, 0
+	}
+	e.insertUpTo(pos)
+	limit := min(len(e.src)-pos, matchMaxLen)
+	cand := e.head[hash4(e.src[pos:])]
+	for depth := e.params.depth; cand >= 0 && depth > 0; depth-- {
+		d := pos - int(cand)
+		if d > e.dictSize {
+			break
+		}
+		if e.src[int(cand)+length] == e.src[pos+length] {
+			if n := e.matchLen(pos, d, limit); n > length {
+				length, dist = n, d
+				if n >= e.params.niceLen || n == limit {
+					break
+				}
+			}
+		}
+		cand = e.prev[cand]
+	}
+	if length < 4 {
+		return 0, 0
+	}
+	return length, dist
+}
+
+// bestRep returns the longest match at pos against the last four
+// distances.
+func (e *lzma2Encoder) bestRep(pos int) (length, rep int) {
+	limit := min(len(e.src)-pos, matchMaxLen)
+	for i, r := range e.m.reps {
+		d := int(r) + 1
+		if d > pos {
+			continue
+		}
+		if n := e.matchLen(pos, d, limit); n > length {
+			length, rep = n, i
+		}
+	}
+	return length, rep
+}
+
+// encodeNext chooses and codes the operation at pos and returns how many
+// bytes it covers.
+func (e *lzma2Encoder) encodeNext(pos int) int {
+	repLen, rep := e.bestRep(pos)
+	mainLen, mainDist := e.findMatch(pos)
+
+	switch {
+	case repLen >= matchMinLen && repLen+1 >= mainLen:
+		e.startOp(pos, repLen)
+		e.encodeRep(pos, rep, repLen)
+		return repLen
+	case mainLen > 0:
+		if e.params.lazy && mainLen < e.params.niceLen && pos+1 < len(e.src) {
+			if nextLen, _ := e.findMatch(pos + 1); nextLen > mainLen+1 {
+				break
+			}
+		}
+		e.startOp(pos, mainLen)
+		e.encodeMatch(pos, uint32(mainDist-1), mainLen)
+		return mainLen
+	}
+
+	e.startOp(pos, 1)
+	if d := int(e.m.reps[0]) + 1; d <= pos && e.src[pos] == e.src[pos-d] {
+		e.encodeShortRep(pos)
+	} else {
+		e.encodeLiteral(pos)
+	}
+	return 1
+}
+
+// startOp ends the current chunk when an operation of n bytes at pos would
+// not fit.
+func (e *lzma2Encoder) startOp(pos, n int) {
+	if pos+n-e.chunkStart > maxChunkUnpacked || e.rc.pending() > chunkSize-chunkMargin {
+		e.flushChunk(pos)
+	}
+}
+
+func (e *lzma2Encoder) encodeLiteral(pos int) {
+	m := &e.m
+	ps := m.posState(uint32(pos))
+	e.rc.encodeBit(&m.isMatch[m.state<<posBitsMax|int(ps)], 0)
+
+	var prevByte byte
+	if pos > 0 {
+		prevByte = e.src[pos-1]
+	}
+	probs := m.literalProbs(uint32(pos), prevByte)
+	b := uint32(e.src[pos])
+	if m.state < 7 {
+		e.rc.encodeTree(probs, 8, b)
+	} else {
+		matchByte := uint32(e.src[pos-int(m.reps[0])-1])
+		sym := uint32(1)
+		i := 7
+		for ; i >= 0; i-- {
+			bit := b >> uint(i) & 1
+			matchBit := matchByte >> uint(i) & 1
+			e.rc.encodeBit(&probs[(1+matchBit)<<8+sym], bit)
+			sym = sym<<1 | bit
+			if matchBit != bit {
+				i--
+				break
+			}
+		}
+		for ; i >= 0; i-- {
+			bit := b >> uint(i) & 1
+			e.rc.encodeBit(&probs[sym], bit)
+			sym = sym<<1 | bit
+		}
+	}
+	m.updateLiteral()
+}
+
+func (e *lzma2Encoder) encodeMatch(pos int, dist uint32, length int) {
+	m := &e.m
+	ps := m.posState(uint32(pos))
+	e.rc.encodeBit(&m.isMatch[m.state<<posBitsMax|int(ps)], 1)
+	e.rc.encodeBit(&m.isRep[m.state], 0)
+	e.encodeLen(&m.length, uint32(length-matchMinLen), ps)
+
+	slot := posSlotOf(dist)
+	e.rc.encodeTree(m.posSlot[min(length-matchMinLen, numLenToPosStates-1)][:], 6, slot)
+	if slot >= 4 {
+		footerBits := uint(slot>>1 - 1)
+		base := (2 | slot&1) << footerBits
+		reduced := dist - base
+		if slot < endPosModelIndex {
+			e.rc.encodeReverseTree(m.posSpecial[base-slot:], footerBits, reduced)
+		} else {
+			e.rc.encodeDirect(reduced>>numAlignBits, footerBits-numAlignBits)
+			e.rc.encodeReverseTree(m.align[:], numAlignBits, reduced&(1<<numAlignBits-1))
+		}
+	}
+	m.reps = [4]uint32{dist, m.reps[0], m.reps[1], m.reps[2]}
+	m.updateMatch()
+}
+
+func (e *lzma2Encoder) encodeShortRep(pos int) {
+	m := &e.m
+	ps := int(m.posState(uint32(pos)))
+	e.rc.encodeBit(&m.isMatch[m.state<<posBitsMax|ps], 1)
+	e.rc.encodeBit(&m.isRep[m.state], 1)
+	e.rc.encodeBit(&m.isRepG0[m.state], 0)
+	e.rc.encodeBit(&m.isRep0Long[m.state<<posBitsMax|ps], 0)
+	m.updateShortRep()
+}
+
+func (e *lzma2Encoder) encodeRep(pos, rep, length int) {
+	m := &e.m
+	ps := m.posState(uint32(pos))
+	e.rc.encodeBit(&m.isMatch[m.state<<posBitsMax|int(ps)], 1)
+	e.rc.encodeBit(&m.isRep[m.state], 1)
+	if rep == 0 {
+		e.rc.encodeBit(&m.isRepG0[m.state], 0)
+		e.rc.encodeBit(&m.isRep0Long[m.state<<posBitsMax|int(ps)], 1)
+	} else {
+		e.rc.encodeBit(&m.isRepG0[m.state], 1)
+		if rep == 1 {
+			e.rc.encodeBit(&m.isRepG1[m.state], 0)
+		} else {
+			e.rc.encodeBit(&m.isRepG1[m.state], 1)
+			e.rc.encodeBit(&m.isRepG2[m.state], uint32(rep-2))
+		}
+		dist := m.reps[rep]
+		copy(m.reps[1:rep+1], m.reps[:rep])
+		m.reps[0] = dist
+	}
+	e.encodeLen(&m.repLength, uint32(length-matchMinLen), ps)
+	m.updateRep()
+}
+
+func (e *lzma2Encoder) encodeLen(c *lenCoder, v, posState uint32) {
+	switch {
+	case v < 8:
+		e.rc.encodeBit(&c.choice, 0)
+		e.rc.encodeTree(c.low[posState][:], 3, v)
+	case v < 16:
+		e.rc.encodeBit(&c.choice, 1)
+		e.rc.encodeBit(&c.choice2, 0)
+		e.rc.encodeTree(c.mid[posState][:], 3, v-8)
+	default:
+		e.rc.encodeBit(&c.choice, 1)
+		e.rc.encodeBit(&c.choice2, 1)
+		e.rc.encodeTree(c.high[:], 8, v-16)
+	}
+}
+
+// flushChunk writes the data from the start of the chunk up to end as an
+// LZMA chunk, or as uncompressed chunks when that is smaller, and starts
+// the next chunk.
+func (e *lzma2Encoder) flushChunk(end int) {
+	unpacked := end - e.chunkStart
+	if unpacked == 0 {
+		return
+	}
+	packed := e.rc.flush()
+	if len(packed)+2 >= unpacked {
+		for off := e.chunkStart; off < end; off += chunkSize {
+			n := min(end-off, chunkSize)
+			ctrl := byte(2)
+			if e.needDictReset {
+				ctrl = 1
+				e.needDictReset = false
+			}
+			e.out = append(e.out, ctrl, byte((n-1)>>8), byte(n-1))
+			e.out = append(e.out, e.src[off:off+n]...)
+		}
+		// The probabilities moved on while coding the chunk that was
+		// thrown away, so the next LZMA chunk starts over.
+		e.needStateReset = true
+		e.m.reset()
+	} else {
+		var mode byte
+		switch {
+		case e.needDictReset:
+			mode = 3
+		case e.needProps:
+			mode = 2
+		case e.needStateReset:
+			mode = 1
+		}
+		e.out = append(e.out,
+			0x80|mode<<5|byte((unpacked-1)>>16), byte((unpacked-1)>>8), byte(unpacked-1),
+			byte((len(packed)-1)>>8), byte(len(packed)-1))
+		if mode >= 2 {
+			e.out = append(e.out, e.m.props())
+		}
+		e.out = append(e.out, packed...)
+		e.needDictReset, e.needProps, e.needStateReset = false, false, false
+	}
+	e.rc = newRangeEncoder()
+	e.chunkStart = end
+}
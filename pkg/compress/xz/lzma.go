@@ -0,0 +1,163 @@
+package xz
+
+import "math/bits"
+
+const (
+	numStates         = 12
+	posBitsMax        = 4
+	matchMinLen       = 2
+	matchMaxLen       = 273
+	numLenToPosStates = 4
+	endPosModelIndex  = 14
+	numFullDistances  = 1 << (endPosModelIndex >> 1)
+	numAlignBits      = 4
+
+	// The literal context and position bits the encoder uses, the xz
+	// defaults.
+	defaultLC = 3
+	defaultLP = 0
+	defaultPB = 2
+)
+
+// lenCoder codes match lengths minus matchMinLen.
+type lenCoder struct {
+	choice  prob
+	choice2 prob
+	low     [1 << posBitsMax][1 << 3]prob
+	mid     [1 << posBitsMax][1 << 3]prob
+	high    [1 << 8]prob
+}
+
+// model is the LZMA coder state shared by the encoder and the decoder: the
+// literal context parameters, the last four distances and the adaptive
+// probabilities.
+type model struct {
+	lc, lp, pb uint
+
+	state int
+	reps  [4]uint32
+
+	isMatch    [numStates << posBitsMax]prob
+	isRep      [numStates]prob
+	isRepG0    [numStates]prob
+	isRepG1    [numStates]prob
+	isRepG2    [numStates]prob
+	isRep0Long [numStates << posBitsMax]prob
+	posSlot    [numLenToPosStates][1 << 6]prob
+	posSpecial [1 + numFullDistances - endPosModelIndex]prob
+	align      [1 << numAlignBits]prob
+	length     lenCoder
+	repLength  lenCoder
+	literal    []prob
+}
+
+// props returns the LZMA properties byte of the literal parameters.
+func (m *model) props() byte {
+	return byte((m.pb*5+m.lp)*9 + m.lc)
+}
+
+// setProps decodes an LZMA properties byte.
+func (m *model) setProps(b byte) error {
+	if b >= 9*5*5 {
+		return ErrCorrupt
+	}
+	lc, lp, pb := uint(b%9), uint(b/9%5), uint(b/45)
+	// LZMA2 limits the literal context to 4 bits.
+	if lc+lp > 4 {
+		return ErrCorrupt
+	}
+	m.lc, m.lp, m.pb = lc, lp, pb
+	return nil
+}
+
+// reset returns the state and all probabilities to their initial values.
+func (m *model) reset() {
+	m.state = 0
+	m.reps = [4]uint32{}
+	for _, p := range [][]prob{
+		m.isMatch[:], m.isRep[:], m.isRepG0[:], m.isRepG1[:], m.isRepG2[:], m.isRep0Long[:],
+		m.posSlot[0][:], m.posSlot[1][:], m.posSlot[2][:], m.posSlot[3][:],
+		m.posSpecial[:], m.align[:],
+	} {
+		fill(p)
+	}
+	for _, c := range []*lenCoder{&m.length, &m.repLength} {
+		c.choice, c.choice2 = probInit, probInit
+		for i := range c.low {
+			fill(c.low[i][:])
+			fill(c.mid[i][:])
+		}
+		fill(c.high[:])
+	}
+	if n := 0x300 << (m.lc + m.lp); len(m.literal) != n {
+		m.literal = make([]prob, n)
+	}
+	fill(m.literal)
+}
+
+func fill(p []prob) {
+	for i := range p {
+		p[i] = probInit
+	}
+}
+
+// literalProbs returns the probabilities of a literal at position pos that
+// follows prev.
+func (m *model) literalProbs(pos uint32, prev byte) []prob {
+	ctx := (pos&(1<<m.lp-1))<<m.lc + uint32(prev)>>(8-m.lc)
+	return m.literal[0x300*ctx : 0x300*(ctx+1)]
+}
+
+func (m *model) posState(pos uint32) uint32 {
+	return pos & (1<<m.pb - 1)
+}
+
+func (m *model) updateLiteral() {
+	switch {
+	case m.state < 4:
+		m.state = 0
+	case m.state < 10:
+		m.state -= 3
+	default:
+		m.state -= 6
+	}
+}
+
+func (m *model) updateMatch() {
+	m.state = next(m.state, 7, 10)
+}
+
+func (m *model) updateRep() {
+	m.state = next(m.state, 8, 11)
+}
+
+func (m *model) updateShortRep() {
+	m.state = next(m.state, 9, 11)
+}
+
+func next(state, afterLiteral, afterMatch int) int {
+	if state < 7 {
+		return afterLiteral
+	}
+	return afterMatch
+}
+
+// posSlotOf returns the slot of a zero-based match distance.
+func posSlotOf(dist uint32) uint32 {
+	if dist < 4 {
+		return dist
+	}
+	n := uint32(bits.Len32(dist)) - 1
+	return 2*n + dist>>(n-1)&1
+}
+
+// dictSizeProp returns the smallest xz dictionary size property for a
+// dictionary of at least size bytes, and the size it stands for.
+func dictSizeProp(size int) (byte, int) {
+	for b := byte(0); b < 40; b++ {
+		if n := (2 | int(b)&1) << (b/2 + 11); n >= size {
+			return b, n
+		}
+	}
+	return 40, 0xffffffff
+}
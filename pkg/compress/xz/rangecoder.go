@@ -0,0 +1,195 @@
+package xz
+
+// prob is an adaptive probability of a bit being 0, out of 1<<11.
+type prob uint16
+
+const (
+	probBits  = 11
+	probInit  = 1 << (probBits - 1)
+	moveBits  = 5
+	topValue  = 1 << 24
+	chunkSize = 1 << 16 // largest compressed LZMA2 chunk
+)
+
+// rangeEncoder is the LZMA range coder. Each LZMA2 chunk is coded with a
+// fresh encoder.
+type rangeEncoder struct {
+	low       uint64
+	rng       uint32
+	cache     byte
+	cacheSize int
+	out       []byte
+}
+
+func newRangeEncoder() *rangeEncoder {
+	return &rangeEncoder{rng: 0xffffffff, cacheSize: 1}
+}
+
+func (e *rangeEncoder) shiftLow() {
+	if uint32(e.low) < 0xff000000 || e.low>>32 != 0 {
+		carry := byte(e.low >> 32)
+		temp := e.cache
+		for {
+			e.out = append(e.out, temp+carry)
+			temp = 0xff
+			if e.cacheSize--; e.cacheSize == 0 {
+				break
+			}
+		}
+		e.cache = byte(e.low >> 24)
+	}
+	e.cacheSize++
+	e.low = (e.low & 0x00ffffff) << 8
+}
+
+func (e *rangeEncoder) encodeBit(p *prob, bit uint32) {
+	bound := (e.rng >> probBits) * uint32(*p)
+	if bit == 0 {
+		e.rng = bound
+		*p += (1<<probBits - *p) >> moveBits
+	} else {
+		e.low += uint64(bound)
+		e.rng -= bound
+		*p -= *p >> moveBits
+	}
+	for e.rng < topValue {
+		e.rng <<= 8
+		e.shiftLow()
+	}
+}
+
+// encodeDirect writes the low n bits of v with a fixed probability of 1/2.
+func (e *rangeEncoder) encodeDirect(v uint32, n uint) {
+	for i := int(n) - 1; i >= 0; i-- {
+		e.rng >>= 1
+		if v>>uint(i)&1 != 0 {
+			e.low += uint64(e.rng)
+		}
+		for e.rng < topValue {
+			e.rng <<= 8
+			e.shiftLow()
+		}
+	}
+}
+
+// encodeTree writes the low n bits of v, most significant first, with the
+// bit tree probs indexed from 1.
+func (e *rangeEncoder) encodeTree(probs []prob, n uint, v uint32) {
+	m := uint32(1)
+	for i := int(n) - 1; i >= 0; i-- {
+		bit := v >> uint(i) & 1
+		e.encodeBit(&probs[m], bit)
+		m = m<<1 | bit
+	}
+}
+
+// encodeReverseTree is encodeTree starting with the least significant bit.
+func (e *rangeEncoder) encodeReverseTree(probs []prob, n uint, v uint32) {
+	m := uint32(1)
+	for i := uint(0); i < n; i++ {
+		bit := v & 1
+		v >>= 1
+		e.encodeBit(&probs[m], bit)
+		m = m<<1 | bit
+	}
+}
+
+// pending returns the number of bytes the chunk takes once flushed.
+func (e *rangeEncoder) pending() int {
+	return len(e.out) + e.cacheSize + 4
+}
+
+func (e *rangeEncoder) flush() []byte {
+	for i := 0; i < 5; i++ {
+		e.shiftLow()
+	}
+	return e.out
+}
+
+// rangeDecoder decodes the bits of one LZMA2 chunk.
+type rangeDecoder struct {
+	src  []byte
+	pos  int
+	rng  uint32
+	code uint32
+}
+
+func newRangeDecoder(src []byte) (*rangeDecoder, error) {
+	if len(src) < 5 || src[0] != 0 {
+		return nil, ErrCorrupt
+	}
+	d := &rangeDecoder{src: src, pos: 5, rng: 0xffffffff}
+	for _, b := range src[1:5] {
+		d.code = d.code<<8 | uint32(b)
+	}
+	if d.code == d.rng {
+		return nil, ErrCorrupt
+	}
+	return d, nil
+}
+
+func (d *rangeDecoder) normalize() {
+	if d.rng < topValue {
+		d.rng <<= 8
+		var b byte
+		// Reading past the end is detected by the caller through pos.
+		if d.pos < len(d.src) {
+			b = d.src[d.pos]
+		}
+		d.pos++
+		d.code = d.code<<8 | uint32(b)
+	}
+}
+
+func (d *rangeDecoder) decodeBit(p *prob) uint32 {
+	bound := (d.rng >> probBits) * uint32(*p)
+	var bit uint32
+	if d.code < bound {
+		d.rng = bound
+		*p += (1<<probBits - *p) >> moveBits
+	} else {
+		d.rng -= bound
+		d.code -= bound
+		*p -= *p >> moveBits
+		bit = 1
+	}
+	d.normalize()
+	return bit
+}
+
+func (d *rangeDecoder) decodeDirect(n uint) uint32 {
+	var v uint32
+	for ; n > 0; n-- {
+		d.rng >>= 1
+		d.code -= d.rng
+		t := 0 - d.code>>31
+		d.code += d.rng & t
+		d.normalize()
+		v = v<<1 + t + 1
+	}
+	return v
+}
+
+func (d *rangeDecoder) decodeTree(probs []prob, n uint) uint32 {
+	m := uint32(1)
+	for i := uint(0); i < n; i++ {
+		m = m<<1 | d.decodeBit(&probs[m])
+	}
+	return m - 1<<n
+}
+
+func (d *rangeDecoder) decodeReverseTree(probs []prob, n uint) uint32 {
+	m := uint32(1)
+	var v uint32
+	for i := uint(0); i < n; i++ {
+		bit := d.decodeBit(&probs[m])
+		m = m<<1 | bit
+		v |= bit << i
+	}
+	return v
+}
+
+// overrun reports whether the decoder read past the end of the chunk.
+func (d *rangeDecoder) overrun() bool {
+	return d.pos > len(d.src)
+}
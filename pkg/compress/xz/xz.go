@@ -0,0 +1,276 @@
+// Package xz implements an encoder and a decoder for the xz format with the
+// LZMA2 filter, the compression used by the chunks of ULMO disk images.
+package xz
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"hash/crc64"
+)
+
+// ErrCorrupt is returned when the input is not a valid xz stream, uses a
+// filter other than LZMA2 or fails its integrity check.
+var ErrCorrupt = errors.New("xz: corrupt input")
+
+var (
+	headerMagic = []byte{0xfd, '7', 'z', 'X', 'Z', 0}
+	footerMagic = []byte{'Y', 'Z'}
+	crc64Table  = crc64.MakeTable(crc64.ECMA)
+)
+
+// Check types of the stream flags.
+const (
+	checkNone   = 0x00
+	checkCRC32  = 0x01
+	checkCRC64  = 0x04
+	checkSHA256 = 0x0a
+
+	filterLZMA2 = 0x21
+)
+
+// Encode compresses src into a single xz stream with a CRC64 check. level
+// ranges from 1 (fastest) to 9 (best compression).
+func Encode(src []byte, level int) ([]byte, error) {
+	if level < 1 || level > 9 {
+		return nil, errors.New("xz: invalid compression level")
+	}
+	dictProp, dictSize := dictSizeProp(max(len(src), 4096))
+	data := encodeLZMA2(src, dictSize, level)
+
+	flags := []byte{0, checkCRC64}
+	out := append([]byte{}, headerMagic...)
+	out = append(out, flags...)
+	out = binary.LittleEndian.AppendUint32(out, crc32.ChecksumIEEE(flags))
+
+	// The block header records both sizes and the single LZMA2 filter.
+	header := []byte{0, 0xc0}
+	header = binary.AppendUvarint(header, uint64(len(data)))
+	header = binary.AppendUvarint(header, uint64(len(src)))
+	header = append(header, filterLZMA2, 1, dictProp)
+	for (len(header)+4)%4 != 0 {
+		header = append(header, 0)
+	}
+	header[0] = byte((len(header)+4)/4 - 1)
+	header = binary.LittleEndian.AppendUint32(header, crc32.ChecksumIEEE(header))
+
+	blockStart := len(out)
+	out = append(out, header...)
+	out = append(out, data...)
+	unpadded := len(out) - blockStart + 8
+	out = pad4(out, len(out)-blockStart)
+	out = binary.LittleEndian.AppendUint64(out, crc64.Checksum(src, crc64Table))
+
+	index := []byte{0, 1}
+	index = binary.AppendUvarint(index, uint64(unpadded))
+	index = binary.AppendUvarint(index, uint64(len(src)))
+	index = pad4(index, len(index))
+	index = binary.LittleEndian.AppendUint32(index, crc32.ChecksumIEEE(index))
+	out = append(out, index...)
+
+	footer := binary.LittleEndian.AppendUint32(nil, uint32(len(index)/4-1))
+	footer = append(footer, flags...)
+	out = binary.LittleEndian.AppendUint32(out, crc32.ChecksumIEEE(footer))
+	out = append(out, footer...)
+	return append(out, footerMagic...), nil
+}
+
+func pad4(b []byte, n int) []byte {
+	for ; n%4 != 0; n++ {
+		b = append(b, 0)
+	}
+	return b
+}
+
+// Decode decompresses the xz streams in src. sizeHint is used to
+// preallocate the output and may be zero.
+func Decode(src []byte, sizeHint int) ([]byte, error) {
+	dst := make([]byte, 0, sizeHint)
+	for len(src) > 0 {
+		var err error
+		if dst, src, err = decodeStream(dst, src); err != nil {
+			return nil, err
+		}
+		// Streams may be followed by padding in multiples of four zero
+		// bytes.
+		for len(src) >= 4 && bytes.Equal(src[:4], []byte{0, 0, 0, 0}) {
+			src = src[4:]
+		}
+	}
+	return dst, nil
+}
+
+// decodeStream decodes one stream from src, appends the result to dst and
+// returns the rest of src.
+func decodeStream(dst, src []byte) ([]byte, []byte, error) {
+	if len(src) < 12+12 || !bytes.Equal(src[:6], headerMagic) ||
+		crc32.ChecksumIEEE(src[6:8]) != binary.LittleEndian.Uint32(src[8:]) || src[6] != 0 {
+		return nil, nil, ErrCorrupt
+	}
+	check := src[7]
+	var checkSize int
+	switch check {
+	case checkNone:
+	case checkCRC32:
+		checkSize = 4
+	case checkCRC64:
+		checkSize = 8
+	case checkSHA256:
+		checkSize = 32
+	default:
+		return nil, nil, ErrCorrupt
+	}
+	flags := src[6:8]
+	src = src[12:]
+
+	var records [][2]uint64
+	for {
+		if len(src) == 0 {
+			return nil, nil, ErrCorrupt
+		}
+		if src[0] == 0 {
+			break
+		}
+		start := len(dst)
+		n, err := decodeBlock(dst, src, checkSize)
+		if err != nil {
+			return nil, nil, err
+		}
+		dst = n.dst
+		block := dst[start:]
+		sum := src[n.size-checkSize : n.size]
+		switch check {
+		case checkCRC32:
+			if crc32.ChecksumIEEE(block) != binary.LittleEndian.Uint32(sum) {
+				return nil, nil, ErrCorrupt
+			}
+		case checkCRC64:
+			if crc64.Checksum(block, crc64Table) != binary.LittleEndian.Uint64(sum) {
+				return nil, nil, ErrCorrupt
+			}
+		case checkSHA256:
+			if h := sha256.Sum256(block); !bytes.Equal(h[:], sum) {
+				return nil, nil, ErrCorrupt
+			}
+		}
+		records = append(records, [2]uint64{uint64(n.unpadded), uint64(len(block))})
+		src = src[n.size:]
+	}
+
+	indexSize, err := checkIndex(src, records)
+	if err != nil {
+		return nil, nil, err
+	}
+	src = src[indexSize:]
+	if len(src) < 12 || crc32.ChecksumIEEE(src[4:10]) != binary.LittleEndian.Uint32(src) ||
+		int(binary.LittleEndian.Uint32(src[4:])+1)*4 != indexSize ||
+		!bytes.Equal(src[8:10], flags) || !bytes.Equal(src[10:12], footerMagic) {
+		return nil, nil, ErrCorrupt
+	}
+	return dst, src[12:], nil
+}
+
+type decodedBlock struct {
+	dst []byte
+	// size is the size of the block including padding and check, unpadded
+	// the size without padding that the index records.
+	size, unpadded int
+}
+
+func decodeBlock(dst, src []byte, checkSize int) (decodedBlock, error) {
+	headerSize := (int(src[0]) + 1) * 4
+	if len(src) < headerSize || crc32.ChecksumIEEE(src[:headerSize-4]) != binary.LittleEndian.Uint32(src[headerSize-4:]) {
+		return decodedBlock{}, ErrCorrupt
+	}
+	header := src[2 : headerSize-4]
+	flags := src[1]
+	if flags&0x3c != 0 || flags&3 != 0 {
+		// Reserved bits, or more than the single LZMA2 filter.
+		return decodedBlock{}, ErrCorrupt
+	}
+	compressed, uncompressed := -1, -1
+	if flags&0x40 != 0 {
+		v, n := binary.Uvarint(header)
+		if n <= 0 || v == 0 {
+			return decodedBlock{}, ErrCorrupt
+		}
+		compressed, header = int(v), header[n:]
+	}
+	if flags&0x80 != 0 {
+		v, n := binary.Uvarint(header)
+		if n <= 0 {
+			return decodedBlock{}, ErrCorrupt
+		}
+		uncompressed, header = int(v), header[n:]
+	}
+	id, n := binary.Uvarint(header)
+	if n <= 0 || id != filterLZMA2 {
+		return decodedBlock{}, ErrCorrupt
+	}
+	header = header[n:]
+	if len(header) < 2 || header[0] != 1 || header[1] > 40 {
+		return decodedBlock{}, ErrCorrupt
+	}
+	dictSize := 0xffffffff
+	if header[1] < 40 {
+		dictSize = (2 | int(header[1])&1) << (header[1]/2 + 11)
+	}
+	for _, b := range header[2:] {
+		if b != 0 {
+			return decodedBlock{}, ErrCorrupt
+		}
+	}
+
+	start := len(dst)
+	dst, used, err := decodeLZMA2(dst, src[headerSize:], dictSize)
+	if err != nil {
+		return decodedBlock{}, err
+	}
+	if compressed >= 0 && used != compressed || uncompressed >= 0 && len(dst)-start != uncompressed {
+		return decodedBlock{}, ErrCorrupt
+	}
+	size := headerSize + used
+	unpadded := size + checkSize
+	for ; size%4 != 0; size++ {
+		if size >= len(src) || src[size] != 0 {
+			return decodedBlock{}, ErrCorrupt
+		}
+	}
+	size += checkSize
+	if size > len(src) {
+		return decodedBlock{}, ErrCorrupt
+	}
+	return decodedBlock{dst: dst, size: size, unpadded: unpadded}, nil
+}
+
+// checkIndex compares the index at the start of src with the blocks that
+// were decoded and returns its size.
+func checkIndex(src []byte, records [][2]uint64) (int, error) {
+	p := src[1:]
+	count, n := binary.Uvarint(p)
+	if n <= 0 || count != uint64(len(records)) {
+		return 0, ErrCorrupt
+	}
+	p = p[n:]
+	for _, r := range records {
+		for _, want := range r {
+			v, n := binary.Uvarint(p)
+			if n <= 0 || v != want {
+				return 0, ErrCorrupt
+			}
+			p = p[n:]
+		}
+	}
+	size := len(src) - len(p)
+	for ; size%4 != 0; size++ {
+		if size >= len(src) || src[size] != 0 {
+			return 0, ErrCorrupt
+		}
+	}
+	if size+4 > len(src) || crc32.ChecksumIEEE(src[:size]) != binary.LittleEndian.Uint32(src[size:]) {
+		return 0, ErrCorrupt
+	}
+	return size + 4, nil
+}
@@ -0,0 +1,158 @@
+package xz
+
+import (
+	"bytes"
+	"math/rand"
+	"os"
+	"os/exec"
+	"testing"
+)
+
+func testData() map[string][]byte {
+	rnd := rand.New(rand.NewSource(1))
+	random := make([]byte, 300000)
+	rnd.Read(random)
+	text := make([]byte, 0, 2500000)
+	for len(text) < 2400000 {
+		text = append(text, "the quick brown fox jumps over the lazy dog "[rnd.Intn(20):]...)
+	}
+	return map[string][]byte{
+		"empty":    nil,
+		"single":   []byte("a"),
+		"short":    []byte("banana"),
+		"zeros":    make([]byte, 1<<20),
+		"periodic": bytes.Repeat([]byte("abcabcabd"), 50000),
+		"random":   random,
+		"text":     text,
+		"mixed":    append(append(bytes.Repeat([]byte("xyz"), 30000), random[:100000]...), text[:100000]...),
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	for name, data := range testData() {
+		for _, level := range []int{1, 6, 9} {
+			enc, err := Encode(data, level)
+			if err != nil {
+				t.Fatal(err)
+			}
+			out, err := Decode(enc, len(data))
+			if err != nil {
+				t.Fatalf("%s level %d: %v", name, level, err)
+			}
+			if !bytes.Equal(out, data) {
+				t.Fatalf("%s level %d: round trip mismatch: got %d bytes, want %d", name, level, len(out), len(data))
+			}
+		}
+	}
+	if _, err := Encode(nil, 0); err == nil {
+		t.Fatal("expected an error for level 0")
+	}
+}
+
+func TestDecodeCorrupt(t *testing.T) {
+	enc, err := Encode(bytes.Repeat([]byte("hello, world "), 1000), 6)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, i := range []int{0, 7, 13, len(enc) / 2, len(enc) - 13, len(enc) - 1} {
+		bad := bytes.Clone(enc)
+		bad[i] ^= 0x55
+		if _, err := Decode(bad, 0); err != ErrCorrupt {
+			t.Errorf("byte %d flipped: expected ErrCorrupt, got %v", i, err)
+		}
+	}
+	if _, err := Decode(enc[:len(enc)-1], 0); err != ErrCorrupt {
+		t.Errorf("truncated: expected ErrCorrupt, got %v", err)
+	}
+}
+
+// TestDecodeReference decodes streams written by xz 5.6.4 (liblzma) from
+// testdata/input.bin: the default preset, the extreme preset with a SHA-256
+// check, several blocks, no check, and two streams with padding in between.
+func TestDecodeReference(t *testing.T) {
+	input, err := os.ReadFile("testdata/input.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range map[string][]byte{
+		"input-6.xz":              input,
+		"input-9e-sha256.xz":      input,
+		"input-1-crc32-blocks.xz": input,
+		"input-none.xz":           input,
+		"multi.xz":                append(bytes.Clone(input), input...),
+		"empty.xz":                nil,
+	} {
+		enc, err := os.ReadFile("testdata/" + name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		out, err := Decode(enc, 0)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if !bytes.Equal(out, want) {
+			t.Fatalf("%s: decoded %d bytes, want %d", name, len(out), len(want))
+		}
+	}
+}
+
+// TestEncodeReference compares the encoder with testdata/encoded-6.xz, its
+// output for testdata/input.bin at level 6, which xz -t accepted and xz -d
+// decoded to the input. Changes to the encoder must regenerate the file and
+// check it with xz again.
+func TestEncodeReference(t *testing.T) {
+	input, err := os.ReadFile("testdata/input.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := os.ReadFile("testdata/encoded-6.xz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	enc, err := Encode(input, 6)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(enc, want) {
+		t.Fatalf("encoded %d bytes that differ from the %d checked with xz", len(enc), len(want))
+	}
+}
+
+// TestXZCompatibility checks the encoder and the decoder against the xz
+// command, when it is installed.
+func TestXZCompatibility(t *testing.T) {
+	if _, err := exec.LookPath("xz"); err != nil {
+		t.Skip("xz is not installed")
+	}
+	for name, data := range testData() {
+		enc, err := Encode(data, 6)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cmd := exec.Command("xz", "-dc")
+		cmd.Stdin = bytes.NewReader(enc)
+		out, err := cmd.Output()
+		if err != nil {
+			t.Fatalf("%s: xz -d: %v", name, err)
+		}
+		if !bytes.Equal(out, data) {
+			t.Fatalf("%s: xz -d returned %d bytes, want %d", name, len(out), len(data))
+		}
+
+		for _, args := range [][]string{{"-c", "-1"}, {"-c", "-9e", "--check=sha256"}, {"-c", "--check=crc32", "--block-size=100000"}} {
+			cmd = exec.Command("xz", args...)
+			cmd.Stdin = bytes.NewReader(data)
+			enc, err = cmd.Output()
+			if err != nil {
+				t.Fatalf("%s: xz %v: %v", name, args, err)
+			}
+			out, err = Decode(enc, 0)
+			if err != nil {
+				t.Fatalf("%s: decoding xz %v: %v", name, args, err)
+			}
+			if !bytes.Equal(out, data) {
+				t.Fatalf("%s: decoding xz %v returned %d bytes, want %d", name, args, len(out), len(data))
+			}
+		}
+	}
+}
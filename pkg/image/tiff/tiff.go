@@ -0,0 +1,169 @@
+// Package tiff implements an encoder for multi-page TIFF files with
+// resolution metadata, as used for Retina images that hold the same picture
+// at several pixel densities.
+package tiff
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/draw"
+	"io"
+)
+
+// Page is an image together with its resolution in dots per inch. Finder
+// and other macOS consumers treat 72 DPI as 1x and 144 DPI as 2x.
+type Page struct {
+	Image image.Image
+	DPI   uint32
+}
+
+// TIFF tags written for each page.
+const (
+	tagImageWidth      = 256
+	tagImageLength     = 257
+	tagBitsPerSample   = 258
+	tagCompression     = 259
+	tagPhotometric     = 262
+	tagStripOffsets    = 273
+	tagSamplesPerPixel = 277
+	tagRowsPerStrip    = 278
+	tagStripByteCounts = 279
+	tagXResolution     = 282
+	tagYResolution     = 283
+	tagPlanarConfig    = 284
+	tagResolutionUnit  = 296
+	tagExtraSamples    = 338
+)
+
+// Field types.
+const (
+	typeShort    = 3
+	typeLong     = 4
+	typeRational = 5
+)
+
+const (
+	compressionDeflate = 8
+	photometricRGB     = 2
+	resolutionInch     = 2
+	unassociatedAlpha  = 2
+)
+
+type field struct {
+	tag, typ uint16
+	values   []uint32
+}
+
+// Encode writes the pages as one little-endian TIFF file. Every page is
+// stored as a single Deflate compressed strip of 8-bit RGBA samples.
+func Encode(w io.Writer, pages []Page) error {
+	if len(pages) == 0 {
+		return errors.New("tiff: no pages to encode")
+	}
+	le := binary.LittleEndian
+	out := []byte{'I', 'I', 42, 0, 0, 0, 0, 0}
+	next := 4 // offset of the pointer to the next IFD
+	for _, page := range pages {
+		b := page.Image.Bounds()
+		if b.Empty() {
+			return errors.New("tiff: empty image")
+		}
+		if page.DPI == 0 {
+			return errors.New("tiff: page without resolution")
+		}
+		nrgba := image.NewNRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+		draw.Draw(nrgba, nrgba.Bounds(), page.Image, b.Min, draw.Src)
+
+		var strip bytes.Buffer
+		zw := zlib.NewWriter(&strip)
+		if _, err := zw.Write(nrgba.Pix); err != nil {
+			return err
+		}
+		if err := zw.Close(); err != nil {
+			return err
+		}
+		out = align(out)
+		stripOffset := len(out)
+		out = append(out, strip.Bytes()...)
+
+		fields := []field{
+			{tagImageWidth, typeLong, []uint32{uint32(b.Dx())}},
+			{tagImageLength, typeLong, []uint32{uint32(b.Dy())}},
+			{tagBitsPerSample, typeShort, []uint32{8, 8, 8, 8}},
+			{tagCompression, typeShort, []uint32{compressionDeflate}},
+			{tagPhotometric, typeShort, []uint32{photometricRGB}},
+			{tagStripOffsets, typeLong, []uint32{uint32(stripOffset)}},
+			{tagSamplesPerPixel, typeShort, []uint32{4}},
+			{tagRowsPerStrip, typeLong, []uint32{uint32(b.Dy())}},
+			{tagStripByteCounts, typeLong, []uint32{uint32(strip.Len())}},
+			{tagXResolution, typeRational, []uint32{page.DPI, 1}},
+			{tagYResolution, typeRational, []uint32{page.DPI, 1}},
+			{tagPlanarConfig, typeShort, []uint32{1}},
+			{tagResolutionUnit, typeShort, []uint32{resolutionInch}},
+			{tagExtraSamples, typeShort, []uint32{unassociatedAlpha}},
+		}
+
+		// Values that do not fit the four bytes of an entry go before the
+		// IFD, which must start on a word boundary.
+		external := make([]uint32, len(fields))
+		for i, f := range fields {
+			if size(f) <= 4 {
+				continue
+			}
+			out = align(out)
+			external[i] = uint32(len(out))
+			out = appendValues(out, f)
+		}
+		out = align(out)
+		le.PutUint32(out[next:], uint32(len(out)))
+		out = le.AppendUint16(out, uint16(len(fields)))
+		for i, f := range fields {
+			out = le.AppendUint16(out, f.tag)
+			out = le.AppendUint16(out, f.typ)
+			count := len(f.values)
+			if f.typ == typeRational {
+				count /= 2
+			}
+			out = le.AppendUint32(out, uint32(count))
+			if size(f) > 4 {
+				out = le.AppendUint32(out, external[i])
+				continue
+			}
+			inline := appendValues(nil, f)
+			out = append(out, inline...)
+			out = append(out, make([]byte, 4-len(inline))...)
+		}
+		next = len(out)
+		out = le.AppendUint32(out, 0)
+	}
+	_, err := w.Write(out)
+	return err
+}
+
+func size(f field) int {
+	if f.typ == typeShort {
+		return 2 * len(f.values)
+	}
+	return 4 * len(f.values)
+}
+
+func appendValues(b []byte, f field) []byte {
+	for _, v := range f.values {
+		if f.typ == typeShort {
+			b = binary.LittleEndian.AppendUint16(b, uint16(v))
+		} else {
+			b = binary.LittleEndian.AppendUint32(b, v)
+		}
+	}
+	return b
+}
+
+func align(b []byte) []byte {
+	if len(b)%2 != 0 {
+		b = append(b, 0)
+	}
+	return b
+}
@@ -0,0 +1,73 @@
+package tiff
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"testing"
+
+	xtiff "golang.org/x/image/tiff"
+)
+
+func TestEncodePages(t *testing.T) {
+	small := image.NewRGBA(image.Rect(0, 0, 3, 2))
+	small.Set(1, 1, color.RGBA{0x10, 0x20, 0x30, 0xff})
+	large := image.NewNRGBA(image.Rect(0, 0, 6, 4))
+	large.Set(5, 3, color.NRGBA{0xff, 0, 0, 0x80})
+
+	var buf bytes.Buffer
+	if err := Encode(&buf, []Page{{small, 72}, {large, 144}}); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+
+	// The first page must decode with a standard reader.
+	img, err := xtiff.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if img.Bounds() != small.Bounds() {
+		t.Fatalf("unexpected bounds %v", img.Bounds())
+	}
+	if r, g, b, _ := img.At(1, 1).RGBA(); r>>8 != 0x10 || g>>8 != 0x20 || b>>8 != 0x30 {
+		t.Fatalf("unexpected pixel %v", img.At(1, 1))
+	}
+
+	// Walk the IFD chain and check the size and resolution of each page.
+	le := binary.LittleEndian
+	type pageInfo struct{ width, height, dpi uint32 }
+	var pages []pageInfo
+	for off := le.Uint32(data[4:]); off != 0; {
+		n := int(le.Uint16(data[off:]))
+		var p pageInfo
+		for i := 0; i < n; i++ {
+			e := data[int(off)+2+12*i:]
+			switch le.Uint16(e) {
+			case tagImageWidth:
+				p.width = le.Uint32(e[8:])
+			case tagImageLength:
+				p.height = le.Uint32(e[8:])
+			case tagXResolution:
+				r := le.Uint32(e[8:])
+				p.dpi = le.Uint32(data[r:]) / le.Uint32(data[r+4:])
+			}
+		}
+		pages = append(pages, p)
+		off = le.Uint32(data[int(off)+2+12*n:])
+	}
+	want := []pageInfo{{3, 2, 72}, {6, 4, 144}}
+	if len(pages) != len(want) || pages[0] != want[0] || pages[1] != want[1] {
+		t.Fatalf("got pages %v, want %v", pages, want)
+	}
+}
+
+func TestEncodeErrors(t *testing.T) {
+	if err := Encode(&bytes.Buffer{}, nil); err == nil {
+		t.Error("expected an error without pages")
+	}
+	img := image.NewRGBA(image.Rect(0, 0, 1, 1))
+	if err := Encode(&bytes.Buffer{}, []Page{{img, 0}}); err == nil {
+		t.Error("expected an error without resolution")
+	}
+}
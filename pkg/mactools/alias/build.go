@@ -0,0 +1,126 @@
+package alias
+
+import (
+	"encoding/binary"
+	"errors"
+	"path"
+	"strings"
+	"time"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// Target describes the target of an alias by volume and path instead of
+// looking it up on disk, so aliases can point into a volume that does not
+// exist yet, such as a disk image being staged.
+type Target struct {
+	// VolumeName and VolumeCreated identify the volume. The creation date
+	// must match the one of the volume header for the alias to resolve.
+	VolumeName    string
+	VolumeCreated time.Time
+	// Path is the location of the target relative to the volume root, for
+	// example /.background/background.png.
+	Path    string
+	Created time.Time
+	Dir     bool
+	// ID and ParentID are the catalog node IDs of the target and its parent
+	// folder. They may be left zero, the alias then resolves by path.
+	ID       uint32
+	ParentID uint32
+	// MountPoint is where the volume is mounted, /Volumes/<VolumeName>
+	// when empty. Use / for the startup volume.
+	MountPoint string
+}
+
+// Build returns an alias record for t. It does not access the file system.
+func Build(t Target) ([]byte, error) {
+	if t.VolumeName == "" || strings.Contains(t.VolumeName, "/") {
+		return nil, errors.New("invalid volume name")
+	}
+	volumeType := "other"
+	if t.Mount() == "/" {
+		volumeType = "local"
+	}
+	info, err := t.info(t.Mount(), volumeType)
+	if err != nil {
+		return nil, err
+	}
+	return Encode(info)
+}
+
+// Mount returns the mount point of the volume of t.
+func (t Target) Mount() string {
+	if t.MountPoint != "" {
+		return path.Clean(t.MountPoint)
+	}
+	return path.Join("/Volumes", t.VolumeName)
+}
+
+// info fills the alias record of t for a volume mounted at mountPoint.
+func (t Target) info(mountPoint, volumeType string) (Info, error) {
+	info := Info{Version: 2, Extra: []Extra{}}
+
+	localPath := path.Clean("/" + t.Path)
+	if localPath == "/" {
+		return info, errors.New("target path must not be the volume root")
+	}
+	parentPath := path.Dir(localPath)
+
+	info.Target.ID = t.ID
+	info.Target.Type = "file"
+	if t.Dir {
+		info.Target.Type = "directory"
+	}
+	info.Target.Filename = truncate(path.Base(localPath), 63)
+	// HFS+ stores whole seconds, round down the same way.
+	info.Target.Created = t.Created.Truncate(time.Second)
+
+	info.Parent.ID = t.ParentID
+	info.Parent.Name = path.Base(parentPath)
+	if parentPath == "/" {
+		info.Parent.Name = t.VolumeName
+	}
+
+	info.Volume.Name = truncate(t.VolumeName, 27)
+	info.Volume.Created = t.VolumeCreated.Truncate(time.Second)
+	info.Volume.Signature = "H+"
+	info.Volume.Type = volumeType
+
+	// Add Type 0
+	info.Extra = append(info.Extra, extra(0, []byte(info.Parent.Name)))
+
+	// Add Type 1
+	info.Extra = append(info.Extra, extra(1, binary.BigEndian.AppendUint32(nil, info.Parent.ID)))
+
+	// Add Type 14, 15: the full names, not truncated to the fixed fields
+	info.Extra = append(info.Extra, extra(14, unicodeName(path.Base(localPath))))
+	info.Extra = append(info.Extra, extra(15, unicodeName(t.VolumeName)))
+
+	// Add Type 18
+	info.Extra = append(info.Extra, extra(18, []byte(localPath)))
+
+	// Add Type 19
+	info.Extra = append(info.Extra, extra(19, []byte(mountPoint)))
+
+	return info, nil
+}
+
+func extra(typ int16, data []byte) Extra {
+	return Extra{Type: typ, Length: uint16(len(data)), Data: data}
+}
+
+// unicodeName encodes str as an HFSUniStr255: the UTF-16 length followed by
+// the big-endian code units.
+func unicodeName(str string) []byte {
+	b := binary.BigEndian.AppendUint16(nil, uint16(len(utf16.Encode([]rune(str)))))
+	return append(b, utf16be(str)...)
+}
+
+// truncate shortens str to at most n bytes without splitting a character.
+func truncate(str string, n int) string {
+	for len(str) > n {
+		_, size := utf8.DecodeLastRuneInString(str)
+		str = str[:len(str)-size]
+	}
+	return str
+}
@@ -0,0 +1,83 @@
+package alias
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+	"time"
+)
+
+func TestBuild(t *testing.T) {
+	volumeCreated := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	data, err := Build(Target{
+		VolumeName:    "My App Installer",
+		VolumeCreated: volumeCreated,
+		Path:          "/.background/background.tiff",
+		Created:       volumeCreated.Add(time.Minute),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := int(binary.BigEndian.Uint16(data[4:])); got != len(data) {
+		t.Errorf("record length = %d, want %d", got, len(data))
+	}
+	if got := string(data[11 : 11+data[10]]); got != "My App Installer" {
+		t.Errorf("volume name = %q", got)
+	}
+	if got := binary.BigEndian.Uint32(data[38:]); got != AppleDate(volumeCreated) {
+		t.Errorf("volume created = %d, want %d", got, AppleDate(volumeCreated))
+	}
+	if got := string(data[51 : 51+data[50]]); got != "background.tiff" {
+		t.Errorf("filename = %q", got)
+	}
+
+	extras := map[int16][]byte{}
+	for pos := 150; ; {
+		typ := int16(binary.BigEndian.Uint16(data[pos:]))
+		length := int(binary.BigEndian.Uint16(data[pos+2:]))
+		if typ == -1 {
+			break
+		}
+		extras[typ] = data[pos+4 : pos+4+length]
+		pos += 4 + length + length%2
+	}
+	want := map[int16][]byte{
+		0:  []byte(".background"),
+		18: []byte("/.background/background.tiff"),
+		19: []byte("/Volumes/My App Installer"),
+		15: append([]byte{0, 16}, utf16be("My App Installer")...),
+	}
+	for typ, w := range want {
+		if !bytes.Equal(extras[typ], w) {
+			t.Errorf("extra %d = %q, want %q", typ, extras[typ], w)
+		}
+	}
+}
+
+func TestBuildLongNames(t *testing.T) {
+	name := "A Very Long Volume Name For The Installer"
+	data, err := Build(Target{VolumeName: name, Path: "/background.png"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data[10] != 27 {
+		t.Errorf("volume name length = %d, want 27", data[10])
+	}
+	if !bytes.Contains(data, utf16be(name)) {
+		t.Error("full volume name missing from extras")
+	}
+	// The parent of a file at the root is the volume itself.
+	if got := data[154 : 154+binary.BigEndian.Uint16(data[152:])]; string(got) != name {
+		t.Errorf("parent name = %q, want %q", got, name)
+	}
+
+	for _, target := range []Target{
+		{Path: "/background.png"},
+		{VolumeName: "a/b", Path: "/background.png"},
+		{VolumeName: "Installer", Path: "/"},
+	} {
+		if _, err := Build(target); err == nil {
+			t.Errorf("Build(%+v) succeeded", target)
+		}
+	}
+}
@@ -0,0 +1,156 @@
+package alias
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+	"unicode/utf16"
+)
+
+// Tagged extra types of an alias record.
+const (
+	ExtraParentName        = 0
+	ExtraParentID          = 1
+	ExtraUnicodeFilename   = 14
+	ExtraUnicodeVolumeName = 15
+	ExtraPath              = 18
+	ExtraVolumePath        = 19
+)
+
+// Decode parses a version 2 alias record. All tagged extras are kept in
+// their original order, including types it does not know, so that the
+// record can be encoded again.
+func Decode(data []byte) (Info, error) {
+	info := Info{Extra: []Extra{}}
+	if len(data) < 150 {
+		return info, errors.New("alias record too short")
+	}
+	length := int(binary.BigEndian.Uint16(data[4:]))
+	if length < 150 || length > len(data) {
+		return info, fmt.Errorf("invalid alias record length %d", length)
+	}
+	data = data[:length]
+
+	info.Version = int(binary.BigEndian.Uint16(data[6:]))
+	if info.Version != 2 {
+		return info, fmt.Errorf("unsupported alias version %d", info.Version)
+	}
+
+	typeIndex := int(binary.BigEndian.Uint16(data[8:]))
+	if typeIndex >= len(Type) {
+		return info, fmt.Errorf("invalid target type %d", typeIndex)
+	}
+	info.Target.Type = Type[typeIndex]
+
+	if data[10] > 27 {
+		return info, errors.New("volume name too long")
+	}
+	info.Volume.Name = string(data[11 : 11+data[10]])
+	info.Volume.Created = fromAppleDate(binary.BigEndian.Uint32(data[38:]))
+	info.Volume.Signature = string(data[42:44])
+	volTypeIndex := int(binary.BigEndian.Uint16(data[44:]))
+	if volTypeIndex >= len(VolumeType) {
+		return info, fmt.Errorf("invalid volume type %d", volTypeIndex)
+	}
+	info.Volume.Type = VolumeType[volTypeIndex]
+
+	info.Parent.ID = binary.BigEndian.Uint32(data[46:])
+
+	if data[50] > 63 {
+		return info, errors.New("filename too long")
+	}
+	info.Target.Filename = string(data[51 : 51+data[50]])
+	info.Target.ID = binary.BigEndian.Uint32(data[114:])
+	info.Target.Created = fromAppleDate(binary.BigEndian.Uint32(data[118:]))
+
+	pos := 150
+	for {
+		if pos+4 > len(data) {
+			return info, errors.New("alias record is missing its end marker")
+		}
+		typ := int16(binary.BigEndian.Uint16(data[pos:]))
+		size := int(binary.BigEndian.Uint16(data[pos+2:]))
+		if typ == -1 {
+			break
+		}
+		pos += 4
+		if pos+size > len(data) {
+			return info, fmt.Errorf("extra %d exceeds the alias record", typ)
+		}
+		info.Extra = append(info.Extra, extra(typ, append([]byte(nil), data[pos:pos+size]...)))
+		pos += size + size%2
+	}
+
+	if e, ok := info.ExtraData(ExtraParentName); ok {
+		info.Parent.Name = string(e)
+	}
+	return info, nil
+}
+
+// ExtraData returns the data of the first extra of type typ.
+func (info Info) ExtraData(typ int16) ([]byte, bool) {
+	for _, e := range info.Extra {
+		if e.Type == typ {
+			return e.Data, true
+		}
+	}
+	return nil, false
+}
+
+// Path returns the location of the target relative to the volume root.
+func (info Info) Path() string {
+	b, _ := info.ExtraData(ExtraPath)
+	return string(b)
+}
+
+// VolumePath returns the mount point of the volume.
+func (info Info) VolumePath() string {
+	b, _ := info.ExtraData(ExtraVolumePath)
+	return string(b)
+}
+
+// Filename returns the full name of the target, which the fixed header
+// truncates to 63 bytes.
+func (info Info) Filename() string {
+	if b, ok := info.ExtraData(ExtraUnicodeFilename); ok {
+		if name, ok := decodeUnicodeName(b); ok {
+			return name
+		}
+	}
+	return info.Target.Filename
+}
+
+// VolumeName returns the full name of the volume, which the fixed header
+// truncates to 27 bytes.
+func (info Info) VolumeName() string {
+	if b, ok := info.ExtraData(ExtraUnicodeVolumeName); ok {
+		if name, ok := decodeUnicodeName(b); ok {
+			return name
+		}
+	}
+	return info.Volume.Name
+}
+
+// decodeUnicodeName decodes an HFSUniStr255 as written by unicodeName.
+func decodeUnicodeName(b []byte) (string, bool) {
+	if len(b) < 2 {
+		return "", false
+	}
+	n := int(binary.BigEndian.Uint16(b))
+	if len(b) < 2+2*n {
+		return "", false
+	}
+	u := make([]uint16, n)
+	for i := range u {
+		u[i] = binary.BigEndian.Uint16(b[2+2*i:])
+	}
+	return string(utf16.Decode(u)), true
+}
+
+func fromAppleDate(v uint32) time.Time {
+	if v == 0 {
+		return time.Time{}
+	}
+	return AppleEpoch.Add(time.Duration(v) * time.Second)
+}
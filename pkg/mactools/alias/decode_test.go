@@ -0,0 +1,85 @@
+package alias
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestDecode(t *testing.T) {
+	created := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	data, err := Build(Target{
+		VolumeName:    "A Very Long Volume Name For The Installer",
+		VolumeCreated: created,
+		Path:          "/.background/background.tiff",
+		Created:       created,
+		ID:            21,
+		ParentID:      20,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := Decode(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Target.Type != "file" || info.Target.Filename != "background.tiff" || info.Target.ID != 21 || !info.Target.Created.Equal(created) {
+		t.Errorf("unexpected target %+v", info.Target)
+	}
+	if info.Parent.ID != 20 || info.Parent.Name != ".background" {
+		t.Errorf("unexpected parent %+v", info.Parent)
+	}
+	if info.Volume.Name != "A Very Long Volume Name For" || info.Volume.Signature != "H+" || info.Volume.Type != "other" || !info.Volume.Created.Equal(created) {
+		t.Errorf("unexpected volume %+v", info.Volume)
+	}
+	if got := info.VolumeName(); got != "A Very Long Volume Name For The Installer" {
+		t.Errorf("VolumeName() = %q", got)
+	}
+	if got := info.Filename(); got != "background.tiff" {
+		t.Errorf("Filename() = %q", got)
+	}
+	if got := info.Path(); got != "/.background/background.tiff" {
+		t.Errorf("Path() = %q", got)
+	}
+	if got := info.VolumePath(); got != "/Volumes/A Very Long Volume Name For The Installer" {
+		t.Errorf("VolumePath() = %q", got)
+	}
+
+	// Unknown extras survive a round trip.
+	info.Extra = append(info.Extra, Extra{Type: 9, Length: 3, Data: []byte{1, 2, 3}})
+	encoded, err := Encode(info)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := Decode(encoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b, ok := decoded.ExtraData(9); !ok || !bytes.Equal(b, []byte{1, 2, 3}) {
+		t.Errorf("unknown extra = %v, %v", b, ok)
+	}
+	again, err := Encode(decoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(again, encoded) {
+		t.Error("round trip changed the record")
+	}
+}
+
+func TestDecodeCorrupt(t *testing.T) {
+	data, err := Build(Target{VolumeName: "Installer", Path: "/background.png"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, corrupt := range map[string][]byte{
+		"short":       data[:100],
+		"truncated":   data[:len(data)-8],
+		"no end":      append(append([]byte(nil), data[:len(data)-4]...), 0, 0, 0, 0),
+		"bad version": append(append([]byte(nil), data[:6]...), append([]byte{0, 3}, data[8:]...)...),
+	} {
+		if _, err := Decode(corrupt); err == nil {
+			t.Errorf("%s: Decode succeeded", name)
+		}
+	}
+}
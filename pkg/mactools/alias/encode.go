@@ -11,6 +11,9 @@ import (
 var AppleEpoch = time.Date(1904, 1, 1, 0, 0, 0, 0, time.UTC)
 
 func AppleDate(value time.Time) uint32 {
+	if value.Before(AppleEpoch) {
+		return 0
+	}
 	return uint32(math.Round(value.Sub(AppleEpoch).Seconds()))
 }
 
@@ -0,0 +1,19 @@
+//go:build !darwin || !cgo
+
+package alias
+
+import (
+	"errors"
+	"path/filepath"
+)
+
+// GetVolumeName returns the name of the volume mounted at path. Without
+// CoreFoundation the name is taken from the mount point, which matches
+// /Volumes/<name> on macOS.
+func GetVolumeName(path string) (string, error) {
+	name := filepath.Base(path)
+	if name == "/" || name == "." {
+		return "", errors.New("failed to get volume name")
+	}
+	return name, nil
+}
@@ -0,0 +1,221 @@
+// Package bookmark is a package for writing NSURL bookmark data, the
+// successor of alias records that newer Finder versions resolve first.
+package bookmark
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+	"net/url"
+	"sort"
+	"time"
+)
+
+// Keys of the bookmark table of contents.
+const (
+	KeyPath               = 0x1004
+	KeyCNIDPath           = 0x1005
+	KeyFileProperties     = 0x1010
+	KeyFileCreationDate   = 0x1040
+	KeyVolumePath         = 0x2002
+	KeyVolumeURL          = 0x2005
+	KeyVolumeName         = 0x2010
+	KeyVolumeCreationDate = 0x2013
+	KeyVolumeProperties   = 0x2020
+	KeyVolumeIsRoot       = 0x2030
+	KeyContainingFolder   = 0xC001
+)
+
+// Resource property flags of KeyFileProperties.
+const (
+	IsRegularFile  = 0x00000001
+	IsDirectory    = 0x00000002
+	IsSymbolicLink = 0x00000004
+	IsVolume       = 0x00000008
+	IsPackage      = 0x00000010
+	IsHidden       = 0x00000080
+)
+
+// Volume property flags of KeyVolumeProperties.
+const (
+	VolumeIsLocal     = 0x00000001
+	VolumeIsReadOnly  = 0x00000008
+	VolumeIsEjectable = 0x00000020
+	VolumeIsRemovable = 0x00000040
+	VolumeIsInternal  = 0x00000080
+	VolumeIsExternal  = 0x00000100
+	VolumeIsDiskImage = 0x00000200
+)
+
+// Data types of bookmark items.
+const (
+	typeString   = 0x0101
+	typeData     = 0x0201
+	typeInt32    = 0x0303
+	typeInt64    = 0x0304
+	typeDate     = 0x0400
+	typeFalse    = 0x0500
+	typeTrue     = 0x0501
+	typeArray    = 0x0601
+	typeURL      = 0x0901
+	tocMagic     = 0xFFFFFFFE
+	version      = 0x10040000
+	headerSize   = 48
+	tocHeader    = 20
+	tocEntrySize = 12
+)
+
+// BookmarkEpoch is the reference date of bookmark dates.
+var BookmarkEpoch = time.Date(2001, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// Properties is a pair of resource property flags and the mask of the
+// flags that are known.
+type Properties struct {
+	Flags uint64
+	Mask  uint64
+}
+
+// Info describes the target of a bookmark.
+type Info struct {
+	// Path holds the components of the absolute path of the target, for
+	// example Volumes, Installer, .background, background.png.
+	Path []string
+	// CNIDPath holds the catalog node IDs of the path components. It may
+	// be left empty, the bookmark then resolves by path.
+	CNIDPath   []uint64
+	Created    time.Time
+	Properties Properties
+	Volume     struct {
+		Path       string
+		Name       string
+		Created    time.Time
+		Properties Properties
+		IsRoot     bool
+	}
+}
+
+// Encode returns the bookmark data of info.
+func Encode(info Info) ([]byte, error) {
+	if len(info.Path) == 0 {
+		return nil, errors.New("empty target path")
+	}
+	if len(info.CNIDPath) != 0 && len(info.CNIDPath) != len(info.Path) {
+		return nil, errors.New("CNID path length mismatch")
+	}
+	if info.Volume.Path == "" || info.Volume.Path[0] != '/' {
+		return nil, errors.New("invalid volume path")
+	}
+
+	// The data area starts with the offset of the first table of contents,
+	// which is filled in once all items are written.
+	w := &writer{buf: make([]byte, 4)}
+	toc := map[uint32]uint32{}
+
+	components := make([]uint32, len(info.Path))
+	for i, c := range info.Path {
+		components[i] = w.item(typeString, []byte(c))
+	}
+	toc[KeyPath] = w.array(components)
+
+	if len(info.CNIDPath) != 0 {
+		ids := make([]uint32, len(info.CNIDPath))
+		for i, id := range info.CNIDPath {
+			ids[i] = w.item(typeInt64, binary.LittleEndian.AppendUint64(nil, id))
+		}
+		toc[KeyCNIDPath] = w.array(ids)
+	}
+
+	toc[KeyFileProperties] = w.properties(info.Properties)
+	if !info.Created.IsZero() {
+		toc[KeyFileCreationDate] = w.date(info.Created)
+	}
+
+	volumeURL := url.URL{Scheme: "file", Path: info.Volume.Path}
+	if volumeURL.Path[len(volumeURL.Path)-1] != '/' {
+		volumeURL.Path += "/"
+	}
+	toc[KeyVolumePath] = w.item(typeString, []byte(info.Volume.Path))
+	toc[KeyVolumeURL] = w.item(typeURL, []byte(volumeURL.String()))
+	toc[KeyVolumeName] = w.item(typeString, []byte(info.Volume.Name))
+	if !info.Volume.Created.IsZero() {
+		toc[KeyVolumeCreationDate] = w.date(info.Volume.Created)
+	}
+	toc[KeyVolumeProperties] = w.properties(info.Volume.Properties)
+	toc[KeyVolumeIsRoot] = w.bool(info.Volume.IsRoot)
+	if len(info.Path) > 1 {
+		toc[KeyContainingFolder] = w.item(typeInt32, binary.LittleEndian.AppendUint32(nil, uint32(len(info.Path)-2)))
+	}
+
+	keys := make([]uint32, 0, len(toc))
+	for k := range toc {
+		keys = append(keys, k)
+	}
+	// Entries must be sorted, the table is searched with a binary search.
+	sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
+
+	tocOffset := uint32(len(w.buf))
+	binary.LittleEndian.PutUint32(w.buf, tocOffset)
+	w.buf = binary.LittleEndian.AppendUint32(w.buf, uint32(tocHeader-8+tocEntrySize*len(keys)))
+	w.buf = binary.LittleEndian.AppendUint32(w.buf, tocMagic)
+	w.buf = binary.LittleEndian.AppendUint32(w.buf, 1) // identifier
+	w.buf = binary.LittleEndian.AppendUint32(w.buf, 0) // next table of contents
+	w.buf = binary.LittleEndian.AppendUint32(w.buf, uint32(len(keys)))
+	for _, k := range keys {
+		w.buf = binary.LittleEndian.AppendUint32(w.buf, k)
+		w.buf = binary.LittleEndian.AppendUint32(w.buf, toc[k])
+		w.buf = binary.LittleEndian.AppendUint32(w.buf, 0)
+	}
+
+	buf := make([]byte, headerSize, headerSize+len(w.buf))
+	copy(buf, "book")
+	binary.LittleEndian.PutUint32(buf[4:], uint32(headerSize+len(w.buf)))
+	binary.LittleEndian.PutUint32(buf[8:], version)
+	binary.LittleEndian.PutUint32(buf[12:], headerSize)
+	return append(buf, w.buf...), nil
+}
+
+// writer appends items to the data area of a bookmark. Offsets are
+// relative to the start of the data area.
+type writer struct {
+	buf []byte
+}
+
+// item writes an item of type typ and returns its offset.
+func (w *writer) item(typ uint32, data []byte) uint32 {
+	offset := uint32(len(w.buf))
+	w.buf = binary.LittleEndian.AppendUint32(w.buf, uint32(len(data)))
+	w.buf = binary.LittleEndian.AppendUint32(w.buf, typ)
+	w.buf = append(w.buf, data...)
+	for len(w.buf)%4 != 0 {
+		w.buf = append(w.buf, 0)
+	}
+	return offset
+}
+
+func (w *writer) array(offsets []uint32) uint32 {
+	var data []byte
+	for _, o := range offsets {
+		data = binary.LittleEndian.AppendUint32(data, o)
+	}
+	return w.item(typeArray, data)
+}
+
+// date writes t as big-endian seconds since BookmarkEpoch.
+func (w *writer) date(t time.Time) uint32 {
+	seconds := t.Sub(BookmarkEpoch).Seconds()
+	return w.item(typeDate, binary.BigEndian.AppendUint64(nil, math.Float64bits(seconds)))
+}
+
+func (w *writer) bool(v bool) uint32 {
+	if v {
+		return w.item(typeTrue, nil)
+	}
+	return w.item(typeFalse, nil)
+}
+
+// properties writes the flags, the mask and 8 reserved bytes.
+func (w *writer) properties(p Properties) uint32 {
+	data := binary.LittleEndian.AppendUint64(nil, p.Flags)
+	data = binary.LittleEndian.AppendUint64(data, p.Mask)
+	return w.item(typeData, append(data, make([]byte, 8)...))
+}
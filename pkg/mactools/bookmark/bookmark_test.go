@@ -0,0 +1,117 @@
+package bookmark
+
+import (
+	"encoding/binary"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/ironpark/zapp/pkg/mactools/alias"
+)
+
+// readTOC returns the items of the first table of contents by key, as the
+// type and data of each item.
+func readTOC(t *testing.T, data []byte) map[uint32][]byte {
+	t.Helper()
+	if string(data[:4]) != "book" || int(binary.LittleEndian.Uint32(data[4:])) != len(data) {
+		t.Fatalf("bad header % x", data[:16])
+	}
+	area := data[headerSize:]
+	toc := area[binary.LittleEndian.Uint32(area):]
+	if binary.LittleEndian.Uint32(toc[4:]) != tocMagic {
+		t.Fatal("bad table of contents magic")
+	}
+	count := int(binary.LittleEndian.Uint32(toc[16:]))
+	if got := int(binary.LittleEndian.Uint32(toc)); got != 12+12*count {
+		t.Errorf("table of contents size = %d, want %d", got, 12+12*count)
+	}
+	items := map[uint32][]byte{}
+	last := uint32(0)
+	for i := 0; i < count; i++ {
+		key := binary.LittleEndian.Uint32(toc[tocHeader+12*i:])
+		if key <= last {
+			t.Errorf("key %#x is out of order", key)
+		}
+		last = key
+		offset := binary.LittleEndian.Uint32(toc[tocHeader+12*i+4:])
+		if offset%4 != 0 {
+			t.Errorf("item %#x is not aligned", key)
+		}
+		size := binary.LittleEndian.Uint32(area[offset:])
+		items[key] = area[offset+4 : offset+8+size]
+	}
+	return items
+}
+
+func TestBuild(t *testing.T) {
+	created := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	data, err := Build(alias.Target{
+		VolumeName:    "My App Installer",
+		VolumeCreated: created,
+		Path:          "/.background/background.tiff",
+		Created:       created,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	items := readTOC(t, data)
+	area := data[headerSize:]
+
+	typ := func(key uint32) uint32 { return binary.LittleEndian.Uint32(items[key]) }
+	value := func(key uint32) []byte { return items[key][4:] }
+
+	if typ(KeyPath) != typeArray {
+		t.Fatalf("path type = %#x", typ(KeyPath))
+	}
+	var path []string
+	for i := 0; i < len(value(KeyPath)); i += 4 {
+		offset := binary.LittleEndian.Uint32(value(KeyPath)[i:])
+		size := binary.LittleEndian.Uint32(area[offset:])
+		path = append(path, string(area[offset+8:offset+8+size]))
+	}
+	want := []string{"Volumes", "My App Installer", ".background", "background.tiff"}
+	if len(path) != len(want) {
+		t.Fatalf("path = %q, want %q", path, want)
+	}
+	for i := range want {
+		if path[i] != want[i] {
+			t.Errorf("path = %q, want %q", path, want)
+		}
+	}
+
+	if got := binary.LittleEndian.Uint32(value(KeyContainingFolder)); got != 2 {
+		t.Errorf("containing folder = %d, want 2", got)
+	}
+	if got := string(value(KeyVolumeURL)); typ(KeyVolumeURL) != typeURL || got != "file:///Volumes/My%20App%20Installer/" {
+		t.Errorf("volume URL = %q", got)
+	}
+	if got := string(value(KeyVolumeName)); got != "My App Installer" {
+		t.Errorf("volume name = %q", got)
+	}
+	seconds := math.Float64frombits(binary.BigEndian.Uint64(value(KeyVolumeCreationDate)))
+	if got := BookmarkEpoch.Add(time.Duration(seconds) * time.Second); !got.Equal(created) {
+		t.Errorf("volume created = %v, want %v", got, created)
+	}
+	if got := binary.LittleEndian.Uint64(value(KeyFileProperties)); got != IsRegularFile {
+		t.Errorf("file properties = %#x", got)
+	}
+	if got := binary.LittleEndian.Uint64(value(KeyVolumeProperties)); got&VolumeIsDiskImage == 0 {
+		t.Errorf("volume properties = %#x", got)
+	}
+	if typ(KeyVolumeIsRoot) != typeFalse {
+		t.Errorf("volume is root type = %#x", typ(KeyVolumeIsRoot))
+	}
+	if _, ok := items[KeyCNIDPath]; ok {
+		t.Error("unexpected CNID path")
+	}
+
+	for _, target := range []alias.Target{
+		{Path: "/background.png"},
+		{VolumeName: "a/b", Path: "/background.png"},
+		{VolumeName: "Installer", Path: "/"},
+	} {
+		if _, err := Build(target); err == nil {
+			t.Errorf("Build(%+v) succeeded", target)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package bookmark
+
+import (
+	"errors"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/ironpark/zapp/pkg/mactools/alias"
+)
+
+// Build returns bookmark data for t. It does not access the file system.
+func Build(t alias.Target) ([]byte, error) {
+	if t.VolumeName == "" || strings.Contains(t.VolumeName, "/") {
+		return nil, errors.New("invalid volume name")
+	}
+	info, err := info(t, t.Mount())
+	if err != nil {
+		return nil, err
+	}
+	if info.Volume.Path == "/" {
+		info.Volume.IsRoot = true
+		info.Volume.Properties = Properties{
+			Flags: VolumeIsLocal | VolumeIsInternal,
+			Mask:  VolumeIsLocal | VolumeIsInternal | VolumeIsDiskImage,
+		}
+		return Encode(info)
+	}
+	// Disk images are attached as removable, ejectable local volumes.
+	info.Volume.Properties = Properties{
+		Flags: VolumeIsLocal | VolumeIsEjectable | VolumeIsRemovable | VolumeIsDiskImage,
+		Mask:  VolumeIsLocal | VolumeIsEjectable | VolumeIsRemovable | VolumeIsInternal | VolumeIsDiskImage,
+	}
+	return Encode(info)
+}
+
+// Create returns bookmark data for the file or directory at targetPath,
+// looking up its dates and volume on disk.
+func Create(targetPath string) ([]byte, error) {
+	t, mountPoint, err := alias.Lookup(targetPath)
+	if err != nil {
+		return nil, err
+	}
+	info, err := info(t, mountPoint)
+	if err != nil {
+		return nil, err
+	}
+	info.Volume.IsRoot = mountPoint == "/"
+	info.Volume.Properties = Properties{Flags: VolumeIsLocal, Mask: VolumeIsLocal}
+	return Encode(info)
+}
+
+// info fills the bookmark of t for a volume mounted at mountPoint.
+func info(t alias.Target, mountPoint string) (Info, error) {
+	var info Info
+	localPath := path.Clean("/" + t.Path)
+	if localPath == "/" {
+		return info, errors.New("target path must not be the volume root")
+	}
+	info.Path = strings.Split(strings.TrimPrefix(path.Join(mountPoint, localPath), "/"), "/")
+	info.Created = t.Created.Truncate(time.Second)
+	info.Properties = Properties{
+		Flags: IsRegularFile,
+		Mask:  IsRegularFile | IsDirectory | IsSymbolicLink,
+	}
+	if t.Dir {
+		info.Properties.Flags = IsDirectory
+	}
+	info.Volume.Path = mountPoint
+	info.Volume.Name = t.VolumeName
+	info.Volume.Created = t.VolumeCreated.Truncate(time.Second)
+	return info, nil
+}
@@ -0,0 +1,88 @@
+package codesign
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// Magics of the blobs of an embedded code signature.
+const (
+	MagicRequirement        = 0xfade0c00
+	MagicRequirements       = 0xfade0c01
+	MagicCodeDirectory      = 0xfade0c02
+	MagicEmbeddedSignature  = 0xfade0cc0
+	MagicEntitlements       = 0xfade7171
+	MagicEntitlementsDER    = 0xfade7172
+	MagicBlobWrapper        = 0xfade0b01
+	blobHeaderSize          = 8
+	superBlobIndexEntrySize = 8
+)
+
+// Slots of the blobs in a superblob. Negated, the slots up to
+// SlotDEREntitlements are also the special slots of a code directory,
+// which hold the hashes of those blobs and of the files they stand for.
+const (
+	SlotCodeDirectory           = 0
+	SlotInfo                    = 1
+	SlotRequirements            = 2
+	SlotResourceDir             = 3
+	SlotApplication             = 4
+	SlotEntitlements            = 5
+	SlotDEREntitlements         = 7
+	SlotAlternateCodeDirectory  = 0x1000
+	SlotAlternateCodeDirectory4 = 0x1004
+	SlotSignature               = 0x10000
+)
+
+var errTruncated = errors.New("truncated code signature")
+
+// blob is one entry of a superblob.
+type blob struct {
+	slot  uint32
+	magic uint32
+	data  []byte // the whole blob, header included
+}
+
+// parseSuperBlob splits an embedded signature into its blobs.
+func parseSuperBlob(data []byte) ([]blob, error) {
+	if len(data) < 12 {
+		return nil, errTruncated
+	}
+	if magic := binary.BigEndian.Uint32(data); magic != MagicEmbeddedSignature {
+		return nil, fmt.Errorf("not an embedded signature: magic 0x%08x", magic)
+	}
+	length := binary.BigEndian.Uint32(data[4:])
+	if int64(length) > int64(len(data)) || length < 12 {
+		return nil, errTruncated
+	}
+	data = data[:length]
+	count := binary.BigEndian.Uint32(data[8:])
+	if int64(count)*superBlobIndexEntrySize > int64(len(data)-12) {
+		return nil, errTruncated
+	}
+	blobs := make([]blob, count)
+	for i := range blobs {
+		entry := data[12+i*superBlobIndexEntrySize:]
+		slot := binary.BigEndian.Uint32(entry)
+		offset := binary.BigEndian.Uint32(entry[4:])
+		b, err := subBlob(data, offset)
+		if err != nil {
+			return nil, fmt.Errorf("slot 0x%x: %w", slot, err)
+		}
+		blobs[i] = blob{slot: slot, magic: binary.BigEndian.Uint32(b), data: b}
+	}
+	return blobs, nil
+}
+
+// subBlob returns the blob at offset in data.
+func subBlob(data []byte, offset uint32) ([]byte, error) {
+	if int64(offset)+blobHeaderSize > int64(len(data)) {
+		return nil, errTruncated
+	}
+	length := binary.BigEndian.Uint32(data[offset+4:])
+	if length < blobHeaderSize || int64(offset)+int64(length) > int64(len(data)) {
+		return nil, errTruncated
+	}
+	return data[offset : offset+length], nil
+}
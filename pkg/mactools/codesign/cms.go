@@ -0,0 +1,450 @@
+package codesign
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"math/big"
+	"sort"
+	"time"
+
+	"howett.net/plist"
+)
+
+var (
+	oidData               = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 1}
+	oidSignedData         = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 2}
+	oidContentType        = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 3}
+	oidMessageDigest      = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 4}
+	oidSigningTime        = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 5}
+	oidTimestampToken     = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 16, 2, 14}
+	oidAppleCDHashesPlist = asn1.ObjectIdentifier{1, 2, 840, 113635, 100, 9, 1}
+	oidAppleCDHashes2     = asn1.ObjectIdentifier{1, 2, 840, 113635, 100, 9, 2}
+
+	oidSHA1            = asn1.ObjectIdentifier{1, 3, 14, 3, 2, 26}
+	oidSHA256          = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 1}
+	oidSHA384          = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 2}
+	oidRSAEncryption   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 1}
+	oidECDSAWithSHA256 = asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 2}
+)
+
+// The subset of CMS (RFC 5652) that code signatures use.
+type contentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     asn1.RawValue `asn1:"explicit,optional,tag:0"`
+}
+
+type signedData struct {
+	Version          int
+	DigestAlgorithms []pkix.AlgorithmIdentifier `asn1:"set"`
+	EncapContentInfo encapContentInfo
+	Certificates     asn1.RawValue `asn1:"optional,tag:0"`
+	CRLs             asn1.RawValue `asn1:"optional,tag:1"`
+	SignerInfos      []signerInfo  `asn1:"set"`
+}
+
+type encapContentInfo struct {
+	EContentType asn1.ObjectIdentifier
+	EContent     asn1.RawValue `asn1:"explicit,optional,tag:0"`
+}
+
+type issuerAndSerial struct {
+	Issuer asn1.RawValue
+	Serial *big.Int
+}
+
+type signerInfo struct {
+	Version         int
+	SID             asn1.RawValue
+	DigestAlgorithm pkix.AlgorithmIdentifier
+	// SignedAttrs is kept raw, as the signature covers its encoding.
+	SignedAttrs        asn1.RawValue `asn1:"optional,tag:0"`
+	SignatureAlgorithm pkix.AlgorithmIdentifier
+	Signature          []byte
+	UnsignedAttrs      []attribute `asn1:"optional,tag:1"`
+}
+
+type attribute struct {
+	Type   asn1.ObjectIdentifier
+	Values asn1.RawValue `asn1:"set"`
+}
+
+// tstInfo is the content of an RFC 3161 timestamp token, up to its nonce.
+type tstInfo struct {
+	Version        int
+	Policy         asn1.ObjectIdentifier
+	MessageImprint asn1.RawValue
+	Serial         *big.Int
+	GenTime        time.Time `asn1:"generalized"`
+	Accuracy       accuracy  `asn1:"optional"`
+	Ordering       bool      `asn1:"optional"`
+	Nonce          *big.Int  `asn1:"optional"`
+}
+
+type accuracy struct {
+	Seconds int `asn1:"optional"`
+	Millis  int `asn1:"optional,tag:0"`
+	Micros  int `asn1:"optional,tag:1"`
+}
+
+// CMSSignature is the parsed CMS blob of a signature.
+type CMSSignature struct {
+	// Certificates is the signer's chain, leaf first.
+	Certificates []*x509.Certificate
+	// SigningTime is the time the signer claims, zero when absent.
+	SigningTime time.Time
+	// Timestamp is the time of the secure timestamp, zero when absent.
+	Timestamp time.Time
+	// CDHashes lists the code directory hashes the signature covers, from
+	// the cdhashes plist attribute.
+	CDHashes [][]byte
+
+	digest        crypto.Hash
+	messageDigest []byte
+	signedAttrs   []byte // DER of the signed attributes, as a SET
+	signature     []byte
+}
+
+// ParseCMSSignature parses the CMS blob wrapper of a signature. Ad-hoc
+// signatures have an empty wrapper, for which it returns nil.
+func ParseCMSSignature(data []byte) (*CMSSignature, error) {
+	if len(data) < blobHeaderSize {
+		return nil, errTruncated
+	}
+	data = data[blobHeaderSize:]
+	if len(data) == 0 {
+		return nil, nil
+	}
+	sd, err := parseSignedData(data)
+	if err != nil {
+		return nil, err
+	}
+	if len(sd.SignerInfos) == 0 {
+		return nil, errors.New("CMS signature has no signer")
+	}
+	certs, err := x509.ParseCertificates(sd.Certificates.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("CMS certificates: %w", err)
+	}
+	si := sd.SignerInfos[0]
+	sig := &CMSSignature{
+		Certificates: signerChain(si, certs),
+		digest:       digestFor(si.DigestAlgorithm.Algorithm),
+		signature:    si.Signature,
+	}
+	var attrs []attribute
+	if len(si.SignedAttrs.FullBytes) > 0 {
+		// The signature covers the attributes with the SET tag in place of
+		// the implicit [0].
+		sig.signedAttrs = append([]byte{0x31}, si.SignedAttrs.FullBytes[1:]...)
+		if _, err := asn1.UnmarshalWithParams(sig.signedAttrs, &attrs, "set"); err != nil {
+			return nil, fmt.Errorf("CMS signed attributes: %w", err)
+		}
+	}
+	for _, attr := range attrs {
+		switch {
+		case attr.Type.Equal(oidMessageDigest):
+			if _, err := asn1.Unmarshal(attr.Values.Bytes, &sig.messageDigest); err != nil {
+				return nil, fmt.Errorf("message digest: %w", err)
+			}
+		case attr.Type.Equal(oidSigningTime):
+			if _, err := asn1.Unmarshal(attr.Values.Bytes, &sig.SigningTime); err != nil {
+				return nil, fmt.Errorf("signing time: %w", err)
+			}
+		case attr.Type.Equal(oidAppleCDHashesPlist):
+			var doc []byte
+			if _, err := asn1.Unmarshal(attr.Values.Bytes, &doc); err != nil {
+				return nil, fmt.Errorf("cdhashes: %w", err)
+			}
+			var hashes struct {
+				CDHashes [][]byte `plist:"cdhashes"`
+			}
+			if _, err := plist.Unmarshal(doc, &hashes); err != nil {
+				return nil, fmt.Errorf("cdhashes: %w", err)
+			}
+			sig.CDHashes = hashes.CDHashes
+		}
+	}
+	for _, attr := range si.UnsignedAttrs {
+		if attr.Type.Equal(oidTimestampToken) {
+			t, err := parseTimestampToken(attr.Values.Bytes)
+			if err != nil {
+				return nil, fmt.Errorf("timestamp: %w", err)
+			}
+			sig.Timestamp = t
+		}
+	}
+	return sig, nil
+}
+
+func parseSignedData(data []byte) (*signedData, error) {
+	var ci contentInfo
+	if _, err := asn1.Unmarshal(data, &ci); err != nil {
+		return nil, fmt.Errorf("CMS signature: %w", err)
+	}
+	if !ci.ContentType.Equal(oidSignedData) {
+		return nil, fmt.Errorf("CMS signature: unexpected content type %s", ci.ContentType)
+	}
+	var sd signedData
+	if _, err := asn1.Unmarshal(ci.Content.Bytes, &sd); err != nil {
+		return nil, fmt.Errorf("CMS signature: %w", err)
+	}
+	return &sd, nil
+}
+
+// parseTimestampToken returns the time of an RFC 3161 timestamp token.
+func parseTimestampToken(data []byte) (time.Time, error) {
+	info, err := parseTSTInfo(data)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return info.GenTime, nil
+}
+
+// parseTSTInfo returns the content of an RFC 3161 timestamp token.
+func parseTSTInfo(data []byte) (*tstInfo, error) {
+	sd, err := parseSignedData(data)
+	if err != nil {
+		return nil, err
+	}
+	var content []byte
+	if _, err := asn1.Unmarshal(sd.EncapContentInfo.EContent.Bytes, &content); err != nil {
+		return nil, err
+	}
+	var info tstInfo
+	if _, err := asn1.Unmarshal(content, &info); err != nil {
+		return nil, err
+	}
+	return &info, nil
+}
+
+// signerChain orders certs from the signer's certificate up to the root.
+// Certificates that are not part of the chain are left out.
+func signerChain(si signerInfo, certs []*x509.Certificate) []*x509.Certificate {
+	var id issuerAndSerial
+	if _, err := asn1.Unmarshal(si.SID.FullBytes, &id); err != nil {
+		return nil
+	}
+	var leaf *x509.Certificate
+	for _, c := range certs {
+		if bytes.Equal(c.RawIssuer, id.Issuer.FullBytes) && c.SerialNumber.Cmp(id.Serial) == 0 {
+			leaf = c
+			break
+		}
+	}
+	var chain []*x509.Certificate
+	for c := leaf; c != nil && len(chain) < len(certs); {
+		chain = append(chain, c)
+		if bytes.Equal(c.RawIssuer, c.RawSubject) {
+			break
+		}
+		next := c
+		c = nil
+		for _, issuer := range certs {
+			if bytes.Equal(issuer.RawSubject, next.RawIssuer) {
+				c = issuer
+				break
+			}
+		}
+	}
+	return chain
+}
+
+func digestFor(oid asn1.ObjectIdentifier) crypto.Hash {
+	switch {
+	case oid.Equal(oidSHA1):
+		return crypto.SHA1
+	case oid.Equal(oidSHA256):
+		return crypto.SHA256
+	case oid.Equal(oidSHA384):
+		return crypto.SHA384
+	}
+	return 0
+}
+
+// VerifySignature checks that the signer's certificate signed content, the
+// primary code directory. It does not evaluate trust in the certificate.
+func (s *CMSSignature) VerifySignature(content []byte) error {
+	if len(s.Certificates) == 0 {
+		return errors.New("CMS signature: signer certificate not found")
+	}
+	if s.digest == 0 || !s.digest.Available() {
+		return errors.New("CMS signature: unsupported digest algorithm")
+	}
+	if s.signedAttrs == nil {
+		return errors.New("CMS signature: no signed attributes")
+	}
+	h := s.digest.New()
+	h.Write(content)
+	if !bytes.Equal(h.Sum(nil), s.messageDigest) {
+		return errors.New("CMS signature: message digest does not match the code directory")
+	}
+	h.Reset()
+	h.Write(s.signedAttrs)
+	digest := h.Sum(nil)
+	var ok bool
+	switch pub := s.Certificates[0].PublicKey.(type) {
+	case *rsa.PublicKey:
+		ok = rsa.VerifyPKCS1v15(pub, s.digest, digest, s.signature) == nil
+	case *ecdsa.PublicKey:
+		ok = ecdsa.VerifyASN1(pub, digest, s.signature)
+	default:
+		return fmt.Errorf("CMS signature: unsupported public key type %T", pub)
+	}
+	if !ok {
+		return errors.New("CMS signature: signature does not match")
+	}
+	return nil
+}
+
+// codeSigningAttributes returns the signed attributes of a code signature
+// over cds, primary code directory first.
+func codeSigningAttributes(cds []*CodeDirectory, signingTime time.Time) ([]attribute, error) {
+	hashes := struct {
+		CDHashes [][]byte `plist:"cdhashes"`
+	}{}
+	var hashes2 []byte
+	for _, cd := range cds {
+		hashes.CDHashes = append(hashes.CDHashes, cd.CDHash())
+		oid := oidSHA256
+		switch cd.HashType {
+		case HashSHA1:
+			oid = oidSHA1
+		case HashSHA384:
+			oid = oidSHA384
+		}
+		b, err := asn1.Marshal(struct {
+			Algorithm asn1.ObjectIdentifier
+			Hash      []byte
+		}{oid, cd.FullCDHash()})
+		if err != nil {
+			return nil, err
+		}
+		hashes2 = append(hashes2, b...)
+	}
+	doc, err := plist.MarshalIndent(hashes, plist.XMLFormat, "\t")
+	if err != nil {
+		return nil, err
+	}
+	h := crypto.SHA256.New()
+	h.Write(cds[0].Raw)
+
+	var attrs []attribute
+	for _, a := range []struct {
+		oid   asn1.ObjectIdentifier
+		value any
+	}{
+		{oidContentType, oidData},
+		{oidSigningTime, signingTime.UTC()},
+		{oidMessageDigest, h.Sum(nil)},
+		{oidAppleCDHashesPlist, doc},
+	} {
+		b, err := asn1.Marshal(a.value)
+		if err != nil {
+			return nil, err
+		}
+		attrs = append(attrs, attribute{a.oid, setOf(b)})
+	}
+	return append(attrs, attribute{oidAppleCDHashes2, setOf(hashes2)}), nil
+}
+
+func setOf(content []byte) asn1.RawValue {
+	return asn1.RawValue{Tag: asn1.TagSet, IsCompound: true, Bytes: content}
+}
+
+// signCMS returns a detached CMS signature by id with the given attributes.
+// It signs with SHA-256, which is what the signed attributes must digest
+// the content with. When timestamp is not nil, it is called with the
+// signature value and returns the timestamp token to add to the signer.
+func signCMS(id *Identity, signed []attribute, timestamp func(signature []byte) ([]byte, error)) ([]byte, error) {
+	// DER sorts the elements of a SET OF by their encoding.
+	encoded := make([][]byte, len(signed))
+	for i, a := range signed {
+		b, err := asn1.Marshal(a)
+		if err != nil {
+			return nil, err
+		}
+		encoded[i] = b
+	}
+	sort.Slice(encoded, func(i, j int) bool { return bytes.Compare(encoded[i], encoded[j]) < 0 })
+	attrs := bytes.Join(encoded, nil)
+	set, err := asn1.Marshal(setOf(attrs))
+	if err != nil {
+		return nil, err
+	}
+	h := crypto.SHA256.New()
+	h.Write(set)
+	signature, err := id.Key.Sign(rand.Reader, h.Sum(nil), crypto.SHA256)
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign: %w", err)
+	}
+	var unsigned []attribute
+	if timestamp != nil {
+		token, err := timestamp(signature)
+		if err != nil {
+			return nil, fmt.Errorf("failed to timestamp the signature: %w", err)
+		}
+		unsigned = []attribute{{oidTimestampToken, setOf(token)}}
+	}
+	// RSA identifiers carry NULL parameters, ECDSA ones none.
+	sigAlg := pkix.AlgorithmIdentifier{Algorithm: oidRSAEncryption, Parameters: asn1.NullRawValue}
+	if _, ok := id.Key.Public().(*ecdsa.PublicKey); ok {
+		sigAlg = pkix.AlgorithmIdentifier{Algorithm: oidECDSAWithSHA256}
+	}
+
+	sid, err := asn1.Marshal(issuerAndSerial{asn1.RawValue{FullBytes: id.Certificate.RawIssuer}, id.Certificate.SerialNumber})
+	if err != nil {
+		return nil, err
+	}
+	certs := id.Certificate.Raw
+	for _, c := range id.Chain {
+		certs = append(certs[:len(certs):len(certs)], c.Raw...)
+	}
+	sha256Alg := pkix.AlgorithmIdentifier{Algorithm: oidSHA256, Parameters: asn1.NullRawValue}
+	sd, err := asn1.Marshal(signedData{
+		Version:          1,
+		DigestAlgorithms: []pkix.AlgorithmIdentifier{sha256Alg},
+		EncapContentInfo: encapContentInfo{EContentType: oidData},
+		Certificates:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: certs},
+		SignerInfos: []signerInfo{{
+			Version:            1,
+			SID:                asn1.RawValue{FullBytes: sid},
+			DigestAlgorithm:    sha256Alg,
+			SignedAttrs:        asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: attrs},
+			SignatureAlgorithm: sigAlg,
+			Signature:          signature,
+			UnsignedAttrs:      unsigned,
+		}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return asn1.Marshal(contentInfo{
+		ContentType: oidSignedData,
+		Content:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: sd},
+	})
+}
+
+// cmsSizeEstimate returns the room to reserve for the CMS signature of id:
+// its certificates, the attributes, the signature and the timestamp token
+// when there is one.
+func cmsSizeEstimate(id *Identity, timestamped bool) int {
+	size := 2048 + len(id.Certificate.Raw)
+	if timestamped {
+		size += timestampSizeEstimate
+	}
+	for _, c := range id.Chain {
+		size += len(c.Raw)
+	}
+	if pub, ok := id.Key.Public().(*rsa.PublicKey); ok {
+		size += pub.Size()
+	}
+	return size
+}
@@ -0,0 +1,318 @@
+package codesign
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"strings"
+)
+
+// Code directory flags.
+const (
+	FlagHost               = 0x00000001
+	FlagAdhoc              = 0x00000002
+	FlagForceHard          = 0x00000100
+	FlagForceKill          = 0x00000200
+	FlagForceExpiration    = 0x00000400
+	FlagRestrict           = 0x00000800
+	FlagEnforcement        = 0x00001000
+	FlagLibraryValidation  = 0x00002000
+	FlagRuntime            = 0x00010000
+	FlagLinkerSigned       = 0x00020000
+	codeDirectoryMinLength = 44
+)
+
+var flagNames = []struct {
+	flag uint32
+	name string
+}{
+	{FlagHost, "host"},
+	{FlagAdhoc, "adhoc"},
+	{FlagForceHard, "hard"},
+	{FlagForceKill, "kill"},
+	{FlagForceExpiration, "expires"},
+	{FlagRestrict, "restrict"},
+	{FlagEnforcement, "enforcement"},
+	{FlagLibraryValidation, "library-validation"},
+	{FlagRuntime, "runtime"},
+	{FlagLinkerSigned, "linker-signed"},
+}
+
+// FlagString returns flags as codesign prints them, such as
+// 0x10000(runtime).
+func FlagString(flags uint32) string {
+	var names []string
+	for _, f := range flagNames {
+		if flags&f.flag != 0 {
+			names = append(names, f.name)
+		}
+	}
+	if len(names) == 0 {
+		return fmt.Sprintf("0x%x(none)", flags)
+	}
+	return fmt.Sprintf("0x%x(%s)", flags, strings.Join(names, ","))
+}
+
+// HashType identifies the hash function of a code directory.
+type HashType uint8
+
+const (
+	HashSHA1            HashType = 1
+	HashSHA256          HashType = 2
+	HashSHA256Truncated HashType = 3
+	HashSHA384          HashType = 4
+)
+
+func (t HashType) String() string {
+	switch t {
+	case HashSHA1:
+		return "sha1"
+	case HashSHA256:
+		return "sha256"
+	case HashSHA256Truncated:
+		return "sha256-truncated"
+	case HashSHA384:
+		return "sha384"
+	}
+	return fmt.Sprintf("hash type %d", uint8(t))
+}
+
+// New returns a hash of the type, or nil for unknown types.
+func (t HashType) New() hash.Hash {
+	switch t {
+	case HashSHA1:
+		return sha1.New()
+	case HashSHA256, HashSHA256Truncated:
+		return sha256.New()
+	case HashSHA384:
+		return sha512.New384()
+	}
+	return nil
+}
+
+// Size returns the length of the hashes of the type as stored in a code
+// directory.
+func (t HashType) Size() int {
+	switch t {
+	case HashSHA1, HashSHA256Truncated:
+		return 20
+	case HashSHA256:
+		return 32
+	case HashSHA384:
+		return 48
+	}
+	return 0
+}
+
+// CodeDirectory is the parsed form of a code directory blob, the part of a
+// signature that holds the hashes of the code pages and the special slots.
+type CodeDirectory struct {
+	Version  uint32
+	Flags    uint32
+	HashType HashType
+	Platform uint8
+	// PageSize is the size of the pages hashed in the code slots, 0 when
+	// the code is hashed as a whole.
+	PageSize   int
+	Identifier string
+	TeamID     string
+	CodeLimit  uint64
+	// ExecSeg* describe the executable segment, from version 0x20400.
+	ExecSegBase  uint64
+	ExecSegLimit uint64
+	ExecSegFlags uint64
+	// Runtime is the SDK version the hardened runtime was built against,
+	// from version 0x20500.
+	Runtime uint32
+	// SpecialSlots holds the hashes of the special slots, SpecialSlots[i]
+	// for slot -(i+1). Empty slots are all zeros.
+	SpecialSlots [][]byte
+	CodeSlots    [][]byte
+	// Raw is the whole blob, which the CDHash is computed over.
+	Raw []byte
+}
+
+// ParseCodeDirectory parses a code directory blob.
+func ParseCodeDirectory(data []byte) (*CodeDirectory, error) {
+	if len(data) < codeDirectoryMinLength {
+		return nil, errTruncated
+	}
+	be := binary.BigEndian
+	if magic := be.Uint32(data); magic != MagicCodeDirectory {
+		return nil, fmt.Errorf("not a code directory: magic 0x%08x", magic)
+	}
+	length := be.Uint32(data[4:])
+	if length < codeDirectoryMinLength || int64(length) > int64(len(data)) {
+		return nil, errTruncated
+	}
+	data = data[:length]
+	cd := &CodeDirectory{
+		Version:   be.Uint32(data[8:]),
+		Flags:     be.Uint32(data[12:]),
+		CodeLimit: uint64(be.Uint32(data[32:])),
+		HashType:  HashType(data[37]),
+		Platform:  data[38],
+		Raw:       data,
+	}
+	hashOffset := be.Uint32(data[16:])
+	identOffset := be.Uint32(data[20:])
+	nSpecial := be.Uint32(data[24:])
+	nCode := be.Uint32(data[28:])
+	hashSize := int(data[36])
+	if data[39] != 0 {
+		if data[39] >= 32 {
+			return nil, fmt.Errorf("invalid page size 2^%d", data[39])
+		}
+		cd.PageSize = 1 << data[39]
+	}
+	if hashSize == 0 || hashSize != cd.HashType.Size() {
+		return nil, fmt.Errorf("unsupported %s with %d byte hashes", cd.HashType, hashSize)
+	}
+
+	field := func(offset int, size int) []byte {
+		if len(data) < offset+size {
+			return nil
+		}
+		return data[offset : offset+size]
+	}
+	if cd.Version >= 0x20200 {
+		if b := field(48, 4); b != nil && be.Uint32(b) != 0 {
+			team, err := cString(data, be.Uint32(b))
+			if err != nil {
+				return nil, fmt.Errorf("team ID: %w", err)
+			}
+			cd.TeamID = team
+		}
+	}
+	if cd.Version >= 0x20300 {
+		if b := field(56, 8); b != nil && be.Uint64(b) != 0 {
+			cd.CodeLimit = be.Uint64(b)
+		}
+	}
+	if cd.Version >= 0x20400 {
+		if b := field(64, 24); b != nil {
+			cd.ExecSegBase = be.Uint64(b)
+			cd.ExecSegLimit = be.Uint64(b[8:])
+			cd.ExecSegFlags = be.Uint64(b[16:])
+		}
+	}
+	if cd.Version >= 0x20500 {
+		if b := field(88, 4); b != nil {
+			cd.Runtime = be.Uint32(b)
+		}
+	}
+
+	ident, err := cString(data, identOffset)
+	if err != nil {
+		return nil, fmt.Errorf("identifier: %w", err)
+	}
+	cd.Identifier = ident
+	if int64(hashOffset) < int64(nSpecial)*int64(hashSize) ||
+		int64(hashOffset)+int64(nCode)*int64(hashSize) > int64(len(data)) {
+		return nil, errTruncated
+	}
+	cd.SpecialSlots = make([][]byte, nSpecial)
+	for i := range cd.SpecialSlots {
+		off := int(hashOffset) - (i+1)*hashSize
+		cd.SpecialSlots[i] = data[off : off+hashSize]
+	}
+	cd.CodeSlots = make([][]byte, nCode)
+	for i := range cd.CodeSlots {
+		off := int(hashOffset) + i*hashSize
+		cd.CodeSlots[i] = data[off : off+hashSize]
+	}
+	return cd, nil
+}
+
+func cString(data []byte, offset uint32) (string, error) {
+	if int64(offset) >= int64(len(data)) {
+		return "", errTruncated
+	}
+	s := data[offset:]
+	end := bytes.IndexByte(s, 0)
+	if end < 0 {
+		return "", errors.New("unterminated string")
+	}
+	return string(s[:end]), nil
+}
+
+// CDHash returns the hash of the code directory that identifies the code,
+// truncated to 20 bytes.
+func (cd *CodeDirectory) CDHash() []byte {
+	return cd.FullCDHash()[:20]
+}
+
+// FullCDHash returns the untruncated hash of the code directory.
+func (cd *CodeDirectory) FullCDHash() []byte {
+	h := cd.HashType.New()
+	h.Write(cd.Raw)
+	return h.Sum(nil)
+}
+
+// SpecialSlot returns the hash of special slot n, nil when the directory
+// has no such slot.
+func (cd *CodeDirectory) SpecialSlot(n int) []byte {
+	if n < 1 || n > len(cd.SpecialSlots) {
+		return nil
+	}
+	return cd.SpecialSlots[n-1]
+}
+
+// hashOf returns the hash of data the way the code directory stores it.
+func (cd *CodeDirectory) hashOf(data []byte) []byte {
+	h := cd.HashType.New()
+	h.Write(data)
+	return h.Sum(nil)[:cd.HashType.Size()]
+}
+
+// VerifyCode checks the code slots against code, the start of the binary up
+// to at least the code limit.
+func (cd *CodeDirectory) VerifyCode(code []byte) error {
+	if uint64(len(code)) < cd.CodeLimit {
+		return fmt.Errorf("code is %d bytes, the code limit is %d", len(code), cd.CodeLimit)
+	}
+	code = code[:cd.CodeLimit]
+	pageSize := cd.PageSize
+	if pageSize == 0 {
+		pageSize = len(code)
+	}
+	if want := (len(code) + pageSize - 1) / max(pageSize, 1); want != len(cd.CodeSlots) {
+		return fmt.Errorf("%d code slots for %d pages", len(cd.CodeSlots), want)
+	}
+	var bad []string
+	for i, slot := range cd.CodeSlots {
+		page := code[i*pageSize : min((i+1)*pageSize, len(code))]
+		if !bytes.Equal(cd.hashOf(page), slot) {
+			bad = append(bad, fmt.Sprint(i))
+		}
+	}
+	if len(bad) > 0 {
+		return fmt.Errorf("%d of %d page hashes do not match (pages %s)", len(bad), len(cd.CodeSlots), strings.Join(bad, ", "))
+	}
+	return nil
+}
+
+// VerifySpecialSlot checks special slot n against the hash of data, which
+// is the blob or file the slot stands for. Absent or empty slots only match
+// absent data.
+func (cd *CodeDirectory) VerifySpecialSlot(n int, data []byte) bool {
+	slot := cd.SpecialSlot(n)
+	if slot == nil || isZero(slot) {
+		return data == nil
+	}
+	return data != nil && bytes.Equal(cd.hashOf(data), slot)
+}
+
+func isZero(b []byte) bool {
+	for _, c := range b {
+		if c != 0 {
+			return false
+		}
+	}
+	return true
+}
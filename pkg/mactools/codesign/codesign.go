@@ -4,7 +4,10 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"os"
 	"os/exec"
+	"path/filepath"
+	"strings"
 )
 
 // ErrCodesignFailed is returned when the codesign command fails.
@@ -19,6 +22,8 @@ type Options struct {
 	Verbose          bool
 	DeepSign         bool
 	Runtime          bool
+	CodeOptions      []string
+	Identifier       string
 	PreserveMetadata []string
 	Requirements     string
 	Timestamp        string
@@ -63,6 +68,21 @@ func WithDeepSign(deep bool) Option {
 	}
 }
 
+// WithCodeOptions sets the codesign --options names, such as runtime, in
+// place of runtime alone.
+func WithCodeOptions(names ...string) Option {
+	return func(o *Options) {
+		o.CodeOptions = names
+	}
+}
+
+// WithIdentifier sets the signing identifier.
+func WithIdentifier(identifier string) Option {
+	return func(o *Options) {
+		o.Identifier = identifier
+	}
+}
+
 // WithPreserveMetadata sets the metadata to preserve.
 func WithPreserveMetadata(metadata ...string) Option {
 	return func(o *Options) {
@@ -84,7 +104,8 @@ func WithTimestamp(url string) Option {
 	}
 }
 
-// CodeSign performs code signing on the specified file.
+// CodeSign performs code signing on the specified file. Nested code is not
+// signed unless WithDeepSign is given; CodeSignPlan signs it inside out.
 func CodeSign(ctx context.Context, identityName, filePath string, opts ...Option) error {
 	if identityName == "" || filePath == "" {
 		return errors.New("identity name and file path are required")
@@ -95,7 +116,6 @@ func CodeSign(ctx context.Context, identityName, filePath string, opts ...Option
 		FilePath:     filePath,
 		Force:        true, // Set force as default
 		Runtime:      true, // Set runtime as default
-		DeepSign:     true,
 	}
 
 	for _, opt := range opts {
@@ -127,9 +147,16 @@ func buildArgs(options *Options) []string {
 	if options.DeepSign {
 		args = append(args, "--deep")
 	}
-	if options.Runtime {
+	if options.CodeOptions != nil {
+		if len(options.CodeOptions) > 0 {
+			args = append(args, "--options="+strings.Join(options.CodeOptions, ","))
+		}
+	} else if options.Runtime {
 		args = append(args, "--options=runtime")
 	}
+	if options.Identifier != "" {
+		args = append(args, "--identifier", options.Identifier)
+	}
 	for _, metadata := range options.PreserveMetadata {
 		args = append(args, "--preserve-metadata="+metadata)
 	}
@@ -145,3 +172,34 @@ func buildArgs(options *Options) []string {
 	args = append(args, options.FilePath)
 	return args
 }
+
+// CodeSignPlan signs the components of plan in order with codesign, each
+// with its own identifier, options and entitlements, instead of signing
+// the bundle with --deep.
+func CodeSignPlan(ctx context.Context, identityName string, plan *SigningPlan, opts ...Option) error {
+	tempDir, err := os.MkdirTemp("", "codesign-")
+	if err != nil {
+		return fmt.Errorf("failed to create temp directory: %w", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	for i, c := range plan.Components {
+		path := filepath.Join(plan.Bundle, filepath.FromSlash(c.Path))
+		componentOpts := append(opts[:len(opts):len(opts)],
+			WithDeepSign(false),
+			WithIdentifier(c.Options.Identifier),
+			WithCodeOptions(codesignOptionNames(c.Options.Flags)...),
+			WithEntitlements(""))
+		if c.Options.Entitlements != nil {
+			entitlements := filepath.Join(tempDir, fmt.Sprintf("%d.entitlements", i))
+			if err := os.WriteFile(entitlements, c.Options.Entitlements, 0o644); err != nil {
+				return err
+			}
+			componentOpts = append(componentOpts, WithEntitlements(entitlements))
+		}
+		if err := CodeSign(ctx, identityName, path, componentOpts...); err != nil {
+			return fmt.Errorf("%s: %w", c.Path, err)
+		}
+	}
+	return nil
+}
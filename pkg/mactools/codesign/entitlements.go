@@ -0,0 +1,97 @@
+package codesign
+
+import (
+	"encoding/asn1"
+	"fmt"
+	"math/big"
+	"sort"
+
+	"howett.net/plist"
+)
+
+// DER tags of the entitlements encoding.
+const (
+	derBoolean    = 0x01
+	derInteger    = 0x02
+	derUTF8String = 0x0c
+	derSequence   = 0x30
+	derSet        = 0x31
+	// derEntitlements and derDictionary wrap the top level dictionary:
+	// [APPLICATION 16] { INTEGER 1, [16] { dictionary } }.
+	derEntitlements = 0x70
+	derDictionary   = 0xb0
+)
+
+// EntitlementsToDER converts an entitlements plist to the DER form that
+// recent systems read from the DER entitlements slot.
+func EntitlementsToDER(doc []byte) ([]byte, error) {
+	var ents map[string]any
+	if _, err := plist.Unmarshal(doc, &ents); err != nil {
+		return nil, fmt.Errorf("entitlements: %w", err)
+	}
+	dict, err := derValue(ents)
+	if err != nil {
+		return nil, err
+	}
+	version := derTLV(derInteger, []byte{1})
+	return derTLV(derEntitlements, append(version, derTLV(derDictionary, dict)...)), nil
+}
+
+func derValue(v any) ([]byte, error) {
+	switch v := v.(type) {
+	case bool:
+		b := byte(0)
+		if v {
+			b = 0xff
+		}
+		return derTLV(derBoolean, []byte{b}), nil
+	case string:
+		return derTLV(derUTF8String, []byte(v)), nil
+	case uint64:
+		return asn1.Marshal(new(big.Int).SetUint64(v))
+	case int64:
+		return asn1.Marshal(v)
+	case []any:
+		var items []byte
+		for _, item := range v {
+			b, err := derValue(item)
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, b...)
+		}
+		return derTLV(derSequence, items), nil
+	case map[string]any:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		var entries []byte
+		for _, k := range keys {
+			b, err := derValue(v[k])
+			if err != nil {
+				return nil, fmt.Errorf("entitlement %q: %w", k, err)
+			}
+			entries = append(entries, derTLV(derSequence, append(derTLV(derUTF8String, []byte(k)), b...))...)
+		}
+		return derTLV(derSet, entries), nil
+	}
+	return nil, fmt.Errorf("%T values cannot be encoded as DER", v)
+}
+
+// derTLV encodes a DER element.
+func derTLV(tag byte, content []byte) []byte {
+	b := []byte{tag}
+	switch n := len(content); {
+	case n < 0x80:
+		b = append(b, byte(n))
+	case n < 0x100:
+		b = append(b, 0x81, byte(n))
+	case n < 0x10000:
+		b = append(b, 0x82, byte(n>>8), byte(n))
+	default:
+		b = append(b, 0x83, byte(n>>16), byte(n>>8), byte(n))
+	}
+	return append(b, content...)
+}
@@ -0,0 +1,78 @@
+package codesign
+
+import (
+	"crypto"
+	"crypto/x509"
+	"encoding/asn1"
+	"fmt"
+	"os"
+
+	"software.sslmate.com/src/go-pkcs12"
+)
+
+var (
+	// oidDeveloperIDApplication marks Developer ID Application certificates.
+	oidDeveloperIDApplication = asn1.ObjectIdentifier{1, 2, 840, 113635, 100, 6, 1, 13}
+	// oidDeveloperIDIntermediate marks the Developer ID certification
+	// authority.
+	oidDeveloperIDIntermediate = asn1.ObjectIdentifier{1, 2, 840, 113635, 100, 6, 2, 6}
+)
+
+// Identity is a signing certificate and its private key.
+type Identity struct {
+	Certificate *x509.Certificate
+	// Chain holds the certificates that issued Certificate, up to the root,
+	// which signatures carry along with it.
+	Chain []*x509.Certificate
+	Key   crypto.Signer
+}
+
+// LoadPKCS12 reads an identity from a PKCS#12 (.p12) file's contents.
+func LoadPKCS12(data []byte, password string) (*Identity, error) {
+	key, cert, chain, err := pkcs12.DecodeChain(data, password)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode PKCS#12: %w", err)
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("unsupported private key type %T", key)
+	}
+	return &Identity{Certificate: cert, Chain: chain, Key: signer}, nil
+}
+
+// LoadPKCS12File reads an identity from a PKCS#12 (.p12) file.
+func LoadPKCS12File(path, password string) (*Identity, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return LoadPKCS12(data, password)
+}
+
+// TeamID returns the team identifier of the certificate, its subject's
+// organizational unit.
+func (id *Identity) TeamID() string {
+	if ou := id.Certificate.Subject.OrganizationalUnit; len(ou) > 0 {
+		return ou[0]
+	}
+	return ""
+}
+
+// isDeveloperID reports whether the certificate is an Apple Developer ID
+// Application certificate.
+func (id *Identity) isDeveloperID() bool {
+	for _, ext := range id.Certificate.Extensions {
+		if ext.Id.Equal(oidDeveloperIDApplication) {
+			return true
+		}
+	}
+	return false
+}
+
+// anchor returns the last certificate of the chain.
+func (id *Identity) anchor() *x509.Certificate {
+	if len(id.Chain) == 0 {
+		return id.Certificate
+	}
+	return id.Chain[len(id.Chain)-1]
+}
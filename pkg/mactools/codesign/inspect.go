@@ -0,0 +1,277 @@
+package codesign
+
+import (
+	"bytes"
+	"debug/macho"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+const loadCmdCodeSignature = 0x1d
+
+// Signature is the parsed form of an embedded code signature.
+type Signature struct {
+	// CodeDirectories holds the primary code directory first, followed by
+	// the alternate ones.
+	CodeDirectories []*CodeDirectory
+	Requirements    []Requirement
+	// Entitlements is the XML entitlements plist, nil when absent.
+	Entitlements []byte
+	// EntitlementsDER is the DER encoded entitlements, nil when absent.
+	EntitlementsDER []byte
+	// CMS is the signer's signature, nil for ad-hoc signatures.
+	CMS *CMSSignature
+
+	// blobs maps the special slots to the blobs stored for them.
+	blobs map[uint32][]byte
+}
+
+// ParseSignature parses an embedded signature, the superblob that
+// LC_CODE_SIGNATURE points to.
+func ParseSignature(data []byte) (*Signature, error) {
+	blobs, err := parseSuperBlob(data)
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(blobs, func(i, j int) bool { return blobs[i].slot < blobs[j].slot })
+	sig := &Signature{blobs: make(map[uint32][]byte)}
+	for _, b := range blobs {
+		switch {
+		case b.slot == SlotCodeDirectory,
+			b.slot >= SlotAlternateCodeDirectory && b.slot <= SlotAlternateCodeDirectory4:
+			cd, err := ParseCodeDirectory(b.data)
+			if err != nil {
+				return nil, fmt.Errorf("code directory in slot 0x%x: %w", b.slot, err)
+			}
+			sig.CodeDirectories = append(sig.CodeDirectories, cd)
+		case b.slot == SlotRequirements:
+			reqs, err := ParseRequirements(b.data)
+			if err != nil {
+				return nil, err
+			}
+			sig.Requirements = reqs
+		case b.slot == SlotEntitlements:
+			sig.Entitlements = b.data[blobHeaderSize:]
+		case b.slot == SlotDEREntitlements:
+			sig.EntitlementsDER = b.data[blobHeaderSize:]
+		case b.slot == SlotSignature:
+			cms, err := ParseCMSSignature(b.data)
+			if err != nil {
+				return nil, err
+			}
+			sig.CMS = cms
+		}
+		if b.slot < SlotAlternateCodeDirectory {
+			sig.blobs[b.slot] = b.data
+		}
+	}
+	if len(sig.CodeDirectories) == 0 || blobs[0].slot != SlotCodeDirectory {
+		return nil, errors.New("signature has no code directory")
+	}
+	return sig, nil
+}
+
+// Verify checks the code pages and the hashes of the blobs in the signature
+// against every code directory, and that the CMS signature, if any, signs
+// them. code is the binary the signature belongs to.
+func (s *Signature) Verify(code []byte) error {
+	for _, cd := range s.CodeDirectories {
+		if err := cd.VerifyCode(code); err != nil {
+			return fmt.Errorf("%s code directory: %w", cd.HashType, err)
+		}
+		for _, slot := range []uint32{SlotRequirements, SlotEntitlements, SlotDEREntitlements} {
+			if !cd.VerifySpecialSlot(int(slot), s.blobs[slot]) {
+				return fmt.Errorf("%s code directory: special slot %d does not match", cd.HashType, slot)
+			}
+		}
+	}
+	if s.CMS == nil {
+		return nil
+	}
+	if err := s.CMS.VerifySignature(s.CodeDirectories[0].Raw); err != nil {
+		return err
+	}
+	for i, cd := range s.CodeDirectories {
+		if i >= len(s.CMS.CDHashes) || !bytes.Equal(s.CMS.CDHashes[i], cd.CDHash()) {
+			return fmt.Errorf("%s code directory is not in the CMS signature's cdhashes", cd.HashType)
+		}
+	}
+	return nil
+}
+
+// Slice is one architecture of a Mach-O file.
+type Slice struct {
+	Arch string
+	// Offset and Size locate the slice in a universal binary.
+	Offset int64
+	Size   int64
+	// Signature is nil when the slice is not signed.
+	Signature *Signature
+	// VerifyErr reports code pages or blobs that do not match the
+	// signature.
+	VerifyErr error
+}
+
+// File is a Mach-O file and the signatures of its slices.
+type File struct {
+	Path   string
+	Slices []Slice
+}
+
+// Inspect reads the code signatures of the Mach-O file at path, or of every
+// Mach-O file in the bundle when path is a directory.
+func Inspect(path string) ([]File, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		f, err := inspectFile(path)
+		if err != nil {
+			return nil, err
+		}
+		return []File{*f}, nil
+	}
+	var files []File
+	err = filepath.WalkDir(path, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || !d.Type().IsRegular() {
+			return err
+		}
+		if ok, err := isMachO(p); err != nil || !ok {
+			return err
+		}
+		f, err := inspectFile(p)
+		if err != nil {
+			return err
+		}
+		files = append(files, *f)
+		return nil
+	})
+	return files, err
+}
+
+// isMachO reports whether the file at path starts with a Mach-O or
+// universal binary magic.
+func isMachO(path string) (bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	var magic [4]byte
+	if _, err := f.Read(magic[:]); err != nil {
+		return false, nil
+	}
+	switch binary.BigEndian.Uint32(magic[:]) {
+	case macho.Magic32, macho.Magic64, macho.MagicFat, 0xcefaedfe, 0xcffaedfe:
+		// A universal binary shares its magic with Java class files, which
+		// have a large version number where the architecture count is.
+		if binary.BigEndian.Uint32(magic[:]) == macho.MagicFat {
+			var n [4]byte
+			if _, err := f.Read(n[:]); err != nil || binary.BigEndian.Uint32(n[:]) > 30 {
+				return false, nil
+			}
+		}
+		return true, nil
+	}
+	return false, nil
+}
+
+func inspectFile(path string) (*File, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	file := &File{Path: path}
+	fat, err := macho.NewFatFile(bytes.NewReader(data))
+	switch {
+	case err == nil:
+		for _, arch := range fat.Arches {
+			end := int64(arch.Offset) + int64(arch.Size)
+			if end > int64(len(data)) {
+				return nil, fmt.Errorf("%s: %s slice is truncated", path, archName(arch.Cpu, arch.SubCpu))
+			}
+			s, err := inspectSlice(data[arch.Offset:end])
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", path, err)
+			}
+			s.Arch = archName(arch.Cpu, arch.SubCpu)
+			s.Offset, s.Size = int64(arch.Offset), int64(arch.Size)
+			file.Slices = append(file.Slices, *s)
+		}
+	case errors.Is(err, macho.ErrNotFat):
+		s, err := inspectSlice(data)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		s.Size = int64(len(data))
+		file.Slices = append(file.Slices, *s)
+	default:
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return file, nil
+}
+
+// inspectSlice parses the signature of a thin Mach-O binary.
+func inspectSlice(data []byte) (*Slice, error) {
+	f, err := macho.NewFile(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	s := &Slice{Arch: archName(f.Cpu, f.SubCpu)}
+	off, size, ok := codeSignatureRange(f)
+	if !ok {
+		return s, nil
+	}
+	if int64(off)+int64(size) > int64(len(data)) {
+		return nil, fmt.Errorf("%s: code signature is outside the file", s.Arch)
+	}
+	s.Signature, err = ParseSignature(data[off : off+size])
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", s.Arch, err)
+	}
+	s.VerifyErr = s.Signature.Verify(data)
+	return s, nil
+}
+
+// codeSignatureRange returns the location of the signature from the
+// LC_CODE_SIGNATURE load command.
+func codeSignatureRange(f *macho.File) (off, size uint32, ok bool) {
+	for _, l := range f.Loads {
+		raw := l.Raw()
+		if len(raw) >= 16 && f.ByteOrder.Uint32(raw) == loadCmdCodeSignature {
+			return f.ByteOrder.Uint32(raw[8:]), f.ByteOrder.Uint32(raw[12:]), true
+		}
+	}
+	return 0, 0, false
+}
+
+// archName returns the architecture name the Apple tools use.
+func archName(cpu macho.Cpu, sub uint32) string {
+	switch cpu {
+	case macho.Cpu386:
+		return "i386"
+	case macho.CpuAmd64:
+		if sub&0xff == 8 {
+			return "x86_64h"
+		}
+		return "x86_64"
+	case macho.CpuArm:
+		return "arm"
+	case macho.CpuArm64:
+		if sub&0xff == 2 {
+			return "arm64e"
+		}
+		return "arm64"
+	case macho.CpuPpc:
+		return "ppc"
+	case macho.CpuPpc64:
+		return "ppc64"
+	}
+	return fmt.Sprintf("cpu 0x%x", uint32(cpu))
+}
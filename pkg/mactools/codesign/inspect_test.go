@@ -0,0 +1,385 @@
+package codesign
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/binary"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+var be = binary.BigEndian
+
+func testBlob(magic uint32, payload []byte) []byte {
+	b := be.AppendUint32(nil, magic)
+	b = be.AppendUint32(b, uint32(8+len(payload)))
+	return append(b, payload...)
+}
+
+// testSuperBlob builds a superblob, or a requirement set, of blobs in the
+// given slots.
+func testSuperBlob(magic uint32, slots []uint32, blobs [][]byte) []byte {
+	b := be.AppendUint32(nil, magic)
+	b = be.AppendUint32(b, 0)
+	b = be.AppendUint32(b, uint32(len(blobs)))
+	offset := 12 + 8*len(blobs)
+	for i, blob := range blobs {
+		b = be.AppendUint32(b, slots[i])
+		b = be.AppendUint32(b, uint32(offset))
+		offset += len(blob)
+	}
+	for _, blob := range blobs {
+		b = append(b, blob...)
+	}
+	be.PutUint32(b[4:], uint32(len(b)))
+	return b
+}
+
+// reqOps assembles requirement opcodes: uint32 values are emitted as they
+// are and strings as length-prefixed data.
+func reqOps(ops ...any) []byte {
+	b := be.AppendUint32(nil, 1) // expression kind
+	for _, op := range ops {
+		switch v := op.(type) {
+		case int:
+			b = be.AppendUint32(b, uint32(v))
+		case string:
+			b = be.AppendUint32(b, uint32(len(v)))
+			b = append(b, v...)
+			for len(b)%4 != 0 {
+				b = append(b, 0)
+			}
+		}
+	}
+	return testBlob(MagicRequirement, b)
+}
+
+var designated = reqOps(
+	opAnd, opAnd, opIdent, "com.example.app", opAppleGenericAnchor,
+	opCertField, 0, "subject.OU", matchEqual, "TEAMID1234")
+
+// testCodeDirectory builds a version 0x20400 code directory over code.
+func testCodeDirectory(code []byte, flags uint32, special map[int][]byte) []byte {
+	const headerSize = 88
+	ident, team := "com.example.app\x00", "TEAMID1234\x00"
+	nSpecial := 0
+	for n := range special {
+		nSpecial = max(nSpecial, n)
+	}
+	nCode := (len(code) + 4095) / 4096
+	hashOffset := headerSize + len(ident) + len(team) + 32*nSpecial
+
+	cd := make([]byte, headerSize)
+	be.PutUint32(cd, MagicCodeDirectory)
+	be.PutUint32(cd[8:], 0x20400)
+	be.PutUint32(cd[12:], flags)
+	be.PutUint32(cd[16:], uint32(hashOffset))
+	be.PutUint32(cd[20:], headerSize)
+	be.PutUint32(cd[24:], uint32(nSpecial))
+	be.PutUint32(cd[28:], uint32(nCode))
+	be.PutUint32(cd[32:], uint32(len(code)))
+	cd[36], cd[37], cd[39] = 32, byte(HashSHA256), 12
+	be.PutUint32(cd[48:], uint32(headerSize+len(ident)))
+	be.PutUint64(cd[72:], 0x4000) // exec segment limit
+	cd = append(cd, ident+team...)
+	for n := nSpecial; n >= 1; n-- {
+		h := make([]byte, 32)
+		if data, ok := special[n]; ok {
+			s := sha256.Sum256(data)
+			h = s[:]
+		}
+		cd = append(cd, h...)
+	}
+	for i := 0; i < nCode; i++ {
+		h := sha256.Sum256(code[i*4096 : min((i+1)*4096, len(code))])
+		cd = append(cd, h[:]...)
+	}
+	be.PutUint32(cd[4:], uint32(len(cd)))
+	return cd
+}
+
+// testCMS builds a CMS signature of cd by a self-signed certificate, with a
+// signing time, a message digest, a cdhashes attribute and a timestamp
+// token.
+func testCMS(t *testing.T, cd []byte, signed, stamped time.Time) []byte {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(42),
+		Subject:      pkix.Name{CommonName: "Developer ID Application: Example (TEAMID1234)", OrganizationalUnit: []string{"TEAMID1234"}},
+		NotBefore:    signed.Add(-time.Hour),
+		NotAfter:     signed.Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mustMarshal := func(v any) []byte {
+		b, err := asn1.Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return b
+	}
+	set := func(v []byte) asn1.RawValue {
+		return asn1.RawValue{Tag: asn1.TagSet, IsCompound: true, Bytes: v}
+	}
+	explicit := func(v []byte) asn1.RawValue {
+		return asn1.RawValue{Class: asn1.ClassContextSpecific, IsCompound: true, Bytes: v}
+	}
+	contentInfoOf := func(sd signedData) []byte {
+		return mustMarshal(contentInfo{ContentType: oidSignedData, Content: explicit(mustMarshal(sd))})
+	}
+
+	tst := mustMarshal(tstInfo{
+		Version:        1,
+		Policy:         asn1.ObjectIdentifier{1, 2, 3},
+		MessageImprint: asn1.RawValue{FullBytes: mustMarshal(asn1.RawValue{Tag: asn1.TagSequence, IsCompound: true})},
+		Serial:         big.NewInt(1),
+		GenTime:        stamped,
+	})
+	token := contentInfoOf(signedData{
+		Version: 3,
+		EncapContentInfo: encapContentInfo{
+			EContentType: asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 16, 1, 4},
+			EContent:     explicit(mustMarshal(tst)),
+		},
+	})
+	cdhash := sha256.Sum256(cd)
+	hashes := []byte(`<?xml version="1.0" encoding="UTF-8"?>
+<plist version="1.0"><dict><key>cdhashes</key><array><data>` +
+		base64.StdEncoding.EncodeToString(cdhash[:20]) + `</data></array></dict></plist>`)
+
+	// The signature covers the attributes encoded as a SET.
+	attrs := bytes.Join([][]byte{
+		mustMarshal(attribute{oidSigningTime, set(mustMarshal(signed))}),
+		mustMarshal(attribute{oidMessageDigest, set(mustMarshal(cdhash[:]))}),
+		mustMarshal(attribute{oidAppleCDHashesPlist, set(mustMarshal(hashes))}),
+	}, nil)
+	digest := sha256.Sum256(mustMarshal(set(attrs)))
+	signature, err := ecdsa.SignASN1(rand.Reader, key, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return contentInfoOf(signedData{
+		Version:          1,
+		EncapContentInfo: encapContentInfo{EContentType: asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 1}},
+		Certificates:     asn1.RawValue{Class: asn1.ClassContextSpecific, IsCompound: true, Bytes: der},
+		SignerInfos: []signerInfo{{
+			Version:            1,
+			DigestAlgorithm:    pkix.AlgorithmIdentifier{Algorithm: asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 1}},
+			SignatureAlgorithm: pkix.AlgorithmIdentifier{Algorithm: asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 2}},
+			SID:                asn1.RawValue{FullBytes: mustMarshal(issuerAndSerial{asn1.RawValue{FullBytes: cert.RawIssuer}, cert.SerialNumber})},
+			SignedAttrs:        asn1.RawValue{Class: asn1.ClassContextSpecific, IsCompound: true, Bytes: attrs},
+			Signature:          signature,
+			UnsignedAttrs:      []attribute{{oidTimestampToken, set(token)}},
+		}},
+	})
+}
+
+// testMachO returns an arm64 executable signed with sig, a function of the
+// code the signature covers.
+func testMachO(sig func(code []byte) []byte) []byte {
+	const sigSize = 0x4000
+	code := make([]byte, 3*4096+100)
+	binary.LittleEndian.PutUint32(code, 0xfeedfacf)
+	binary.LittleEndian.PutUint32(code[4:], 0x0100000c)
+	binary.LittleEndian.PutUint32(code[12:], 2)
+	binary.LittleEndian.PutUint32(code[16:], 1)
+	binary.LittleEndian.PutUint32(code[20:], 16)
+	lc := code[32:]
+	binary.LittleEndian.PutUint32(lc, loadCmdCodeSignature)
+	binary.LittleEndian.PutUint32(lc[4:], 16)
+	binary.LittleEndian.PutUint32(lc[8:], uint32(len(code)))
+	binary.LittleEndian.PutUint32(lc[12:], sigSize)
+	for i := 64; i < len(code); i++ {
+		code[i] = byte(i * 7)
+	}
+	s := sig(code)
+	return append(append(code, s...), make([]byte, sigSize-len(s))...)
+}
+
+func TestInspect(t *testing.T) {
+	signed := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	stamped := signed.Add(time.Minute)
+	reqs := testSuperBlob(MagicRequirements, []uint32{DesignatedRequirement}, [][]byte{designated})
+	ents := testBlob(MagicEntitlements, []byte("<plist><dict/></plist>"))
+	exe := testMachO(func(code []byte) []byte {
+		cd := testCodeDirectory(code, FlagRuntime, map[int][]byte{SlotRequirements: reqs, SlotEntitlements: ents})
+		return testSuperBlob(MagicEmbeddedSignature,
+			[]uint32{SlotCodeDirectory, SlotRequirements, SlotEntitlements, SlotSignature},
+			[][]byte{cd, reqs, ents, testBlob(MagicBlobWrapper, testCMS(t, cd, signed, stamped))})
+	})
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "Example.app", "Contents", "MacOS", "Example")
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, exe, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "Example.app", "Contents", "Info.plist"), []byte("<plist/>"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	files, err := Inspect(filepath.Join(dir, "Example.app"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 || files[0].Path != path || len(files[0].Slices) != 1 {
+		t.Fatalf("files = %+v", files)
+	}
+	s := files[0].Slices[0]
+	if s.Arch != "arm64" || s.Signature == nil || s.VerifyErr != nil {
+		t.Fatalf("slice = %+v", s)
+	}
+	sig := s.Signature
+	cd := sig.CodeDirectories[0]
+	if cd.Identifier != "com.example.app" || cd.TeamID != "TEAMID1234" || cd.HashType != HashSHA256 ||
+		cd.PageSize != 4096 || len(cd.CodeSlots) != 4 || cd.ExecSegLimit != 0x4000 {
+		t.Errorf("code directory = %+v", cd)
+	}
+	if got := FlagString(cd.Flags); got != "0x10000(runtime)" {
+		t.Errorf("flags = %s", got)
+	}
+	want := `designated => identifier "com.example.app" and anchor apple generic and certificate leaf[subject.OU] = TEAMID1234`
+	if len(sig.Requirements) != 1 || sig.Requirements[0].String() != want {
+		t.Errorf("requirements = %q", sig.Requirements)
+	}
+	if string(sig.Entitlements) != "<plist><dict/></plist>" || sig.EntitlementsDER != nil {
+		t.Errorf("entitlements = %q %x", sig.Entitlements, sig.EntitlementsDER)
+	}
+	cms := sig.CMS
+	if cms == nil || len(cms.Certificates) != 1 || !strings.HasPrefix(cms.Certificates[0].Subject.CommonName, "Developer ID") {
+		t.Fatalf("CMS = %+v", cms)
+	}
+	if !cms.SigningTime.Equal(signed) || !cms.Timestamp.Equal(stamped) {
+		t.Errorf("times = %s, %s", cms.SigningTime, cms.Timestamp)
+	}
+	if len(cms.CDHashes) != 1 || !bytes.Equal(cms.CDHashes[0], cd.CDHash()) {
+		t.Errorf("cdhashes = %x, want %x", cms.CDHashes, cd.CDHash())
+	}
+
+	// A universal binary with a tampered slice.
+	tampered := bytes.Clone(exe)
+	tampered[5000]++
+	fat := make([]byte, 0x4000)
+	be.PutUint32(fat, 0xcafebabe)
+	be.PutUint32(fat[4:], 2)
+	for i, slice := range [][]byte{exe, tampered} {
+		arch := fat[8+20*i:]
+		be.PutUint32(arch, 0x0100000c)
+		be.PutUint32(arch[8:], uint32(len(fat)))
+		be.PutUint32(arch[12:], uint32(len(slice)))
+		be.PutUint32(arch[16:], 14)
+		fat = append(fat, slice...)
+		for len(fat)%0x4000 != 0 {
+			fat = append(fat, 0)
+		}
+	}
+	be.PutUint32(fat[8+20+4:], 2) // arm64e
+	fatPath := filepath.Join(dir, "fat")
+	if err := os.WriteFile(fatPath, fat, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	files, err = Inspect(fatPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	slices := files[0].Slices
+	if len(slices) != 2 || slices[0].Arch != "arm64" || slices[1].Arch != "arm64e" || slices[1].Offset == 0 {
+		t.Fatalf("slices = %+v", slices)
+	}
+	if slices[0].VerifyErr != nil {
+		t.Errorf("arm64: %v", slices[0].VerifyErr)
+	}
+	if err := slices[1].VerifyErr; err == nil || !strings.Contains(err.Error(), "pages 1") {
+		t.Errorf("tampered slice: %v", err)
+	}
+}
+
+func TestInspectAdhoc(t *testing.T) {
+	reqs := testSuperBlob(MagicRequirements, nil, nil)
+	sign := func(sealed []byte) []byte {
+		return testMachO(func(code []byte) []byte {
+			cd := testCodeDirectory(code, FlagAdhoc, map[int][]byte{SlotRequirements: sealed})
+			return testSuperBlob(MagicEmbeddedSignature,
+				[]uint32{SlotCodeDirectory, SlotRequirements, SlotSignature},
+				[][]byte{cd, reqs, testBlob(MagicBlobWrapper, nil)})
+		})
+	}
+	path := filepath.Join(t.TempDir(), "tool")
+	if err := os.WriteFile(path, sign(reqs), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	files, err := Inspect(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := files[0].Slices[0]
+	if s.VerifyErr != nil || s.Signature.CMS != nil || len(s.Signature.Requirements) != 0 {
+		t.Errorf("slice = %+v", s)
+	}
+	if got := FlagString(s.Signature.CodeDirectories[0].Flags); got != "0x2(adhoc)" {
+		t.Errorf("flags = %s", got)
+	}
+
+	// The code directory sealed different requirements.
+	if err := os.WriteFile(path, sign(designated), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	files, err = Inspect(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := files[0].Slices[0].VerifyErr; err == nil || !strings.Contains(err.Error(), "special slot 2") {
+		t.Errorf("VerifyErr = %v", err)
+	}
+}
+
+func TestDecompileRequirement(t *testing.T) {
+	for _, tt := range []struct {
+		req  []byte
+		want string
+	}{
+		{reqOps(opOr, opAnd, opTrue, opFalse, opNot, opIdent, "1x"), `always and never or ! identifier "1x"`},
+		{reqOps(opAnd, opOr, opTrue, opFalse, opNot, opAnd, opTrue, opTrue), `(always or never) and ! (always and always)`},
+		{reqOps(opInfoKeyField, "CFBundleVersion", matchBeginsWith, "1."), `info[CFBundleVersion] = "1.*"`},
+		{reqOps(opEntitlementField, "com.apple.security.app-sandbox", matchExists), `entitlement["com.apple.security.app-sandbox"] /* exists */`},
+		{reqOps(opCertGeneric, 1, "\x2a\x86\x48\x86\xf7\x63\x64\x06\x02\x06", matchExists), `certificate 1[field.1.2.840.113635.100.6.2.6] /* exists */`},
+		{reqOps(opAnchorHash, -1, "\x01\x02"), `certificate root = H"0102"`},
+		{reqOps(opCertFieldDate, 0, "1.2.3", matchBefore, 0, 86400), `certificate leaf[timestamp.1.2.3] < timestamp "2001-01-02T00:00:00Z"`},
+		{reqOps(opPlatform, 1), `platform = 1`},
+	} {
+		got, err := DecompileRequirement(tt.req)
+		if err != nil || got != tt.want {
+			t.Errorf("got %q, %v; want %q", got, err, tt.want)
+		}
+	}
+	if _, err := DecompileRequirement(reqOps(opAnd, opTrue)); err == nil {
+		t.Error("accepted a truncated expression")
+	}
+	if _, err := DecompileRequirement(reqOps(99)); err == nil {
+		t.Error("accepted an unknown opcode")
+	}
+}
@@ -0,0 +1,262 @@
+package codesign
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+const (
+	loadCmdSegment   = 0x1
+	loadCmdSegment64 = 0x19
+
+	machoExecute = 0x2
+	cpuArm64     = 0x0100000c
+
+	// execSegMainBinary marks the executable segment of a main executable.
+	execSegMainBinary = 0x1
+
+	sectionTypeMask = 0xff
+)
+
+// zerofillSections are the section types without data in the file.
+var zerofillSections = map[uint32]bool{0x1: true, 0xc: true, 0x12: true}
+
+// machoLayout holds what signing needs to know about, and change in, a thin
+// Mach-O binary.
+type machoLayout struct {
+	data      []byte
+	order     binary.ByteOrder
+	is64      bool
+	cpu       uint32
+	fileType  uint32
+	cmdsEnd   int    // end of the load commands
+	dataStart uint64 // file offset of the first section data
+	linkedit  int    // offset of the __LINKEDIT segment command
+	textOff   uint64
+	textSize  uint64
+	codeSig   int // offset of the LC_CODE_SIGNATURE command, or -1
+}
+
+// parseMachO reads the header and the load commands of a thin binary.
+func parseMachO(data []byte) (*machoLayout, error) {
+	if len(data) < 28 {
+		return nil, errors.New("not a Mach-O binary")
+	}
+	m := &machoLayout{data: data, linkedit: -1, codeSig: -1, dataStart: uint64(len(data))}
+	headerSize := 28
+	switch binary.LittleEndian.Uint32(data) {
+	case 0xfeedface:
+		m.order = binary.LittleEndian
+	case 0xfeedfacf:
+		m.order, m.is64, headerSize = binary.LittleEndian, true, 32
+	case 0xcefaedfe:
+		m.order = binary.BigEndian
+	case 0xcffaedfe:
+		m.order, m.is64, headerSize = binary.BigEndian, true, 32
+	default:
+		return nil, errors.New("not a Mach-O binary")
+	}
+	m.cpu = m.order.Uint32(data[4:])
+	m.fileType = m.order.Uint32(data[12:])
+	ncmds := m.order.Uint32(data[16:])
+	m.cmdsEnd = headerSize + int(m.order.Uint32(data[20:]))
+	if m.cmdsEnd > len(data) {
+		return nil, errors.New("load commands are truncated")
+	}
+	off := headerSize
+	for i := uint32(0); i < ncmds; i++ {
+		if off+8 > m.cmdsEnd {
+			return nil, errors.New("load commands are truncated")
+		}
+		cmd, size := m.order.Uint32(data[off:]), int(m.order.Uint32(data[off+4:]))
+		if size < 8 || off+size > m.cmdsEnd {
+			return nil, fmt.Errorf("load command %d has an invalid size", i)
+		}
+		switch cmd {
+		case loadCmdSegment, loadCmdSegment64:
+			if (cmd == loadCmdSegment64) != m.is64 {
+				return nil, fmt.Errorf("load command %d is a segment of the wrong word size", i)
+			}
+			if err := m.readSegment(off, size); err != nil {
+				return nil, err
+			}
+		case loadCmdCodeSignature:
+			if size < 16 {
+				return nil, errors.New("LC_CODE_SIGNATURE is truncated")
+			}
+			m.codeSig = off
+		}
+		off += size
+	}
+	if m.linkedit < 0 {
+		return nil, errors.New("binary has no __LINKEDIT segment")
+	}
+	return m, nil
+}
+
+func (m *machoLayout) readSegment(off, size int) error {
+	cmd := m.data[off : off+size]
+	nsectsAt, sectionsAt, sectionSize, offsetAt, flagsAt := 48, 56, 68, 40, 56
+	if m.is64 {
+		nsectsAt, sectionsAt, sectionSize, offsetAt, flagsAt = 64, 72, 80, 48, 64
+	}
+	// The section headers follow the fixed part of the command.
+	if size < sectionsAt {
+		return fmt.Errorf("segment command at %#x is truncated", off)
+	}
+	name := cString16(cmd[8:24])
+	fileOff, fileSize, _ := m.segmentRange(off)
+	nsects := m.order.Uint32(cmd[nsectsAt:])
+	if sectionsAt+int(nsects)*sectionSize > size {
+		return fmt.Errorf("segment %s is truncated", name)
+	}
+	for i := 0; i < int(nsects); i++ {
+		sect := cmd[sectionsAt+i*sectionSize:]
+		offset := uint64(m.order.Uint32(sect[offsetAt:]))
+		if !zerofillSections[m.order.Uint32(sect[flagsAt:])&sectionTypeMask] && offset != 0 {
+			m.dataStart = min(m.dataStart, offset)
+		}
+	}
+	switch name {
+	case "__TEXT":
+		m.textOff, m.textSize = fileOff, fileSize
+	case "__LINKEDIT":
+		m.linkedit = off
+	}
+	return nil
+}
+
+func cString16(b []byte) string {
+	for i, c := range b {
+		if c == 0 {
+			return string(b[:i])
+		}
+	}
+	return string(b)
+}
+
+// segmentRange returns the file range and the virtual size of the segment
+// command at off.
+func (m *machoLayout) segmentRange(off int) (fileOff, fileSize, vmSize uint64) {
+	cmd := m.data[off:]
+	if m.is64 {
+		return m.order.Uint64(cmd[40:]), m.order.Uint64(cmd[48:]), m.order.Uint64(cmd[32:])
+	}
+	return uint64(m.order.Uint32(cmd[32:])), uint64(m.order.Uint32(cmd[36:])), uint64(m.order.Uint32(cmd[28:]))
+}
+
+// signatureOffset returns where the signature goes: in place of the current
+// one, or after the __LINKEDIT data.
+func (m *machoLayout) signatureOffset() (uint64, error) {
+	if m.codeSig >= 0 {
+		return uint64(m.order.Uint32(m.data[m.codeSig+8:])), nil
+	}
+	fileOff, fileSize, _ := m.segmentRange(m.linkedit)
+	end := fileOff + fileSize
+	if end < uint64(len(m.data)) {
+		return 0, errors.New("binary has data after __LINKEDIT")
+	}
+	if m.cmdsEnd+16 > int(m.dataStart) {
+		return 0, errors.New("no room for LC_CODE_SIGNATURE after the load commands")
+	}
+	return (end + 15) &^ 15, nil
+}
+
+// withSignatureSpace returns a copy of the binary up to the signature, with
+// LC_CODE_SIGNATURE and __LINKEDIT pointing to size bytes of signature at
+// offset.
+func (m *machoLayout) withSignatureSpace(offset, size uint64) ([]byte, error) {
+	fileOff, _, vmSize := m.segmentRange(m.linkedit)
+	if offset < fileOff || offset > uint64(len(m.data))+15 {
+		return nil, errors.New("code signature is outside __LINKEDIT")
+	}
+	out := make([]byte, offset, offset+size)
+	copy(out, m.data)
+	o := m.order
+	sig := m.codeSig
+	if sig < 0 {
+		sig = m.cmdsEnd
+		o.PutUint32(out[16:], o.Uint32(out[16:])+1)
+		o.PutUint32(out[20:], o.Uint32(out[20:])+16)
+		o.PutUint32(out[sig:], loadCmdCodeSignature)
+		o.PutUint32(out[sig+4:], 16)
+	}
+	o.PutUint32(out[sig+8:], uint32(offset))
+	o.PutUint32(out[sig+12:], uint32(size))
+
+	pageSize := uint64(0x1000)
+	if m.cpu == cpuArm64 {
+		pageSize = 0x4000
+	}
+	fileSize := offset + size - fileOff
+	vmSize = max(vmSize, (fileSize+pageSize-1)&^(pageSize-1))
+	seg := out[m.linkedit:]
+	if m.is64 {
+		o.PutUint64(seg[32:], vmSize)
+		o.PutUint64(seg[48:], fileSize)
+	} else {
+		if offset+size > 1<<32-1 {
+			return nil, errors.New("binary is too large")
+		}
+		o.PutUint32(seg[28:], uint32(vmSize))
+		o.PutUint32(seg[36:], uint32(fileSize))
+	}
+	return out, nil
+}
+
+// fatArch is an entry of a universal binary header.
+type fatArch struct {
+	cpu, subCPU  uint32
+	offset, size uint32
+	align        uint32
+}
+
+// parseFat returns the architectures of a universal binary, or nil when
+// data is a thin binary.
+func parseFat(data []byte) ([]fatArch, error) {
+	if len(data) < 8 || binary.BigEndian.Uint32(data) != 0xcafebabe {
+		return nil, nil
+	}
+	n := binary.BigEndian.Uint32(data[4:])
+	if int64(n)*20 > int64(len(data)-8) {
+		return nil, errors.New("universal binary header is truncated")
+	}
+	archs := make([]fatArch, n)
+	for i := range archs {
+		b := data[8+20*i:]
+		a := fatArch{
+			cpu:    binary.BigEndian.Uint32(b),
+			subCPU: binary.BigEndian.Uint32(b[4:]),
+			offset: binary.BigEndian.Uint32(b[8:]),
+			size:   binary.BigEndian.Uint32(b[12:]),
+			align:  binary.BigEndian.Uint32(b[16:]),
+		}
+		if int64(a.offset)+int64(a.size) > int64(len(data)) || a.align > 20 {
+			return nil, fmt.Errorf("architecture %d is outside the universal binary", i)
+		}
+		archs[i] = a
+	}
+	return archs, nil
+}
+
+// buildFat lays out slices as a universal binary.
+func buildFat(archs []fatArch, slices [][]byte) []byte {
+	out := binary.BigEndian.AppendUint32(nil, 0xcafebabe)
+	out = binary.BigEndian.AppendUint32(out, uint32(len(archs)))
+	out = append(out, make([]byte, 20*len(archs))...)
+	for i, a := range archs {
+		align := 1 << a.align
+		for len(out)%align != 0 {
+			out = append(out, 0)
+		}
+		b := out[8+20*i:]
+		binary.BigEndian.PutUint32(b, a.cpu)
+		binary.BigEndian.PutUint32(b[4:], a.subCPU)
+		binary.BigEndian.PutUint32(b[8:], uint32(len(out)))
+		binary.BigEndian.PutUint32(b[12:], uint32(len(slices[i])))
+		binary.BigEndian.PutUint32(b[16:], a.align)
+		out = append(out, slices[i]...)
+	}
+	return out
+}
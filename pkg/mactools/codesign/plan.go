@@ -0,0 +1,299 @@
+package codesign
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+	"howett.net/plist"
+)
+
+// ComponentConfig configures the signature of one component of a bundle.
+type ComponentConfig struct {
+	// Identifier replaces the bundle identifier or file name.
+	Identifier string `json:"identifier" yaml:"identifier"`
+	// Entitlements is the path of an entitlements plist.
+	Entitlements string `json:"entitlements" yaml:"entitlements"`
+	// Options are code directory flags by the names codesign --options
+	// takes, such as runtime. When set, they replace the default flags.
+	Options []string `json:"options" yaml:"options"`
+}
+
+// SignConfig holds the configuration of the components of a bundle, by
+// path relative to the bundle, such as
+// Contents/Library/LoginItems/Helper.app. "." is the bundle itself.
+type SignConfig map[string]ComponentConfig
+
+// LoadSignConfig reads a SignConfig from a JSON or YAML file, chosen by
+// extension. Relative entitlements paths are resolved against the
+// directory of the file.
+func LoadSignConfig(path string) (SignConfig, error) {
+	var config SignConfig
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config: %w", err)
+	}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.DisallowUnknownFields()
+		err = dec.Decode(&config)
+	case ".yaml", ".yml":
+		dec := yaml.NewDecoder(bytes.NewReader(data))
+		dec.KnownFields(true)
+		err = dec.Decode(&config)
+	default:
+		return nil, fmt.Errorf("unsupported config format %q, expected .json, .yaml or .yml", filepath.Ext(path))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse config %s: %w", path, err)
+	}
+	for name, c := range config {
+		if c.Entitlements != "" && !filepath.IsAbs(c.Entitlements) {
+			c.Entitlements = filepath.Join(filepath.Dir(path), c.Entitlements)
+			config[name] = c
+		}
+	}
+	return config, nil
+}
+
+// codesignOptions are the flags codesign --options sets, by the names it
+// takes.
+var codesignOptions = []struct {
+	flag uint32
+	name string
+}{
+	{FlagForceHard, "hard"},
+	{FlagForceKill, "kill"},
+	{FlagForceExpiration, "expires"},
+	{FlagRestrict, "restrict"},
+	{FlagEnforcement, "enforcement"},
+	{FlagLibraryValidation, "library"},
+	{FlagRuntime, "runtime"},
+}
+
+func parseCodesignOption(name string) (uint32, bool) {
+	if name == "library-validation" {
+		name = "library"
+	}
+	for _, o := range codesignOptions {
+		if o.name == name {
+			return o.flag, true
+		}
+	}
+	return 0, false
+}
+
+// codesignOptionNames returns the codesign --options names of flags, an
+// empty list rather than nil when there are none.
+func codesignOptionNames(flags uint32) []string {
+	names := []string{}
+	for _, o := range codesignOptions {
+		if flags&o.flag != 0 {
+			names = append(names, o.name)
+		}
+	}
+	return names
+}
+
+// Component is a piece of code in a bundle that gets its own signature.
+type Component struct {
+	// Path is relative to the bundle being planned, with slashes. "." is
+	// the bundle itself.
+	Path string
+	// Bundle is set for bundles, which are signed through their main
+	// executable along with a seal of their resources, and clear for loose
+	// binaries.
+	Bundle  bool
+	Options SignOptions
+}
+
+// SigningPlan lists the code of a bundle in the order it must be signed:
+// nested code before the code that contains it, and the bundle last.
+type SigningPlan struct {
+	Bundle     string
+	Components []Component
+}
+
+// PlanBundle finds the code in the bundle at path: nested bundles in
+// Frameworks, PlugIns, XPCServices, Library/LoginItems, Helpers and the
+// other places codesign looks for them, and loose Mach-O binaries. The
+// bundle is signed with opts; nested code only gets its flags, identity
+// and timestamp authority, unless config has an entry for it.
+func PlanBundle(path string, opts SignOptions, config SignConfig) (*SigningPlan, error) {
+	rules2, err := compileRules(StandardResourceRules2())
+	if err != nil {
+		return nil, err
+	}
+	plan := &SigningPlan{Bundle: path}
+	nested := SignOptions{Flags: opts.Flags, Identity: opts.Identity, TimestampURL: opts.TimestampURL}
+	if err := plan.add(rules2, ".", opts, nested, config); err != nil {
+		return nil, err
+	}
+	used := map[string]bool{}
+	for _, c := range plan.Components {
+		used[c.Path] = true
+	}
+	for name := range config {
+		if !used[name] {
+			return nil, fmt.Errorf("config: %s is not code in %s", name, path)
+		}
+	}
+	return plan, nil
+}
+
+// add appends the components of the bundle at name, then the bundle.
+func (p *SigningPlan) add(rules2 []compiledRule, name string, opts, nested SignOptions, config SignConfig) error {
+	root, err := bundleRoot(filepath.Join(p.Bundle, filepath.FromSlash(name)))
+	if err != nil {
+		return err
+	}
+	main, err := bundleExecutable(root)
+	if err != nil {
+		return err
+	}
+	var loose, bundles []string
+	err = filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || path == root || path == main {
+			return err
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if d.IsDir() {
+			if rel == codeSignatureDir {
+				return filepath.SkipDir
+			}
+			if _, ok := nestedBundle(rules2, rel, path); ok {
+				bundles = append(bundles, path)
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		if ok, err := isMachO(path); err != nil || !ok {
+			return err
+		}
+		loose = append(loose, path)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	rel := func(path string) string {
+		r, _ := filepath.Rel(p.Bundle, path)
+		return filepath.ToSlash(r)
+	}
+	sort.Strings(loose)
+	for _, path := range loose {
+		c := Component{Path: rel(path), Options: nested}
+		base := filepath.Base(path)
+		c.Options.Identifier = strings.TrimSuffix(base, filepath.Ext(base))
+		if err := c.configure(config); err != nil {
+			return err
+		}
+		p.Components = append(p.Components, c)
+	}
+	sort.Strings(bundles)
+	for _, path := range bundles {
+		if err := p.add(rules2, rel(path), nested, nested, config); err != nil {
+			return err
+		}
+	}
+
+	c := Component{Path: name, Bundle: true, Options: opts}
+	if c.Options.Identifier == "" {
+		_, info, err := bundleInfo(root)
+		if err != nil {
+			return err
+		}
+		var b struct {
+			Identifier string `plist:"CFBundleIdentifier"`
+			Executable string `plist:"CFBundleExecutable"`
+		}
+		if _, err := plist.Unmarshal(info, &b); err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		c.Options.Identifier = b.Identifier
+		if c.Options.Identifier == "" {
+			c.Options.Identifier = b.Executable
+		}
+	}
+	if err := c.configure(config); err != nil {
+		return err
+	}
+	p.Components = append(p.Components, c)
+	return nil
+}
+
+// configure applies the entry of config for the component.
+func (c *Component) configure(config SignConfig) error {
+	cfg, ok := config[c.Path]
+	if !ok {
+		return nil
+	}
+	if cfg.Identifier != "" {
+		c.Options.Identifier = cfg.Identifier
+	}
+	if cfg.Entitlements != "" {
+		data, err := os.ReadFile(cfg.Entitlements)
+		if err != nil {
+			return fmt.Errorf("%s: failed to read entitlements: %w", c.Path, err)
+		}
+		c.Options.Entitlements = data
+	}
+	if cfg.Options != nil {
+		c.Options.Flags = 0
+		for _, name := range cfg.Options {
+			flag, ok := parseCodesignOption(strings.TrimSpace(name))
+			if !ok {
+				return fmt.Errorf("%s: unknown option %q", c.Path, name)
+			}
+			c.Options.Flags |= flag
+		}
+	}
+	return nil
+}
+
+// Sign signs the components in order.
+func (p *SigningPlan) Sign() error {
+	for _, c := range p.Components {
+		path := filepath.Join(p.Bundle, filepath.FromSlash(c.Path))
+		var err error
+		if c.Bundle {
+			err = signBundleExecutable(path, c.Options)
+		} else {
+			err = signFile(path, c.Options, sealed{})
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// nestedBundle returns the main executable of the directory at path when
+// it is a bundle in a place the rules seal as nested code. name is the
+// path relative to the bundle contents.
+func nestedBundle(rules2 []compiledRule, name, path string) (string, bool) {
+	rule := match(rules2, name)
+	if rule == nil || !rule.Nested || filepath.Ext(name) == "" {
+		return "", false
+	}
+	exe, err := bundleExecutable(path)
+	if err != nil {
+		return "", false
+	}
+	return exe, true
+}
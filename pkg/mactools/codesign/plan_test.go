@@ -0,0 +1,110 @@
+package codesign
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPlanBundle(t *testing.T) {
+	app := testBundle(t)
+	helper := filepath.Join(app, "Contents", "Library", "LoginItems", "Helper.app")
+	files := map[string][]byte{
+		filepath.Join(app, "Contents", "Frameworks", "libz.dylib"): testUnsigned(cpuArm64),
+		filepath.Join(helper, "Contents", "Info.plist"): []byte(`<plist version="1.0"><dict>
+<key>CFBundleIdentifier</key><string>com.example.helper</string>
+<key>CFBundleExecutable</key><string>Helper</string>
+</dict></plist>`),
+		filepath.Join(helper, "Contents", "MacOS", "Helper"):            testUnsigned(cpuArm64),
+		filepath.Join(helper, "Contents", "Frameworks", "libbar.dylib"): testUnsigned(cpuArm64),
+	}
+	for p, data := range files {
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, data, 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	dir := t.TempDir()
+	config := filepath.Join(dir, "sign.yaml")
+	err := os.WriteFile(config, []byte(`
+Contents/Library/LoginItems/Helper.app:
+  entitlements: helper.entitlements
+  options: [runtime, library]
+Contents/MacOS/helper:
+  identifier: com.example.tool
+`), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	helperEnts := []byte(`<plist version="1.0"><dict><key>com.apple.security.app-sandbox</key><true/></dict></plist>`)
+	if err := os.WriteFile(filepath.Join(dir, "helper.entitlements"), helperEnts, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := LoadSignConfig(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	appEnts := []byte(`<plist version="1.0"><dict><key>com.apple.security.get-task-allow</key><true/></dict></plist>`)
+	plan, err := PlanBundle(app, SignOptions{Entitlements: appEnts, Flags: FlagRuntime}, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []struct {
+		path       string
+		bundle     bool
+		identifier string
+		flags      uint32
+	}{
+		{"Contents/Frameworks/libz.dylib", false, "libz", FlagRuntime},
+		{"Contents/MacOS/helper", false, "com.example.tool", FlagRuntime},
+		{"Contents/Frameworks/Foo.framework", true, "com.example.foo", FlagRuntime},
+		{"Contents/Library/LoginItems/Helper.app/Contents/Frameworks/libbar.dylib", false, "libbar", FlagRuntime},
+		{"Contents/Library/LoginItems/Helper.app", true, "com.example.helper", FlagRuntime | FlagLibraryValidation},
+		{".", true, "com.example.app", FlagRuntime},
+	}
+	if len(plan.Components) != len(want) {
+		t.Fatalf("plan = %+v", plan.Components)
+	}
+	for i, c := range plan.Components {
+		w := want[i]
+		if c.Path != w.path || c.Bundle != w.bundle || c.Options.Identifier != w.identifier || c.Options.Flags != w.flags {
+			t.Errorf("component %d = %s %v %s %#x, want %+v", i, c.Path, c.Bundle, c.Options.Identifier, c.Options.Flags, w)
+		}
+	}
+	if err := plan.Sign(); err != nil {
+		t.Fatal(err)
+	}
+
+	signed, err := Inspect(app)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ents := map[string]string{}
+	for _, f := range signed {
+		s := f.Slices[0]
+		if s.Signature == nil || s.VerifyErr != nil {
+			t.Fatalf("%s: %+v", f.Path, s)
+		}
+		rel, _ := filepath.Rel(app, f.Path)
+		ents[filepath.ToSlash(rel)] = string(s.Signature.Entitlements)
+	}
+	if len(ents) != 6 || ents["Contents/MacOS/Example"] != string(appEnts) ||
+		ents["Contents/Library/LoginItems/Helper.app/Contents/MacOS/Helper"] != string(helperEnts) || ents["Contents/MacOS/helper"] != "" {
+		t.Errorf("entitlements = %q", ents)
+	}
+	for _, bundle := range []string{app, helper} {
+		if changes, err := VerifyResources(bundle); err != nil || len(changes) != 0 {
+			t.Errorf("%s: changes = %v, %v", bundle, changes, err)
+		}
+	}
+
+	if _, err := PlanBundle(app, SignOptions{}, SignConfig{"Contents/MacOS/missing": {}}); err == nil {
+		t.Error("planned with config for missing code")
+	}
+	if _, err := PlanBundle(app, SignOptions{}, SignConfig{".": {Options: []string{"adhoc"}}}); err == nil {
+		t.Error("planned with an unknown option")
+	}
+}
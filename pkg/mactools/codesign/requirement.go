@@ -0,0 +1,478 @@
+package codesign
+
+import (
+	"crypto/sha1"
+	"encoding/asn1"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Requirement types of a requirement set.
+const (
+	HostRequirement       = 1
+	GuestRequirement      = 2
+	DesignatedRequirement = 3
+	LibraryRequirement    = 4
+	PluginRequirement     = 5
+)
+
+var requirementTypeNames = map[uint32]string{
+	HostRequirement:       "host",
+	GuestRequirement:      "guest",
+	DesignatedRequirement: "designated",
+	LibraryRequirement:    "library",
+	PluginRequirement:     "plugin",
+}
+
+// Requirement is one entry of a requirement set, decompiled to the
+// requirement language, such as
+// identifier "com.example.app" and anchor apple generic.
+type Requirement struct {
+	Type uint32
+	Expr string
+}
+
+func (r Requirement) String() string {
+	name, ok := requirementTypeNames[r.Type]
+	if !ok {
+		name = fmt.Sprintf("type %d", r.Type)
+	}
+	return name + " => " + r.Expr
+}
+
+// ParseRequirements parses a requirement set blob.
+func ParseRequirements(data []byte) ([]Requirement, error) {
+	if len(data) < 12 {
+		return nil, errTruncated
+	}
+	be := binary.BigEndian
+	if magic := be.Uint32(data); magic != MagicRequirements {
+		return nil, fmt.Errorf("not a requirement set: magic 0x%08x", magic)
+	}
+	if length := be.Uint32(data[4:]); int64(length) <= int64(len(data)) {
+		data = data[:length]
+	}
+	count := be.Uint32(data[8:])
+	if int64(count)*8 > int64(len(data)-12) {
+		return nil, errTruncated
+	}
+	reqs := make([]Requirement, count)
+	for i := range reqs {
+		typ := be.Uint32(data[12+8*i:])
+		b, err := subBlob(data, be.Uint32(data[16+8*i:]))
+		if err != nil {
+			return nil, err
+		}
+		expr, err := DecompileRequirement(b)
+		if err != nil {
+			return nil, fmt.Errorf("%s requirement: %w", requirementTypeNames[typ], err)
+		}
+		reqs[i] = Requirement{Type: typ, Expr: expr}
+	}
+	return reqs, nil
+}
+
+// Requirement opcodes.
+const (
+	opFalse = iota
+	opTrue
+	opIdent
+	opAppleAnchor
+	opAnchorHash
+	opInfoKeyValue
+	opAnd
+	opOr
+	opCDHash
+	opNot
+	opInfoKeyField
+	opCertField
+	opTrustedCert
+	opTrustedCerts
+	opCertGeneric
+	opAppleGenericAnchor
+	opEntitlementField
+	opCertPolicy
+	opNamedAnchor
+	opNamedCode
+	opPlatform
+	opNotarized
+	opCertFieldDate
+	opLegacyDevID
+
+	opFlagMask = 0xff000000
+)
+
+// Match operations of field tests.
+const (
+	matchExists = iota
+	matchEqual
+	matchContains
+	matchBeginsWith
+	matchEndsWith
+	matchLessThan
+	matchGreaterThan
+	matchLessEqual
+	matchGreaterEqual
+	matchOn
+	matchBefore
+	matchAfter
+	matchOnOrBefore
+	matchOnOrAfter
+	matchAbsent
+)
+
+// Operator precedences, used to put parentheses where the source needs
+// them.
+const (
+	precOr = iota
+	precAnd
+	precNot
+	precPrimary
+)
+
+// DecompileRequirement turns a requirement blob into the requirement
+// language.
+func DecompileRequirement(data []byte) (string, error) {
+	be := binary.BigEndian
+	if len(data) < 12 {
+		return "", errTruncated
+	}
+	if magic := be.Uint32(data); magic != MagicRequirement {
+		return "", fmt.Errorf("not a requirement: magic 0x%08x", magic)
+	}
+	if kind := be.Uint32(data[8:]); kind != 1 {
+		return "", fmt.Errorf("unsupported requirement kind %d", kind)
+	}
+	r := &reqReader{data: data[:min(int(be.Uint32(data[4:])), len(data))], pos: 12}
+	s, _, err := r.expr()
+	return s, err
+}
+
+type reqReader struct {
+	data []byte
+	pos  int
+}
+
+func (r *reqReader) uint32() (uint32, error) {
+	if r.pos+4 > len(r.data) {
+		return 0, errTruncated
+	}
+	v := binary.BigEndian.Uint32(r.data[r.pos:])
+	r.pos += 4
+	return v, nil
+}
+
+// bytes reads length-prefixed data padded to four bytes.
+func (r *reqReader) bytes() ([]byte, error) {
+	n, err := r.uint32()
+	if err != nil {
+		return nil, err
+	}
+	if int64(r.pos)+int64(n) > int64(len(r.data)) {
+		return nil, errTruncated
+	}
+	b := r.data[r.pos : r.pos+int(n)]
+	r.pos += (int(n) + 3) &^ 3
+	return b, nil
+}
+
+func (r *reqReader) string() (string, error) {
+	b, err := r.bytes()
+	return quote(b), err
+}
+
+// expr decompiles one expression and returns it with its precedence.
+func (r *reqReader) expr() (string, int, error) {
+	op, err := r.uint32()
+	if err != nil {
+		return "", 0, err
+	}
+	switch op &^ opFlagMask {
+	case opFalse:
+		return "never", precPrimary, nil
+	case opTrue:
+		return "always", precPrimary, nil
+	case opIdent:
+		s, err := r.string()
+		return "identifier " + s, precPrimary, err
+	case opAppleAnchor:
+		return "anchor apple", precPrimary, nil
+	case opAppleGenericAnchor:
+		return "anchor apple generic", precPrimary, nil
+	case opAnchorHash:
+		slot, err := r.certSlot()
+		if err != nil {
+			return "", 0, err
+		}
+		h, err := r.bytes()
+		return "certificate " + slot + " = H\"" + hex.EncodeToString(h) + "\"", precPrimary, err
+	case opInfoKeyValue:
+		key, err := r.string()
+		if err != nil {
+			return "", 0, err
+		}
+		v, err := r.string()
+		return "info[" + key + "] = " + v, precPrimary, err
+	case opAnd, opOr:
+		prec, sep := precAnd, " and "
+		if op&^opFlagMask == opOr {
+			prec, sep = precOr, " or "
+		}
+		var parts [2]string
+		for i := range parts {
+			s, p, err := r.expr()
+			if err != nil {
+				return "", 0, err
+			}
+			if p < prec {
+				s = "(" + s + ")"
+			}
+			parts[i] = s
+		}
+		return parts[0] + sep + parts[1], prec, nil
+	case opNot:
+		s, p, err := r.expr()
+		if p < precNot {
+			s = "(" + s + ")"
+		}
+		return "! " + s, precNot, err
+	case opCDHash:
+		h, err := r.bytes()
+		return "cdhash H\"" + hex.EncodeToString(h) + "\"", precPrimary, err
+	case opInfoKeyField:
+		key, err := r.string()
+		if err != nil {
+			return "", 0, err
+		}
+		m, err := r.match()
+		return "info[" + key + "]" + m, precPrimary, err
+	case opEntitlementField:
+		key, err := r.string()
+		if err != nil {
+			return "", 0, err
+		}
+		m, err := r.match()
+		return "entitlement[" + key + "]" + m, precPrimary, err
+	case opCertField, opCertFieldDate:
+		slot, err := r.certSlot()
+		if err != nil {
+			return "", 0, err
+		}
+		key, err := r.bytes()
+		if err != nil {
+			return "", 0, err
+		}
+		m, err := r.match()
+		prefix := ""
+		if op&^opFlagMask == opCertFieldDate {
+			prefix = "timestamp."
+		}
+		return "certificate " + slot + "[" + prefix + string(key) + "]" + m, precPrimary, err
+	case opCertGeneric, opCertPolicy:
+		slot, err := r.certSlot()
+		if err != nil {
+			return "", 0, err
+		}
+		oid, err := r.bytes()
+		if err != nil {
+			return "", 0, err
+		}
+		m, err := r.match()
+		kind := "field"
+		if op&^opFlagMask == opCertPolicy {
+			kind = "policy"
+		}
+		return "certificate " + slot + "[" + kind + "." + oidString(oid) + "]" + m, precPrimary, err
+	case opTrustedCert:
+		slot, err := r.certSlot()
+		return "certificate " + slot + " trusted", precPrimary, err
+	case opTrustedCerts:
+		return "anchor trusted", precPrimary, nil
+	case opNamedAnchor:
+		s, err := r.string()
+		return "anchor apple " + s, precPrimary, err
+	case opNamedCode:
+		s, err := r.string()
+		return "(" + s + ")", precPrimary, err
+	case opPlatform:
+		v, err := r.uint32()
+		return "platform = " + strconv.Itoa(int(v)), precPrimary, err
+	case opNotarized:
+		return "notarized", precPrimary, nil
+	case opLegacyDevID:
+		return "legacy", precPrimary, nil
+	}
+	return "", 0, fmt.Errorf("unknown requirement opcode 0x%x", op)
+}
+
+// certSlot reads a certificate index: 0 is the leaf, -1 the anchor.
+func (r *reqReader) certSlot() (string, error) {
+	v, err := r.uint32()
+	switch int32(v) {
+	case 0:
+		return "leaf", err
+	case -1:
+		return "root", err
+	}
+	return strconv.Itoa(int(int32(v))), err
+}
+
+// match reads the match operation of a field test and its value.
+func (r *reqReader) match() (string, error) {
+	op, err := r.uint32()
+	if err != nil {
+		return "", err
+	}
+	switch op {
+	case matchExists:
+		return " /* exists */", nil
+	case matchAbsent:
+		return " absent", nil
+	case matchEqual, matchContains, matchBeginsWith, matchEndsWith,
+		matchLessThan, matchGreaterThan, matchLessEqual, matchGreaterEqual:
+		v, err := r.bytes()
+		if err != nil {
+			return "", err
+		}
+		s := quote(v)
+		switch op {
+		case matchEqual:
+			return " = " + s, nil
+		case matchContains:
+			return " ~ " + s, nil
+		case matchBeginsWith:
+			return " = " + strconv.Quote(string(v)+"*"), nil
+		case matchEndsWith:
+			return " = " + strconv.Quote("*"+string(v)), nil
+		case matchLessThan:
+			return " < " + s, nil
+		case matchGreaterThan:
+			return " > " + s, nil
+		case matchLessEqual:
+			return " <= " + s, nil
+		}
+		return " >= " + s, nil
+	case matchOn, matchBefore, matchAfter, matchOnOrBefore, matchOnOrAfter:
+		hi, err := r.uint32()
+		if err != nil {
+			return "", err
+		}
+		lo, err := r.uint32()
+		if err != nil {
+			return "", err
+		}
+		// Dates are seconds since 2001, as a big-endian int64.
+		t := time.Date(2001, 1, 1, 0, 0, 0, 0, time.UTC).Add(time.Duration(int64(hi)<<32|int64(lo)) * time.Second)
+		sym := map[uint32]string{matchOn: " = ", matchBefore: " < ", matchAfter: " > ", matchOnOrBefore: " <= ", matchOnOrAfter: " >= "}[op]
+		return sym + "timestamp \"" + t.Format(time.RFC3339) + "\"", nil
+	}
+	return "", fmt.Errorf("unknown match operation %d", op)
+}
+
+// quote returns b as a requirement language string, left bare when it is
+// a plain word the way codesign prints it.
+func quote(b []byte) string {
+	s := string(b)
+	plain := s != "" && (s[0] < '0' || s[0] > '9')
+	for _, c := range s {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_') {
+			plain = false
+		}
+	}
+	if plain {
+		return s
+	}
+	return strconv.Quote(s)
+}
+
+// oidString formats the content bytes of a DER object identifier.
+func oidString(b []byte) string {
+	if len(b) == 0 {
+		return ""
+	}
+	var parts []string
+	var v uint64
+	first := true
+	for _, c := range b {
+		v = v<<7 | uint64(c&0x7f)
+		if c&0x80 != 0 {
+			continue
+		}
+		if first {
+			x := min(v/40, 2)
+			parts = append(parts, strconv.FormatUint(x, 10), strconv.FormatUint(v-40*x, 10))
+			first = false
+		} else {
+			parts = append(parts, strconv.FormatUint(v, 10))
+		}
+		v = 0
+	}
+	return strings.Join(parts, ".")
+}
+
+// reqWriter compiles requirement expressions, the reverse of reqReader.
+type reqWriter []byte
+
+func (w *reqWriter) uint32(v uint32) {
+	*w = binary.BigEndian.AppendUint32(*w, v)
+}
+
+func (w *reqWriter) bytes(b []byte) {
+	w.uint32(uint32(len(b)))
+	*w = append(*w, b...)
+	*w = append(*w, make([]byte, (4-len(b)%4)%4)...)
+}
+
+// and starts a conjunction of two expressions, which the caller then
+// writes.
+func (w *reqWriter) and() { w.uint32(opAnd) }
+
+func (w *reqWriter) certField(slot int32, field, value string) {
+	w.uint32(opCertField)
+	w.uint32(uint32(slot))
+	w.bytes([]byte(field))
+	w.uint32(matchEqual)
+	w.bytes([]byte(value))
+}
+
+func (w *reqWriter) certExtension(slot int32, oid asn1.ObjectIdentifier) {
+	der, _ := asn1.Marshal(oid)
+	w.uint32(opCertGeneric)
+	w.uint32(uint32(slot))
+	w.bytes(der[2:])
+	w.uint32(matchExists)
+}
+
+// designatedRequirement returns the requirement set of a signature by id
+// with the designated requirement codesign derives for it. Developer ID
+// code is designated by its team, other code by the hash of its anchor.
+func designatedRequirement(identifier string, id *Identity) []byte {
+	var w reqWriter
+	if id.isDeveloperID() {
+		// identifier and anchor apple generic and the Developer ID
+		// intermediate and leaf extensions and the team.
+		for range 4 {
+			w.and()
+		}
+		w.uint32(opIdent)
+		w.bytes([]byte(identifier))
+		w.uint32(opAppleGenericAnchor)
+		w.certExtension(1, oidDeveloperIDIntermediate)
+		w.certExtension(0, oidDeveloperIDApplication)
+		w.certField(0, "subject.OU", id.TeamID())
+	} else {
+		h := sha1.Sum(id.anchor().Raw)
+		w.and()
+		w.uint32(opIdent)
+		w.bytes([]byte(identifier))
+		w.uint32(opAnchorHash)
+		w.uint32(0xffffffff)
+		w.bytes(h[:])
+	}
+	req := wrapBlob(MagicRequirement, append(binary.BigEndian.AppendUint32(nil, 1), w...))
+	return buildSuperBlob(MagicRequirements, []uint32{DesignatedRequirement}, [][]byte{req})
+}
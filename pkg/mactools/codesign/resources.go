@@ -0,0 +1,454 @@
+package codesign
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+
+	"howett.net/plist"
+)
+
+// codeSignatureDir holds a bundle's resource seal, codeResourcesPath,
+// relative to its contents.
+const (
+	codeSignatureDir  = "_CodeSignature"
+	codeResourcesPath = codeSignatureDir + "/CodeResources"
+)
+
+// ResourceRule decides how the files whose path, relative to the bundle
+// contents, matches its pattern are sealed. Of the rules that match a path,
+// the one with the highest weight applies.
+type ResourceRule struct {
+	Weight float64 `plist:"weight,omitempty"`
+	// Omit leaves the files out of the seal.
+	Omit bool `plist:"omit,omitempty"`
+	// Optional files may be missing without breaking the seal.
+	Optional bool `plist:"optional,omitempty"`
+	// Nested files are code, sealed by their signature.
+	Nested bool `plist:"nested,omitempty"`
+}
+
+// MarshalPlist writes a rule without options as true, the way codesign
+// does.
+func (r ResourceRule) MarshalPlist() (any, error) {
+	if r == (ResourceRule{}) {
+		return true, nil
+	}
+	type rule ResourceRule
+	return rule(r), nil
+}
+
+func (r *ResourceRule) UnmarshalPlist(unmarshal func(any) error) error {
+	var b bool
+	if err := unmarshal(&b); err == nil {
+		*r = ResourceRule{}
+		return nil
+	}
+	type rule ResourceRule
+	return unmarshal((*rule)(r))
+}
+
+func (r ResourceRule) weight() float64 {
+	if r.Weight == 0 {
+		return 1
+	}
+	return r.Weight
+}
+
+// ResourceFile is the seal of one file of a bundle: the hashes of a
+// regular file, the target of a symbolic link or the code directory hash
+// and designated requirement of nested code.
+type ResourceFile struct {
+	Hash        []byte `plist:"hash,omitempty"`  // SHA-1
+	Hash2       []byte `plist:"hash2,omitempty"` // SHA-256
+	Optional    bool   `plist:"optional,omitempty"`
+	Symlink     string `plist:"symlink,omitempty"`
+	CDHash      []byte `plist:"cdhash,omitempty"`
+	Requirement string `plist:"requirement,omitempty"`
+}
+
+// legacyResourceFile is an entry of the version 1 files dictionary, the
+// SHA-1 hash alone unless the file is optional.
+type legacyResourceFile ResourceFile
+
+func (f legacyResourceFile) MarshalPlist() (any, error) {
+	if !f.Optional {
+		return f.Hash, nil
+	}
+	return struct {
+		Hash     []byte `plist:"hash"`
+		Optional bool   `plist:"optional"`
+	}{f.Hash, true}, nil
+}
+
+func (f *legacyResourceFile) UnmarshalPlist(unmarshal func(any) error) error {
+	var hash []byte
+	if err := unmarshal(&hash); err == nil {
+		*f = legacyResourceFile{Hash: hash}
+		return nil
+	}
+	return unmarshal((*ResourceFile)(f))
+}
+
+// CodeResources is a bundle's resource seal, _CodeSignature/CodeResources.
+// Files and Rules are the version 1 seal that old systems read, Files2 and
+// Rules2 the current one.
+type CodeResources struct {
+	Files  map[string]ResourceFile
+	Files2 map[string]ResourceFile
+	Rules  map[string]ResourceRule
+	Rules2 map[string]ResourceRule
+}
+
+type codeResourcesPlist struct {
+	Files  map[string]legacyResourceFile `plist:"files"`
+	Files2 map[string]ResourceFile       `plist:"files2"`
+	Rules  map[string]ResourceRule       `plist:"rules"`
+	Rules2 map[string]ResourceRule       `plist:"rules2"`
+}
+
+// StandardResourceRules returns the version 1 rules codesign seals bundles
+// with.
+func StandardResourceRules() map[string]ResourceRule {
+	return map[string]ResourceRule{
+		`^Resources/`:                            {},
+		`^Resources/.*\.lproj/`:                  {Optional: true, Weight: 1000},
+		`^Resources/.*\.lproj/locversion.plist$`: {Omit: true, Weight: 1100},
+		`^Resources/Base\.lproj/`:                {Weight: 1010},
+		`^version.plist$`:                        {},
+	}
+}
+
+// StandardResourceRules2 returns the rules codesign seals bundles with.
+func StandardResourceRules2() map[string]ResourceRule {
+	return map[string]ResourceRule{
+		`.*\.dSYM($|/)`:      {Weight: 11},
+		`^(.*/)?\.DS_Store$`: {Omit: true, Weight: 2000},
+		`^(Frameworks|SharedFrameworks|PlugIns|Plug-ins|XPCServices|Helpers|MacOS|Library/(Automator|Spotlight|LoginItems))/`: {Nested: true, Weight: 10},
+		`^.*`:                                    {},
+		`^Info\.plist$`:                          {Omit: true, Weight: 20},
+		`^PkgInfo$`:                              {Omit: true, Weight: 20},
+		`^Resources/`:                            {Weight: 20},
+		`^Resources/.*\.lproj/`:                  {Optional: true, Weight: 1000},
+		`^Resources/.*\.lproj/locversion.plist$`: {Omit: true, Weight: 1100},
+		`^Resources/Base\.lproj/`:                {Weight: 1010},
+		`^[^/]+$`:                                {Nested: true, Weight: 10},
+		`^embedded\.provisionprofile$`:           {Weight: 20},
+		`^version\.plist$`:                       {Weight: 20},
+	}
+}
+
+// ParseCodeResources parses a CodeResources plist.
+func ParseCodeResources(data []byte) (*CodeResources, error) {
+	var p codeResourcesPlist
+	if _, err := plist.Unmarshal(data, &p); err != nil {
+		return nil, fmt.Errorf("CodeResources: %w", err)
+	}
+	c := &CodeResources{Files: map[string]ResourceFile{}, Files2: p.Files2, Rules: p.Rules, Rules2: p.Rules2}
+	for name, f := range p.Files {
+		c.Files[name] = ResourceFile(f)
+	}
+	return c, nil
+}
+
+// Marshal returns c as an XML plist.
+func (c *CodeResources) Marshal() ([]byte, error) {
+	p := codeResourcesPlist{Files: map[string]legacyResourceFile{}, Files2: c.Files2, Rules: c.Rules, Rules2: c.Rules2}
+	for name, f := range c.Files {
+		p.Files[name] = legacyResourceFile(f)
+	}
+	return plist.MarshalIndent(p, plist.XMLFormat, "\t")
+}
+
+// compiledRule is a rule with its pattern compiled.
+type compiledRule struct {
+	ResourceRule
+	pattern string
+	re      *regexp.Regexp
+}
+
+func compileRules(rules map[string]ResourceRule) ([]compiledRule, error) {
+	compiled := make([]compiledRule, 0, len(rules))
+	for pattern, r := range rules {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("resource rule %q: %w", pattern, err)
+		}
+		compiled = append(compiled, compiledRule{r, pattern, re})
+	}
+	sort.Slice(compiled, func(i, j int) bool { return compiled[i].pattern < compiled[j].pattern })
+	return compiled, nil
+}
+
+// match returns the rule that applies to name, nil when none matches.
+func match(rules []compiledRule, name string) *compiledRule {
+	var best *compiledRule
+	for i, r := range rules {
+		if r.re.MatchString(name) && (best == nil || r.weight() > best.weight()) {
+			best = &rules[i]
+		}
+	}
+	return best
+}
+
+// SealResources seals the resources of the bundle with the
+// standard rules. Nested code must be signed first.
+func SealResources(bundle string) (*CodeResources, error) {
+	c := &CodeResources{Rules: StandardResourceRules(), Rules2: StandardResourceRules2()}
+	return c, c.seal(bundle)
+}
+
+// seal fills in the files of c from the bundle by the rules of c.
+func (c *CodeResources) seal(bundle string) error {
+	root, err := bundleRoot(bundle)
+	if err != nil {
+		return err
+	}
+	main, err := bundleExecutable(root)
+	if err != nil {
+		return err
+	}
+	rules, err := compileRules(c.Rules)
+	if err != nil {
+		return err
+	}
+	rules2, err := compileRules(c.Rules2)
+	if err != nil {
+		return err
+	}
+	c.Files, c.Files2 = map[string]ResourceFile{}, map[string]ResourceFile{}
+	return filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || p == root {
+			return err
+		}
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return err
+		}
+		name := filepath.ToSlash(rel)
+		if name == codeSignatureDir || name == "CodeResources" || p == main {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		rule := match(rules2, name)
+		if d.IsDir() {
+			// A bundle under a nested rule is sealed by the signature of its
+			// main executable.
+			exe, ok := nestedBundle(rules2, name, p)
+			if !ok {
+				return nil
+			}
+			seal, err := nestedCodeSeal(exe)
+			if err != nil {
+				return err
+			}
+			c.Files2[name] = seal
+			return filepath.SkipDir
+		}
+
+		if rule == nil || rule.Omit {
+			return nil
+		}
+		var hashed []byte
+		switch {
+		case d.Type()&fs.ModeSymlink != 0:
+			target, err := os.Readlink(p)
+			if err != nil {
+				return err
+			}
+			hashed = []byte(target)
+			c.Files2[name] = ResourceFile{Symlink: target}
+		case d.Type().IsRegular():
+			if rule.Nested {
+				ok, err := isMachO(p)
+				if err != nil {
+					return err
+				}
+				if ok {
+					c.Files2[name], err = nestedCodeSeal(p)
+					return err
+				}
+			}
+			if hashed, err = os.ReadFile(p); err != nil {
+				return err
+			}
+			h1, h2 := sha1.Sum(hashed), sha256.Sum256(hashed)
+			c.Files2[name] = ResourceFile{Hash: h1[:], Hash2: h2[:], Optional: rule.Optional}
+		default:
+			return nil
+		}
+		// The version 1 seal covers a subset of the files, and hashes
+		// symbolic links by their target.
+		if rule := match(rules, name); rule != nil && !rule.Omit {
+			h := sha1.Sum(hashed)
+			c.Files[name] = ResourceFile{Hash: h[:], Optional: rule.Optional}
+		}
+		return nil
+	})
+}
+
+// nestedCodeSeal returns the seal of the signed code at path.
+func nestedCodeSeal(path string) (ResourceFile, error) {
+	f, err := inspectFile(path)
+	if err != nil {
+		return ResourceFile{}, err
+	}
+	sig := f.Slices[0].Signature
+	if sig == nil {
+		return ResourceFile{}, fmt.Errorf("%s: nested code is not signed", path)
+	}
+	cdhash := sig.CodeDirectories[0].CDHash()
+	seal := ResourceFile{CDHash: cdhash, Requirement: `cdhash H"` + hex.EncodeToString(cdhash) + `"`}
+	for _, r := range sig.Requirements {
+		if r.Type == DesignatedRequirement {
+			seal.Requirement = r.Expr
+		}
+	}
+	return seal, nil
+}
+
+// bundleRoot returns the directory that holds a bundle's contents:
+// Contents for apps and the current version for frameworks.
+func bundleRoot(bundle string) (string, error) {
+	contents := filepath.Join(bundle, "Contents")
+	if info, err := os.Stat(contents); err == nil && info.IsDir() {
+		return contents, nil
+	}
+	if target, err := os.Readlink(filepath.Join(bundle, "Versions", "Current")); err == nil {
+		if filepath.IsAbs(target) {
+			return target, nil
+		}
+		return filepath.Join(bundle, "Versions", target), nil
+	}
+	if _, err := os.Stat(bundle); err != nil {
+		return "", err
+	}
+	return bundle, nil
+}
+
+// bundleInfo reads the Info.plist of the bundle contents at root, where
+// apps keep it, or in Resources, where frameworks do.
+func bundleInfo(root string) (path string, info []byte, err error) {
+	for _, name := range []string{"Info.plist", filepath.Join("Resources", "Info.plist")} {
+		path = filepath.Join(root, name)
+		if info, err = os.ReadFile(path); err == nil || !os.IsNotExist(err) {
+			return path, info, err
+		}
+	}
+	return "", nil, fmt.Errorf("%s: Info.plist not found", root)
+}
+
+// bundleExecutable returns the path of the main executable of a bundle, or
+// of the bundle contents at root.
+func bundleExecutable(bundle string) (string, error) {
+	root, err := bundleRoot(bundle)
+	if err != nil {
+		return "", err
+	}
+	infoPath, info, err := bundleInfo(root)
+	if err != nil {
+		return "", err
+	}
+	var b struct {
+		Executable string `plist:"CFBundleExecutable"`
+	}
+	if _, err := plist.Unmarshal(info, &b); err != nil {
+		return "", fmt.Errorf("%s: %w", infoPath, err)
+	}
+	if b.Executable == "" {
+		return "", fmt.Errorf("%s: CFBundleExecutable is not set", infoPath)
+	}
+	if _, err := os.Stat(filepath.Join(root, "MacOS")); err == nil {
+		return filepath.Join(root, "MacOS", b.Executable), nil
+	}
+	return filepath.Join(root, b.Executable), nil
+}
+
+// ResourceChange is a file of a bundle that differs from its seal.
+type ResourceChange struct {
+	Path string
+	// Kind is "added", "removed" or "modified".
+	Kind string
+}
+
+func (c ResourceChange) String() string {
+	return c.Path + ": " + c.Kind
+}
+
+// VerifyResources recomputes the resource seal of the bundle at path with
+// the rules of its CodeResources and returns the files that changed since
+// it was sealed. It also checks that the main executable's signature seals
+// that CodeResources.
+func VerifyResources(bundle string) ([]ResourceChange, error) {
+	root, err := bundleRoot(bundle)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(filepath.Join(root, filepath.FromSlash(codeResourcesPath)))
+	if err != nil {
+		return nil, err
+	}
+	sealed, err := ParseCodeResources(data)
+	if err != nil {
+		return nil, err
+	}
+	main, err := bundleExecutable(root)
+	if err != nil {
+		return nil, err
+	}
+	f, err := inspectFile(main)
+	if err != nil {
+		return nil, err
+	}
+	for _, s := range f.Slices {
+		if s.Signature == nil {
+			return nil, fmt.Errorf("%s: main executable is not signed", main)
+		}
+		if !s.Signature.CodeDirectories[0].VerifySpecialSlot(SlotResourceDir, data) {
+			return nil, fmt.Errorf("%s: CodeResources does not match the main executable's signature", s.Arch)
+		}
+	}
+
+	current := &CodeResources{Rules: sealed.Rules, Rules2: sealed.Rules2}
+	if err := current.seal(root); err != nil {
+		return nil, err
+	}
+	var changes []ResourceChange
+	for name, want := range sealed.Files2 {
+		got, ok := current.Files2[name]
+		switch {
+		case !ok && !want.Optional:
+			changes = append(changes, ResourceChange{name, "removed"})
+		case ok && !sameResource(got, want):
+			changes = append(changes, ResourceChange{name, "modified"})
+		}
+	}
+	for name := range current.Files2 {
+		if _, ok := sealed.Files2[name]; !ok {
+			changes = append(changes, ResourceChange{name, "added"})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Path < changes[j].Path })
+	return changes, nil
+}
+
+// sameResource compares the hashes a seal has, as seals may carry only
+// SHA-256 hashes.
+func sameResource(got, want ResourceFile) bool {
+	if want.Hash2 != nil {
+		return bytes.Equal(got.Hash2, want.Hash2)
+	}
+	if want.Hash != nil {
+		return bytes.Equal(got.Hash, want.Hash)
+	}
+	return got.Symlink == want.Symlink && bytes.Equal(got.CDHash, want.CDHash)
+}
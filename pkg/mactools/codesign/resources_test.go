@@ -0,0 +1,156 @@
+package codesign
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// testBundle writes an app bundle with a helper tool, a framework and
+// resources, none of them signed.
+func testBundle(t *testing.T) string {
+	t.Helper()
+	app := filepath.Join(t.TempDir(), "Example.app")
+	files := map[string][]byte{
+		"Contents/Info.plist": []byte(`<plist version="1.0"><dict>
+<key>CFBundleIdentifier</key><string>com.example.app</string>
+<key>CFBundleExecutable</key><string>Example</string>
+</dict></plist>`),
+		"Contents/PkgInfo":                                 []byte("APPL????"),
+		"Contents/MacOS/Example":                           testUnsigned(cpuArm64),
+		"Contents/MacOS/helper":                            testUnsigned(cpuArm64),
+		"Contents/Resources/icon.icns":                     []byte("icon"),
+		"Contents/Resources/.DS_Store":                     []byte("finder"),
+		"Contents/Resources/en.lproj/Localizable.strings":  []byte(`"a" = "b";`),
+		"Contents/Frameworks/Foo.framework/Versions/A/Foo": testUnsigned(cpuArm64),
+		"Contents/Frameworks/Foo.framework/Versions/A/Resources/Info.plist": []byte(`<plist version="1.0"><dict>
+<key>CFBundleIdentifier</key><string>com.example.foo</string>
+<key>CFBundleExecutable</key><string>Foo</string>
+</dict></plist>`),
+	}
+	for name, data := range files {
+		p := filepath.Join(app, name)
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, data, 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	links := map[string]string{
+		"Contents/Resources/AppIcon.icns":                    "icon.icns",
+		"Contents/Frameworks/Foo.framework/Versions/Current": "A",
+		"Contents/Frameworks/Foo.framework/Foo":              "Versions/Current/Foo",
+		"Contents/Frameworks/Foo.framework/Resources":        "Versions/Current/Resources",
+	}
+	for name, target := range links {
+		if err := os.Symlink(target, filepath.Join(app, name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return app
+}
+
+func TestSealResources(t *testing.T) {
+	app := testBundle(t)
+	if err := SignBundle(app, SignOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(filepath.Join(app, "Contents", "_CodeSignature", "CodeResources"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(data, []byte("<key>^.*</key>\n\t\t\t<true/>")) {
+		t.Errorf("rules without options are not written as true:\n%s", data)
+	}
+	seal, err := ParseCodeResources(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(seal.Rules, StandardResourceRules()) || !reflect.DeepEqual(seal.Rules2, StandardResourceRules2()) {
+		t.Errorf("rules = %+v, %+v", seal.Rules, seal.Rules2)
+	}
+
+	var files2 []string
+	for name := range seal.Files2 {
+		files2 = append(files2, name)
+	}
+	want := []string{
+		"Frameworks/Foo.framework",
+		"MacOS/helper",
+		"Resources/AppIcon.icns",
+		"Resources/en.lproj/Localizable.strings",
+		"Resources/icon.icns",
+	}
+	if strings.Join(sortedCopy(files2), " ") != strings.Join(want, " ") {
+		t.Fatalf("files2 = %q", sortedCopy(files2))
+	}
+	if f := seal.Files2["Resources/icon.icns"]; len(f.Hash) != 20 || len(f.Hash2) != 32 || f.Optional {
+		t.Errorf("icon = %+v", f)
+	}
+	if f := seal.Files2["Resources/en.lproj/Localizable.strings"]; !f.Optional {
+		t.Errorf("localization = %+v", f)
+	}
+	if f := seal.Files2["Resources/AppIcon.icns"]; f.Symlink != "icon.icns" || f.Hash != nil {
+		t.Errorf("symbolic link = %+v", f)
+	}
+	files, err := Inspect(filepath.Join(app, "Contents", "Frameworks", "Foo.framework", "Versions", "A", "Foo"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cdhash := files[0].Slices[0].Signature.CodeDirectories[0].CDHash()
+	if f := seal.Files2["Frameworks/Foo.framework"]; !bytes.Equal(f.CDHash, cdhash) || !strings.HasPrefix(f.Requirement, `cdhash H"`) {
+		t.Errorf("framework = %+v", f)
+	}
+	if len(seal.Files) != 3 || !seal.Files["Resources/en.lproj/Localizable.strings"].Optional || seal.Files["Resources/icon.icns"].Hash2 != nil {
+		t.Errorf("files = %+v", seal.Files)
+	}
+
+	// The main executable seals CodeResources, and the seal covers the
+	// resources.
+	changes, err := VerifyResources(app)
+	if err != nil || len(changes) != 0 {
+		t.Fatalf("changes = %v, %v", changes, err)
+	}
+	resources := filepath.Join(app, "Contents", "Resources")
+	if err := os.WriteFile(filepath.Join(resources, "icon.icns"), []byte("new icon"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(resources, "notes.txt"), []byte("added"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.RemoveAll(filepath.Join(resources, "en.lproj")); err != nil {
+		t.Fatal(err)
+	}
+	changes, err = VerifyResources(app)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := fmtChanges(changes); got != "Resources/icon.icns: modified, Resources/notes.txt: added" {
+		t.Errorf("changes = %s", got)
+	}
+	if err := os.WriteFile(filepath.Join(app, "Contents", "_CodeSignature", "CodeResources"), data[1:], 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := VerifyResources(app); err == nil {
+		t.Error("verified CodeResources the main executable does not seal")
+	}
+}
+
+func sortedCopy(s []string) []string {
+	s = append([]string(nil), s...)
+	sort.Strings(s)
+	return s
+}
+
+func fmtChanges(changes []ResourceChange) string {
+	var s []string
+	for _, c := range changes {
+		s = append(s, c.String())
+	}
+	return strings.Join(s, ", ")
+}
@@ -0,0 +1,371 @@
+package codesign
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	signPageSizeLog = 12
+	signPageSize    = 1 << signPageSizeLog
+	// codeDirectoryVersion is the version of the code directories written,
+	// the first with the executable segment fields.
+	codeDirectoryVersion    = 0x20400
+	codeDirectoryHeaderSize = 88
+)
+
+// emptyRequirements is a requirement set without requirements, which ad-hoc
+// signatures carry.
+var emptyRequirements = []byte{0xfa, 0xde, 0x0c, 0x01, 0, 0, 0, 12, 0, 0, 0, 0}
+
+// SignOptions configures the native signer.
+type SignOptions struct {
+	// Identifier is the signing identifier. It defaults to the bundle
+	// identifier for the main executable of a bundle and to the file name
+	// without its extension otherwise.
+	Identifier string
+	// Entitlements is an entitlements plist to embed, nil for none.
+	Entitlements []byte
+	// Flags are code directory flags to set, such as FlagRuntime.
+	Flags uint32
+	// Identity is the certificate and key to sign with. Without one, code
+	// is signed ad hoc.
+	Identity *Identity
+	// TimestampURL is the RFC 3161 timestamp authority, such as
+	// AppleTimestampURL, that signatures by Identity are timestamped by.
+	// Signatures are not timestamped when it is empty.
+	TimestampURL string
+}
+
+// sealed are the files and blobs a signature seals in its special slots, by
+// slot number.
+type sealed map[int][]byte
+
+// Sign signs a thin or universal Mach-O binary and returns the signed
+// binary. An existing signature is replaced.
+func Sign(data []byte, opts SignOptions) ([]byte, error) {
+	if opts.Identifier == "" {
+		return nil, errors.New("signing identifier is required")
+	}
+	return signBinary(data, opts, sealed{})
+}
+
+func signBinary(data []byte, opts SignOptions, files sealed) ([]byte, error) {
+	archs, err := parseFat(data)
+	if err != nil {
+		return nil, err
+	}
+	if archs == nil {
+		return signSlice(data, opts, files)
+	}
+	slices := make([][]byte, len(archs))
+	for i, a := range archs {
+		slices[i], err = signSlice(data[a.offset:a.offset+a.size], opts, files)
+		if err != nil {
+			return nil, fmt.Errorf("architecture %d: %w", i, err)
+		}
+	}
+	return buildFat(archs, slices), nil
+}
+
+// signSlice signs a thin binary.
+func signSlice(data []byte, opts SignOptions, files sealed) ([]byte, error) {
+	m, err := parseMachO(data)
+	if err != nil {
+		return nil, err
+	}
+	offset, err := m.signatureOffset()
+	if err != nil {
+		return nil, err
+	}
+
+	requirements := emptyRequirements
+	flags := opts.Flags | FlagAdhoc
+	teamID := ""
+	if opts.Identity != nil {
+		requirements = designatedRequirement(opts.Identifier, opts.Identity)
+		flags = opts.Flags
+		teamID = opts.Identity.TeamID()
+	}
+	special := sealed{SlotRequirements: requirements}
+	for slot, data := range files {
+		special[slot] = data
+	}
+	slots := []uint32{SlotRequirements}
+	blobs := [][]byte{requirements}
+	if opts.Entitlements != nil {
+		der, err := EntitlementsToDER(opts.Entitlements)
+		if err != nil {
+			return nil, err
+		}
+		special[SlotEntitlements] = wrapBlob(MagicEntitlements, opts.Entitlements)
+		special[SlotDEREntitlements] = wrapBlob(MagicEntitlementsDER, der)
+		slots = append(slots, SlotEntitlements, SlotDEREntitlements)
+		blobs = append(blobs, special[SlotEntitlements], special[SlotDEREntitlements])
+	}
+
+	cd := &codeDirectoryBuilder{
+		identifier: opts.Identifier,
+		teamID:     teamID,
+		flags:      flags,
+		special:    special,
+		codeLimit:  offset,
+		execSegOff: m.textOff,
+		execSegLen: m.textSize,
+	}
+	if m.fileType == machoExecute {
+		cd.execSegFlags = execSegMainBinary
+	}
+	cmsSize := 0
+	if opts.Identity != nil {
+		cmsSize = cmsSizeEstimate(opts.Identity, opts.TimestampURL != "")
+	}
+	size := uint64(12 + 8*(len(blobs)+2) + cd.size() + blobHeaderSize + cmsSize)
+	for _, b := range blobs {
+		size += uint64(len(b))
+	}
+	size = (size + 15) &^ 15
+
+	code, err := m.withSignatureSpace(offset, size)
+	if err != nil {
+		return nil, err
+	}
+	directory := cd.build(code)
+	var cms []byte
+	if opts.Identity != nil {
+		parsed, err := ParseCodeDirectory(directory)
+		if err != nil {
+			return nil, err
+		}
+		attrs, err := codeSigningAttributes([]*CodeDirectory{parsed}, time.Now())
+		if err != nil {
+			return nil, err
+		}
+		var timestamp func([]byte) ([]byte, error)
+		if opts.TimestampURL != "" {
+			timestamp = func(signature []byte) ([]byte, error) {
+				return requestTimestamp(opts.TimestampURL, signature)
+			}
+		}
+		if cms, err = signCMS(opts.Identity, attrs, timestamp); err != nil {
+			return nil, err
+		}
+	}
+	sig := buildSuperBlob(MagicEmbeddedSignature,
+		append([]uint32{SlotCodeDirectory}, append(slots, SlotSignature)...),
+		append([][]byte{directory}, append(blobs, wrapBlob(MagicBlobWrapper, cms))...))
+	if uint64(len(sig)) > size {
+		return nil, fmt.Errorf("signature of %d bytes does not fit the %d bytes reserved", len(sig), size)
+	}
+	out := append(code, sig...)
+	return append(out, make([]byte, size-uint64(len(sig)))...), nil
+}
+
+func wrapBlob(magic uint32, payload []byte) []byte {
+	b := binary.BigEndian.AppendUint32(nil, magic)
+	b = binary.BigEndian.AppendUint32(b, uint32(blobHeaderSize+len(payload)))
+	return append(b, payload...)
+}
+
+// buildSuperBlob lays out blobs, in the given slots, one after the other.
+func buildSuperBlob(magic uint32, slots []uint32, blobs [][]byte) []byte {
+	b := binary.BigEndian.AppendUint32(nil, magic)
+	b = binary.BigEndian.AppendUint32(b, 0)
+	b = binary.BigEndian.AppendUint32(b, uint32(len(blobs)))
+	offset := 12 + superBlobIndexEntrySize*len(blobs)
+	for i, blob := range blobs {
+		b = binary.BigEndian.AppendUint32(b, slots[i])
+		b = binary.BigEndian.AppendUint32(b, uint32(offset))
+		offset += len(blob)
+	}
+	for _, blob := range blobs {
+		b = append(b, blob...)
+	}
+	binary.BigEndian.PutUint32(b[4:], uint32(len(b)))
+	return b
+}
+
+// codeDirectoryBuilder writes a SHA-256 code directory.
+type codeDirectoryBuilder struct {
+	identifier   string
+	teamID       string
+	flags        uint32
+	special      sealed
+	codeLimit    uint64
+	execSegOff   uint64
+	execSegLen   uint64
+	execSegFlags uint64
+}
+
+func (b *codeDirectoryBuilder) nSpecial() int {
+	n := 0
+	for slot := range b.special {
+		n = max(n, slot)
+	}
+	return n
+}
+
+func (b *codeDirectoryBuilder) nCode() int {
+	return int((b.codeLimit + signPageSize - 1) / signPageSize)
+}
+
+func (b *codeDirectoryBuilder) size() int {
+	size := codeDirectoryHeaderSize + len(b.identifier) + 1
+	if b.teamID != "" {
+		size += len(b.teamID) + 1
+	}
+	return size + HashSHA256.Size()*(b.nSpecial()+b.nCode())
+}
+
+// build returns the code directory of code, which is codeLimit bytes long.
+func (b *codeDirectoryBuilder) build(code []byte) []byte {
+	be := binary.BigEndian
+	hashSize := HashSHA256.Size()
+	nSpecial := b.nSpecial()
+	cd := make([]byte, codeDirectoryHeaderSize, b.size())
+	cd = append(cd, b.identifier...)
+	cd = append(cd, 0)
+	teamOffset := 0
+	if b.teamID != "" {
+		teamOffset = len(cd)
+		cd = append(cd, b.teamID...)
+		cd = append(cd, 0)
+	}
+	hashOffset := len(cd) + nSpecial*hashSize
+
+	be.PutUint32(cd, MagicCodeDirectory)
+	be.PutUint32(cd[4:], uint32(b.size()))
+	be.PutUint32(cd[8:], codeDirectoryVersion)
+	be.PutUint32(cd[12:], b.flags)
+	be.PutUint32(cd[16:], uint32(hashOffset))
+	be.PutUint32(cd[20:], codeDirectoryHeaderSize)
+	be.PutUint32(cd[24:], uint32(nSpecial))
+	be.PutUint32(cd[28:], uint32(b.nCode()))
+	if b.codeLimit < 1<<32 {
+		be.PutUint32(cd[32:], uint32(b.codeLimit))
+	} else {
+		be.PutUint64(cd[56:], b.codeLimit)
+	}
+	cd[36] = byte(hashSize)
+	cd[37] = byte(HashSHA256)
+	cd[39] = signPageSizeLog
+	be.PutUint32(cd[48:], uint32(teamOffset))
+	be.PutUint64(cd[64:], b.execSegOff)
+	be.PutUint64(cd[72:], b.execSegLen)
+	be.PutUint64(cd[80:], b.execSegFlags)
+
+	h := HashSHA256.New()
+	for slot := nSpecial; slot >= 1; slot-- {
+		data, ok := b.special[slot]
+		if !ok {
+			cd = append(cd, make([]byte, hashSize)...)
+			continue
+		}
+		h.Reset()
+		h.Write(data)
+		cd = h.Sum(cd)
+	}
+	for off := 0; off < len(code); off += signPageSize {
+		h.Reset()
+		h.Write(code[off:min(off+signPageSize, len(code))])
+		cd = h.Sum(cd)
+	}
+	return cd
+}
+
+// SignFile signs the Mach-O binary at path in place.
+func SignFile(path string, opts SignOptions) error {
+	if opts.Identifier == "" {
+		base := filepath.Base(path)
+		opts.Identifier = strings.TrimSuffix(base, filepath.Ext(base))
+	}
+	return signFile(path, opts, sealed{})
+}
+
+func signFile(path string, opts SignOptions, files sealed) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	signed, err := signBinary(data, opts, files)
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	return replaceFile(path, signed)
+}
+
+// replaceFile writes data to a new file that replaces path, so that the
+// system does not keep using the signature it cached for the old file.
+func replaceFile(path string, data []byte) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), info.Mode().Perm()); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// SignBundle signs the code in a bundle inside out, by the plan
+// PlanBundle makes without a config: nested code with the options' flags
+// and identity under its bundle identifier or file name, then the bundle
+// with opts.
+func SignBundle(path string, opts SignOptions) error {
+	plan, err := PlanBundle(path, opts, nil)
+	if err != nil {
+		return err
+	}
+	return plan.Sign()
+}
+
+// signBundleExecutable signs the main executable of a bundle with a seal
+// of Info.plist and of the bundle's resources, which it writes to
+// _CodeSignature. Nested code must be signed first.
+func signBundleExecutable(path string, opts SignOptions) error {
+	root, err := bundleRoot(path)
+	if err != nil {
+		return err
+	}
+	_, info, err := bundleInfo(root)
+	if err != nil {
+		return err
+	}
+	main, err := bundleExecutable(root)
+	if err != nil {
+		return err
+	}
+	resources, err := SealResources(root)
+	if err != nil {
+		return err
+	}
+	seal, err := resources.Marshal()
+	if err != nil {
+		return err
+	}
+	sealPath := filepath.Join(root, filepath.FromSlash(codeResourcesPath))
+	if err := os.MkdirAll(filepath.Dir(sealPath), 0o755); err != nil {
+		return err
+	}
+	if err := os.WriteFile(sealPath, seal, 0o644); err != nil {
+		return err
+	}
+	return signFile(main, opts, sealed{SlotInfo: info, SlotResourceDir: seal})
+}
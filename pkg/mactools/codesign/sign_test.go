@@ -0,0 +1,589 @@
+package codesign
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"debug/macho"
+	"encoding/asn1"
+	"encoding/binary"
+	"encoding/hex"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"howett.net/plist"
+	"software.sslmate.com/src/go-pkcs12"
+)
+
+// testUnsigned returns an unsigned 64-bit executable with a __TEXT segment
+// holding one section and a __LINKEDIT segment that ends the file.
+func testUnsigned(cpu uint32) []byte {
+	le := binary.LittleEndian
+	const textSize, linkeditSize = 0x5000, 0x321
+	data := make([]byte, textSize+linkeditSize)
+	le.PutUint32(data, 0xfeedfacf)
+	le.PutUint32(data[4:], cpu)
+	le.PutUint32(data[12:], machoExecute)
+	le.PutUint32(data[16:], 2)
+	le.PutUint32(data[20:], 72+80+72)
+
+	text := data[32:]
+	le.PutUint32(text, loadCmdSegment64)
+	le.PutUint32(text[4:], 72+80)
+	copy(text[8:], "__TEXT")
+	le.PutUint64(text[32:], textSize)
+	le.PutUint64(text[48:], textSize)
+	le.PutUint32(text[64:], 1)
+	sect := text[72:]
+	copy(sect, "__text")
+	copy(sect[16:], "__TEXT")
+	le.PutUint64(sect[40:], textSize-0x1000)
+	le.PutUint32(sect[48:], 0x1000)
+
+	linkedit := text[72+80:]
+	le.PutUint32(linkedit, loadCmdSegment64)
+	le.PutUint32(linkedit[4:], 72)
+	copy(linkedit[8:], "__LINKEDIT")
+	le.PutUint64(linkedit[24:], textSize)
+	le.PutUint64(linkedit[32:], 0x1000)
+	le.PutUint64(linkedit[40:], textSize)
+	le.PutUint64(linkedit[48:], linkeditSize)
+
+	for i := 0x1000; i < len(data); i++ {
+		data[i] = byte(i * 13)
+	}
+	return data
+}
+
+func TestSign(t *testing.T) {
+	unsigned := testUnsigned(cpuArm64)
+	signed, err := Sign(unsigned, SignOptions{Identifier: "tool", Flags: FlagRuntime})
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := macho.NewFile(bytes.NewReader(signed))
+	if err != nil {
+		t.Fatal(err)
+	}
+	off, size, ok := codeSignatureRange(f)
+	if !ok || off != 0x5330 || int(off+size) != len(signed) {
+		t.Fatalf("signature at %#x, %d bytes, in %d byte file", off, size, len(signed))
+	}
+	linkedit := f.Segment("__LINKEDIT")
+	if linkedit.Offset+linkedit.Filesz != uint64(len(signed)) || linkedit.Memsz != 0x4000 {
+		t.Errorf("__LINKEDIT = %+v", linkedit.SegmentHeader)
+	}
+	if !bytes.Equal(signed[0x1000:0x5321], unsigned[0x1000:]) {
+		t.Error("signing changed the code")
+	}
+
+	s, err := inspectSlice(signed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.VerifyErr != nil {
+		t.Fatal(s.VerifyErr)
+	}
+	cd := s.Signature.CodeDirectories[0]
+	if cd.Identifier != "tool" || cd.Flags != FlagAdhoc|FlagRuntime || cd.CodeLimit != 0x5330 ||
+		len(cd.CodeSlots) != 6 || len(cd.SpecialSlots) != 2 || cd.ExecSegLimit != 0x5000 || cd.ExecSegFlags != execSegMainBinary {
+		t.Errorf("code directory = %+v", cd)
+	}
+	if s.Signature.CMS != nil || len(s.Signature.Requirements) != 0 {
+		t.Error("ad-hoc signature has a signer or requirements")
+	}
+
+	// Signing again replaces the signature, here with entitlements.
+	ents := []byte(`<plist version="1.0"><dict><key>com.apple.security.get-task-allow</key><true/></dict></plist>`)
+	resigned, err := Sign(signed, SignOptions{Identifier: "tool", Entitlements: ents})
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err = macho.NewFile(bytes.NewReader(resigned))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if off, size, _ := codeSignatureRange(f); off != 0x5330 || int(off+size) != len(resigned) || len(f.Loads) != 3 {
+		t.Errorf("signature moved to %#x, %d bytes, in %d byte file", off, size, len(resigned))
+	}
+	s, err = inspectSlice(resigned)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.VerifyErr != nil {
+		t.Fatal(s.VerifyErr)
+	}
+	if !bytes.Equal(s.Signature.Entitlements, ents) || len(s.Signature.CodeDirectories[0].SpecialSlots) != 7 {
+		t.Errorf("entitlements = %q", s.Signature.Entitlements)
+	}
+	der := "702f020101b02a312830260c21636f6d2e6170706c652e73656375726974792e6765742d7461736b2d616c6c6f770101ff"
+	if got := hex.EncodeToString(s.Signature.EntitlementsDER); got != der {
+		t.Errorf("DER entitlements = %s", got)
+	}
+}
+
+func TestSignUniversal(t *testing.T) {
+	slices := [][]byte{testUnsigned(cpuArm64), testUnsigned(0x01000007)}
+	fat := buildFat([]fatArch{{cpu: cpuArm64, align: 14}, {cpu: 0x01000007, align: 12}}, slices)
+	path := filepath.Join(t.TempDir(), "tool.bin")
+	if err := os.WriteFile(path, fat, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := SignFile(path, SignOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	files, err := Inspect(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := files[0].Slices
+	if len(got) != 2 || got[0].Arch != "arm64" || got[1].Arch != "x86_64" || got[1].Offset%0x1000 != 0 {
+		t.Fatalf("slices = %+v", got)
+	}
+	for _, s := range got {
+		if s.Signature == nil || s.VerifyErr != nil || s.Signature.CodeDirectories[0].Identifier != "tool" {
+			t.Errorf("%s: %+v", s.Arch, s)
+		}
+	}
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0o755 {
+		t.Errorf("mode = %v, %v", info.Mode(), err)
+	}
+}
+
+func TestSignBundle(t *testing.T) {
+	app := filepath.Join(t.TempDir(), "Example.app")
+	info := []byte(`<plist version="1.0"><dict>
+<key>CFBundleIdentifier</key><string>com.example.app</string>
+<key>CFBundleExecutable</key><string>Example</string>
+</dict></plist>`)
+	files := map[string][]byte{
+		"Contents/Info.plist":                 info,
+		"Contents/MacOS/Example":              testUnsigned(cpuArm64),
+		"Contents/Frameworks/libhelper.dylib": testUnsigned(cpuArm64),
+		"Contents/Resources/notes.txt":        []byte("not code"),
+	}
+	for name, data := range files {
+		p := filepath.Join(app, name)
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, data, 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ents := []byte(`<plist version="1.0"><dict><key>com.apple.security.app-sandbox</key><true/></dict></plist>`)
+	if err := SignBundle(app, SignOptions{Entitlements: ents, Flags: FlagRuntime}); err != nil {
+		t.Fatal(err)
+	}
+	signed, err := Inspect(app)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(signed) != 2 {
+		t.Fatalf("%d binaries", len(signed))
+	}
+	for _, f := range signed {
+		s := f.Slices[0]
+		if s.VerifyErr != nil {
+			t.Fatalf("%s: %v", f.Path, s.VerifyErr)
+		}
+		cd := s.Signature.CodeDirectories[0]
+		switch filepath.Base(f.Path) {
+		case "Example":
+			if cd.Identifier != "com.example.app" || s.Signature.Entitlements == nil || !cd.VerifySpecialSlot(SlotInfo, info) {
+				t.Errorf("main executable: %+v", cd)
+			}
+		case "libhelper.dylib":
+			if cd.Identifier != "libhelper" || s.Signature.Entitlements != nil || cd.Flags != FlagAdhoc|FlagRuntime {
+				t.Errorf("library: %+v", cd)
+			}
+		}
+	}
+}
+
+// testIdentity returns a PKCS#12 file holding a certificate issued by a test
+// CA, with the Developer ID extension when devID is set, and the CA.
+func testIdentity(t *testing.T, devID bool) ([]byte, *x509.Certificate) {
+	t.Helper()
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caTmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTmpl, caTmpl, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca, err := x509.ParseCertificate(caDER)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "Developer ID Application: Example (TEAMID1234)", OrganizationalUnit: []string{"TEAMID1234"}},
+		NotBefore:    ca.NotBefore,
+		NotAfter:     ca.NotAfter,
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageCodeSigning},
+	}
+	if devID {
+		tmpl.ExtraExtensions = []pkix.Extension{{Id: oidDeveloperIDApplication, Critical: true, Value: []byte{5, 0}}}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca, &key.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p12, err := pkcs12.Modern2023.Encode(key, cert, []*x509.Certificate{ca}, "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p12, ca
+}
+
+func TestSignIdentity(t *testing.T) {
+	p12, ca := testIdentity(t, true)
+	if _, err := LoadPKCS12(p12, "wrong"); err == nil {
+		t.Error("loaded a PKCS#12 file with the wrong password")
+	}
+	id, err := LoadPKCS12(p12, "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id.TeamID() != "TEAMID1234" || len(id.Chain) != 1 || !id.isDeveloperID() {
+		t.Fatalf("identity = %+v", id)
+	}
+	signed, err := Sign(testUnsigned(cpuArm64), SignOptions{Identifier: "com.example.tool", Flags: FlagRuntime, Identity: id})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := inspectSlice(signed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.VerifyErr != nil {
+		t.Fatal(s.VerifyErr)
+	}
+	sig := s.Signature
+	cd := sig.CodeDirectories[0]
+	if cd.Flags != FlagRuntime || cd.TeamID != "TEAMID1234" {
+		t.Errorf("code directory = %+v", cd)
+	}
+	want := `designated => identifier "com.example.tool" and anchor apple generic and ` +
+		`certificate 1[field.1.2.840.113635.100.6.2.6] /* exists */ and ` +
+		`certificate leaf[field.1.2.840.113635.100.6.1.13] /* exists */ and ` +
+		`certificate leaf[subject.OU] = TEAMID1234`
+	if len(sig.Requirements) != 1 || sig.Requirements[0].String() != want {
+		t.Errorf("requirements = %q", sig.Requirements)
+	}
+	cms := sig.CMS
+	if cms == nil || len(cms.Certificates) != 2 || !cms.Certificates[1].Equal(ca) || cms.SigningTime.IsZero() {
+		t.Fatalf("CMS = %+v", cms)
+	}
+	if len(cms.CDHashes) != 1 || !bytes.Equal(cms.CDHashes[0], cd.CDHash()) {
+		t.Errorf("cdhashes = %x, want %x", cms.CDHashes, cd.CDHash())
+	}
+
+	// The CMS signature must cover the code directory.
+	tampered := bytes.Clone(signed)
+	f, err := macho.NewFile(bytes.NewReader(tampered))
+	if err != nil {
+		t.Fatal(err)
+	}
+	off, _, _ := codeSignatureRange(f)
+	i := bytes.Index(tampered[off:], []byte("com.example.tool"))
+	tampered[int(off)+i]++
+	if s, err := inspectSlice(tampered); err != nil || s.VerifyErr == nil {
+		t.Errorf("tampered code directory verified: %v", err)
+	}
+
+	// Other certificates are designated by their anchor.
+	p12, ca = testIdentity(t, false)
+	if id, err = LoadPKCS12(p12, "secret"); err != nil {
+		t.Fatal(err)
+	}
+	reqs, err := ParseRequirements(designatedRequirement("tool", id))
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := sha1.Sum(ca.Raw)
+	if want := `identifier tool and certificate root = H"` + hex.EncodeToString(h[:]) + `"`; len(reqs) != 1 || reqs[0].Expr != want {
+		t.Errorf("requirements = %q, want %q", reqs, want)
+	}
+}
+
+// TestSignTimestamp signs with a fake timestamp authority that stamps the
+// imprint and nonce of each request, or the imprint of other when set.
+func TestSignTimestamp(t *testing.T) {
+	p12, _ := testIdentity(t, true)
+	id, err := LoadPKCS12(p12, "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stamped := time.Date(2024, 5, 6, 7, 8, 9, 0, time.UTC)
+	status := 0
+	var other []byte
+	tsa := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		var req timeStampReq
+		if _, err := asn1.Unmarshal(body, &req); err != nil || r.Header.Get("Content-Type") != "application/timestamp-query" {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		if other != nil {
+			h := sha256.Sum256(other)
+			req.MessageImprint.HashedMessage = h[:]
+		}
+		imprint, _ := asn1.Marshal(req.MessageImprint)
+		info, _ := asn1.Marshal(tstInfo{
+			Version:        1,
+			Policy:         asn1.ObjectIdentifier{1, 2, 3},
+			MessageImprint: asn1.RawValue{FullBytes: imprint},
+			Serial:         big.NewInt(7),
+			GenTime:        stamped,
+			Accuracy:       accuracy{Seconds: 1},
+			Nonce:          req.Nonce,
+		})
+		content, _ := asn1.Marshal(info)
+		sd, _ := asn1.Marshal(signedData{
+			Version: 3,
+			EncapContentInfo: encapContentInfo{
+				EContentType: asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 16, 1, 4},
+				EContent:     asn1.RawValue{Class: asn1.ClassContextSpecific, IsCompound: true, Bytes: content},
+			},
+			// Room for the certificates of a real authority.
+			Certificates: asn1.RawValue{Class: asn1.ClassContextSpecific, IsCompound: true, Bytes: make([]byte, 5000)},
+		})
+		token, _ := asn1.Marshal(contentInfo{ContentType: oidSignedData, Content: asn1.RawValue{Class: asn1.ClassContextSpecific, IsCompound: true, Bytes: sd}})
+		statusInfo, _ := asn1.Marshal(struct{ Status int }{status})
+		resp, _ := asn1.Marshal(timeStampResp{Status: asn1.RawValue{FullBytes: statusInfo}, TimeStampToken: asn1.RawValue{FullBytes: token}})
+		w.Header().Set("Content-Type", "application/timestamp-reply")
+		w.Write(resp)
+	}))
+	defer tsa.Close()
+
+	opts := SignOptions{Identifier: "com.example.tool", Identity: id, TimestampURL: tsa.URL}
+	signed, err := Sign(testUnsigned(cpuArm64), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := inspectSlice(signed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.VerifyErr != nil {
+		t.Fatal(s.VerifyErr)
+	}
+	if cms := s.Signature.CMS; cms == nil || !cms.Timestamp.Equal(stamped) {
+		t.Fatalf("timestamp = %+v", cms)
+	}
+
+	// The token must stamp the signature value of the signer.
+	other = []byte("another signature")
+	if _, err := Sign(testUnsigned(cpuArm64), opts); err == nil {
+		t.Error("signed with a timestamp of another signature")
+	}
+	other, status = nil, 2
+	if _, err := Sign(testUnsigned(cpuArm64), opts); err == nil {
+		t.Error("signed with a rejected timestamp request")
+	}
+}
+
+// TestSignCMS decodes the CMS signature of the signer with its own ASN.1
+// structures, and has openssl verify it when installed.
+func TestSignCMS(t *testing.T) {
+	p12, ca := testIdentity(t, true)
+	id, err := LoadPKCS12(p12, "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw := testCodeDirectory([]byte("code"), FlagRuntime, nil)
+	cd, err := ParseCodeDirectory(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signingTime := time.Date(2024, 3, 4, 5, 6, 7, 0, time.UTC)
+	attrs, err := codeSigningAttributes([]*CodeDirectory{cd}, signingTime)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig, err := signCMS(id, attrs, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var ci struct {
+		ContentType asn1.ObjectIdentifier
+		Content     asn1.RawValue `asn1:"explicit,tag:0"`
+	}
+	if rest, err := asn1.Unmarshal(sig, &ci); err != nil || len(rest) != 0 {
+		t.Fatalf("content info: %v, %d trailing bytes", err, len(rest))
+	}
+	if !ci.ContentType.Equal(asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 2}) {
+		t.Fatalf("content type %s", ci.ContentType)
+	}
+	var sd struct {
+		Version          int
+		DigestAlgorithms asn1.RawValue
+		EncapContentInfo struct {
+			EContentType asn1.ObjectIdentifier
+		}
+		Certificates asn1.RawValue `asn1:"tag:0"`
+		SignerInfos  []struct {
+			Version            int
+			SID                asn1.RawValue
+			DigestAlgorithm    pkix.AlgorithmIdentifier
+			SignedAttrs        asn1.RawValue `asn1:"tag:0"`
+			SignatureAlgorithm pkix.AlgorithmIdentifier
+			Signature          []byte
+		} `asn1:"set"`
+	}
+	if _, err := asn1.Unmarshal(ci.Content.Bytes, &sd); err != nil {
+		t.Fatal(err)
+	}
+	if len(sd.SignerInfos) != 1 || !sd.EncapContentInfo.EContentType.Equal(asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 1}) {
+		t.Fatalf("signed data = %+v", sd)
+	}
+	certs, err := x509.ParseCertificates(sd.Certificates.Bytes)
+	if err != nil || len(certs) != 2 || !certs[0].Equal(id.Certificate) || !certs[1].Equal(ca) {
+		t.Fatalf("certificates: %v", err)
+	}
+	si := sd.SignerInfos[0]
+	if !si.DigestAlgorithm.Algorithm.Equal(asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 1}) {
+		t.Errorf("digest algorithm %s", si.DigestAlgorithm.Algorithm)
+	}
+	if !si.SignatureAlgorithm.Algorithm.Equal(asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 1}) || !bytes.Equal(si.SignatureAlgorithm.Parameters.FullBytes, asn1.NullBytes) {
+		t.Errorf("signature algorithm %s with parameters %x", si.SignatureAlgorithm.Algorithm, si.SignatureAlgorithm.Parameters.FullBytes)
+	}
+
+	// The attributes are sorted by their encoding, as DER requires of a SET.
+	values := map[string][]byte{}
+	var prev []byte
+	for rest := si.SignedAttrs.Bytes; len(rest) > 0; {
+		var a struct {
+			Type   asn1.ObjectIdentifier
+			Values asn1.RawValue `asn1:"set"`
+		}
+		next, err := asn1.Unmarshal(rest, &a)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if enc := rest[:len(rest)-len(next)]; bytes.Compare(prev, enc) >= 0 {
+			t.Errorf("attribute %s out of order", a.Type)
+		} else {
+			prev = enc
+		}
+		values[a.Type.String()] = a.Values.Bytes
+		rest = next
+	}
+	var contentType asn1.ObjectIdentifier
+	asn1.Unmarshal(values["1.2.840.113549.1.9.3"], &contentType)
+	var messageDigest []byte
+	asn1.Unmarshal(values["1.2.840.113549.1.9.4"], &messageDigest)
+	var signed time.Time
+	asn1.Unmarshal(values["1.2.840.113549.1.9.5"], &signed)
+	var doc []byte
+	asn1.Unmarshal(values["1.2.840.113635.100.9.1"], &doc)
+	var hashes struct {
+		CDHashes [][]byte `plist:"cdhashes"`
+	}
+	plist.Unmarshal(doc, &hashes)
+	var hashes2 struct {
+		Algorithm asn1.ObjectIdentifier
+		Hash      []byte
+	}
+	asn1.Unmarshal(values["1.2.840.113635.100.9.2"], &hashes2)
+
+	digest := sha256.Sum256(raw)
+	if !contentType.Equal(asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 1}) || !bytes.Equal(messageDigest, digest[:]) || !signed.Equal(signingTime) {
+		t.Errorf("content type %s, message digest %x, signing time %v", contentType, messageDigest, signed)
+	}
+	if len(hashes.CDHashes) != 1 || !bytes.Equal(hashes.CDHashes[0], digest[:20]) {
+		t.Errorf("cdhashes = %x", hashes.CDHashes)
+	}
+	if !hashes2.Algorithm.Equal(asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 1}) || !bytes.Equal(hashes2.Hash, digest[:]) {
+		t.Errorf("CDHashes2 = %s %x", hashes2.Algorithm, hashes2.Hash)
+	}
+
+	// The signature covers the attributes with a SET tag in place of [0].
+	signedAttrs := append([]byte{0x31}, si.SignedAttrs.FullBytes[1:]...)
+	h := sha256.Sum256(signedAttrs)
+	if err := rsa.VerifyPKCS1v15(id.Certificate.PublicKey.(*rsa.PublicKey), crypto.SHA256, h[:], si.Signature); err != nil {
+		t.Errorf("signature: %v", err)
+	}
+
+	if _, err := exec.LookPath("openssl"); err != nil {
+		return
+	}
+	dir := t.TempDir()
+	sigPath, content := filepath.Join(dir, "sig.der"), filepath.Join(dir, "cd")
+	if err := os.WriteFile(sigPath, sig, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(content, raw, 0644); err != nil {
+		t.Fatal(err)
+	}
+	out, err := exec.Command("openssl", "cms", "-verify", "-binary", "-noverify", "-inform", "DER",
+		"-in", sigPath, "-content", content, "-out", os.DevNull).CombinedOutput()
+	if err != nil {
+		t.Errorf("openssl cms -verify: %v: %s", err, out)
+	}
+}
+
+func TestSignErrors(t *testing.T) {
+	if _, err := Sign([]byte("#!/bin/sh\n"), SignOptions{Identifier: "x"}); err == nil {
+		t.Error("signed a script")
+	}
+	// No room between the load commands and the first section.
+	full := testUnsigned(cpuArm64)
+	binary.LittleEndian.PutUint32(full[32+72+48:], 32+72+80+72)
+	if _, err := Sign(full, SignOptions{Identifier: "x"}); err == nil {
+		t.Error("signed a binary without room for LC_CODE_SIGNATURE")
+	}
+	if _, err := Sign(testUnsigned(cpuArm64), SignOptions{}); err == nil {
+		t.Error("signed without an identifier")
+	}
+	// Segment commands shorter than their fixed fields, or of the other
+	// word size, are rejected rather than read past their end.
+	for _, cmdsize := range []uint32{8, 24, 71} {
+		short := testUnsigned(cpuArm64)
+		binary.LittleEndian.PutUint32(short[32+72+80+4:], cmdsize)
+		if _, err := Sign(short, SignOptions{Identifier: "x"}); err == nil {
+			t.Errorf("signed a binary with a %d byte segment command", cmdsize)
+		}
+	}
+	mixed := testUnsigned(cpuArm64)
+	binary.LittleEndian.PutUint32(mixed[32+72+80:], loadCmdSegment)
+	if _, err := Sign(mixed, SignOptions{Identifier: "x"}); err == nil {
+		t.Error("signed a 64-bit binary with a 32-bit segment command")
+	}
+}
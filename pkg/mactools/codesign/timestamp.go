@@ -0,0 +1,104 @@
+package codesign
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"time"
+)
+
+// AppleTimestampURL is the timestamp authority codesign uses. Notarization
+// requires code signed with a Developer ID to carry one of its timestamps.
+const AppleTimestampURL = "http://timestamp.apple.com/ts01"
+
+// timestampSizeEstimate is the room reserved in a signature for a timestamp
+// token, which carries the certificates of the authority.
+const timestampSizeEstimate = 8192
+
+var timestampClient = &http.Client{Timeout: time.Minute}
+
+// The request and response of the RFC 3161 time-stamp protocol.
+type timeStampReq struct {
+	Version        int
+	MessageImprint messageImprint
+	Nonce          *big.Int
+	CertReq        bool
+}
+
+type messageImprint struct {
+	HashAlgorithm pkix.AlgorithmIdentifier
+	HashedMessage []byte
+}
+
+type timeStampResp struct {
+	// Status is a PKIStatusInfo, of which only the status code is used.
+	Status         asn1.RawValue
+	TimeStampToken asn1.RawValue `asn1:"optional"`
+}
+
+// requestTimestamp asks the authority at url for a timestamp token over
+// signature, the value of a CMS signer, and checks that the token it
+// returns stamps that signature.
+func requestTimestamp(url string, signature []byte) ([]byte, error) {
+	digest := sha256.Sum256(signature)
+	imprint := messageImprint{
+		HashAlgorithm: pkix.AlgorithmIdentifier{Algorithm: oidSHA256, Parameters: asn1.NullRawValue},
+		HashedMessage: digest[:],
+	}
+	nonce, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 64))
+	if err != nil {
+		return nil, err
+	}
+	req, err := asn1.Marshal(timeStampReq{Version: 1, MessageImprint: imprint, Nonce: nonce, CertReq: true})
+	if err != nil {
+		return nil, err
+	}
+	resp, err := timestampClient.Post(url, "application/timestamp-query", bytes.NewReader(req))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("timestamp authority returned %s", resp.Status)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+
+	var tsr timeStampResp
+	if _, err := asn1.Unmarshal(body, &tsr); err != nil {
+		return nil, fmt.Errorf("timestamp response: %w", err)
+	}
+	var status int
+	if _, err := asn1.Unmarshal(tsr.Status.Bytes, &status); err != nil {
+		return nil, fmt.Errorf("timestamp response status: %w", err)
+	}
+	// 0 is granted and 1 granted with modifications.
+	if status > 1 || len(tsr.TimeStampToken.FullBytes) == 0 {
+		return nil, fmt.Errorf("timestamp authority rejected the request with status %d", status)
+	}
+	token := tsr.TimeStampToken.FullBytes
+	info, err := parseTSTInfo(token)
+	if err != nil {
+		return nil, fmt.Errorf("timestamp token: %w", err)
+	}
+	var stamped messageImprint
+	if _, err := asn1.Unmarshal(info.MessageImprint.FullBytes, &stamped); err != nil {
+		return nil, fmt.Errorf("timestamp token: %w", err)
+	}
+	if !stamped.HashAlgorithm.Algorithm.Equal(oidSHA256) || !bytes.Equal(stamped.HashedMessage, digest[:]) {
+		return nil, errors.New("timestamp token does not stamp the signature")
+	}
+	if info.Nonce == nil || info.Nonce.Cmp(nonce) != 0 {
+		return nil, errors.New("timestamp token does not answer the request")
+	}
+	return token, nil
+}
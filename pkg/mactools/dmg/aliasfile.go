@@ -0,0 +1,97 @@
+package dmg
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/ironpark/zapp/pkg/mactools/alias"
+	"github.com/ironpark/zapp/pkg/mactools/finder"
+	"github.com/ironpark/zapp/pkg/mactools/hfsplus"
+	"github.com/ironpark/zapp/pkg/mactools/rsrc"
+)
+
+// startupVolume is the volume name recorded in alias files. The alias is
+// resolved by its path on the machine that opens the image, so the name of
+// the startup volume of the build machine is not recorded in the image.
+const startupVolume = "Macintosh HD"
+
+// aliasFile returns the resource fork and Finder info of a Finder alias
+// file pointing to target, a path on the startup volume of the machine the
+// image is opened on.
+func aliasFile(target string) ([]byte, hfsplus.FinderInfo, error) {
+	var info hfsplus.FinderInfo
+	if !path.IsAbs(target) {
+		return nil, info, fmt.Errorf("alias target %q is not an absolute path", target)
+	}
+	// Link targets such as /Applications are folders; treat the target as
+	// a file only when the build machine has a regular file there.
+	dir := true
+	if fi, err := os.Stat(target); err == nil && fi.Mode().IsRegular() {
+		dir = false
+	}
+	record, err := alias.Build(alias.Target{
+		VolumeName: startupVolume,
+		MountPoint: "/",
+		Path:       target,
+		Dir:        dir,
+	})
+	if err != nil {
+		return nil, info, fmt.Errorf("failed to create alias for %s: %w", target, err)
+	}
+	fork, err := rsrc.Encode([]rsrc.Resource{{Type: "alis", ID: 0, Data: record}})
+	if err != nil {
+		return nil, info, err
+	}
+
+	switch {
+	case strings.HasSuffix(target, ".app"):
+		copy(info.Type[:], "fapa")
+	case dir:
+		copy(info.Type[:], "fdrp")
+	default:
+		copy(info.Type[:], "alis")
+	}
+	copy(info.Creator[:], "MACS")
+	info.Flags = hfsplus.FinderIsAlias
+	return fork, info, nil
+}
+
+// aliasNode returns a Finder alias file named name pointing to target.
+func aliasNode(name, target string) (*hfsplus.Node, error) {
+	fork, info, err := aliasFile(target)
+	if err != nil {
+		return nil, err
+	}
+	n := hfsplus.NewNode(name, 0644)
+	n.ResourceFork = fork
+	n.Finder = info
+	return n, nil
+}
+
+// writeAliasFile creates a Finder alias file at dst pointing to target. The
+// resource fork and Finder info are stored as extended attributes, so dst
+// must be on a volume that supports them, such as a mounted image.
+func writeAliasFile(dst, target string) error {
+	fork, info, err := aliasFile(target)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(dst, nil, 0644); err != nil {
+		return err
+	}
+	if err := finder.SetResourceFork(dst, fork); err != nil {
+		return err
+	}
+	return finder.SetInfo(dst, info)
+}
+
+// createLink creates the link item to target at dst, as a Finder alias file
+// when config.AliasLinks is set and as a symbolic link otherwise.
+func createLink(config Config, target, dst string) error {
+	if config.AliasLinks {
+		return writeAliasFile(dst, target)
+	}
+	return os.Symlink(target, dst)
+}
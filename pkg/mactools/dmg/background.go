@@ -0,0 +1,297 @@
+package dmg
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	_ "image/jpeg"
+	_ "image/png"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/ironpark/zapp/pkg/image/tiff"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/goregular"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/math/fixed"
+	"golang.org/x/image/vector"
+)
+
+// Default colors of generated backgrounds.
+const (
+	defaultGradientTop    = "#ffffff"
+	defaultGradientBottom = "#e6e9ee"
+	defaultArrowColor     = "#9aa0a8"
+	defaultTextColor      = "#50555c"
+)
+
+// GeneratedBackground describes a background image rendered at the window
+// size: a vertical gradient with an optional arrow from the app to the
+// Applications link and an optional caption below the icons.
+type GeneratedBackground struct {
+	Top        string `json:"top" yaml:"top"`
+	Bottom     string `json:"bottom" yaml:"bottom"`
+	Arrow      bool   `json:"arrow" yaml:"arrow"`
+	ArrowColor string `json:"arrowColor" yaml:"arrowColor"`
+	Caption    string `json:"caption" yaml:"caption"`
+	TextColor  string `json:"textColor" yaml:"textColor"`
+}
+
+// ParseHexColor parses a color written as #rgb or #rrggbb.
+func ParseHexColor(s string) (color.RGBA, error) {
+	hex := strings.TrimPrefix(s, "#")
+	if len(hex) == 3 {
+		hex = string([]byte{hex[0], hex[0], hex[1], hex[1], hex[2], hex[2]})
+	}
+	v, err := strconv.ParseUint(hex, 16, 32)
+	if !strings.HasPrefix(s, "#") || len(hex) != 6 || err != nil {
+		return color.RGBA{}, fmt.Errorf("invalid color %q, expected #rrggbb", s)
+	}
+	return color.RGBA{R: uint8(v >> 16), G: uint8(v >> 8), B: uint8(v), A: 0xff}, nil
+}
+
+// PrepareBackground resolves the background of config. A Background given
+// as a hex color moves to BackgroundColor. A GeneratedBackground, or a
+// Background with a Retina version, is written into dir as a TIFF holding
+// the 1x and 2x images and becomes the Background.
+func PrepareBackground(config *Config, dir string) error {
+	if strings.HasPrefix(config.Background, "#") {
+		config.BackgroundColor = config.Background
+		config.Background = ""
+	}
+	if config.BackgroundColor != "" {
+		if _, err := ParseHexColor(config.BackgroundColor); err != nil {
+			return err
+		}
+	}
+
+	var pages []tiff.Page
+	switch {
+	case config.GeneratedBackground != nil:
+		if config.Background != "" || config.BackgroundColor != "" {
+			return fmt.Errorf("a generated background cannot be combined with a background image or color")
+		}
+		for _, scale := range []int{1, 2} {
+			img, err := config.GeneratedBackground.Render(*config, scale)
+			if err != nil {
+				return err
+			}
+			pages = append(pages, tiff.Page{Image: img, DPI: uint32(72 * scale)})
+		}
+	case config.Background != "":
+		if config.Background2x == "" {
+			ext := filepath.Ext(config.Background)
+			retina := strings.TrimSuffix(config.Background, ext) + "@2x" + ext
+			if _, err := os.Stat(retina); err == nil {
+				config.Background2x = retina
+			}
+		}
+		if config.Background2x == "" {
+			return nil
+		}
+		var err error
+		if pages, err = retinaPages(*config); err != nil {
+			return err
+		}
+	default:
+		return nil
+	}
+
+	config.Background = filepath.Join(dir, "background.tiff")
+	config.Background2x = ""
+	f, err := os.Create(config.Background)
+	if err != nil {
+		return fmt.Errorf("failed to create background: %w", err)
+	}
+	defer f.Close()
+	if err := tiff.Encode(f, pages); err != nil {
+		return fmt.Errorf("failed to encode background: %w", err)
+	}
+	return f.Close()
+}
+
+// retinaPages loads the 1x and 2x background images, which must match the
+// window size and twice the window size.
+func retinaPages(config Config) ([]tiff.Page, error) {
+	var pages []tiff.Page
+	for i, path := range []string{config.Background, config.Background2x} {
+		scale := i + 1
+		img, err := readImage(path)
+		if err != nil {
+			return nil, err
+		}
+		b := img.Bounds()
+		w, h := config.WindowWidth*scale, config.WindowHeight*scale
+		if b.Dx() != w || b.Dy() != h {
+			return nil, fmt.Errorf("background %s is %dx%d, expected %dx%d for a %dx%d window",
+				filepath.Base(path), b.Dx(), b.Dy(), w, h, config.WindowWidth, config.WindowHeight)
+		}
+		pages = append(pages, tiff.Page{Image: img, DPI: uint32(72 * scale)})
+	}
+	return pages, nil
+}
+
+func readImage(path string) (image.Image, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open background: %w", err)
+	}
+	defer f.Close()
+	img, _, err := image.Decode(f)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode background %s: %w", path, err)
+	}
+	return img, nil
+}
+
+// backgroundName is the file name of the background image in the
+// .background folder of the volume.
+func backgroundName(config Config) string {
+	return "background" + strings.ToLower(filepath.Ext(config.Background))
+}
+
+// Render draws the background for the window and contents of config.
+// scale multiplies the window size for Retina displays.
+func (g *GeneratedBackground) Render(config Config, scale int) (*image.RGBA, error) {
+	colors := make([]color.RGBA, 4)
+	for i, c := range []struct{ value, fallback string }{
+		{g.Top, defaultGradientTop},
+		{g.Bottom, defaultGradientBottom},
+		{g.ArrowColor, defaultArrowColor},
+		{g.TextColor, defaultTextColor},
+	} {
+		if c.value == "" {
+			c.value = c.fallback
+		}
+		var err error
+		if colors[i], err = ParseHexColor(c.value); err != nil {
+			return nil, err
+		}
+	}
+	top, bottom, arrowColor, textColor := colors[0], colors[1], colors[2], colors[3]
+	if config.WindowWidth <= 0 || config.WindowHeight <= 0 {
+		return nil, fmt.Errorf("window size %dx%d is not valid", config.WindowWidth, config.WindowHeight)
+	}
+
+	w, h := config.WindowWidth*scale, config.WindowHeight*scale
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		t := float64(y) / float64(max(h-1, 1))
+		c := color.RGBA{
+			R: lerp(top.R, bottom.R, t),
+			G: lerp(top.G, bottom.G, t),
+			B: lerp(top.B, bottom.B, t),
+			A: 0xff,
+		}
+		for x := 0; x < w; x++ {
+			img.SetRGBA(x, y, c)
+		}
+	}
+	if g.Arrow {
+		if err := drawArrow(img, config, arrowColor, float64(scale)); err != nil {
+			return nil, err
+		}
+	}
+	if g.Caption != "" {
+		if err := drawCaption(img, config, g.Caption, textColor, float64(scale)); err != nil {
+			return nil, err
+		}
+	}
+	return img, nil
+}
+
+func lerp(a, b uint8, t float64) uint8 {
+	return uint8(math.Round(float64(a) + (float64(b)-float64(a))*t))
+}
+
+// applicationsItem returns the link to /Applications among the contents.
+func (c *Config) applicationsItem() *Item {
+	for i, item := range c.Contents {
+		if item.Type == Link && filepath.Base(item.Path) == "Applications" {
+			return &c.Contents[i]
+		}
+	}
+	return nil
+}
+
+// drawArrow draws an arrow between the icons of the app and the
+// Applications link. Item positions are icon centers in window points.
+func drawArrow(img *image.RGBA, config Config, c color.RGBA, scale float64) error {
+	app, link := config.AppItem(), config.applicationsItem()
+	if app == nil || link == nil {
+		return fmt.Errorf("an arrow needs an app and an Applications link in the contents")
+	}
+	ax, ay := float64(app.X), float64(app.Y)
+	bx, by := float64(link.X), float64(link.Y)
+	dist := math.Hypot(bx-ax, by-ay)
+	// Keep clear of the icons and leave a quarter icon of space.
+	margin := float64(config.ContentsIconSize) * 0.75
+	length := dist - 2*margin
+	const shaft, headLength, headWidth = 3.0, 22.0, 13.0
+	if length < headLength*1.5 {
+		return fmt.Errorf("the app and Applications icons are too close for an arrow")
+	}
+	dx, dy := (bx-ax)/dist, (by-ay)/dist
+	nx, ny := -dy, dx
+	sx, sy := ax+dx*margin, ay+dy*margin
+	ex, ey := sx+dx*length, sy+dy*length
+	hx, hy := ex-dx*headLength, ey-dy*headLength
+	points := [][2]float64{
+		{sx + nx*shaft, sy + ny*shaft},
+		{hx + nx*shaft, hy + ny*shaft},
+		{hx + nx*headWidth, hy + ny*headWidth},
+		{ex, ey},
+		{hx - nx*headWidth, hy - ny*headWidth},
+		{hx - nx*shaft, hy - ny*shaft},
+		{sx - nx*shaft, sy - ny*shaft},
+	}
+
+	b := img.Bounds()
+	z := vector.NewRasterizer(b.Dx(), b.Dy())
+	for i, p := range points {
+		x, y := float32(p[0]*scale), float32(p[1]*scale)
+		if i == 0 {
+			z.MoveTo(x, y)
+		} else {
+			z.LineTo(x, y)
+		}
+	}
+	z.ClosePath()
+	z.Draw(img, b, image.NewUniform(c), image.Point{})
+	return nil
+}
+
+// drawCaption centers text below the lowest row of icons.
+func drawCaption(img *image.RGBA, config Config, text string, c color.RGBA, scale float64) error {
+	ttf, err := opentype.Parse(goregular.TTF)
+	if err != nil {
+		return fmt.Errorf("failed to parse font: %w", err)
+	}
+	size := float64(max(config.LabelSize, 12)) + 2
+	face, err := opentype.NewFace(ttf, &opentype.FaceOptions{Size: size * scale, DPI: 72, Hinting: font.HintingFull})
+	if err != nil {
+		return fmt.Errorf("failed to create font face: %w", err)
+	}
+	defer face.Close()
+
+	baseline := float64(config.WindowHeight) - 2*size
+	if len(config.Contents) > 0 {
+		lowest := 0
+		for _, item := range config.Contents {
+			lowest = max(lowest, item.Y)
+		}
+		// Icon, label and a line of space.
+		baseline = min(baseline, float64(lowest+config.ContentsIconSize/2+config.LabelSize)+3*size)
+	}
+	d := &font.Drawer{Dst: img, Src: image.NewUniform(c), Face: face}
+	width := d.MeasureString(text)
+	d.Dot = fixed.Point26_6{
+		X: (fixed.I(img.Bounds().Dx()) - width) / 2,
+		Y: fixed.Int26_6(baseline * scale * 64),
+	}
+	d.DrawString(text)
+	return nil
+}
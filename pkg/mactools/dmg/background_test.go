@@ -0,0 +1,165 @@
+package dmg
+
+import (
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+
+	xtiff "golang.org/x/image/tiff"
+)
+
+func TestParseHexColor(t *testing.T) {
+	for s, want := range map[string]color.RGBA{
+		"#ffffff": {0xff, 0xff, 0xff, 0xff},
+		"#1E2a3b": {0x1e, 0x2a, 0x3b, 0xff},
+		"#abc":    {0xaa, 0xbb, 0xcc, 0xff},
+	} {
+		got, err := ParseHexColor(s)
+		if err != nil || got != want {
+			t.Errorf("%s: got %v, %v", s, got, err)
+		}
+	}
+	for _, s := range []string{"", "ffffff", "#ffff", "#gggggg", "#1234567"} {
+		if _, err := ParseHexColor(s); err == nil {
+			t.Errorf("%q: expected an error", s)
+		}
+	}
+}
+
+func TestGeneratedBackground(t *testing.T) {
+	config := Config{
+		WindowWidth:      640,
+		WindowHeight:     400,
+		ContentsIconSize: 128,
+		LabelSize:        14,
+		Contents: []Item{
+			{X: 150, Y: 180, Type: Dir, Path: "My.app"},
+			{X: 490, Y: 180, Type: Link, Path: "/Applications"},
+		},
+	}
+	g := &GeneratedBackground{Top: "#ffffff", Bottom: "#000000", Arrow: true, ArrowColor: "#ff0000", Caption: "Drag to install", TextColor: "#00ff00"}
+	for _, scale := range []int{1, 2} {
+		img, err := g.Render(config, scale)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if img.Bounds().Dx() != 640*scale || img.Bounds().Dy() != 400*scale {
+			t.Fatalf("unexpected size %v", img.Bounds())
+		}
+		if c := img.RGBAAt(0, 0); c != (color.RGBA{0xff, 0xff, 0xff, 0xff}) {
+			t.Errorf("top color %v", c)
+		}
+		if c := img.RGBAAt(0, 400*scale-1); c != (color.RGBA{0, 0, 0, 0xff}) {
+			t.Errorf("bottom color %v", c)
+		}
+		if c := img.RGBAAt(320*scale, 180*scale); c != (color.RGBA{0xff, 0, 0, 0xff}) {
+			t.Errorf("arrow color %v", c)
+		}
+		green := false
+		for y := 240 * scale; y < 400*scale && !green; y++ {
+			for x := 0; x < 640*scale; x++ {
+				if c := img.RGBAAt(x, y); c.G > 0xc0 && c.R < 0x40 {
+					green = true
+					break
+				}
+			}
+		}
+		if !green {
+			t.Error("caption not drawn below the icons")
+		}
+	}
+
+	config.Contents[1].X = 200
+	if _, err := g.Render(config, 1); err == nil {
+		t.Error("expected an error for icons too close for an arrow")
+	}
+}
+
+func TestPrepareBackground(t *testing.T) {
+	config := Config{Background: "#336699"}
+	if err := PrepareBackground(&config, t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	if config.Background != "" || config.BackgroundColor != "#336699" {
+		t.Fatalf("color not moved: %+v", config)
+	}
+	view := newDSStore(config).IconViewPreferences()
+	if view.BackgroundType != 1 || view.BackgroundColorRed != 0x33/255.0 || view.BackgroundColorBlue != 0x99/255.0 {
+		t.Fatalf("unexpected icon view background %+v", view)
+	}
+
+	config = Config{
+		WindowWidth:         300,
+		WindowHeight:        200,
+		GeneratedBackground: &GeneratedBackground{Caption: "Install"},
+	}
+	if err := PrepareBackground(&config, t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	if backgroundName(config) != "background.tiff" {
+		t.Fatalf("unexpected background %s", config.Background)
+	}
+	checkTIFF(t, config.Background, 300, 200)
+}
+
+func TestPrepareRetinaBackground(t *testing.T) {
+	dir := t.TempDir()
+	writeImage := func(name string, w, h int) string {
+		path := filepath.Join(dir, name)
+		f, err := os.Create(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		if err := png.Encode(f, image.NewRGBA(image.Rect(0, 0, w, h))); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	writeImage("background.png", 320, 240)
+	writeImage("background@2x.png", 640, 480)
+	writeImage("odd.png", 320, 240)
+	writeImage("odd@2x.png", 600, 480)
+	writeImage("single.png", 100, 100)
+
+	config := Config{WindowWidth: 320, WindowHeight: 240, Background: filepath.Join(dir, "background.png")}
+	if err := PrepareBackground(&config, t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	checkTIFF(t, config.Background, 320, 240)
+
+	config = Config{WindowWidth: 320, WindowHeight: 240, Background: filepath.Join(dir, "odd.png")}
+	if err := PrepareBackground(&config, t.TempDir()); err == nil {
+		t.Error("expected an error for a mismatched @2x image")
+	}
+	config = Config{WindowWidth: 640, WindowHeight: 480, Background: filepath.Join(dir, "single.png"), Background2x: filepath.Join(dir, "background@2x.png")}
+	if err := PrepareBackground(&config, t.TempDir()); err == nil {
+		t.Error("expected an error for a 1x image not matching the window")
+	}
+
+	// A background without a Retina version is used as is.
+	config = Config{WindowWidth: 640, WindowHeight: 480, Background: filepath.Join(dir, "single.png")}
+	if err := PrepareBackground(&config, t.TempDir()); err != nil || backgroundName(config) != "background.png" {
+		t.Fatalf("single image changed to %s: %v", config.Background, err)
+	}
+}
+
+// checkTIFF decodes the first page of a background TIFF.
+func checkTIFF(t *testing.T, path string, width, height int) {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	img, err := xtiff.Decode(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if img.Bounds().Dx() != width || img.Bounds().Dy() != height {
+		t.Fatalf("unexpected size %v", img.Bounds())
+	}
+}
@@ -0,0 +1,92 @@
+package dmg
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// LoadConfig reads a Config from a JSON or YAML file, chosen by extension.
+// Relative paths of the icon, the background and the copied contents are
+// resolved against the directory of the file; link targets are kept as is.
+func LoadConfig(path string) (Config, error) {
+	var config Config
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return config, fmt.Errorf("failed to read config: %w", err)
+	}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.DisallowUnknownFields()
+		err = dec.Decode(&config)
+	case ".yaml", ".yml":
+		dec := yaml.NewDecoder(bytes.NewReader(data))
+		dec.KnownFields(true)
+		err = dec.Decode(&config)
+	default:
+		return config, fmt.Errorf("unsupported config format %q, expected .json, .yaml or .yml", filepath.Ext(path))
+	}
+	if err != nil {
+		return config, fmt.Errorf("failed to parse config %s: %w", path, err)
+	}
+	if config.LabelSize != 0 && (config.LabelSize < 10 || config.LabelSize > 16) {
+		return config, fmt.Errorf("labelSize must be between 10 and 16")
+	}
+	if config.ContentsIconSize != 0 && (config.ContentsIconSize < 16 || config.ContentsIconSize > 512) {
+		return config, fmt.Errorf("iconSize must be between 16 and 512")
+	}
+	if err := validateView(config); err != nil {
+		return config, err
+	}
+
+	base := filepath.Dir(path)
+	resolve := func(p string) string {
+		switch {
+		case p == "":
+			return p
+		case filepath.IsAbs(p):
+			return filepath.Clean(p)
+		}
+		return filepath.Join(base, p)
+	}
+	config.Icon = resolve(config.Icon)
+	config.Background2x = resolve(config.Background2x)
+	if !strings.HasPrefix(config.Background, "#") {
+		config.Background = resolve(config.Background)
+	}
+	for i := range config.Licenses {
+		config.Licenses[i].Path = resolve(config.Licenses[i].Path)
+	}
+	if err := validateLicenses(config.Licenses); err != nil {
+		return config, err
+	}
+	for i, item := range config.Contents {
+		switch item.Type {
+		case File, Dir:
+			config.Contents[i].Path = resolve(item.Path)
+		case Link:
+		default:
+			return config, fmt.Errorf("unknown item type %q for %s, expected %s, %s or %s", item.Type, item.Path, File, Dir, Link)
+		}
+		if item.Path == "" {
+			return config, fmt.Errorf("item %d has no path", i)
+		}
+	}
+	return config, nil
+}
+
+// AppItem returns the first app bundle among the contents, or nil.
+func (c *Config) AppItem() *Item {
+	for i, item := range c.Contents {
+		if item.Type == Dir && strings.HasSuffix(item.Path, ".app") {
+			return &c.Contents[i]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,93 @@
+package dmg
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/ironpark/zapp/pkg/mactools/hdiutil"
+)
+
+func TestLoadConfig(t *testing.T) {
+	dir := t.TempDir()
+	want := Config{
+		Title:        "My App",
+		Icon:         filepath.Join(dir, "assets", "icon.icns"),
+		WindowWidth:  720,
+		WindowHeight: 400,
+		Background:   "/abs/bg.png",
+		Format:       hdiutil.UDBZ,
+		Contents: []Item{
+			{X: 100, Y: 120, Type: Dir, Path: filepath.Join(dir, "build", "My App.app")},
+			{X: 400, Y: 120, Type: Link, Path: "/Applications"},
+			{X: 100, Y: 300, Type: File, Path: filepath.Join(dir, "README.md")},
+			{X: 250, Y: 300, Type: Link, Path: "../Uninstall.app"},
+		},
+	}
+	files := map[string]string{
+		"dmg.json": `{
+  "title": "My App",
+  "icon": "assets/icon.icns",
+  "windowWidth": 720,
+  "windowHeight": 400,
+  "background": "/abs/bg.png",
+  "format": "UDBZ",
+  "contents": [
+    {"type": "dir", "path": "build/My App.app", "x": 100, "y": 120},
+    {"type": "link", "path": "/Applications", "x": 400, "y": 120},
+    {"type": "file", "path": "README.md", "x": 100, "y": 300},
+    {"type": "link", "path": "../Uninstall.app", "x": 250, "y": 300}
+  ]
+}`,
+		"dmg.yaml": `title: My App
+icon: assets/icon.icns
+windowWidth: 720
+windowHeight: 400
+background: /abs/bg.png
+format: UDBZ
+contents:
+  - {type: dir, path: build/My App.app, x: 100, y: 120}
+  - {type: link, path: /Applications, x: 400, y: 120}
+  - {type: file, path: README.md, x: 100, y: 300}
+  - {type: link, path: ../Uninstall.app, x: 250, y: 300}
+`,
+	}
+	for name, content := range files {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(dir, name)
+			if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+				t.Fatal(err)
+			}
+			got, err := LoadConfig(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Fatalf("got %+v, want %+v", got, want)
+			}
+			if app := got.AppItem(); app == nil || app.Path != want.Contents[0].Path {
+				t.Fatalf("unexpected app item %+v", app)
+			}
+		})
+	}
+}
+
+func TestLoadConfigErrors(t *testing.T) {
+	dir := t.TempDir()
+	for name, content := range map[string]string{
+		"unknown-field.json": `{"titel": "typo"}`,
+		"unknown-type.yaml":  "contents:\n  - {type: alias, path: a}\n",
+		"no-path.json":       `{"contents": [{"type": "file"}]}`,
+		"label-size.yml":     "labelSize: 40\n",
+		"config.toml":        "title = 'x'\n",
+	} {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := LoadConfig(path); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
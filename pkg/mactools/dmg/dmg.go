@@ -10,26 +10,72 @@ import (
 	"strings"
 	"time"
 
-	"github.com/ironpark/zapp/pkg/mactools/dsstore"
+	"github.com/ironpark/zapp/pkg/mactools/finder"
 	"github.com/ironpark/zapp/pkg/mactools/hdiutil"
+	"github.com/ironpark/zapp/pkg/mactools/hfsplus"
 )
 
 // Config represents the configuration for the DMG file.
 type Config struct {
-	FileName         string `json:"fileName"`
-	Title            string `json:"title"`
-	Icon             string `json:"icon"`
-	LabelSize        int    `json:"labelSize"`
-	ContentsIconSize int    `json:"iconSize"`
-	WindowWidth      int    `json:"windowWidth"`
-	WindowHeight     int    `json:"windowHeight"`
-	Background       string `json:"background"`
-	Contents         []Item `json:"contents"`
-	LogWriter        io.Writer
-	Format           hdiutil.Format `json:"format"`
-	CompressionLevel string         `json:"compressionLevel"`
-	UseHardLinks     bool           `json:"useHardLinks"`
-	OptimizeAppSize  bool           `json:"optimizeAppSize"`
+	FileName         string         `json:"fileName" yaml:"fileName"`
+	Title            string         `json:"title" yaml:"title"`
+	Icon             string         `json:"icon" yaml:"icon"`
+	LabelSize        int            `json:"labelSize" yaml:"labelSize"`
+	ContentsIconSize int            `json:"iconSize" yaml:"iconSize"`
+	WindowWidth      int            `json:"windowWidth" yaml:"windowWidth"`
+	WindowHeight     int            `json:"windowHeight" yaml:"windowHeight"`
+	Background       string         `json:"background" yaml:"background"`
+	Contents         []Item         `json:"contents" yaml:"contents"`
+	LogWriter        io.Writer      `json:"-" yaml:"-"`
+	Format           hdiutil.Format `json:"format" yaml:"format"`
+	CompressionLevel string         `json:"compressionLevel" yaml:"compressionLevel"`
+	UseHardLinks     bool           `json:"useHardLinks" yaml:"useHardLinks"`
+	OptimizeAppSize  bool           `json:"optimizeAppSize" yaml:"optimizeAppSize"`
+	Layout           *Layout        `json:"-" yaml:"-"`
+
+	// Finder window position, chrome and icon view options. Unset options
+	// keep the defaults of the dsstore package.
+	WindowX         int     `json:"windowX" yaml:"windowX"`
+	WindowY         int     `json:"windowY" yaml:"windowY"`
+	ShowToolbar     *bool   `json:"showToolbar,omitempty" yaml:"showToolbar,omitempty"`
+	ShowSidebar     *bool   `json:"showSidebar,omitempty" yaml:"showSidebar,omitempty"`
+	ShowPathbar     *bool   `json:"showPathbar,omitempty" yaml:"showPathbar,omitempty"`
+	ShowStatusBar   *bool   `json:"showStatusBar,omitempty" yaml:"showStatusBar,omitempty"`
+	SidebarWidth    int     `json:"sidebarWidth" yaml:"sidebarWidth"`
+	ShowItemInfo    *bool   `json:"showItemInfo,omitempty" yaml:"showItemInfo,omitempty"`
+	ShowIconPreview *bool   `json:"showIconPreview,omitempty" yaml:"showIconPreview,omitempty"`
+	GridSpacing     float64 `json:"gridSpacing" yaml:"gridSpacing"`
+	GridOffsetX     float64 `json:"gridOffsetX" yaml:"gridOffsetX"`
+	GridOffsetY     float64 `json:"gridOffsetY" yaml:"gridOffsetY"`
+	ArrangeBy       string  `json:"arrangeBy" yaml:"arrangeBy"`
+	LabelPosition   string  `json:"labelPosition" yaml:"labelPosition"`
+
+	// BackgroundColor is a solid window color such as "#f0f0f0", used
+	// instead of a background image. Background2x is the Retina version of
+	// Background, found next to it as name@2x.png when not set.
+	BackgroundColor     string               `json:"backgroundColor" yaml:"backgroundColor"`
+	Background2x        string               `json:"background2x" yaml:"background2x"`
+	GeneratedBackground *GeneratedBackground `json:"generatedBackground,omitempty" yaml:"generatedBackground,omitempty"`
+
+	// AliasLinks writes link items, such as the Applications shortcut, as
+	// Finder alias files instead of symbolic links.
+	AliasLinks bool `json:"aliasLinks" yaml:"aliasLinks"`
+
+	// Licenses are shown in a license agreement window when the image is
+	// opened. The first one is used when none matches the user's language.
+	Licenses []License `json:"licenses" yaml:"licenses"`
+
+	// ChunkSize is the uncompressed size of an image chunk in KiB and
+	// CompressionThreads the number of chunks compressed in parallel, every
+	// CPU core when zero. Only CreateDMGNative uses them.
+	ChunkSize          int `json:"chunkSize" yaml:"chunkSize"`
+	CompressionThreads int `json:"compressionThreads" yaml:"compressionThreads"`
+
+	// Reproducible makes equal inputs give byte for byte equal images: all
+	// dates are SOURCE_DATE_EPOCH, or the Unix epoch when it is unset, and
+	// the image identifiers derive from the title and that date. Only
+	// CreateDMGNative supports it.
+	Reproducible bool `json:"reproducible" yaml:"reproducible"`
 }
 
 type ItemType string
@@ -42,14 +88,17 @@ const (
 
 // Item represents an item in the DMG file.
 type Item struct {
-	X    int      `json:"x"`
-	Y    int      `json:"y"`
-	Type ItemType `json:"type"`
-	Path string   `json:"path"`
+	X    int      `json:"x" yaml:"x"`
+	Y    int      `json:"y" yaml:"y"`
+	Type ItemType `json:"type" yaml:"type"`
+	Path string   `json:"path" yaml:"path"`
 }
 
 // CreateDMG creates a DMG file with the specified configuration.
 func CreateDMG(config Config, sourceDir string) error {
+	if err := checkLicenses(config); err != nil {
+		return err
+	}
 	// Если используются hard links, используем безопасный метод
 	if config.UseHardLinks {
 		return createDMGWithSafeHardLinks(config, sourceDir)
@@ -66,15 +115,7 @@ func CreateDMG(config Config, sourceDir string) error {
 	if config.LogWriter == nil {
 		config.LogWriter = os.Stdout
 	}
-	store := dsstore.NewDSStore()
-	store.SetIconSize(float64(config.ContentsIconSize))
-	store.SetWindow(config.WindowWidth, config.WindowHeight, 0, 0)
-	store.SetLabelSize(float64(config.LabelSize))
-	store.SetLabelPlaceToBottom(true)
-	store.SetBgToDefault()
-	for _, content := range config.Contents {
-		store.SetIconPos(filepath.Base(content.Path), uint32(content.X), uint32(content.Y))
-	}
+	store := newDSStore(config)
 	err := store.Write(filepath.Join(sourceDir, ".DS_Store"))
 	if err != nil {
 		return fmt.Errorf("failed to write .DS_Store: %w", err)
@@ -97,7 +138,7 @@ func CreateDMG(config Config, sourceDir string) error {
 	ctx := context.Background()
 	
 	// For compressed formats, create directly without intermediate conversion
-	if config.Format == hdiutil.UDZO || config.Format == hdiutil.UDBZ {
+	if config.Format == hdiutil.UDZO || config.Format == hdiutil.UDBZ || config.Format == hdiutil.ULFO || config.Format == hdiutil.ULMO {
 		// Create compressed DMG directly
 		if err := createCompressedDMG(ctx, config, sourceDir); err != nil {
 			return fmt.Errorf("failed to create compressed dmg: %w", err)
@@ -130,7 +171,7 @@ func CreateDMG(config Config, sourceDir string) error {
 				}
 			}
 			if config.Background != "" {
-				store.SetBackgroundImage(filepath.Join(mountPoint, ".background", "background.png"))
+				store.SetBackgroundImage(filepath.Join(mountPoint, ".background", backgroundName(config)))
 				if err := store.Write(filepath.Join(mountPoint, ".DS_Store")); err != nil {
 					return fmt.Errorf("failed to write .DS_Store: %w", err)
 				}
@@ -139,8 +180,13 @@ func CreateDMG(config Config, sourceDir string) error {
 		})
 	}
 
+	if err := embedLicenses(config); err != nil {
+		return err
+	}
 	if config.Icon != "" {
-		setFileIcon(config.FileName, config.Icon)
+		if err := setFileIcon(config.FileName, config.Icon); err != nil {
+			return err
+		}
 	}
 	return nil
 }
@@ -150,6 +196,9 @@ func CreateDMGDirect(config Config) error {
 	if config.LogWriter == nil {
 		config.LogWriter = os.Stdout
 	}
+	if err := checkLicenses(config); err != nil {
+		return err
+	}
 
 	// Set Default Filename
 	if config.FileName == "" {
@@ -180,22 +229,14 @@ func CreateDMGDirect(config Config) error {
 	}
 
 	// Create DS_Store file
-	store := dsstore.NewDSStore()
-	store.SetIconSize(float64(config.ContentsIconSize))
-	store.SetWindow(config.WindowWidth, config.WindowHeight, 0, 0)
-	store.SetLabelSize(float64(config.LabelSize))
-	store.SetLabelPlaceToBottom(true)
-	store.SetBgToDefault()
-	for _, content := range config.Contents {
-		store.SetIconPos(filepath.Base(content.Path), uint32(content.X), uint32(content.Y))
-	}
+	store := newDSStore(config)
 	
 	if err := store.Write(filepath.Join(tempDir, ".DS_Store")); err != nil {
 		return fmt.Errorf("failed to write .DS_Store: %w", err)
 	}
 
 	// For compressed formats, create directly
-	if config.Format == hdiutil.UDZO || config.Format == hdiutil.UDBZ {
+	if config.Format == hdiutil.UDZO || config.Format == hdiutil.UDBZ || config.Format == hdiutil.ULFO || config.Format == hdiutil.ULMO {
 		// Create compressed DMG directly
 		if err := createCompressedDMGDirect(ctx, config, tempDir); err != nil {
 			return fmt.Errorf("failed to create compressed dmg: %w", err)
@@ -219,9 +260,14 @@ func CreateDMGDirect(config Config) error {
 		}
 	}
 
+	if err := embedLicenses(config); err != nil {
+		return err
+	}
 	// Set custom icon for the DMG if specified
 	if config.Icon != "" {
-		setFileIcon(config.FileName, config.Icon)
+		if err := setFileIcon(config.FileName, config.Icon); err != nil {
+			return err
+		}
 	}
 	
 	return nil
@@ -232,6 +278,9 @@ func CreateDMGOptimal(config Config) error {
 	if config.LogWriter == nil {
 		config.LogWriter = os.Stdout
 	}
+	if err := checkLicenses(config); err != nil {
+		return err
+	}
 
 	// Set Default Filename
 	if config.FileName == "" {
@@ -302,7 +351,7 @@ func CreateDMGOptimal(config Config) error {
 	
 	err := tmpMount(tempDMG, func(dmgFilePath string, mountPoint string) error {
 		// Add Applications link
-		if err := os.Symlink("/Applications", filepath.Join(mountPoint, "Applications")); err != nil {
+		if err := createLink(config, "/Applications", filepath.Join(mountPoint, "Applications")); err != nil {
 			return fmt.Errorf("failed to create Applications link: %w", err)
 		}
 
@@ -312,14 +361,13 @@ func CreateDMGOptimal(config Config) error {
 			if err := os.MkdirAll(backgroundDir, 0755); err != nil {
 				return fmt.Errorf("failed to create .background directory: %w", err)
 			}
-			if err := copyFile(config.Background, filepath.Join(backgroundDir, "background.png")); err != nil {
+			if err := copyFile(config.Background, filepath.Join(backgroundDir, backgroundName(config))); err != nil {
 				return fmt.Errorf("failed to copy background: %w", err)
 			}
 			
 			// Make background folder invisible
-			cmd := exec.Command("SetFile", "-a", "V", backgroundDir)
-			if output, err := cmd.CombinedOutput(); err != nil {
-				return fmt.Errorf("failed to hide background folder: %s, output: %s", err, string(output))
+			if err := finder.SetFlags(backgroundDir, hfsplus.FinderIsInvisible); err != nil {
+				return fmt.Errorf("failed to hide background folder: %w", err)
 			}
 		}
 
@@ -331,19 +379,10 @@ func CreateDMGOptimal(config Config) error {
 		}
 
 		// Create DS_Store with custom layout
-		store := dsstore.NewDSStore()
-		store.SetIconSize(float64(config.ContentsIconSize))
-		store.SetWindow(config.WindowWidth, config.WindowHeight, 0, 0)
-		store.SetLabelSize(float64(config.LabelSize))
-		store.SetLabelPlaceToBottom(true)
-		store.SetBgToDefault()
+		store := newDSStore(config)
 		
 		if config.Background != "" {
-			store.SetBackgroundImage(filepath.Join(mountPoint, ".background", "background.png"))
-		}
-		
-		for _, content := range config.Contents {
-			store.SetIconPos(filepath.Base(content.Path), uint32(content.X), uint32(content.Y))
+			store.SetBackgroundImage(filepath.Join(mountPoint, ".background", backgroundName(config)))
 		}
 		
 		if err := store.Write(filepath.Join(mountPoint, ".DS_Store")); err != nil {
@@ -373,9 +412,14 @@ func CreateDMGOptimal(config Config) error {
 		return fmt.Errorf("hdiutil convert failed: %w, output: %s", err, string(output))
 	}
 
+	if err := embedLicenses(config); err != nil {
+		return err
+	}
 	// Step 5: Set file icon if specified
 	if config.Icon != "" {
-		setFileIcon(config.FileName, config.Icon)
+		if err := setFileIcon(config.FileName, config.Icon); err != nil {
+			return err
+		}
 	}
 
 	fmt.Fprintf(config.LogWriter, "DMG created successfully: %s\n", config.FileName)
@@ -411,22 +455,14 @@ func createDMGWithSafeHardLinks(config Config, sourceDir string) error {
 			}
 		case Link:
 			// Создаем символическую ссылку
-			if err := os.Symlink(item.Path, filepath.Join(safeTempDir, filepath.Base(item.Path))); err != nil {
+			if err := createLink(config, item.Path, filepath.Join(safeTempDir, filepath.Base(item.Path))); err != nil {
 				return fmt.Errorf("failed to create symlink %s: %w", item.Path, err)
 			}
 		}
 	}
 
 	// Создаем DS_Store
-	store := dsstore.NewDSStore()
-	store.SetIconSize(float64(config.ContentsIconSize))
-	store.SetWindow(config.WindowWidth, config.WindowHeight, 0, 0)
-	store.SetLabelSize(float64(config.LabelSize))
-	store.SetLabelPlaceToBottom(true)
-	store.SetBgToDefault()
-	for _, content := range config.Contents {
-		store.SetIconPos(filepath.Base(content.Path), uint32(content.X), uint32(content.Y))
-	}
+	store := newDSStore(config)
 	
 	if err := store.Write(filepath.Join(safeTempDir, ".DS_Store")); err != nil {
 		return fmt.Errorf("failed to write .DS_Store: %w", err)
@@ -438,7 +474,7 @@ func createDMGWithSafeHardLinks(config Config, sourceDir string) error {
 		if err := os.MkdirAll(backgroundDir, 0755); err != nil {
 			return fmt.Errorf("failed to create .background directory: %w", err)
 		}
-		if err := copyFile(config.Background, filepath.Join(backgroundDir, "background.png")); err != nil {
+		if err := copyFile(config.Background, filepath.Join(backgroundDir, backgroundName(config))); err != nil {
 			return fmt.Errorf("failed to copy background: %w", err)
 		}
 	}
@@ -452,7 +488,7 @@ func createDMGWithSafeHardLinks(config Config, sourceDir string) error {
 		return fmt.Errorf("failed to calculate directory size: %w", err)
 	}
 	
-	if config.Format == hdiutil.UDZO || config.Format == hdiutil.UDBZ {
+	if config.Format == hdiutil.UDZO || config.Format == hdiutil.UDBZ || config.Format == hdiutil.ULFO || config.Format == hdiutil.ULMO {
 		if err := createCompressedDMGWithSize(ctx, config, safeTempDir, sizeMB); err != nil {
 			return fmt.Errorf("failed to create compressed dmg: %w", err)
 		}
@@ -462,9 +498,14 @@ func createDMGWithSafeHardLinks(config Config, sourceDir string) error {
 		}
 	}
 
+	if err := embedLicenses(config); err != nil {
+		return err
+	}
 	// Устанавливаем иконку если нужно
 	if config.Icon != "" {
-		setFileIcon(config.FileName, config.Icon)
+		if err := setFileIcon(config.FileName, config.Icon); err != nil {
+			return err
+		}
 	}
 
 	return nil
@@ -495,22 +536,13 @@ func createCompressedDMG(ctx context.Context, config Config, sourceDir string) e
 				if err := os.MkdirAll(backgroundDir, 0755); err != nil {
 					return fmt.Errorf("failed to create .background directory: %w", err)
 				}
-				if err := copyFile(config.Background, filepath.Join(backgroundDir, "background.png")); err != nil {
+				if err := copyFile(config.Background, filepath.Join(backgroundDir, backgroundName(config))); err != nil {
 					return fmt.Errorf("failed to copy background: %w", err)
 				}
 				
 				// Create DS_Store with background settings
-				store := dsstore.NewDSStore()
-				store.SetIconSize(float64(config.ContentsIconSize))
-				store.SetWindow(config.WindowWidth, config.WindowHeight, 0, 0)
-				store.SetLabelSize(float64(config.LabelSize))
-				store.SetLabelPlaceToBottom(true)
-				store.SetBgToDefault()
-				store.SetBackgroundImage(filepath.Join(mountPoint, ".background", "background.png"))
-				
-				for _, content := range config.Contents {
-					store.SetIconPos(filepath.Base(content.Path), uint32(content.X), uint32(content.Y))
-				}
+				store := newDSStore(config)
+				store.SetBackgroundImage(filepath.Join(mountPoint, ".background", backgroundName(config)))
 				
 				if err := store.Write(filepath.Join(mountPoint, ".DS_Store")); err != nil {
 					return fmt.Errorf("failed to write .DS_Store: %w", err)
@@ -527,10 +559,7 @@ func createCompressedDMG(ctx context.Context, config Config, sourceDir string) e
 	forceDetachDMG(ctx, tempDMG)
 
 	// Convert to compressed format
-	var convertArgs []string
-	if config.CompressionLevel != "" {
-		convertArgs = append(convertArgs, "-imagekey", fmt.Sprintf("zlib-level=%s", config.CompressionLevel))
-	}
+	convertArgs := hdiutil.CompressionLevelArgs(config.Format, config.CompressionLevel)
 	
 	cmd := exec.CommandContext(ctx, "hdiutil", append([]string{"convert", tempDMG, "-format", string(config.Format), "-o", config.FileName}, convertArgs...)...)
 	output, err := cmd.CombinedOutput()
@@ -541,40 +570,10 @@ func createCompressedDMG(ctx context.Context, config Config, sourceDir string) e
 	return nil
 }
 
+// setFileIcon gives the DMG file at dmgPath the icon read from iconPath.
 func setFileIcon(dmgPath, iconPath string) error {
-	// Create temporary mount point
-	tempDir, err := os.MkdirTemp("", "*-zapp-dmg")
-	if err != nil {
-		return fmt.Errorf("failed to create temporary directory: %w", err)
-	}
-	defer os.RemoveAll(tempDir) // Ensure cleanup of temp directory
-
-	tempIconPath := filepath.Join(tempDir, "icon.icns")
-	err = copyFile(iconPath, tempIconPath)
-	if err != nil {
-		return fmt.Errorf("failed to copy icon: %w", err)
-	}
-
-	cmd := exec.Command("sips", "-i", tempIconPath)
-	if output, err := cmd.CombinedOutput(); err != nil {
-		return fmt.Errorf("failed to set icon: %s, output: %s", err, string(output))
-	}
-	cmd = exec.Command("DeRez", "-only", "icns", tempIconPath)
-	output, err := cmd.CombinedOutput()
-	if err != nil {
-		return fmt.Errorf("failed to DeRez icon: %s, output: %s", err, string(output))
-	}
-	rsrcPath := filepath.Join(tempDir, "icns.rsrc")
-	if err := os.WriteFile(rsrcPath, output, 0644); err != nil {
-		return fmt.Errorf("failed to write icns.rsrc: %w", err)
-	}
-	cmd = exec.Command("Rez", "-append", rsrcPath, "-o", dmgPath)
-	if output, err := cmd.CombinedOutput(); err != nil {
-		return fmt.Errorf("failed to append icns.rsrc: %s, output: %s", err, string(output))
-	}
-	cmd = exec.Command("SetFile", "-a", "C", dmgPath)
-	if output, err := cmd.CombinedOutput(); err != nil {
-		return fmt.Errorf("failed to set icon: %s, output: %s", err, string(output))
+	if err := finder.SetIcon(dmgPath, iconPath); err != nil {
+		return fmt.Errorf("failed to set DMG file icon: %w", err)
 	}
 	return nil
 }
@@ -611,16 +610,20 @@ func setDMGIcon(mountPoint, iconPath string) error {
 		return fmt.Errorf("failed to copy icon to mount point: %w", err)
 	}
 
-	// Set the icon
-	cmd := exec.Command("SetFile", "-c", "icnC", iconFile)
-	if output, err := cmd.CombinedOutput(); err != nil {
-		return fmt.Errorf("failed to set icon: %s, output: %s", err, string(output))
+	// Mark the icon file the way the Finder does
+	info, err := finder.Info(iconFile)
+	if err != nil {
+		return err
+	}
+	copy(info.Creator[:], "icnC")
+	info.Flags |= hfsplus.FinderIsInvisible
+	if err := finder.SetInfo(iconFile, info); err != nil {
+		return fmt.Errorf("failed to set icon: %w", err)
 	}
 
-	// Tell the volume that it has a special file attribute
-	cmd = exec.Command("SetFile", "-a", "C", mountPoint)
-	if output, err := cmd.CombinedOutput(); err != nil {
-		return fmt.Errorf("failed to set icon: %s, output: %s", err, string(output))
+	// Tell the volume that it has a custom icon
+	if err := finder.SetFlags(mountPoint, hfsplus.FinderHasCustomIcon); err != nil {
+		return fmt.Errorf("failed to set icon: %w", err)
 	}
 
 	return nil
@@ -687,7 +690,7 @@ func setupSourceDirectory(config Config, sourceDir string) error {
 			}
 		case Link:
 			// Create a symbolic link
-			err := os.Symlink(item.Path, filepath.Join(sourceDir, filepath.Base(item.Path)))
+			err := createLink(config, item.Path, filepath.Join(sourceDir, filepath.Base(item.Path)))
 			if err != nil {
 				return fmt.Errorf("failed to create symbolic link %s: %s", item.Path, err)
 			}
@@ -700,7 +703,7 @@ func setupSourceDirectory(config Config, sourceDir string) error {
 		if err := os.MkdirAll(backgroundDir, 0755); err != nil {
 			return fmt.Errorf("failed to create .background directory: %w", err)
 		}
-		if err := copyFile(config.Background, filepath.Join(backgroundDir, "background.png")); err != nil {
+		if err := copyFile(config.Background, filepath.Join(backgroundDir, backgroundName(config))); err != nil {
 			return fmt.Errorf("failed to copy background: %s", err)
 		}
 	}
@@ -983,7 +986,7 @@ func createSymbolicLinks(config Config, tempDir string) error {
 			}
 		case Link:
 			// Create a symbolic link
-			err := os.Symlink(item.Path, filepath.Join(tempDir, filepath.Base(item.Path)))
+			err := createLink(config, item.Path, filepath.Join(tempDir, filepath.Base(item.Path)))
 			if err != nil {
 				return fmt.Errorf("failed to create symbolic link %s: %w", item.Path, err)
 			}
@@ -996,7 +999,7 @@ func createSymbolicLinks(config Config, tempDir string) error {
 		if err := os.MkdirAll(backgroundDir, 0755); err != nil {
 			return fmt.Errorf("failed to create .background directory: %w", err)
 		}
-		if err := copyFile(config.Background, filepath.Join(backgroundDir, "background.png")); err != nil {
+		if err := copyFile(config.Background, filepath.Join(backgroundDir, backgroundName(config))); err != nil {
 			return fmt.Errorf("failed to copy background: %w", err)
 		}
 	}
@@ -1029,22 +1032,13 @@ func createCompressedDMGDirect(ctx context.Context, config Config, tempDir strin
 				if err := os.MkdirAll(backgroundDir, 0755); err != nil {
 					return fmt.Errorf("failed to create .background directory: %w", err)
 				}
-				if err := copyFile(config.Background, filepath.Join(backgroundDir, "background.png")); err != nil {
+				if err := copyFile(config.Background, filepath.Join(backgroundDir, backgroundName(config))); err != nil {
 					return fmt.Errorf("failed to copy background: %w", err)
 				}
 				
 				// Create DS_Store with background settings
-				store := dsstore.NewDSStore()
-				store.SetIconSize(float64(config.ContentsIconSize))
-				store.SetWindow(config.WindowWidth, config.WindowHeight, 0, 0)
-				store.SetLabelSize(float64(config.LabelSize))
-				store.SetLabelPlaceToBottom(true)
-				store.SetBgToDefault()
-				store.SetBackgroundImage(filepath.Join(mountPoint, ".background", "background.png"))
-				
-				for _, content := range config.Contents {
-					store.SetIconPos(filepath.Base(content.Path), uint32(content.X), uint32(content.Y))
-				}
+				store := newDSStore(config)
+				store.SetBackgroundImage(filepath.Join(mountPoint, ".background", backgroundName(config)))
 				
 				if err := store.Write(filepath.Join(mountPoint, ".DS_Store")); err != nil {
 					return fmt.Errorf("failed to write .DS_Store: %w", err)
@@ -1061,10 +1055,7 @@ func createCompressedDMGDirect(ctx context.Context, config Config, tempDir strin
 	forceDetachDMG(ctx, tempDMG)
 
 	// Convert to compressed format
-	var convertArgs []string
-	if config.CompressionLevel != "" {
-		convertArgs = append(convertArgs, "-imagekey", fmt.Sprintf("zlib-level=%s", config.CompressionLevel))
-	}
+	convertArgs := hdiutil.CompressionLevelArgs(config.Format, config.CompressionLevel)
 	
 	cmd := exec.CommandContext(ctx, "hdiutil", append([]string{"convert", tempDMG, "-format", string(config.Format), "-o", config.FileName}, convertArgs...)...)
 	output, err := cmd.CombinedOutput()
@@ -1129,22 +1120,13 @@ func createCompressedDMGWithSize(ctx context.Context, config Config, sourceDir s
 				if err := os.MkdirAll(backgroundDir, 0755); err != nil {
 					return fmt.Errorf("failed to create .background directory: %w", err)
 				}
-				if err := copyFile(config.Background, filepath.Join(backgroundDir, "background.png")); err != nil {
+				if err := copyFile(config.Background, filepath.Join(backgroundDir, backgroundName(config))); err != nil {
 					return fmt.Errorf("failed to copy background: %w", err)
 				}
 				
 				// Create DS_Store with background settings
-				store := dsstore.NewDSStore()
-				store.SetIconSize(float64(config.ContentsIconSize))
-				store.SetWindow(config.WindowWidth, config.WindowHeight, 0, 0)
-				store.SetLabelSize(float64(config.LabelSize))
-				store.SetLabelPlaceToBottom(true)
-				store.SetBgToDefault()
-				store.SetBackgroundImage(filepath.Join(mountPoint, ".background", "background.png"))
-				
-				for _, content := range config.Contents {
-					store.SetIconPos(filepath.Base(content.Path), uint32(content.X), uint32(content.Y))
-				}
+				store := newDSStore(config)
+				store.SetBackgroundImage(filepath.Join(mountPoint, ".background", backgroundName(config)))
 				
 				if err := store.Write(filepath.Join(mountPoint, ".DS_Store")); err != nil {
 					return fmt.Errorf("failed to write .DS_Store: %w", err)
@@ -1161,10 +1143,7 @@ func createCompressedDMGWithSize(ctx context.Context, config Config, sourceDir s
 	forceDetachDMG(ctx, tempDMG)
 
 	// Convert to compressed format
-	var convertArgs []string
-	if config.CompressionLevel != "" {
-		convertArgs = append(convertArgs, "-imagekey", fmt.Sprintf("zlib-level=%s", config.CompressionLevel))
-	}
+	convertArgs := hdiutil.CompressionLevelArgs(config.Format, config.CompressionLevel)
 	
 	cmd := exec.CommandContext(ctx, "hdiutil", append([]string{"convert", tempDMG, "-format", string(config.Format), "-o", config.FileName}, convertArgs...)...)
 	output, err := cmd.CombinedOutput()
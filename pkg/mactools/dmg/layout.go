@@ -0,0 +1,223 @@
+package dmg
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/ironpark/zapp/pkg/mactools/dmg/udif"
+	"github.com/ironpark/zapp/pkg/mactools/dsstore"
+	"github.com/ironpark/zapp/pkg/mactools/dsstore/entry"
+	"github.com/ironpark/zapp/pkg/mactools/hfsplus"
+)
+
+// Layout is the Finder window layout of a reference image, applied on top
+// of the one generated from the Config.
+type Layout struct {
+	Store *dsstore.DSStore
+	// Background is the background image extracted from a reference image.
+	Background string
+	// Rename maps item names of the reference to the names in the new image.
+	Rename map[string]string
+}
+
+// LoadLayout reads the layout of a .dmg or a .DS_Store file. The background
+// image of a reference .dmg is extracted into workDir.
+func LoadLayout(path, workDir string) (*Layout, error) {
+	if !strings.EqualFold(filepath.Ext(path), ".dmg") {
+		store, err := dsstore.Read(path)
+		if err != nil {
+			return nil, err
+		}
+		return &Layout{Store: store}, nil
+	}
+
+	img, err := udif.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open DMG: %w", err)
+	}
+	defer img.Close()
+	part, err := img.FilesystemPartition()
+	if err != nil {
+		return nil, err
+	}
+	vol, err := hfsplus.Open(img.PartitionReader(part))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read volume in partition %q: %w", part.Name, err)
+	}
+	data, err := readVolumeFile(vol, ".DS_Store")
+	if err != nil {
+		return nil, err
+	}
+	store, err := dsstore.Decode(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode .DS_Store: %w", err)
+	}
+	layout := &Layout{Store: store}
+
+	if dir, err := vol.Lookup(".background"); err == nil && dir.IsDir() {
+		for _, e := range vol.Children(dir) {
+			ext := strings.ToLower(filepath.Ext(e.Name))
+			if !e.Mode.IsRegular() || !slices.Contains([]string{".png", ".jpg", ".jpeg", ".tif", ".tiff", ".gif"}, ext) {
+				continue
+			}
+			data, err := readVolumeFile(vol, ".background/"+e.Name)
+			if err != nil {
+				return nil, err
+			}
+			layout.Background = filepath.Join(workDir, "background"+ext)
+			if err := os.WriteFile(layout.Background, data, 0644); err != nil {
+				return nil, fmt.Errorf("failed to extract background: %w", err)
+			}
+			break
+		}
+	}
+	return layout, nil
+}
+
+func readVolumeFile(vol *hfsplus.Volume, name string) ([]byte, error) {
+	e, err := vol.Lookup(name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find %s: %w", name, err)
+	}
+	r, err := vol.OpenFile(e)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if _, err := io.Copy(&buf, r); err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", name, err)
+	}
+	return buf.Bytes(), nil
+}
+
+// renames completes Rename for the item names of the new image. A reference
+// item that is missing from the new image is paired with the one new item
+// of the same extension that has no position in the reference, so that
+// "MyApp 1.0.app" takes the place of "MyApp 0.9.app".
+func (l *Layout) renames(names []string) map[string]string {
+	rename := make(map[string]string, len(l.Rename))
+	positioned := make(map[string]bool)
+	for _, e := range l.Store.Entries {
+		if e.EntryType() != entry.TypeIconLocation {
+			continue
+		}
+		name := e.Filename()
+		if newName, ok := l.Rename[name]; ok {
+			rename[name] = newName
+			name = newName
+		}
+		positioned[name] = true
+	}
+
+	unplaced := make(map[string][]string)
+	for _, name := range names {
+		if !positioned[name] {
+			ext := filepath.Ext(name)
+			unplaced[ext] = append(unplaced[ext], name)
+		}
+	}
+	unused := make(map[string][]string)
+	for _, e := range l.Store.Entries {
+		name := e.Filename()
+		if e.EntryType() != entry.TypeIconLocation || slices.Contains(names, name) {
+			continue
+		}
+		if _, ok := rename[name]; !ok {
+			ext := filepath.Ext(name)
+			unused[ext] = append(unused[ext], name)
+		}
+	}
+	for ext, refs := range unused {
+		if len(refs) == 1 && len(unplaced[ext]) == 1 {
+			rename[refs[0]] = unplaced[ext][0]
+		}
+	}
+	return rename
+}
+
+// Label positions of the icon view.
+const (
+	LabelBottom = "bottom"
+	LabelRight  = "right"
+)
+
+// ArrangeByValues lists the icon arrangements Finder understands.
+var ArrangeByValues = []string{"none", "grid", "name", "kind", "size", "label", "dateModified", "dateCreated", "dateAdded", "dateLastOpened"}
+
+// validateView checks the icon view options of config.
+func validateView(config Config) error {
+	if config.LabelPosition != "" && config.LabelPosition != LabelBottom && config.LabelPosition != LabelRight {
+		return fmt.Errorf("invalid label position %q, expected %s or %s", config.LabelPosition, LabelBottom, LabelRight)
+	}
+	if config.ArrangeBy != "" && !slices.Contains(ArrangeByValues, config.ArrangeBy) {
+		return fmt.Errorf("invalid arrangeBy %q, expected one of %s", config.ArrangeBy, strings.Join(ArrangeByValues, ", "))
+	}
+	return nil
+}
+
+// newDSStore creates the .DS_Store describing the Finder window: window
+// bounds and chrome, icon view options and the positions of the contents.
+// The geometry of the reference layout, if one is set, replaces the one of
+// config, while the view options config sets explicitly are kept.
+func newDSStore(config Config) *dsstore.DSStore {
+	store := dsstore.NewDSStore()
+	store.SetIconSize(float64(config.ContentsIconSize))
+	store.SetWindow(config.WindowWidth, config.WindowHeight, config.WindowX, config.WindowY)
+	store.SetLabelSize(float64(config.LabelSize))
+	store.SetLabelPlaceToBottom(config.LabelPosition != LabelRight)
+	store.SetBgToDefault()
+	if c, err := ParseHexColor(config.BackgroundColor); err == nil {
+		store.SetBgColor(float64(c.R)/255, float64(c.G)/255, float64(c.B)/255)
+	}
+	view := store.IconViewPreferences()
+	view.GridOffsetX = config.GridOffsetX
+	view.GridOffsetY = config.GridOffsetY
+
+	names := make([]string, len(config.Contents))
+	for i, content := range config.Contents {
+		names[i] = filepath.Base(content.Path)
+		store.SetIconPos(names[i], uint32(content.X), uint32(content.Y))
+	}
+	if config.Layout != nil {
+		store.CopyLayout(config.Layout.Store, config.Layout.renames(names))
+	}
+
+	settings := store.WorkspaceSettings()
+	if config.ShowToolbar != nil {
+		settings.ShowToolbar = *config.ShowToolbar
+	}
+	if config.ShowSidebar != nil {
+		store.SetSidebar(*config.ShowSidebar)
+	}
+	if config.ShowPathbar != nil {
+		settings.ShowPathbar = *config.ShowPathbar
+	}
+	if config.ShowStatusBar != nil {
+		settings.ShowStatusBar = *config.ShowStatusBar
+	}
+	if config.SidebarWidth != 0 {
+		settings.SidebarWidth = config.SidebarWidth
+	}
+
+	if config.ShowItemInfo != nil {
+		view.ShowItemInfo = *config.ShowItemInfo
+	}
+	if config.ShowIconPreview != nil {
+		view.ShowIconPreview = *config.ShowIconPreview
+	}
+	if config.GridSpacing != 0 {
+		view.GridSpacing = config.GridSpacing
+	}
+	if config.ArrangeBy != "" {
+		view.ArrangeBy = config.ArrangeBy
+	}
+	if config.LabelPosition != "" {
+		store.SetLabelPlaceToBottom(config.LabelPosition == LabelBottom)
+	}
+	return store
+}
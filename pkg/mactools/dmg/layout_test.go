@@ -0,0 +1,180 @@
+package dmg
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ironpark/zapp/pkg/mactools/dsstore"
+	"github.com/ironpark/zapp/pkg/mactools/dsstore/entry"
+	"github.com/ironpark/zapp/pkg/mactools/hdiutil"
+	"github.com/samber/lo"
+)
+
+func TestLoadLayoutFromDMG(t *testing.T) {
+	dir := t.TempDir()
+	oldApp := filepath.Join(dir, "MyApp 0.9.app")
+	if err := os.MkdirAll(filepath.Join(oldApp, "Contents"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	background := filepath.Join(dir, "bg.png")
+	if err := os.WriteFile(background, []byte("\x89PNG fake"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	ref := filepath.Join(dir, "ref.dmg")
+	err := CreateDMGNative(Config{
+		FileName:         ref,
+		Title:            "MyApp",
+		LabelSize:        12,
+		ContentsIconSize: 96,
+		WindowWidth:      700,
+		WindowHeight:     500,
+		Background:       background,
+		Format:           hdiutil.UDRO,
+		LogWriter:        io.Discard,
+		Contents: []Item{
+			{X: 100, Y: 150, Type: Dir, Path: oldApp},
+			{X: 400, Y: 150, Type: Link, Path: "/Applications"},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	layout, err := LoadLayout(ref, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(layout.Background)
+	if err != nil || !bytes.Equal(data, []byte("\x89PNG fake")) {
+		t.Fatalf("background not extracted: %v", err)
+	}
+
+	store := newDSStore(Config{
+		LabelSize:        14,
+		ContentsIconSize: 128,
+		WindowWidth:      640,
+		WindowHeight:     480,
+		Layout:           layout,
+		Contents: []Item{
+			{X: 1, Y: 1, Type: Dir, Path: filepath.Join(dir, "MyApp 1.0.app")},
+			{X: 2, Y: 2, Type: Link, Path: "/Applications"},
+		},
+	})
+	positions := map[string][2]uint32{}
+	for _, e := range store.Entries {
+		switch e := e.(type) {
+		case *entry.IconLocationEntry:
+			positions[e.Filename()] = [2]uint32{e.X, e.Y}
+		case *entry.WorkspaceSettingsEntry:
+			if e.Width != 700 || e.Height != 500 {
+				t.Errorf("window size %dx%d not copied", e.Width, e.Height)
+			}
+		case *entry.IconViewPreferencesEntry:
+			if e.IconSize != 96 || e.TextSize != 12 {
+				t.Errorf("icon size %v, text size %v not copied", e.IconSize, e.TextSize)
+			}
+		}
+	}
+	if positions["MyApp 1.0.app"] != [2]uint32{100, 150} || positions["Applications"] != [2]uint32{400, 150} {
+		t.Errorf("unexpected positions %v", positions)
+	}
+	if _, ok := positions["MyApp 0.9.app"]; ok {
+		t.Errorf("old app name kept: %v", positions)
+	}
+}
+
+func TestLayoutRenames(t *testing.T) {
+	store := newDSStore(Config{Contents: []Item{{Path: "A.app"}, {Path: "B.app"}, {Path: "Applications"}}})
+	layout := &Layout{Store: store, Rename: map[string]string{"A.app": "C.app"}}
+	got := layout.renames([]string{"C.app", "D.app", "Applications"})
+	if len(got) != 2 || got["A.app"] != "C.app" || got["B.app"] != "D.app" {
+		t.Fatalf("unexpected renames %v", got)
+	}
+	// Two candidates of the same kind are ambiguous and left alone.
+	got = (&Layout{Store: store}).renames([]string{"C.app", "D.app"})
+	if len(got) != 0 {
+		t.Fatalf("unexpected renames %v", got)
+	}
+}
+
+// TestNewDSStoreLayoutAndView checks that a reference layout supplies the
+// window geometry without overriding the background or the view options set
+// in the config.
+func TestNewDSStoreLayoutAndView(t *testing.T) {
+	ref := dsstore.NewDSStore()
+	ref.SetWindow(700, 500, 10, 20)
+	ref.SetIconSize(96)
+	ref.SetLabelSize(12)
+	ref.WorkspaceSettings().ShowToolbar = true
+	refView := ref.IconViewPreferences()
+	refView.GridSpacing = 80
+	refView.ArrangeBy = "kind"
+	ref.SetBackgroundAlias([]byte("reference alias"), []byte("reference bookmark"))
+	ref.SetIconPos("App.app", 100, 150)
+
+	store := newDSStore(Config{
+		WindowWidth:      640,
+		WindowHeight:     480,
+		ContentsIconSize: 128,
+		LabelSize:        14,
+		BackgroundColor:  "#ff0000",
+		ShowToolbar:      lo.ToPtr(false),
+		GridSpacing:      50,
+		ArrangeBy:        "name",
+		LabelPosition:    LabelRight,
+		Layout:           &Layout{Store: ref},
+		Contents:         []Item{{X: 1, Y: 1, Type: Dir, Path: "App.app"}},
+	})
+	settings := store.WorkspaceSettings()
+	if settings.Width != 700 || settings.Height != 500 || settings.X != 10 || settings.Y != 20 {
+		t.Errorf("window bounds not copied: %+v", settings)
+	}
+	if settings.ShowToolbar {
+		t.Error("toolbar option of the config overridden by the reference")
+	}
+	view := store.IconViewPreferences()
+	if view.IconSize != 96 || view.TextSize != 12 {
+		t.Errorf("icon size %v, text size %v not copied", view.IconSize, view.TextSize)
+	}
+	if view.GridSpacing != 50 || view.ArrangeBy != "name" || view.LabelOnBottom {
+		t.Errorf("view options of the config overridden by the reference: %+v", view)
+	}
+	if view.BackgroundType != 1 || view.BackgroundColorRed != 1 || view.BackgroundImageAlias != nil || view.BackgroundImageBookmark != nil {
+		t.Errorf("background of the config not kept: %+v", view)
+	}
+	for _, e := range store.Entries {
+		if e, ok := e.(*entry.IconLocationEntry); ok && (e.X != 100 || e.Y != 150) {
+			t.Errorf("icon position %d,%d not copied", e.X, e.Y)
+		}
+	}
+}
+
+func TestNewDSStoreView(t *testing.T) {
+	store := newDSStore(Config{
+		WindowWidth:   500,
+		WindowHeight:  300,
+		WindowX:       470,
+		WindowY:       300,
+		ShowSidebar:   lo.ToPtr(false),
+		ShowToolbar:   lo.ToPtr(false),
+		ShowItemInfo:  lo.ToPtr(false),
+		GridSpacing:   50,
+		GridOffsetY:   10,
+		ArrangeBy:     "name",
+		LabelPosition: LabelRight,
+	})
+	settings := store.WorkspaceSettings()
+	if settings.X != 470 || settings.Y != 300 || settings.ShowSidebar || settings.ContainerShowSidebar || settings.ShowToolbar {
+		t.Errorf("unexpected window settings %+v", settings)
+	}
+	view := store.IconViewPreferences()
+	if view.ShowItemInfo || !view.ShowIconPreview || view.GridSpacing != 50 || view.GridOffsetY != 10 || view.ArrangeBy != "name" || view.LabelOnBottom {
+		t.Errorf("unexpected icon view options %+v", view)
+	}
+	if err := validateView(Config{ArrangeBy: "color"}); err == nil {
+		t.Error("expected an error for an unknown arrangement")
+	}
+}
@@ -0,0 +1,201 @@
+package dmg
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/ironpark/zapp/pkg/mactools/dmg/udif"
+	"github.com/ironpark/zapp/pkg/mactools/pkg"
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/japanese"
+	"golang.org/x/text/encoding/korean"
+	"golang.org/x/text/encoding/simplifiedchinese"
+)
+
+// License is a software license agreement shown before the image is
+// mounted. Path is a plain text file.
+type License struct {
+	Language string `json:"language" yaml:"language"`
+	Path     string `json:"path" yaml:"path"`
+}
+
+// ParseLicense parses a license given as lang:path, such as en:LICENSE.txt.
+func ParseLicense(s string) (License, error) {
+	lang, path, ok := strings.Cut(s, ":")
+	if !ok || lang == "" || path == "" {
+		return License{}, fmt.Errorf("invalid license %q, expected lang:path", s)
+	}
+	return License{Language: lang, Path: path}, nil
+}
+
+// slaBaseID is the resource ID of the LPic resource and of the resources of
+// the first language; the following languages count up from it.
+const slaBaseID = 5000
+
+// slaLanguage is a language the license window can be shown in: its classic
+// Mac OS region code, text encoding and localized window strings.
+type slaLanguage struct {
+	region    int16
+	encoding  encoding.Encoding
+	multibyte bool
+	// strings are the language name, the Agree, Disagree, Print and Save
+	// button titles and the message shown above the license.
+	strings [6]string
+}
+
+var slaLanguages = map[string]slaLanguage{
+	"en": {0, charmap.Macintosh, false, [6]string{"English", "Agree", "Disagree", "Print", "Save...", `If you agree with the terms of this license, press "Agree" to install the software. If you do not agree, press "Disagree".`}},
+	"fr": {1, charmap.Macintosh, false, [6]string{"Français", "Accepter", "Refuser", "Imprimer", "Enregistrer...", `Si vous acceptez les termes de la présente licence, cliquez sur "Accepter" afin d'installer le logiciel. Si vous n'êtes pas d'accord avec les termes de la licence, cliquez sur "Refuser".`}},
+	"de": {3, charmap.Macintosh, false, [6]string{"Deutsch", "Akzeptieren", "Ablehnen", "Drucken", "Sichern...", `Klicken Sie auf "Akzeptieren", wenn Sie mit den Bestimmungen des Software-Lizenzvertrags einverstanden sind. Falls nicht, klicken Sie bitte auf "Ablehnen". Sie können die Software nur installieren, wenn Sie "Akzeptieren" angeklickt haben.`}},
+	"it": {4, charmap.Macintosh, false, [6]string{"Italiano", "Accetto", "Rifiuto", "Stampa", "Registra...", `Se accetti le condizioni di questa licenza, fai clic su "Accetto" per installare il software. Altrimenti fai clic su "Rifiuto".`}},
+	"nl": {5, charmap.Macintosh, false, [6]string{"Nederlands", "Ja", "Nee", "Print", "Bewaar...", `Indien u akkoord gaat met de voorwaarden van deze licentie, kunt u op "Ja" klikken om het programma te installeren. Indien u niet akkoord gaat, klikt u op "Nee".`}},
+	"sv": {7, charmap.Macintosh, false, [6]string{"Svenska", "Godkänner", "Avböjer", "Skriv ut", "Spara...", `Om du godkänner licensvillkoren klickar du på "Godkänner" för att installera programvaran. Om du inte godkänner licensvillkoren klickar du på "Avböjer".`}},
+	"es": {8, charmap.Macintosh, false, [6]string{"Español", "Aceptar", "No aceptar", "Imprimir", "Guardar...", `Si está de acuerdo con los términos de esta licencia, pulse "Aceptar" para instalar el software. Si no está de acuerdo con los términos de esta licencia, pulse "No aceptar".`}},
+	"da": {9, charmap.Macintosh, false, [6]string{"Dansk", "Enig", "Uenig", "Udskriv", "Arkiver...", `Hvis du accepterer betingelserne i licensaftalen, skal du klikke på "Enig" for at installere softwaren. Klik på "Uenig" for at annullere installeringen.`}},
+	"pt": {10, charmap.Macintosh, false, [6]string{"Português", "Concordar", "Discordar", "Imprimir", "Salvar...", `Se está de acordo com os termos desta licença, pressione "Concordar" para instalar o software. Se não está de acordo, pressione "Discordar".`}},
+	"no": {12, charmap.Macintosh, false, [6]string{"Norsk", "Enig", "Ikke enig", "Skriv ut", "Arkiver...", `Hvis du er enig i bestemmelsene i denne lisensavtalen, klikker du på "Enig" for å installere programvaren. Hvis du ikke er enig, klikker du på "Ikke enig".`}},
+	"nb": {12, charmap.Macintosh, false, [6]string{"Norsk", "Enig", "Ikke enig", "Skriv ut", "Arkiver...", `Hvis du er enig i bestemmelsene i denne lisensavtalen, klikker du på "Enig" for å installere programvaren. Hvis du ikke er enig, klikker du på "Ikke enig".`}},
+	"ja": {14, japanese.ShiftJIS, true, [6]string{"日本語", "同意します", "同意しません", "印刷する", "保存...", "本ソフトウェア使用許諾契約の条件に同意される場合には、ソフトウェアをインストールするために「同意します」を押してください。同意されない場合には、「同意しません」を押してください。"}},
+	"fi": {17, charmap.Macintosh, false, [6]string{"Suomi", "Hyväksyn", "En hyväksy", "Tulosta", "Tallenna...", `Hyväksy lisenssisopimuksen ehdot osoittamalla "Hyväksyn". Jos et hyväksy sopimuksen ehtoja, osoita "En hyväksy".`}},
+	"ru": {49, charmap.MacintoshCyrillic, false, [6]string{"Русский", "Согласен", "Не согласен", "Печать", "Сохранить...", `Если Вы согласны с условиями данной лицензии, нажмите "Согласен", чтобы установить программное обеспечение. Если Вы не согласны, нажмите "Не согласен".`}},
+	"ko": {51, korean.EUCKR, true, [6]string{"한국어", "동의", "동의 안함", "프린트", "저장...", `사용 계약서의 내용에 동의하면, "동의" 버튼을 눌러 소프트웨어를 설치하십시오. 동의하지 않는다면, "동의 안함" 버튼을 누르십시오.`}},
+	"zh": {52, simplifiedchinese.GBK, true, [6]string{"简体中文", "同意", "不同意", "打印", "存储...", `如果您同意本许可协议的条款，请按"同意"来安装此软件。如果您不同意本许可协议的条款，请按"不同意"。`}},
+}
+
+// validateLicenses checks the language codes of licenses, which must be
+// ISO 639-1 codes the license window has strings for, each used once.
+func validateLicenses(licenses []License) error {
+	seen := map[string]bool{}
+	for _, l := range licenses {
+		lang := strings.ToLower(l.Language)
+		if !pkg.IsValidLanguageCode(lang) {
+			return fmt.Errorf("invalid language code: %s", l.Language)
+		}
+		if _, ok := slaLanguages[lang]; !ok {
+			return fmt.Errorf("license agreements are not supported in language %s", l.Language)
+		}
+		if seen[lang] {
+			return fmt.Errorf("duplicate license for language %s", l.Language)
+		}
+		seen[lang] = true
+	}
+	return nil
+}
+
+// licenseResources returns the LPic, STR#, TEXT and styl resources of a
+// software license agreement in the languages of licenses. The first
+// license is shown when none matches the user's language.
+func licenseResources(licenses []License) (map[string][]udif.Resource, error) {
+	if err := validateLicenses(licenses); err != nil {
+		return nil, err
+	}
+	res := map[string][]udif.Resource{}
+	resource := func(typ string, id int, name string, data []byte) {
+		res[typ] = append(res[typ], udif.Resource{
+			Attributes: "0x0000",
+			Data:       data,
+			ID:         strconv.Itoa(id),
+			Name:       name,
+		})
+	}
+
+	lpic := binary.BigEndian.AppendUint16(nil, uint16(slaLanguages[strings.ToLower(licenses[0].Language)].region))
+	lpic = binary.BigEndian.AppendUint16(lpic, uint16(len(licenses)))
+	for i, l := range licenses {
+		lang := slaLanguages[strings.ToLower(l.Language)]
+		multibyte := uint16(0)
+		if lang.multibyte {
+			multibyte = 1
+		}
+		lpic = binary.BigEndian.AppendUint16(lpic, uint16(lang.region))
+		lpic = binary.BigEndian.AppendUint16(lpic, uint16(i))
+		lpic = binary.BigEndian.AppendUint16(lpic, multibyte)
+
+		str, err := slaStrings(lang)
+		if err != nil {
+			return nil, fmt.Errorf("license %s: %w", l.Language, err)
+		}
+		text, err := os.ReadFile(l.Path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read license: %w", err)
+		}
+		text = bytes.TrimPrefix(text, []byte("\xef\xbb\xbf"))
+		text = bytes.ReplaceAll(text, []byte("\r\n"), []byte("\r"))
+		text = bytes.ReplaceAll(text, []byte("\n"), []byte("\r"))
+		if text, err = lang.encoding.NewEncoder().Bytes(text); err != nil {
+			return nil, fmt.Errorf("license %s can not be stored in the classic Mac OS encoding of its language: %w", l.Path, err)
+		}
+
+		id := slaBaseID + i
+		resource("STR#", id, lang.strings[0]+" buttons", str)
+		resource("TEXT", id, lang.strings[0]+" SLA", text)
+		resource("styl", id, lang.strings[0]+" SLA", slaStyle())
+	}
+	resource("LPic", slaBaseID, "", lpic)
+	return res, nil
+}
+
+// slaStrings encodes the window strings of lang as a string list.
+func slaStrings(lang slaLanguage) ([]byte, error) {
+	data := binary.BigEndian.AppendUint16(nil, uint16(len(lang.strings)))
+	for _, s := range lang.strings {
+		b, err := lang.encoding.NewEncoder().Bytes([]byte(s))
+		if err != nil {
+			return nil, err
+		}
+		if len(b) > 255 {
+			return nil, fmt.Errorf("string %q is too long", s)
+		}
+		data = append(data, byte(len(b)))
+		data = append(data, b...)
+	}
+	return data, nil
+}
+
+// slaStyle returns a style table with a single run of 12 point text in the
+// application font.
+func slaStyle() []byte {
+	data := binary.BigEndian.AppendUint16(nil, 1)  // number of runs
+	data = binary.BigEndian.AppendUint32(data, 0)  // start offset
+	data = binary.BigEndian.AppendUint16(data, 16) // line height
+	data = binary.BigEndian.AppendUint16(data, 12) // font ascent
+	data = binary.BigEndian.AppendUint16(data, 1)  // font family, applFont
+	data = append(data, 0, 0)                      // face, padding
+	data = binary.BigEndian.AppendUint16(data, 12) // font size
+	return append(data, make([]byte, 6)...)        // black
+}
+
+// checkLicenses reads the license agreement of config, so that unknown
+// languages and unreadable files are reported before the image is built.
+func checkLicenses(config Config) error {
+	if len(config.Licenses) == 0 {
+		return nil
+	}
+	_, err := licenseResources(config.Licenses)
+	return err
+}
+
+// embedLicenses adds the license agreement of config to the image written
+// to config.FileName. The image is removed when that fails, rather than
+// left without its agreement.
+func embedLicenses(config Config) error {
+	if len(config.Licenses) == 0 {
+		return nil
+	}
+	res, err := licenseResources(config.Licenses)
+	if err == nil {
+		if err = udif.UpdateResources(config.FileName, res); err != nil {
+			err = fmt.Errorf("failed to add license agreement: %w", err)
+		}
+	}
+	if err != nil {
+		os.Remove(config.FileName)
+		return err
+	}
+	return nil
+}
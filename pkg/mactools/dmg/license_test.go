@@ -0,0 +1,126 @@
+package dmg
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ironpark/zapp/pkg/mactools/dmg/udif"
+	"github.com/ironpark/zapp/pkg/mactools/hdiutil"
+)
+
+func TestLicenseResources(t *testing.T) {
+	dir := t.TempDir()
+	en := filepath.Join(dir, "en.txt")
+	ja := filepath.Join(dir, "ja.txt")
+	if err := os.WriteFile(en, []byte("\xef\xbb\xbfLicense\r\nCafé\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(ja, []byte("使用許諾契約\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	res, err := licenseResources([]License{{Language: "en", Path: en}, {Language: "JA", Path: ja}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lpic := res["LPic"][0].Data
+	want := []uint16{0, 2, 0, 0, 0, 14, 1, 1}
+	for i, w := range want {
+		if got := binary.BigEndian.Uint16(lpic[2*i:]); got != w {
+			t.Fatalf("LPic = % x", lpic)
+		}
+	}
+	if len(res["TEXT"]) != 2 || res["TEXT"][1].ID != "5001" {
+		t.Fatalf("TEXT resources = %+v", res["TEXT"])
+	}
+	if got := res["TEXT"][0].Data; !bytes.Equal(got, []byte("License\rCaf\x8e\r")) {
+		t.Errorf("English text = %q", got)
+	}
+	if got := res["TEXT"][1].Data; !bytes.Equal(got, []byte("\x8eg\x97p\x8b\x96\x91\xf8\x8c_\x96\xf1\r")) {
+		t.Errorf("Japanese text = % x", got)
+	}
+	str := res["STR#"][0].Data
+	if binary.BigEndian.Uint16(str) != 6 || string(str[3:3+str[2]]) != "English" {
+		t.Errorf("STR# = %q", str)
+	}
+	for _, lang := range []string{"en", "fr", "de", "it", "nl", "sv", "es", "da", "pt", "no", "ja", "fi", "ru", "ko", "zh"} {
+		if _, err := slaStrings(slaLanguages[lang]); err != nil {
+			t.Errorf("%s: %v", lang, err)
+		}
+	}
+
+	for _, licenses := range [][]License{
+		{{Language: "xx", Path: en}},
+		{{Language: "aa", Path: en}},
+		{{Language: "en", Path: en}, {Language: "en", Path: en}},
+		{{Language: "ru", Path: ja}},
+	} {
+		if _, err := licenseResources(licenses); err == nil {
+			t.Errorf("licenseResources(%v) succeeded", licenses)
+		}
+	}
+}
+
+func TestEmbedLicenses(t *testing.T) {
+	dir := t.TempDir()
+	text := filepath.Join(dir, "LICENSE")
+	if err := os.WriteFile(text, []byte("License"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	out := filepath.Join(dir, "test.dmg")
+	if err := udif.Create(out, bytes.NewReader(make([]byte, 4096)), hdiutil.UDZO); err != nil {
+		t.Fatal(err)
+	}
+	if err := embedLicenses(Config{FileName: out, Licenses: []License{{Language: "en", Path: text}}}); err != nil {
+		t.Fatal(err)
+	}
+	img, err := udif.Open(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer img.Close()
+	for _, typ := range []string{"LPic", "STR#", "TEXT", "styl"} {
+		if len(img.Resources.Resources[typ]) != 1 {
+			t.Errorf("%d %s resources", len(img.Resources.Resources[typ]), typ)
+		}
+	}
+}
+
+func TestLicensesCheckedBeforeBuilding(t *testing.T) {
+	dir := t.TempDir()
+	text := filepath.Join(dir, "LICENSE")
+	if err := os.WriteFile(text, []byte("License"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	out := filepath.Join(dir, "test.dmg")
+	for _, l := range []License{
+		{Language: "xx", Path: text},
+		{Language: "en", Path: filepath.Join(dir, "missing.txt")},
+	} {
+		err := CreateDMGNative(Config{FileName: out, Title: "Test", LogWriter: io.Discard, Licenses: []License{l}})
+		if err == nil {
+			t.Fatalf("license %s:%s accepted", l.Language, l.Path)
+		}
+		if _, err := os.Stat(out); !os.IsNotExist(err) {
+			t.Fatalf("image written for license %s:%s", l.Language, l.Path)
+		}
+	}
+
+	// An image the agreement can not be added to is removed.
+	if err := udif.Create(out, bytes.NewReader(make([]byte, 4096)), hdiutil.UDZO); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(text, []byte("使用許諾契約"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := embedLicenses(Config{FileName: out, Licenses: []License{{Language: "en", Path: text}}}); err == nil {
+		t.Fatal("embedded a license the language encoding can not store")
+	}
+	if _, err := os.Stat(out); !os.IsNotExist(err) {
+		t.Fatal("image kept after the license agreement failed")
+	}
+}
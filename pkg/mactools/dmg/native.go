@@ -0,0 +1,217 @@
+package dmg
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ironpark/zapp/pkg/mactools/alias"
+	"github.com/ironpark/zapp/pkg/mactools/bookmark"
+	"github.com/ironpark/zapp/pkg/mactools/dmg/udif"
+	"github.com/ironpark/zapp/pkg/mactools/hdiutil"
+	"github.com/ironpark/zapp/pkg/mactools/hfsplus"
+)
+
+// CreateDMGNative creates a DMG file without hdiutil. The HFS+ volume is
+// assembled in Go and encoded as UDIF, so it also works on Linux.
+func CreateDMGNative(config Config) error {
+	if config.LogWriter == nil {
+		config.LogWriter = os.Stdout
+	}
+	if config.FileName == "" {
+		config.FileName = config.Title + ".dmg"
+	}
+	if !strings.HasSuffix(config.FileName, ".dmg") {
+		config.FileName += ".dmg"
+	}
+	if config.Format == "" {
+		config.Format = hdiutil.UDZO
+	}
+	if _, err := udif.ChunkTypeFor(config.Format); err != nil {
+		return err
+	}
+	if err := checkLicenses(config); err != nil {
+		return err
+	}
+	level := udif.DefaultCompressionLevel
+	if config.CompressionLevel != "" {
+		var err error
+		if level, err = strconv.Atoi(config.CompressionLevel); err != nil {
+			return fmt.Errorf("invalid compression level: %s", config.CompressionLevel)
+		}
+	}
+
+	opts := []udif.Option{udif.WithCompressionLevel(level)}
+	if config.ChunkSize < 0 || config.CompressionThreads < 0 {
+		return fmt.Errorf("invalid chunk size %d or compression threads %d", config.ChunkSize, config.CompressionThreads)
+	}
+	if config.ChunkSize != 0 {
+		opts = append(opts, udif.WithChunkSize(config.ChunkSize*1024/udif.SectorSize))
+	}
+	if config.CompressionThreads != 0 {
+		opts = append(opts, udif.WithConcurrency(config.CompressionThreads))
+	}
+	var date time.Time
+	if config.Reproducible {
+		var err error
+		if date, err = sourceDate(); err != nil {
+			return err
+		}
+		sum := sha256.Sum256([]byte(fmt.Sprintf("%s\x00%d", config.Title, date.Unix())))
+		var id [16]byte
+		copy(id[:], sum[:])
+		opts = append(opts, udif.WithSegmentID(id))
+	}
+
+	volume, err := buildVolume(config, date)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(config.LogWriter, "Writing %s image...\n", config.Format)
+	pr, pw := io.Pipe()
+	go func() {
+		_, err := volume.WriteTo(pw)
+		pw.CloseWithError(err)
+	}()
+	err = udif.Create(config.FileName, pr, config.Format, opts...)
+	pr.CloseWithError(err)
+	if err != nil {
+		return fmt.Errorf("failed to write DMG: %w", err)
+	}
+
+	if err := embedLicenses(config); err != nil {
+		return err
+	}
+	// The file icon lives in extended attributes that only macOS keeps.
+	if config.Icon != "" && runtime.GOOS == "darwin" {
+		if err := setFileIcon(config.FileName, config.Icon); err != nil {
+			return err
+		}
+	}
+
+	fmt.Fprintf(config.LogWriter, "DMG created successfully: %s\n", config.FileName)
+	return nil
+}
+
+// sourceDate returns the date of reproducible builds, SOURCE_DATE_EPOCH
+// seconds after the Unix epoch.
+func sourceDate() (time.Time, error) {
+	v := os.Getenv("SOURCE_DATE_EPOCH")
+	if v == "" {
+		return time.Unix(0, 0).UTC(), nil
+	}
+	sec, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || sec < 0 {
+		return time.Time{}, fmt.Errorf("invalid SOURCE_DATE_EPOCH: %s", v)
+	}
+	return time.Unix(sec, 0).UTC(), nil
+}
+
+// buildVolume lays out the volume contents the same way the hdiutil based
+// builders do after mounting: contents, background, volume icon and the
+// .DS_Store describing the window. Unless date is zero, every file and
+// folder is dated date.
+func buildVolume(config Config, date time.Time) (*hfsplus.Builder, error) {
+	b := hfsplus.NewBuilder(config.Title)
+	for _, item := range config.Contents {
+		var n *hfsplus.Node
+		switch item.Type {
+		case File, Dir:
+			var err error
+			if n, err = hfsplus.NodeFromPath(item.Path); err != nil {
+				return nil, fmt.Errorf("failed to read %s: %w", item.Path, err)
+			}
+		case Link:
+			if config.AliasLinks {
+				var err error
+				if n, err = aliasNode(filepath.Base(item.Path), item.Path); err != nil {
+					return nil, err
+				}
+				break
+			}
+			n = hfsplus.NewNode(filepath.Base(item.Path), os.ModeSymlink|0755)
+			n.Target = item.Path
+		default:
+			return nil, fmt.Errorf("unknown item type %q", item.Type)
+		}
+		if err := b.Add("", n); err != nil {
+			return nil, err
+		}
+	}
+
+	var background *hfsplus.Node
+	if config.Background != "" {
+		backgroundDir := hfsplus.NewNode(".background", os.ModeDir|0755)
+		backgroundDir.Finder.Flags |= hfsplus.FinderIsInvisible
+		var err error
+		background, err = hfsplus.NodeFromPath(config.Background)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read background: %w", err)
+		}
+		background.Name = backgroundName(config)
+		backgroundDir.Children = append(backgroundDir.Children, background)
+		if err := b.Add("", backgroundDir); err != nil {
+			return nil, err
+		}
+	}
+
+	if config.Icon != "" {
+		icon, err := hfsplus.NodeFromPath(config.Icon)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read icon: %w", err)
+		}
+		icon.Name = ".VolumeIcon.icns"
+		icon.Finder.Flags |= hfsplus.FinderIsInvisible
+		copy(icon.Finder.Creator[:], "icnC")
+		if err := b.Add("", icon); err != nil {
+			return nil, err
+		}
+		b.Root.Finder.Flags |= hfsplus.FinderHasCustomIcon
+	}
+
+	if !date.IsZero() {
+		b.SetTimes(date)
+	}
+
+	store := newDSStore(config)
+	if background != nil {
+		// The volume only exists once the image is mounted, so describe the
+		// background by name and dates instead of looking it up.
+		target := alias.Target{
+			VolumeName:    config.Title,
+			VolumeCreated: b.Root.CreateTime,
+			Path:          "/.background/" + background.Name,
+			Created:       background.CreateTime,
+		}
+		aliasData, err := alias.Build(target)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create background alias: %w", err)
+		}
+		bookmarkData, err := bookmark.Build(target)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create background bookmark: %w", err)
+		}
+		store.SetBackgroundAlias(aliasData, bookmarkData)
+	}
+	dsStore := hfsplus.NewNode(".DS_Store", 0644)
+	if !date.IsZero() {
+		dsStore.ModTime, dsStore.CreateTime = date, date
+	}
+	var buf bytes.Buffer
+	if _, err := store.WriteTo(&buf); err != nil {
+		return nil, fmt.Errorf("failed to write .DS_Store: %w", err)
+	}
+	dsStore.Data = buf.Bytes()
+	if err := b.Add("", dsStore); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
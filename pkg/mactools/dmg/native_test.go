@@ -0,0 +1,257 @@
+package dmg
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ironpark/zapp/pkg/mactools/alias"
+	"github.com/ironpark/zapp/pkg/mactools/dmg/udif"
+	"github.com/ironpark/zapp/pkg/mactools/dsstore"
+	"github.com/ironpark/zapp/pkg/mactools/hdiutil"
+	"github.com/ironpark/zapp/pkg/mactools/hfsplus"
+)
+
+func TestCreateDMGNative(t *testing.T) {
+	dir := t.TempDir()
+	app := filepath.Join(dir, "Test.app")
+	if err := os.MkdirAll(filepath.Join(app, "Contents", "MacOS"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(app, "Contents", "MacOS", "Test"), []byte("#!/bin/sh\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	icon := filepath.Join(dir, "icon.icns")
+	if err := os.WriteFile(icon, []byte("icns"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, format := range []hdiutil.Format{hdiutil.UDZO, hdiutil.ULFO, hdiutil.ULMO} {
+		t.Run(string(format), func(t *testing.T) {
+			out := filepath.Join(dir, "Test-"+string(format)+".dmg")
+			err := CreateDMGNative(Config{
+				FileName:         out,
+				Title:            "Test",
+				Icon:             icon,
+				LabelSize:        14,
+				ContentsIconSize: 128,
+				WindowWidth:      640,
+				WindowHeight:     480,
+				Format:           format,
+				LogWriter:        io.Discard,
+				Contents: []Item{
+					{X: 160, Y: 240, Type: Dir, Path: app},
+					{X: 480, Y: 240, Type: Link, Path: "/Applications"},
+				},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			img, err := udif.Open(out)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer img.Close()
+			if img.Format() != format {
+				t.Fatalf("image format %s, want %s", img.Format(), format)
+			}
+			part, err := img.FilesystemPartition()
+			if err != nil {
+				t.Fatal(err)
+			}
+			vol, err := hfsplus.Open(img.PartitionReader(part))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if vol.VolumeName() != "Test" || vol.Root().Finder.Flags&hfsplus.FinderHasCustomIcon == 0 {
+				t.Fatalf("unexpected volume %q, flags %#x", vol.VolumeName(), vol.Root().Finder.Flags)
+			}
+			for _, p := range []string{"Test.app/Contents/MacOS/Test", "Applications", ".VolumeIcon.icns", ".DS_Store"} {
+				if _, err := vol.Lookup(p); err != nil {
+					t.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestNativeBackgroundAlias(t *testing.T) {
+	dir := t.TempDir()
+	background := filepath.Join(dir, "bg.png")
+	if err := os.WriteFile(background, []byte("png"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	b, err := buildVolume(Config{
+		Title:            "Installer",
+		Background:       background,
+		LabelSize:        12,
+		ContentsIconSize: 128,
+		WindowWidth:      640,
+		WindowHeight:     480,
+	}, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	n, err := b.Lookup(".DS_Store")
+	if err != nil {
+		t.Fatal(err)
+	}
+	store, err := dsstore.Decode(n.Data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ivp := store.IconViewPreferences()
+	if ivp.BackgroundType != 2 {
+		t.Fatalf("background type = %d, want 2", ivp.BackgroundType)
+	}
+	for _, want := range []string{"/.background/background.png", "/Volumes/Installer"} {
+		if !bytes.Contains(ivp.BackgroundImageAlias, []byte(want)) {
+			t.Errorf("background alias does not contain %q", want)
+		}
+	}
+	if !bytes.HasPrefix(ivp.BackgroundImageBookmark, []byte("book")) || !bytes.Contains(ivp.BackgroundImageBookmark, []byte("background.png")) {
+		t.Error("background bookmark is missing")
+	}
+}
+
+func TestNativeAliasLinks(t *testing.T) {
+	b, err := buildVolume(Config{
+		Title:      "Installer",
+		AliasLinks: true,
+		Contents:   []Item{{X: 480, Y: 240, Type: Link, Path: "/Applications"}},
+	}, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	n, err := b.Lookup("Applications")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n.Mode&os.ModeSymlink != 0 || n.Finder.Flags&hfsplus.FinderIsAlias == 0 {
+		t.Fatalf("Applications is not an alias file: mode %v, flags %#x", n.Mode, n.Finder.Flags)
+	}
+	if string(n.Finder.Type[:]) != "fdrp" || string(n.Finder.Creator[:]) != "MACS" {
+		t.Errorf("type %q, creator %q", n.Finder.Type[:], n.Finder.Creator[:])
+	}
+	// The alias record is the only resource, right after the fork header.
+	fork := n.ResourceFork
+	if len(fork) < 260 {
+		t.Fatalf("resource fork is %d bytes", len(fork))
+	}
+	size := binary.BigEndian.Uint32(fork[256:])
+	info, err := alias.Decode(fork[260 : 260+size])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Path() != "/Applications" || info.VolumePath() != "/" || info.Target.Type != "directory" {
+		t.Errorf("alias points to %s on %s (%s)", info.Path(), info.VolumePath(), info.Target.Type)
+	}
+	if info.VolumeName() != startupVolume {
+		t.Errorf("alias records volume %q", info.VolumeName())
+	}
+}
+
+func TestCreateDMGNativeReproducible(t *testing.T) {
+	t.Setenv("SOURCE_DATE_EPOCH", "1700000000")
+	dir := t.TempDir()
+	app := filepath.Join(dir, "Test.app")
+	if err := os.MkdirAll(filepath.Join(app, "Contents", "MacOS"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	exe := filepath.Join(app, "Contents", "MacOS", "Test")
+	if err := os.WriteFile(exe, []byte("#!/bin/sh\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	background := filepath.Join(dir, "bg.png")
+	if err := os.WriteFile(background, []byte("png"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	build := func(name string) [32]byte {
+		out := filepath.Join(dir, name)
+		err := CreateDMGNative(Config{
+			FileName:         out,
+			Title:            "Test",
+			Background:       background,
+			LabelSize:        14,
+			ContentsIconSize: 128,
+			WindowWidth:      640,
+			WindowHeight:     480,
+			Format:           hdiutil.UDZO,
+			LogWriter:        io.Discard,
+			Reproducible:     true,
+			Contents: []Item{
+				{X: 160, Y: 240, Type: Dir, Path: app},
+				{X: 480, Y: 240, Type: Link, Path: "/Applications"},
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := os.ReadFile(out)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return sha256.Sum256(data)
+	}
+
+	first := build("first.dmg")
+	// A fresh checkout has other file dates; they must not matter.
+	later := time.Now().Add(time.Hour)
+	for _, p := range []string{exe, app, background} {
+		if err := os.Chtimes(p, later, later); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if second := build("second.dmg"); first != second {
+		t.Fatalf("checksums differ: %x, %x", first, second)
+	}
+
+	img, err := udif.Open(filepath.Join(dir, "first.dmg"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer img.Close()
+	part, err := img.FilesystemPartition()
+	if err != nil {
+		t.Fatal(err)
+	}
+	vol, err := hfsplus.Open(img.PartitionReader(part))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := time.Unix(1700000000, 0)
+	err = vol.Walk("/", func(p string, e *hfsplus.Entry) error {
+		if !e.ModTime.Equal(want) || !e.CreateTime.Equal(want) {
+			t.Errorf("%s dated %v, %v", p, e.CreateTime, e.ModTime)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCreateDMGNativeUnsupportedFormat(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "Test.dmg")
+	err := CreateDMGNative(Config{
+		Title:     "Test",
+		FileName:  out,
+		Format:    hdiutil.UDRW,
+		Contents:  []Item{{Type: Dir, Path: "missing.app"}},
+		LogWriter: io.Discard,
+	})
+	if err == nil || !strings.Contains(err.Error(), "unsupported format") {
+		t.Fatalf("expected an unsupported format error before reading the contents, got %v", err)
+	}
+	if _, err := os.Stat(out); !os.IsNotExist(err) {
+		t.Fatalf("image written for an unsupported format: %v", err)
+	}
+}
@@ -0,0 +1,64 @@
+package udif
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+
+	"howett.net/plist"
+)
+
+// UpdateResources replaces the resources of each type in resources in the
+// resource plist of the image at path, the way hdiutil udifrez does. Types
+// mapped to an empty list are removed. The data fork is left untouched, so
+// the image checksums stay valid.
+func UpdateResources(path string, resources map[string][]Resource) error {
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	st, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	img, err := NewReader(f, st.Size())
+	if err != nil {
+		return err
+	}
+	k := img.Koly
+	if k.XMLOffset+k.XMLLength+KolySize != uint64(st.Size()) {
+		return fmt.Errorf("resource plist of %s is not followed by the koly trailer", path)
+	}
+
+	fork := img.Resources
+	for typ, list := range resources {
+		if typ == "blkx" {
+			return fmt.Errorf("blkx resources can not be replaced")
+		}
+		if len(list) == 0 {
+			delete(fork.Resources, typ)
+			continue
+		}
+		fork.Resources[typ] = list
+	}
+	var xml bytes.Buffer
+	enc := plist.NewEncoderForFormat(&xml, plist.XMLFormat)
+	enc.Indent("\t")
+	if err := enc.Encode(fork); err != nil {
+		return fmt.Errorf("failed to write resource plist: %w", err)
+	}
+
+	k.XMLLength = uint64(xml.Len())
+	trailer, err := k.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	if _, err := f.WriteAt(append(xml.Bytes(), trailer...), int64(k.XMLOffset)); err != nil {
+		return fmt.Errorf("failed to update resource plist: %w", err)
+	}
+	if err := f.Truncate(int64(k.XMLOffset + k.XMLLength + KolySize)); err != nil {
+		return err
+	}
+	return f.Close()
+}
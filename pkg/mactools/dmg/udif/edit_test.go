@@ -0,0 +1,50 @@
+package udif
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ironpark/zapp/pkg/mactools/hdiutil"
+)
+
+func TestUpdateResources(t *testing.T) {
+	img := testImage()
+	path := filepath.Join(t.TempDir(), "test.dmg")
+	if err := Create(path, bytes.NewReader(img), hdiutil.UDZO); err != nil {
+		t.Fatal(err)
+	}
+	text := bytes.Repeat([]byte("license text\r"), 100)
+	err := UpdateResources(path, map[string][]Resource{
+		"TEXT": {{Attributes: "0x0000", Data: text, ID: "5000", Name: "English SLA"}},
+		"plst": nil,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := decodeImage(t, data)
+	if !bytes.Equal(out[:len(img)], img) {
+		t.Fatal("data fork changed")
+	}
+	dmg, err := NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res := dmg.Resources.Resources
+	if len(res["TEXT"]) != 1 || !bytes.Equal(res["TEXT"][0].Data, text) || res["TEXT"][0].ID != "5000" {
+		t.Errorf("TEXT resources = %+v", res["TEXT"])
+	}
+	if _, ok := res["plst"]; ok {
+		t.Error("plst resource was not removed")
+	}
+
+	if err := UpdateResources(path, map[string][]Resource{"blkx": nil}); err == nil {
+		t.Error("UpdateResources replaced the blkx tables")
+	}
+}
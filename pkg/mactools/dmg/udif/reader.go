@@ -0,0 +1,349 @@
+package udif
+
+import (
+	"bytes"
+	"compress/bzip2"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/ironpark/zapp/pkg/compress/adc"
+	"github.com/ironpark/zapp/pkg/compress/lzfse"
+	"github.com/ironpark/zapp/pkg/compress/xz"
+	"github.com/ironpark/zapp/pkg/mactools/hdiutil"
+	"howett.net/plist"
+)
+
+// Partition is one blkx entry of an image. Sectors are absolute within the
+// whole disk image.
+type Partition struct {
+	ID         int
+	Name       string
+	Attributes string
+	Table      BlkxTable
+}
+
+// Size returns the uncompressed partition size in bytes.
+func (p *Partition) Size() int64 {
+	return int64(p.Table.SectorCount) * SectorSize
+}
+
+// CompressedSize returns the number of data fork bytes the chunks of the
+// partition take up.
+func (p *Partition) CompressedSize() int64 {
+	var n int64
+	for _, c := range p.Table.Chunks {
+		n += int64(c.CompressedLength)
+	}
+	return n
+}
+
+// Image is an opened UDIF image.
+type Image struct {
+	Koly       Koly
+	Resources  ResourceFork
+	Partitions []Partition
+
+	r      io.ReaderAt
+	size   int64
+	closer io.Closer
+}
+
+// Open opens the UDIF image at path.
+func Open(path string) (*Image, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	st, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	img, err := NewReader(f, st.Size())
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	img.closer = f
+	return img, nil
+}
+
+// NewReader parses the koly trailer and blkx tables of an image of the given size.
+func NewReader(r io.ReaderAt, size int64) (*Image, error) {
+	if size < KolySize {
+		return nil, errors.New("file too small to be a UDIF image")
+	}
+	trailer := make([]byte, KolySize)
+	if _, err := r.ReadAt(trailer, size-KolySize); err != nil {
+		return nil, fmt.Errorf("failed to read koly trailer: %w", err)
+	}
+	img := &Image{r: r, size: size}
+	if err := img.Koly.UnmarshalBinary(trailer); err != nil {
+		return nil, err
+	}
+	k := &img.Koly
+	if k.XMLLength == 0 || k.XMLOffset+k.XMLLength > uint64(size) {
+		return nil, errors.New("resource plist is missing or out of range")
+	}
+	xml := make([]byte, k.XMLLength)
+	if _, err := r.ReadAt(xml, int64(k.XMLOffset)); err != nil {
+		return nil, fmt.Errorf("failed to read resource plist: %w", err)
+	}
+	if _, err := plist.Unmarshal(xml, &img.Resources); err != nil {
+		return nil, fmt.Errorf("failed to parse resource plist: %w", err)
+	}
+	for _, res := range img.Resources.Resources["blkx"] {
+		p := Partition{Name: res.Name, Attributes: res.Attributes}
+		if p.Name == "" {
+			p.Name = res.CFName
+		}
+		p.ID, _ = strconv.Atoi(res.ID)
+		if err := p.Table.UnmarshalBinary(res.Data); err != nil {
+			return nil, fmt.Errorf("partition %q: %w", p.Name, err)
+		}
+		img.Partitions = append(img.Partitions, p)
+	}
+	if len(img.Partitions) == 0 {
+		return nil, errors.New("image has no blkx tables")
+	}
+	sort.SliceStable(img.Partitions, func(i, j int) bool {
+		return img.Partitions[i].Table.SectorNumber < img.Partitions[j].Table.SectorNumber
+	})
+	return img, nil
+}
+
+// Format returns the format of the image as hdiutil names it, judged by its
+// compressed chunks. Images storing every chunk uncompressed are UDRO; the
+// format is empty when the compression is unknown.
+func (img *Image) Format() hdiutil.Format {
+	for _, p := range img.Partitions {
+		for _, c := range p.Table.Chunks {
+			switch c.Type {
+			case ChunkZeroFill, ChunkRaw, ChunkIgnore, ChunkComment, ChunkTerminator:
+				continue
+			}
+			return FormatFor(c.Type)
+		}
+	}
+	return hdiutil.UDRO
+}
+
+// Close closes the underlying file when the image was opened with Open.
+func (img *Image) Close() error {
+	if img.closer != nil {
+		return img.closer.Close()
+	}
+	return nil
+}
+
+// FilesystemPartition returns the partition holding the HFS+ volume. Images
+// without a partition map have a single "whole disk" partition, which is
+// returned as is; otherwise the largest partition is used as a fallback.
+func (img *Image) FilesystemPartition() (*Partition, error) {
+	var largest *Partition
+	for i := range img.Partitions {
+		p := &img.Partitions[i]
+		if strings.Contains(p.Name, "Apple_HFS") {
+			return p, nil
+		}
+		if largest == nil || p.Table.SectorCount > largest.Table.SectorCount {
+			largest = p
+		}
+	}
+	if largest == nil {
+		return nil, errors.New("no partition found")
+	}
+	return largest, nil
+}
+
+// PartitionReader returns a reader for the uncompressed contents of p.
+func (img *Image) PartitionReader(p *Partition) *PartitionReader {
+	return &PartitionReader{img: img, part: p, cached: -1}
+}
+
+// PartitionReader decompresses partition chunks on demand. It implements
+// io.ReaderAt, io.Reader and io.Seeker, keeping the most recently decoded
+// chunk cached. It is not safe for concurrent use.
+type PartitionReader struct {
+	img    *Image
+	part   *Partition
+	pos    int64
+	cached int
+	chunk  []byte
+}
+
+// Size returns the uncompressed size of the partition.
+func (pr *PartitionReader) Size() int64 {
+	return pr.part.Size()
+}
+
+func (pr *PartitionReader) Read(p []byte) (int, error) {
+	n, err := pr.ReadAt(p, pr.pos)
+	pr.pos += int64(n)
+	return n, err
+}
+
+func (pr *PartitionReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += pr.pos
+	case io.SeekEnd:
+		offset += pr.Size()
+	default:
+		return 0, errors.New("invalid whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("negative position")
+	}
+	pr.pos = offset
+	return offset, nil
+}
+
+func (pr *PartitionReader) ReadAt(p []byte, off int64) (int, error) {
+	size := pr.Size()
+	if off >= size {
+		return 0, io.EOF
+	}
+	total := 0
+	for len(p) > 0 && off < size {
+		idx := pr.findChunk(uint64(off / SectorSize))
+		if idx < 0 {
+			return total, fmt.Errorf("no chunk covers offset %d", off)
+		}
+		c := pr.part.Table.Chunks[idx]
+		inChunk := off - int64(c.SectorNumber)*SectorSize
+		var n int
+		if c.Type == ChunkZeroFill || c.Type == ChunkIgnore {
+			// Zero chunks may cover most of a partition; fill them in
+			// place rather than allocating them.
+			if err := checkChunk(pr.part, c); err != nil {
+				return total, err
+			}
+			n = int(min(int64(len(p)), int64(c.SectorCount)*SectorSize-inChunk))
+			clear(p[:n])
+		} else {
+			data, err := pr.chunkData(idx)
+			if err != nil {
+				return total, err
+			}
+			n = copy(p, data[inChunk:])
+		}
+		p = p[n:]
+		off += int64(n)
+		total += n
+	}
+	if len(p) > 0 {
+		return total, io.EOF
+	}
+	return total, nil
+}
+
+func (pr *PartitionReader) findChunk(sector uint64) int {
+	chunks := pr.part.Table.Chunks
+	i := sort.Search(len(chunks), func(i int) bool {
+		return chunks[i].SectorNumber+chunks[i].SectorCount > sector
+	})
+	for ; i < len(chunks); i++ {
+		c := chunks[i]
+		if c.Type == ChunkTerminator || c.Type == ChunkComment {
+			continue
+		}
+		if c.SectorNumber <= sector && sector < c.SectorNumber+c.SectorCount {
+			return i
+		}
+		break
+	}
+	return -1
+}
+
+func (pr *PartitionReader) chunkData(idx int) ([]byte, error) {
+	if idx == pr.cached {
+		return pr.chunk, nil
+	}
+	c := pr.part.Table.Chunks[idx]
+	data, err := pr.img.readChunk(pr.part, c)
+	if err != nil {
+		return nil, err
+	}
+	pr.cached, pr.chunk = idx, data
+	return data, nil
+}
+
+// readChunk returns the uncompressed contents of a single chunk.
+func (img *Image) readChunk(p *Partition, c Chunk) ([]byte, error) {
+	if err := checkChunk(p, c); err != nil {
+		return nil, err
+	}
+	size := int(c.SectorCount * SectorSize)
+	if c.Type == ChunkZeroFill || c.Type == ChunkIgnore {
+		return make([]byte, size), nil
+	}
+	offset := img.Koly.DataForkOffset + p.Table.DataOffset + c.CompressedOffset
+	if offset+c.CompressedLength > uint64(img.size) {
+		return nil, fmt.Errorf("chunk at sector %d points outside the image", c.SectorNumber)
+	}
+	src := make([]byte, c.CompressedLength)
+	if _, err := img.r.ReadAt(src, int64(offset)); err != nil {
+		return nil, fmt.Errorf("failed to read chunk: %w", err)
+	}
+	data, err := decompressChunk(c.Type, src, size)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress %s chunk at sector %d: %w", c.Type, c.SectorNumber, err)
+	}
+	if len(data) != size {
+		return nil, fmt.Errorf("%s chunk at sector %d decoded to %d bytes, expected %d", c.Type, c.SectorNumber, len(data), size)
+	}
+	return data, nil
+}
+
+// checkChunk rejects chunks that reach past the partition, and chunks with
+// data larger than the buffers the table asks for, before their size is
+// trusted for an allocation.
+func checkChunk(p *Partition, c Chunk) error {
+	if c.SectorNumber > p.Table.SectorCount || c.SectorCount > p.Table.SectorCount-c.SectorNumber {
+		return fmt.Errorf("chunk at sector %d reaches past the partition", c.SectorNumber)
+	}
+	isZero := c.Type == ChunkZeroFill || c.Type == ChunkIgnore
+	if !isZero && p.Table.BuffersNeeded != 0 && c.SectorCount > uint64(p.Table.BuffersNeeded) {
+		return fmt.Errorf("chunk at sector %d spans %d sectors, more than the %d buffers of its table", c.SectorNumber, c.SectorCount, p.Table.BuffersNeeded)
+	}
+	return nil
+}
+
+func decompressChunk(t ChunkType, src []byte, size int) ([]byte, error) {
+	var r io.Reader
+	switch t {
+	case ChunkRaw:
+		return src, nil
+	case ChunkADC:
+		return adc.Decode(src, size)
+	case ChunkZlib:
+		zr, err := zlib.NewReader(bytes.NewReader(src))
+		if err != nil {
+			return nil, err
+		}
+		defer zr.Close()
+		r = zr
+	case ChunkBzip2:
+		r = bzip2.NewReader(bytes.NewReader(src))
+	case ChunkLZFSE:
+		return lzfse.Decode(src, size)
+	case ChunkLZMA:
+		return xz.Decode(src, size)
+	default:
+		return nil, fmt.Errorf("unsupported chunk type: %s", t)
+	}
+	out := make([]byte, size)
+	n, err := io.ReadFull(r, out)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return nil, err
+	}
+	return out[:n], nil
+}
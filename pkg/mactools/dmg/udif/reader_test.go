@@ -0,0 +1,75 @@
+package udif
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/ironpark/zapp/pkg/mactools/hdiutil"
+)
+
+func TestReaderRoundTrip(t *testing.T) {
+	img := testImage()
+	for _, format := range []hdiutil.Format{hdiutil.UDRO, hdiutil.UDZO, hdiutil.UDBZ, hdiutil.ULFO, hdiutil.ULMO} {
+		t.Run(string(format), func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := Encode(&buf, bytes.NewReader(img), format); err != nil {
+				t.Fatal(err)
+			}
+			dmg, err := NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+			if err != nil {
+				t.Fatal(err)
+			}
+			part, err := dmg.FilesystemPartition()
+			if err != nil {
+				t.Fatal(err)
+			}
+			pr := dmg.PartitionReader(part)
+			got, err := io.ReadAll(pr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got[:len(img)], img) {
+				t.Fatal("partition data does not match source image")
+			}
+
+			// Reads spanning chunk boundaries.
+			off := int64(DefaultChunkSize*SectorSize - 100)
+			p := make([]byte, 300)
+			if _, err := pr.ReadAt(p, off); err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(p, img[off:off+300]) {
+				t.Fatal("ReadAt across chunk boundary returned wrong data")
+			}
+		})
+	}
+}
+
+// TestReaderChunkBounds checks that chunk sizes from the table are checked
+// before they are allocated.
+func TestReaderChunkBounds(t *testing.T) {
+	var buf bytes.Buffer
+	if err := Encode(&buf, bytes.NewReader(testImage()), hdiutil.UDZO); err != nil {
+		t.Fatal(err)
+	}
+	dmg, err := NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	part, err := dmg.FilesystemPartition()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := part.Table.Chunks[0]
+	for _, sectors := range []uint64{1 << 50, part.Table.SectorCount + 1, uint64(part.Table.BuffersNeeded) + 1} {
+		huge := c
+		huge.SectorCount = sectors
+		if _, err := dmg.readChunk(part, huge); err == nil {
+			t.Errorf("chunk of %d sectors accepted", sectors)
+		}
+	}
+	if _, err := dmg.readChunk(part, c); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,228 @@
+// Package udif reads and writes Apple Universal Disk Image Format (UDIF)
+// images, the container behind .dmg files, without relying on hdiutil.
+//
+// A flattened UDIF image is the (possibly compressed) data fork, followed by
+// an XML property list describing the blkx chunk tables, followed by the
+// 512-byte koly trailer.
+package udif
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// SectorSize is the sector size all UDIF offsets and counts are expressed in.
+const SectorSize = 512
+
+// ChunkType identifies how a blkx chunk is stored in the data fork.
+type ChunkType uint32
+
+const (
+	ChunkZeroFill   ChunkType = 0x00000000
+	ChunkRaw        ChunkType = 0x00000001
+	ChunkIgnore     ChunkType = 0x00000002
+	ChunkADC        ChunkType = 0x80000004
+	ChunkZlib       ChunkType = 0x80000005
+	ChunkBzip2      ChunkType = 0x80000006
+	ChunkLZFSE      ChunkType = 0x80000007
+	ChunkLZMA       ChunkType = 0x80000008
+	ChunkComment    ChunkType = 0x7ffffffe
+	ChunkTerminator ChunkType = 0xffffffff
+)
+
+func (t ChunkType) String() string {
+	switch t {
+	case ChunkZeroFill:
+		return "zero-fill"
+	case ChunkRaw:
+		return "raw"
+	case ChunkIgnore:
+		return "ignore"
+	case ChunkADC:
+		return "adc"
+	case ChunkZlib:
+		return "zlib"
+	case ChunkBzip2:
+		return "bzip2"
+	case ChunkLZFSE:
+		return "lzfse"
+	case ChunkLZMA:
+		return "lzma"
+	case ChunkComment:
+		return "comment"
+	case ChunkTerminator:
+		return "terminator"
+	}
+	return fmt.Sprintf("unknown(0x%08x)", uint32(t))
+}
+
+// ChecksumCRC32 is the only checksum type written by this package.
+const ChecksumCRC32 = 2
+
+// Checksum is the UDIF checksum record shared by the koly trailer and blkx tables.
+type Checksum struct {
+	Type uint32
+	Size uint32 // in bits
+	Data [32]uint32
+}
+
+// NewCRC32Checksum wraps a CRC32 value in a Checksum record.
+func NewCRC32Checksum(crc uint32) Checksum {
+	c := Checksum{Type: ChecksumCRC32, Size: 32}
+	c.Data[0] = crc
+	return c
+}
+
+// Koly is the trailer found in the last 512 bytes of every UDIF image.
+type Koly struct {
+	Signature             [4]byte
+	Version               uint32
+	HeaderSize            uint32
+	Flags                 uint32
+	RunningDataForkOffset uint64
+	DataForkOffset        uint64
+	DataForkLength        uint64
+	RsrcForkOffset        uint64
+	RsrcForkLength        uint64
+	SegmentNumber         uint32
+	SegmentCount          uint32
+	SegmentID             [16]byte
+	DataChecksum          Checksum
+	XMLOffset             uint64
+	XMLLength             uint64
+	Reserved1             [120]byte
+	MasterChecksum        Checksum
+	ImageVariant          uint32
+	SectorCount           uint64
+	Reserved2             [12]byte
+}
+
+// KolySize is the encoded size of the koly trailer.
+const KolySize = 512
+
+var (
+	kolySignature = [4]byte{'k', 'o', 'l', 'y'}
+	mishSignature = [4]byte{'m', 'i', 's', 'h'}
+)
+
+// MarshalBinary encodes the trailer in its on-disk big-endian layout.
+func (k *Koly) MarshalBinary() ([]byte, error) {
+	buf := bytes.NewBuffer(make([]byte, 0, KolySize))
+	if err := binary.Write(buf, binary.BigEndian, k); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// UnmarshalBinary decodes a trailer and checks its signature.
+func (k *Koly) UnmarshalBinary(data []byte) error {
+	if len(data) < KolySize {
+		return errors.New("koly trailer too short")
+	}
+	if err := binary.Read(bytes.NewReader(data[:KolySize]), binary.BigEndian, k); err != nil {
+		return err
+	}
+	if k.Signature != kolySignature {
+		return errors.New("koly signature not found")
+	}
+	return nil
+}
+
+// Chunk describes a run of sectors and where its data lives in the data fork.
+// SectorNumber is relative to the start of the owning blkx table.
+type Chunk struct {
+	Type             ChunkType
+	Comment          uint32
+	SectorNumber     uint64
+	SectorCount      uint64
+	CompressedOffset uint64
+	CompressedLength uint64
+}
+
+type blkxHeader struct {
+	Signature        [4]byte
+	Version          uint32
+	SectorNumber     uint64
+	SectorCount      uint64
+	DataOffset       uint64
+	BuffersNeeded    uint32
+	BlockDescriptors uint32
+	Reserved         [24]byte
+	Checksum         Checksum
+	NumberOfChunks   uint32
+}
+
+// BlkxTable (the "mish" block) maps the sectors of one partition onto chunks
+// of the data fork.
+type BlkxTable struct {
+	SectorNumber     uint64
+	SectorCount      uint64
+	DataOffset       uint64
+	BuffersNeeded    uint32
+	BlockDescriptors uint32
+	Checksum         Checksum
+	Chunks           []Chunk
+}
+
+// MarshalBinary encodes the table as stored in the blkx resource Data field.
+func (b *BlkxTable) MarshalBinary() ([]byte, error) {
+	hdr := blkxHeader{
+		Signature:        mishSignature,
+		Version:          1,
+		SectorNumber:     b.SectorNumber,
+		SectorCount:      b.SectorCount,
+		DataOffset:       b.DataOffset,
+		BuffersNeeded:    b.BuffersNeeded,
+		BlockDescriptors: b.BlockDescriptors,
+		Checksum:         b.Checksum,
+		NumberOfChunks:   uint32(len(b.Chunks)),
+	}
+	buf := &bytes.Buffer{}
+	if err := binary.Write(buf, binary.BigEndian, &hdr); err != nil {
+		return nil, err
+	}
+	if err := binary.Write(buf, binary.BigEndian, b.Chunks); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// UnmarshalBinary decodes a mish block.
+func (b *BlkxTable) UnmarshalBinary(data []byte) error {
+	r := bytes.NewReader(data)
+	var hdr blkxHeader
+	if err := binary.Read(r, binary.BigEndian, &hdr); err != nil {
+		return fmt.Errorf("failed to read blkx header: %w", err)
+	}
+	if hdr.Signature != mishSignature {
+		return errors.New("mish signature not found")
+	}
+	if int64(hdr.NumberOfChunks)*int64(binary.Size(Chunk{})) > int64(r.Len()) {
+		return errors.New("blkx chunk count exceeds table size")
+	}
+	b.SectorNumber = hdr.SectorNumber
+	b.SectorCount = hdr.SectorCount
+	b.DataOffset = hdr.DataOffset
+	b.BuffersNeeded = hdr.BuffersNeeded
+	b.BlockDescriptors = hdr.BlockDescriptors
+	b.Checksum = hdr.Checksum
+	b.Chunks = make([]Chunk, hdr.NumberOfChunks)
+	return binary.Read(r, binary.BigEndian, b.Chunks)
+}
+
+// Resource is one entry of the resource-fork dictionary in the XML plist.
+type Resource struct {
+	Attributes string `plist:"Attributes"`
+	CFName     string `plist:"CFName,omitempty"`
+	Data       []byte `plist:"Data"`
+	ID         string `plist:"ID"`
+	Name       string `plist:"Name"`
+}
+
+// ResourceFork is the XML property list stored between the data fork and the
+// koly trailer, keyed by resource type ("blkx", "plst", ...).
+type ResourceFork struct {
+	Resources map[string][]Resource `plist:"resource-fork"`
+}
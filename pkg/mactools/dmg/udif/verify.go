@@ -0,0 +1,97 @@
+package udif
+
+import (
+	"fmt"
+	"hash/crc32"
+	"io"
+)
+
+// Check is the outcome of verifying one checksum of an image.
+type Check struct {
+	Name     string
+	Type     uint32
+	Stored   uint32
+	Computed uint32
+	// Skipped is set for checksums of a type other than CRC32, which are
+	// not computed.
+	Skipped bool
+}
+
+// OK reports whether the checksum matches the data it covers.
+func (c Check) OK() bool {
+	return c.Skipped || c.Stored == c.Computed
+}
+
+// Verify reads the whole image and recomputes the checksum of the data
+// fork, of the uncompressed contents of every partition and the master
+// checksum over the partition checksums. Mismatches are reported in the
+// returned checks; the error is only set when the image cannot be read.
+func (img *Image) Verify() ([]Check, error) {
+	k := &img.Koly
+	var checks []Check
+
+	if k.DataChecksum.Type != 0 {
+		check := Check{Name: "data fork", Type: k.DataChecksum.Type, Stored: k.DataChecksum.Data[0]}
+		if check.Skipped = k.DataChecksum.Type != ChecksumCRC32; !check.Skipped {
+			if k.DataForkOffset+k.DataForkLength > uint64(img.size) {
+				return nil, fmt.Errorf("data fork is out of range")
+			}
+			crc := crc32.NewIEEE()
+			if _, err := io.Copy(crc, io.NewSectionReader(img.r, int64(k.DataForkOffset), int64(k.DataForkLength))); err != nil {
+				return nil, fmt.Errorf("failed to read data fork: %w", err)
+			}
+			check.Computed = crc.Sum32()
+		}
+		checks = append(checks, check)
+	}
+
+	tables := make([]BlkxTable, len(img.Partitions))
+	computed := true
+	zeros := make([]byte, 64*1024)
+	for i := range img.Partitions {
+		p := &img.Partitions[i]
+		tables[i] = p.Table
+		sum := p.Table.Checksum
+		if sum.Type == 0 {
+			continue
+		}
+		check := Check{Name: fmt.Sprintf("partition %d %q", p.ID, p.Name), Type: sum.Type, Stored: sum.Data[0]}
+		if check.Skipped = sum.Type != ChecksumCRC32; !check.Skipped {
+			crc := crc32.NewIEEE()
+			for _, c := range p.Table.Chunks {
+				if c.Type == ChunkComment || c.Type == ChunkTerminator {
+					continue
+				}
+				if c.Type == ChunkZeroFill || c.Type == ChunkIgnore {
+					if err := checkChunk(p, c); err != nil {
+						return nil, fmt.Errorf("partition %q: %w", p.Name, err)
+					}
+					for n := int64(c.SectorCount) * SectorSize; n > 0; n -= int64(len(zeros)) {
+						crc.Write(zeros[:min(n, int64(len(zeros)))])
+					}
+					continue
+				}
+				data, err := img.readChunk(p, c)
+				if err != nil {
+					return nil, fmt.Errorf("partition %q: %w", p.Name, err)
+				}
+				crc.Write(data)
+			}
+			check.Computed = crc.Sum32()
+		} else {
+			computed = false
+		}
+		checks = append(checks, check)
+	}
+
+	if k.MasterChecksum.Type != 0 {
+		check := Check{Name: "master", Type: k.MasterChecksum.Type, Stored: k.MasterChecksum.Data[0]}
+		// The master checksum covers the partition checksums, so it can
+		// only be computed when those are CRC32 too.
+		if check.Skipped = k.MasterChecksum.Type != ChecksumCRC32 || !computed; !check.Skipped {
+			check.Computed = masterChecksum(tables).Data[0]
+		}
+		checks = append(checks, check)
+	}
+	return checks, nil
+}
@@ -0,0 +1,58 @@
+package udif
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/ironpark/zapp/pkg/mactools/hdiutil"
+)
+
+func TestVerify(t *testing.T) {
+	for _, format := range []hdiutil.Format{hdiutil.UDRO, hdiutil.UDZO, hdiutil.UDBZ, hdiutil.ULFO, hdiutil.ULMO} {
+		var buf bytes.Buffer
+		if err := Encode(&buf, bytes.NewReader(testImage()), format); err != nil {
+			t.Fatal(err)
+		}
+		img, err := NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := img.Format(); got != format {
+			t.Errorf("Format() = %s, want %s", got, format)
+		}
+		checks, err := img.Verify()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(checks) != 3 {
+			t.Fatalf("%s: %d checks, want 3", format, len(checks))
+		}
+		for _, c := range checks {
+			if !c.OK() || c.Skipped {
+				t.Errorf("%s: %s checksum %08x, computed %08x", format, c.Name, c.Stored, c.Computed)
+			}
+		}
+	}
+
+	// Flip a byte of an uncompressed chunk: the data fork and partition
+	// checksums no longer match, the master checksum still does.
+	var buf bytes.Buffer
+	if err := Encode(&buf, bytes.NewReader(testImage()), hdiutil.UDRO); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+	data[10] ^= 0xff
+	img, err := NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	checks, err := img.Verify()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, want := range []bool{false, false, true} {
+		if checks[i].OK() != want {
+			t.Errorf("%s checksum OK = %v, want %v", checks[i].Name, checks[i].OK(), want)
+		}
+	}
+}
@@ -0,0 +1,399 @@
+package udif
+
+import (
+	"bytes"
+	"compress/zlib"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"os"
+	"runtime"
+
+	"github.com/ironpark/zapp/pkg/compress/bzip2"
+	"github.com/ironpark/zapp/pkg/compress/lzfse"
+	"github.com/ironpark/zapp/pkg/compress/xz"
+	"github.com/ironpark/zapp/pkg/mactools/hdiutil"
+	"howett.net/plist"
+)
+
+const (
+	// DefaultChunkSize is the number of sectors per chunk (1 MiB).
+	DefaultChunkSize = 2048
+	// DefaultCompressionLevel is used when no level is given.
+	DefaultCompressionLevel = 9
+
+	wholeDiskName = "whole disk (Apple_HFS : 0)"
+)
+
+// Options holds the configuration for the Encode function.
+type Options struct {
+	CompressionLevel int
+	ChunkSize        int // in sectors
+	// Concurrency is the number of chunks compressed at the same time.
+	Concurrency int
+	// SegmentID identifies the image; a random one is used when it is zero.
+	SegmentID [16]byte
+}
+
+// Option is a function that modifies Options.
+type Option func(*Options)
+
+// WithCompressionLevel sets the compression level (1-9) for zlib, bzip2 and
+// LZMA chunks. LZFSE has no levels.
+func WithCompressionLevel(level int) Option {
+	return func(o *Options) {
+		o.CompressionLevel = level
+	}
+}
+
+// WithChunkSize sets the number of sectors stored per chunk.
+func WithChunkSize(sectors int) Option {
+	return func(o *Options) {
+		o.ChunkSize = sectors
+	}
+}
+
+// WithConcurrency sets how many chunks are compressed in parallel. The
+// image is the same for every setting.
+func WithConcurrency(n int) Option {
+	return func(o *Options) {
+		o.Concurrency = n
+	}
+}
+
+// WithSegmentID sets the segment ID of the image instead of a random one,
+// for builds that must give the same bytes every time.
+func WithSegmentID(id [16]byte) Option {
+	return func(o *Options) {
+		o.SegmentID = id
+	}
+}
+
+// ChunkTypeFor returns the chunk encoding used for an image format.
+func ChunkTypeFor(format hdiutil.Format) (ChunkType, error) {
+	switch format {
+	case hdiutil.UDRO:
+		return ChunkRaw, nil
+	case hdiutil.UDZO:
+		return ChunkZlib, nil
+	case hdiutil.UDBZ:
+		return ChunkBzip2, nil
+	case hdiutil.ULFO:
+		return ChunkLZFSE, nil
+	case hdiutil.ULMO:
+		return ChunkLZMA, nil
+	}
+	return 0, fmt.Errorf("unsupported format: %s", format)
+}
+
+// FormatFor returns the image format whose chunks are compressed as t, the
+// inverse of ChunkTypeFor. It is empty for chunk types no format uses.
+func FormatFor(t ChunkType) hdiutil.Format {
+	switch t {
+	case ChunkRaw:
+		return hdiutil.UDRO
+	case ChunkADC:
+		return hdiutil.UDCO
+	case ChunkZlib:
+		return hdiutil.UDZO
+	case ChunkBzip2:
+		return hdiutil.UDBZ
+	case ChunkLZFSE:
+		return hdiutil.ULFO
+	case ChunkLZMA:
+		return hdiutil.ULMO
+	}
+	return ""
+}
+
+// Create writes the raw filesystem image read from src to outputFile as a
+// UDIF image in the given format.
+func Create(outputFile string, src io.Reader, format hdiutil.Format, opts ...Option) error {
+	f, err := os.Create(outputFile)
+	if err != nil {
+		return fmt.Errorf("failed to create image file: %w", err)
+	}
+	if err := Encode(f, src, format, opts...); err != nil {
+		f.Close()
+		os.Remove(outputFile)
+		return err
+	}
+	return f.Close()
+}
+
+// Encode reads a raw filesystem image from src and writes it to w as a
+// single-partition flattened UDIF image. The last partial sector of src, if
+// any, is zero padded.
+func Encode(w io.Writer, src io.Reader, format hdiutil.Format, opts ...Option) error {
+	options := &Options{
+		CompressionLevel: DefaultCompressionLevel,
+		ChunkSize:        DefaultChunkSize,
+		Concurrency:      runtime.GOMAXPROCS(0),
+	}
+	for _, opt := range opts {
+		opt(options)
+	}
+	if options.CompressionLevel < 1 || options.CompressionLevel > 9 {
+		return fmt.Errorf("invalid compression level: %d", options.CompressionLevel)
+	}
+	if options.ChunkSize < 1 {
+		return fmt.Errorf("invalid chunk size: %d", options.ChunkSize)
+	}
+	if options.Concurrency < 1 {
+		return fmt.Errorf("invalid concurrency: %d", options.Concurrency)
+	}
+	chunkType, err := ChunkTypeFor(format)
+	if err != nil {
+		return err
+	}
+
+	out := &countingWriter{w: w, crc: crc32.NewIEEE()}
+	table := BlkxTable{
+		BuffersNeeded:    uint32(options.ChunkSize + 8),
+		BlockDescriptors: 0xffffffff,
+	}
+	partCRC := crc32.NewIEEE()
+	done := make(chan struct{})
+	defer close(done)
+	var sector uint64
+	for result := range compressChunks(src, chunkType, options, done) {
+		r := <-result
+		if r.err != nil {
+			return r.err
+		}
+		partCRC.Write(r.data)
+
+		chunk := Chunk{
+			Type:             r.typ,
+			SectorNumber:     sector,
+			SectorCount:      uint64(len(r.data) / SectorSize),
+			CompressedOffset: out.n,
+		}
+		if r.typ != ChunkZeroFill {
+			if _, err := out.Write(r.encoded); err != nil {
+				return fmt.Errorf("failed to write chunk: %w", err)
+			}
+			chunk.CompressedLength = uint64(len(r.encoded))
+		}
+		table.Chunks = append(table.Chunks, chunk)
+		sector += chunk.SectorCount
+	}
+	table.Chunks = append(table.Chunks, Chunk{
+		Type:             ChunkTerminator,
+		SectorNumber:     sector,
+		CompressedOffset: out.n,
+	})
+	table.SectorCount = sector
+	table.Checksum = NewCRC32Checksum(partCRC.Sum32())
+	dataForkLength := out.n
+	dataCRC := out.crc.Sum32()
+
+	mish, err := table.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	fork := ResourceFork{Resources: map[string][]Resource{
+		"blkx": {{
+			Attributes: "0x0050",
+			CFName:     wholeDiskName,
+			Data:       mish,
+			ID:         "-1",
+			Name:       wholeDiskName,
+		}},
+		"plst": {{
+			Attributes: "0x0050",
+			Data:       make([]byte, 0x600),
+			ID:         "0",
+			Name:       "",
+		}},
+	}}
+	xmlOffset := out.n
+	enc := plist.NewEncoderForFormat(out, plist.XMLFormat)
+	enc.Indent("\t")
+	if err := enc.Encode(fork); err != nil {
+		return fmt.Errorf("failed to write resource plist: %w", err)
+	}
+
+	koly := Koly{
+		Signature:      kolySignature,
+		Version:        4,
+		HeaderSize:     KolySize,
+		Flags:          1, // flattened
+		DataForkLength: dataForkLength,
+		SegmentNumber:  1,
+		SegmentCount:   1,
+		DataChecksum:   NewCRC32Checksum(dataCRC),
+		XMLOffset:      xmlOffset,
+		XMLLength:      out.n - xmlOffset,
+		MasterChecksum: masterChecksum([]BlkxTable{table}),
+		ImageVariant:   1,
+		SectorCount:    sector,
+		SegmentID:      options.SegmentID,
+	}
+	if koly.SegmentID == [16]byte{} {
+		if _, err := rand.Read(koly.SegmentID[:]); err != nil {
+			return err
+		}
+	}
+	trailer, err := koly.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	if _, err := out.Write(trailer); err != nil {
+		return fmt.Errorf("failed to write koly trailer: %w", err)
+	}
+	return nil
+}
+
+// masterChecksum is the CRC32 of the big-endian checksums of every blkx table.
+func masterChecksum(tables []BlkxTable) Checksum {
+	crc := crc32.NewIEEE()
+	for _, t := range tables {
+		crc.Write(binary.BigEndian.AppendUint32(nil, t.Checksum.Data[0]))
+	}
+	return NewCRC32Checksum(crc.Sum32())
+}
+
+// chunkResult is a chunk of the source image, padded to whole sectors, and
+// its encoding.
+type chunkResult struct {
+	data    []byte
+	encoded []byte
+	typ     ChunkType
+	err     error
+}
+
+// compressChunks reads src one chunk at a time and compresses the chunks on
+// o.Concurrency goroutines. The returned channel yields a result channel per
+// chunk in source order, so the image does not depend on which worker
+// finishes first, and holds at most o.Concurrency chunks that have not been
+// written yet. Closing done stops reading.
+func compressChunks(src io.Reader, t ChunkType, o *Options, done <-chan struct{}) <-chan chan chunkResult {
+	type job struct {
+		data   []byte
+		result chan chunkResult
+	}
+	order := make(chan chan chunkResult, o.Concurrency)
+	jobs := make(chan job, o.Concurrency)
+	go func() {
+		defer close(order)
+		defer close(jobs)
+		size := o.ChunkSize * SectorSize
+		for {
+			buf := make([]byte, size)
+			n, err := io.ReadFull(src, buf)
+			if err == io.EOF {
+				return
+			}
+			result := make(chan chunkResult, 1)
+			if err != nil && err != io.ErrUnexpectedEOF {
+				result <- chunkResult{err: fmt.Errorf("failed to read image: %w", err)}
+				select {
+				case order <- result:
+				case <-done:
+				}
+				return
+			}
+			if rem := n % SectorSize; rem != 0 {
+				n += SectorSize - rem
+			}
+			select {
+			case order <- result:
+			case <-done:
+				return
+			}
+			select {
+			case jobs <- job{data: buf[:n], result: result}:
+			case <-done:
+				return
+			}
+			if n < size {
+				return
+			}
+		}
+	}()
+	for i := 0; i < o.Concurrency; i++ {
+		go func() {
+			for j := range jobs {
+				r := chunkResult{data: j.data, typ: ChunkZeroFill}
+				if !isZero(j.data) {
+					r.encoded, r.typ, r.err = compressChunk(j.data, t, o.CompressionLevel)
+				}
+				j.result <- r
+			}
+		}()
+	}
+	return order
+}
+
+// compressChunk encodes data with the requested chunk type, falling back to
+// a raw chunk when compression does not save space.
+func compressChunk(data []byte, t ChunkType, level int) ([]byte, ChunkType, error) {
+	buf := &bytes.Buffer{}
+	switch t {
+	case ChunkRaw:
+		return data, ChunkRaw, nil
+	case ChunkZlib:
+		zw, err := zlib.NewWriterLevel(buf, level)
+		if err != nil {
+			return nil, 0, err
+		}
+		if _, err := zw.Write(data); err != nil {
+			return nil, 0, err
+		}
+		if err := zw.Close(); err != nil {
+			return nil, 0, err
+		}
+	case ChunkBzip2:
+		bw, err := bzip2.NewWriterLevel(buf, level)
+		if err != nil {
+			return nil, 0, err
+		}
+		if _, err := bw.Write(data); err != nil {
+			return nil, 0, err
+		}
+		if err := bw.Close(); err != nil {
+			return nil, 0, err
+		}
+	case ChunkLZFSE:
+		buf.Write(lzfse.Encode(data))
+	case ChunkLZMA:
+		enc, err := xz.Encode(data, level)
+		if err != nil {
+			return nil, 0, err
+		}
+		buf.Write(enc)
+	default:
+		return nil, 0, fmt.Errorf("unsupported chunk type: %s", t)
+	}
+	if buf.Len() >= len(data) {
+		return data, ChunkRaw, nil
+	}
+	return buf.Bytes(), t, nil
+}
+
+func isZero(data []byte) bool {
+	for _, b := range data {
+		if b != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// countingWriter tracks the data fork offset and checksum while writing.
+type countingWriter struct {
+	w   io.Writer
+	n   uint64
+	crc hash.Hash32
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += uint64(n)
+	c.crc.Write(p[:n])
+	return n, err
+}
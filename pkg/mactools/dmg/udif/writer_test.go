@@ -0,0 +1,178 @@
+package udif
+
+import (
+	"bytes"
+	"compress/bzip2"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"math/rand"
+	"runtime"
+	"testing"
+	"testing/iotest"
+
+	"github.com/ironpark/zapp/pkg/compress/lzfse"
+	"github.com/ironpark/zapp/pkg/compress/xz"
+	"github.com/ironpark/zapp/pkg/mactools/hdiutil"
+	"howett.net/plist"
+)
+
+// testImage returns a fake filesystem image mixing compressible data, noise
+// and zeroed regions, with a size that is not a multiple of the sector size.
+func testImage() []byte {
+	rnd := rand.New(rand.NewSource(1))
+	img := make([]byte, 3*DefaultChunkSize*SectorSize+1234)
+	for i := 0; i < 200000; i++ {
+		img[i] = "zapp disk image "[i%16]
+	}
+	rnd.Read(img[len(img)/2 : len(img)/2+100000])
+	return img
+}
+
+// decodeImage is a minimal independent UDIF decoder used to verify Encode.
+func decodeImage(t *testing.T, dmg []byte) []byte {
+	t.Helper()
+	var koly Koly
+	if err := koly.UnmarshalBinary(dmg[len(dmg)-KolySize:]); err != nil {
+		t.Fatal(err)
+	}
+	if got := crc32.ChecksumIEEE(dmg[:koly.DataForkLength]); got != koly.DataChecksum.Data[0] {
+		t.Fatalf("data fork checksum mismatch: %08x != %08x", got, koly.DataChecksum.Data[0])
+	}
+	var fork ResourceFork
+	if _, err := plist.Unmarshal(dmg[koly.XMLOffset:koly.XMLOffset+koly.XMLLength], &fork); err != nil {
+		t.Fatal(err)
+	}
+	blkx := fork.Resources["blkx"]
+	if len(blkx) != 1 {
+		t.Fatalf("expected 1 blkx resource, got %d", len(blkx))
+	}
+	var table BlkxTable
+	if err := table.UnmarshalBinary(blkx[0].Data); err != nil {
+		t.Fatal(err)
+	}
+	if table.SectorCount != koly.SectorCount {
+		t.Fatalf("sector count mismatch: %d != %d", table.SectorCount, koly.SectorCount)
+	}
+	if got := masterChecksum([]BlkxTable{table}); got != koly.MasterChecksum {
+		t.Fatal("master checksum mismatch")
+	}
+
+	out := make([]byte, 0, table.SectorCount*SectorSize)
+	for _, c := range table.Chunks {
+		data := dmg[c.CompressedOffset : c.CompressedOffset+c.CompressedLength]
+		var r io.Reader
+		switch c.Type {
+		case ChunkTerminator:
+			continue
+		case ChunkZeroFill:
+			out = append(out, make([]byte, c.SectorCount*SectorSize)...)
+			continue
+		case ChunkRaw:
+			r = bytes.NewReader(data)
+		case ChunkZlib:
+			zr, err := zlib.NewReader(bytes.NewReader(data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			r = zr
+		case ChunkBzip2:
+			r = bzip2.NewReader(bytes.NewReader(data))
+		case ChunkLZFSE, ChunkLZMA:
+			decode := lzfse.Decode
+			if c.Type == ChunkLZMA {
+				decode = xz.Decode
+			}
+			chunk, err := decode(data, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			r = bytes.NewReader(chunk)
+		default:
+			t.Fatalf("unexpected chunk type %s", c.Type)
+		}
+		chunk, err := io.ReadAll(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if uint64(len(chunk)) != c.SectorCount*SectorSize {
+			t.Fatalf("chunk at sector %d decoded to %d bytes", c.SectorNumber, len(chunk))
+		}
+		out = append(out, chunk...)
+	}
+	if got := crc32.ChecksumIEEE(out); got != table.Checksum.Data[0] {
+		t.Fatal("partition checksum mismatch")
+	}
+	return out
+}
+
+func TestEncodeRoundTrip(t *testing.T) {
+	img := testImage()
+	for _, format := range []hdiutil.Format{hdiutil.UDRO, hdiutil.UDZO, hdiutil.UDBZ, hdiutil.ULFO, hdiutil.ULMO} {
+		t.Run(string(format), func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := Encode(&buf, bytes.NewReader(img), format); err != nil {
+				t.Fatal(err)
+			}
+			out := decodeImage(t, buf.Bytes())
+			if len(out) != (len(img)+SectorSize-1)/SectorSize*SectorSize {
+				t.Fatalf("unexpected decoded size %d", len(out))
+			}
+			if !bytes.Equal(out[:len(img)], img) {
+				t.Fatal("decoded image differs from source")
+			}
+			if format != hdiutil.UDRO && buf.Len() >= len(img) {
+				t.Fatalf("%s image is not compressed: %d bytes", format, buf.Len())
+			}
+		})
+	}
+}
+
+func TestEncodeUnsupportedFormat(t *testing.T) {
+	if err := Encode(io.Discard, bytes.NewReader(nil), hdiutil.UDSP); err == nil {
+		t.Fatal("expected error for unsupported format")
+	}
+}
+
+func TestEncodeConcurrency(t *testing.T) {
+	img := testImage()
+	id := [16]byte{1}
+	var want []byte
+	for _, n := range []int{1, 2, 8} {
+		var buf bytes.Buffer
+		if err := Encode(&buf, bytes.NewReader(img), hdiutil.UDZO, WithChunkSize(64), WithConcurrency(n), WithSegmentID(id)); err != nil {
+			t.Fatal(err)
+		}
+		if want == nil {
+			want = buf.Bytes()
+			decodeImage(t, want)
+		} else if !bytes.Equal(buf.Bytes(), want) {
+			t.Fatalf("image written with concurrency %d differs", n)
+		}
+	}
+
+	src := io.MultiReader(bytes.NewReader(img), iotest.ErrReader(errors.New("read failed")))
+	if err := Encode(io.Discard, src, hdiutil.UDZO, WithChunkSize(64), WithConcurrency(4)); err == nil {
+		t.Fatal("expected read error")
+	}
+}
+
+func BenchmarkEncode(b *testing.B) {
+	img := testImage()
+	for _, format := range []hdiutil.Format{hdiutil.UDZO, hdiutil.UDBZ, hdiutil.ULMO} {
+		for _, level := range []int{1, 6, 9} {
+			for _, n := range []int{1, max(2, runtime.GOMAXPROCS(0))} {
+				b.Run(fmt.Sprintf("%s/level=%d/concurrency=%d", format, level, n), func(b *testing.B) {
+					b.SetBytes(int64(len(img)))
+					for i := 0; i < b.N; i++ {
+						if err := Encode(io.Discard, bytes.NewReader(img), format, WithCompressionLevel(level), WithConcurrency(n)); err != nil {
+							b.Fatal(err)
+						}
+					}
+				})
+			}
+		}
+	}
+}
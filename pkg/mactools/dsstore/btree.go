@@ -0,0 +1,120 @@
+package dsstore
+
+import "encoding/binary"
+
+// node is a node of the DSDB B-tree. Unlike a B+ tree, records live in
+// internal nodes too: record i sorts between the subtrees children[i] and
+// children[i+1]. Leaves have no children.
+type node struct {
+	records  [][]byte
+	children []uint32
+}
+
+func (n *node) isLeaf() bool {
+	return n.children == nil
+}
+
+func (n *node) size() int {
+	size := 8
+	for _, rec := range n.records {
+		size += len(rec)
+		if !n.isLeaf() {
+			size += 4
+		}
+	}
+	return size
+}
+
+// bytes encodes the node: the rightmost child (zero for leaves), the record
+// count and the records, each preceded by its left child in internal nodes.
+func (n *node) bytes() []byte {
+	b := make([]byte, 8, n.size())
+	if !n.isLeaf() {
+		binary.BigEndian.PutUint32(b, n.children[len(n.children)-1])
+	}
+	binary.BigEndian.PutUint32(b[4:], uint32(len(n.records)))
+	for i, rec := range n.records {
+		if !n.isLeaf() {
+			b = binary.BigEndian.AppendUint32(b, n.children[i])
+		}
+		b = append(b, rec...)
+	}
+	return b
+}
+
+// buildTree packs sorted records into a balanced B-tree whose nodes get
+// consecutive block numbers starting at first. It returns the nodes in block
+// order, the root block and the number of internal levels.
+func buildTree(records [][]byte, first uint32) (nodes []*node, root uint32, levels uint32) {
+	var children []uint32
+	for {
+		level, separators := packLevel(records, children)
+		children = make([]uint32, len(level))
+		for i, n := range level {
+			children[i] = first + uint32(len(nodes))
+			nodes = append(nodes, n)
+		}
+		if len(level) == 1 {
+			return nodes, children[0], levels
+		}
+		records = separators
+		levels++
+	}
+}
+
+// packLevel fills nodes with records up to the page size. The record
+// following each full node moves up to the parent level as a separator.
+// children holds the subtrees around the records of an internal level and
+// is nil for the leaves.
+func packLevel(records [][]byte, children []uint32) (nodes []*node, separators [][]byte) {
+	recordSize := func(i int) int {
+		if children != nil {
+			return len(records[i]) + 4
+		}
+		return len(records[i])
+	}
+	add := func(n *node, i int) {
+		n.records = append(n.records, records[i])
+		if children != nil {
+			n.children = append(n.children, children[i+1])
+		}
+	}
+	if len(records) == 0 {
+		n := &node{}
+		if children != nil {
+			n.children = children[:1]
+		}
+		return []*node{n}, nil
+	}
+	for i := 0; i < len(records); {
+		n := &node{}
+		if children != nil {
+			n.children = []uint32{children[i]}
+		}
+		add(n, i)
+		i++
+		for i < len(records) && n.size()+recordSize(i) <= pageSize {
+			add(n, i)
+			i++
+		}
+		switch {
+		case i == len(records):
+		case i < len(records)-1:
+			separators = append(separators, records[i])
+			i++
+		case len(n.records) > 1:
+			// A lone last record cannot separate two nodes, so promote the
+			// last record of this node and start the next node with it.
+			separators = append(separators, n.records[len(n.records)-1])
+			n.records = n.records[:len(n.records)-1]
+			if children != nil {
+				n.children = n.children[:len(n.children)-1]
+			}
+		default:
+			add(n, i)
+			i++
+		}
+		nodes = append(nodes, n)
+	}
+	return nodes, separators
+}
@@ -0,0 +1,127 @@
+package dsstore
+
+import (
+	"encoding/binary"
+	"errors"
+	"math/bits"
+	"sort"
+)
+
+const (
+	// headerSize is the size of the allocator header following the 4-byte
+	// file magic. All allocator offsets are relative to the header.
+	headerSize = 32
+	// pageSize is the node size recorded in the DSDB block.
+	pageSize = 0x1000
+
+	budMagic = 0x42756431 // "Bud1"
+)
+
+// allocator is the buddy allocator that manages the space of a .DS_Store
+// file. The address space of 2^31 bytes is split into power of two blocks
+// aligned to their size; every block is addressed by its offset with the
+// log2 of its size in the low five bits.
+type allocator struct {
+	free   [32][]uint32
+	blocks []uint32
+}
+
+func newAllocator() *allocator {
+	a := &allocator{}
+	a.free[31] = []uint32{0}
+	// The header always takes the first 32 bytes.
+	a.alloc(5)
+	return a
+}
+
+// alloc reserves a block of 2^k bytes and returns its offset.
+func (a *allocator) alloc(k int) (uint32, error) {
+	j := k
+	for j < len(a.free) && len(a.free[j]) == 0 {
+		j++
+	}
+	if j == len(a.free) {
+		return 0, errors.New("no free space left in allocator")
+	}
+	offset := a.free[j][0]
+	a.free[j] = a.free[j][1:]
+	// Split the block, returning the upper buddies to the free lists.
+	for j > k {
+		j--
+		a.free[j] = insertOffset(a.free[j], offset+1<<j)
+	}
+	return offset, nil
+}
+
+// allocBlock reserves a block of at least size bytes as block number n.
+func (a *allocator) allocBlock(n int, size int) error {
+	k := max(5, bits.Len(uint(size-1)))
+	offset, err := a.alloc(k)
+	if err != nil {
+		return err
+	}
+	for len(a.blocks) <= n {
+		a.blocks = append(a.blocks, 0)
+	}
+	a.blocks[n] = offset | uint32(k)
+	return nil
+}
+
+func insertOffset(list []uint32, offset uint32) []uint32 {
+	i := sort.Search(len(list), func(i int) bool { return list[i] >= offset })
+	list = append(list, 0)
+	copy(list[i+1:], list[i:])
+	list[i] = offset
+	return list
+}
+
+// blockRange returns the offset and size of block n.
+func (a *allocator) blockRange(n int) (offset, size uint32) {
+	addr := a.blocks[n]
+	return addr &^ 0x1f, 1 << (addr & 0x1f)
+}
+
+// bookkeepingSize returns the encoded size of the allocator state, the
+// contents of block 0.
+func (a *allocator) bookkeepingSize(directories map[string]uint32) int {
+	size := 8 + 4*((len(a.blocks)+255)/256*256) + 4
+	for name := range directories {
+		size += 1 + len(name) + 4
+	}
+	for _, list := range a.free {
+		size += 4 + 4*len(list)
+	}
+	return size
+}
+
+// bookkeeping encodes the block addresses, the directory of named blocks
+// and the free lists.
+func (a *allocator) bookkeeping(directories map[string]uint32) []byte {
+	b := binary.BigEndian.AppendUint32(nil, uint32(len(a.blocks)))
+	b = binary.BigEndian.AppendUint32(b, 0)
+	for i := 0; i < (len(a.blocks)+255)/256*256; i++ {
+		var addr uint32
+		if i < len(a.blocks) {
+			addr = a.blocks[i]
+		}
+		b = binary.BigEndian.AppendUint32(b, addr)
+	}
+	names := make([]string, 0, len(directories))
+	for name := range directories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	b = binary.BigEndian.AppendUint32(b, uint32(len(names)))
+	for _, name := range names {
+		b = append(b, byte(len(name)))
+		b = append(b, name...)
+		b = binary.BigEndian.AppendUint32(b, directories[name])
+	}
+	for _, list := range a.free {
+		b = binary.BigEndian.AppendUint32(b, uint32(len(list)))
+		for _, offset := range list {
+			b = binary.BigEndian.AppendUint32(b, offset)
+		}
+	}
+	return b
+}
@@ -1,25 +1,22 @@
-// Package dsstore is a package for writing .DS_Store files on macOS.
+// Package dsstore is a package for reading and writing .DS_Store files on macOS.
 // Original code from https://github.com/LinusU/node-ds-store (MIT)
 package dsstore
 
 import (
 	"bytes"
 	"encoding/binary"
+	"io"
 	"os"
 	"sort"
 	"unicode/utf16"
 
 	"github.com/ironpark/zapp/pkg/mactools/dsstore/entry"
+	"github.com/ironpark/zapp/pkg/mactools/hfsplus"
 
 	"github.com/samber/lo"
 	"golang.org/x/text/unicode/norm"
-
-	_ "embed"
 )
 
-//go:embed clean
-var DSStoreClean []byte
-
 type Entries []entry.Entry
 
 func (e Entries) Len() int {
@@ -30,14 +27,13 @@ func (e Entries) Swap(i, j int) {
 	e[i], e[j] = e[j], e[i]
 }
 
+// Less orders entries like the Finder: by file name as HFS+ compares them,
+// then by entry type.
 func (e Entries) Less(i, j int) bool {
-	if result := hfsPlusFastUnicodeCompare(e[i].Filename(), e[j].Filename()); result != 0 {
+	if result := hfsplus.CompareNames(norm.NFD.String(e[i].Filename()), norm.NFD.String(e[j].Filename())); result != 0 {
 		return result < 0
 	}
-	if result := hfsPlusFastUnicodeCompare(e[i].EntryType(), e[j].EntryType()); result != 0 {
-		return result < 0
-	}
-	return false
+	return e[i].EntryType() < e[j].EntryType()
 }
 
 type DSStore struct {
@@ -49,7 +45,10 @@ func NewDSStore() *DSStore {
 		Entries: make([]entry.Entry, 0),
 	}
 }
-func (ds *DSStore) getIconViewPreferences() *entry.IconViewPreferencesEntry {
+
+// IconViewPreferences returns the icon view options of the folder, adding
+// the entry with default values if it does not exist yet.
+func (ds *DSStore) IconViewPreferences() *entry.IconViewPreferencesEntry {
 	e, ok := lo.Find(ds.Entries, func(e entry.Entry) bool {
 		return e.EntryType() == entry.TypeIconViewPreferences
 	})
@@ -61,44 +60,64 @@ func (ds *DSStore) getIconViewPreferences() *entry.IconViewPreferencesEntry {
 	return newEntry
 }
 
+// WorkspaceSettings returns the window settings of the folder, adding the
+// entry with default values if it does not exist yet.
+func (ds *DSStore) WorkspaceSettings() *entry.WorkspaceSettingsEntry {
+	e, ok := lo.Find(ds.Entries, func(e entry.Entry) bool {
+		return e.EntryType() == entry.TypeWorkspaceSettings
+	})
+	if ok {
+		return e.(*entry.WorkspaceSettingsEntry)
+	}
+	newEntry := entry.NewWorkspaceSettingsEntry(0, 0, 0, 0)
+	ds.AddEntry(newEntry)
+	return newEntry
+}
+
 func (ds *DSStore) SetLabelPlaceToBottom(bottom bool) {
-	ds.getIconViewPreferences().LabelOnBottom = bottom
+	ds.IconViewPreferences().LabelOnBottom = bottom
 }
 
 func (ds *DSStore) SetLabelSize(size float64) {
-	ds.getIconViewPreferences().TextSize = size
+	ds.IconViewPreferences().TextSize = size
 }
 
 func (ds *DSStore) SetIconSize(size float64) {
-	ds.getIconViewPreferences().IconSize = size
+	ds.IconViewPreferences().IconSize = size
 }
 
 func (ds *DSStore) SetBgColor(r, g, b float64) {
-	ds.getIconViewPreferences().SetBgColor(r, g, b)
+	ds.IconViewPreferences().SetBgColor(r, g, b)
 }
 
 func (ds *DSStore) SetBackgroundImage(path string) {
-	ivp := ds.getIconViewPreferences()
+	ivp := ds.IconViewPreferences()
 	ivp.SetBgImage(path)
 }
 
+// SetBackgroundAlias sets the background picture from an alias record and
+// bookmark data, for a picture on a volume that is not mounted.
+func (ds *DSStore) SetBackgroundAlias(aliasData, bookmarkData []byte) {
+	ds.IconViewPreferences().SetBgAlias(aliasData, bookmarkData)
+}
+
 func (ds *DSStore) SetBgToDefault() {
-	ds.getIconViewPreferences().SetBgToDefault()
+	ds.IconViewPreferences().SetBgToDefault()
 }
 
 func (ds *DSStore) SetWindow(width, height, x, y int) {
-	e, ok := lo.Find(ds.Entries, func(e entry.Entry) bool {
-		return e.EntryType() == entry.TypeWorkspaceSettings
-	})
-	if ok {
-		e.(*entry.WorkspaceSettingsEntry).Width = width
-		e.(*entry.WorkspaceSettingsEntry).Height = height
-		e.(*entry.WorkspaceSettingsEntry).X = x
-		e.(*entry.WorkspaceSettingsEntry).Y = y
-	} else {
-		newEntry := entry.NewWorkspaceSettingsEntry(x, y, width, height)
-		ds.AddEntry(newEntry)
-	}
+	settings := ds.WorkspaceSettings()
+	settings.Width = width
+	settings.Height = height
+	settings.X = x
+	settings.Y = y
+}
+
+// SetSidebar shows or hides the sidebar of the window.
+func (ds *DSStore) SetSidebar(show bool) {
+	settings := ds.WorkspaceSettings()
+	settings.ShowSidebar = show
+	settings.ContainerShowSidebar = show
 }
 
 func (ds *DSStore) SetIconPos(name string, x, y uint32) {
@@ -118,38 +137,83 @@ func (ds *DSStore) AddEntry(entry entry.Entry) {
 	ds.Entries = append(ds.Entries, entry)
 }
 
+// Write writes the .DS_Store file to filePath.
 func (ds *DSStore) Write(filePath string) error {
-	sort.Sort(Entries(ds.Entries))
-
-	buf := bytes.Clone(DSStoreClean)
-	modified := make([]byte, 3840)
-	currentPos := 0
-	P := uint32(0)
-	count := uint32(len(ds.Entries))
-
-	binary.BigEndian.PutUint32(modified[currentPos:], P)
-	binary.BigEndian.PutUint32(modified[currentPos+4:], count)
-	currentPos += 8
-
-	for _, entry := range ds.Entries {
-		blob := entryBuild(entry)
-		copy(modified[currentPos:], blob)
-		currentPos += len(blob)
+	var buf bytes.Buffer
+	if _, err := ds.WriteTo(&buf); err != nil {
+		return err
 	}
+	return os.WriteFile(filePath, buf.Bytes(), 0644)
+}
 
-	binary.BigEndian.PutUint32(buf[76:], count)
-	copy(buf[4100:], modified)
-	return os.WriteFile(filePath, buf, 0644)
+// WriteTo encodes the entries as a buddy allocated file holding the DSDB
+// B-tree and writes it to w.
+func (ds *DSStore) WriteTo(w io.Writer) (int64, error) {
+	sort.Stable(Entries(ds.Entries))
+	records := make([][]byte, len(ds.Entries))
+	for i, e := range ds.Entries {
+		records[i] = entryBuild(e)
+	}
+
+	// Block 0 holds the allocator state and block 1 the DSDB header; the
+	// tree nodes follow.
+	nodes, root, levels := buildTree(records, 2)
+	a := newAllocator()
+	if err := a.allocBlock(1, 20); err != nil {
+		return 0, err
+	}
+	for i, n := range nodes {
+		if err := a.allocBlock(i+2, max(pageSize, n.size())); err != nil {
+			return 0, err
+		}
+	}
+	directories := map[string]uint32{"DSDB": 1}
+	// Allocating block 0 splits at most one block per size class, adding
+	// at most one free list entry for each.
+	if err := a.allocBlock(0, max(2048, a.bookkeepingSize(directories)+4*len(a.free))); err != nil {
+		return 0, err
+	}
+
+	var end uint32
+	for i := range a.blocks {
+		offset, size := a.blockRange(i)
+		end = max(end, offset+size)
+	}
+	buf := make([]byte, 4+end)
+	put := func(block int, data []byte) {
+		offset, _ := a.blockRange(block)
+		copy(buf[4+offset:], data)
+	}
+	rootOffset, rootSize := a.blockRange(0)
+	binary.BigEndian.PutUint32(buf, 1)
+	binary.BigEndian.PutUint32(buf[4:], budMagic)
+	binary.BigEndian.PutUint32(buf[8:], rootOffset)
+	binary.BigEndian.PutUint32(buf[12:], rootSize)
+	binary.BigEndian.PutUint32(buf[16:], rootOffset)
+	binary.BigEndian.PutUint32(buf[20:], 0x100c)
+	put(0, a.bookkeeping(directories))
+
+	dsdb := make([]byte, 20)
+	binary.BigEndian.PutUint32(dsdb, root)
+	binary.BigEndian.PutUint32(dsdb[4:], levels)
+	binary.BigEndian.PutUint32(dsdb[8:], uint32(len(records)))
+	binary.BigEndian.PutUint32(dsdb[12:], uint32(len(nodes)))
+	binary.BigEndian.PutUint32(dsdb[16:], pageSize)
+	put(1, dsdb)
+	for i, n := range nodes {
+		put(i+2, n.bytes())
+	}
+	written, err := w.Write(buf)
+	return int64(written), err
 }
 
 func entryBuild(entry entry.Entry) []byte {
-	filename := norm.NFD.String(entry.Filename())
-	filenameLength := len(filename)
-	filenameBytes := filenameLength * 2
+	filename := utf16be(norm.NFD.String(entry.Filename()))
+	filenameBytes := len(filename)
 	blob := entry.Bytes()
 	buffer := make([]byte, 4+filenameBytes+4+4+len(blob))
-	binary.BigEndian.PutUint32(buffer[0:], uint32(filenameLength))
-	copy(buffer[4:], utf16be(filename))
+	binary.BigEndian.PutUint32(buffer[0:], uint32(filenameBytes/2))
+	copy(buffer[4:], filename)
 	copy(buffer[4+filenameBytes:], entry.EntryType())
 	copy(buffer[8+filenameBytes:], entry.DataType())
 	copy(buffer[12+filenameBytes:], blob)
@@ -0,0 +1,121 @@
+package dsstore
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"testing"
+
+	"github.com/ironpark/zapp/pkg/mactools/dsstore/entry"
+)
+
+// walkTree returns the file names of the records of a written file in tree
+// order, checking the allocator bookkeeping on the way.
+func walkTree(t *testing.T, data []byte) []string {
+	t.Helper()
+	be := binary.BigEndian
+	if be.Uint32(data) != 1 || be.Uint32(data[4:]) != budMagic {
+		t.Fatal("bad file header")
+	}
+	alloc := data[4:]
+	rootOffset := be.Uint32(alloc[4:])
+	info := alloc[rootOffset:]
+	blocks := make([]uint32, be.Uint32(info))
+	for i := range blocks {
+		blocks[i] = be.Uint32(info[8+4*i:])
+	}
+	// Allocated blocks must not overlap each other or the header.
+	used := map[uint32]bool{0: true}
+	for _, addr := range blocks {
+		offset, size := addr&^0x1f, uint32(1)<<(addr&0x1f)
+		for o := offset; o < offset+size; o += 32 {
+			if used[o] {
+				t.Fatalf("block at %#x overlaps another block", offset)
+			}
+			used[o] = true
+		}
+	}
+	block := func(n uint32) []byte {
+		return alloc[blocks[n]&^0x1f:]
+	}
+	dsdb := block(1)
+	var names []string
+	var walk func(n uint32, depth int)
+	walk = func(n uint32, depth int) {
+		b := block(n)
+		p, count := be.Uint32(b), int(be.Uint32(b[4:]))
+		if (p == 0) != (depth == 0) {
+			t.Fatalf("node %d at unexpected depth", n)
+		}
+		off := 8
+		for i := 0; i < count; i++ {
+			if p != 0 {
+				walk(be.Uint32(b[off:]), depth-1)
+				off += 4
+			}
+			nameLen := int(be.Uint32(b[off:]))
+			name := make([]rune, nameLen)
+			for j := range name {
+				name[j] = rune(be.Uint16(b[off+4+2*j:]))
+			}
+			names = append(names, string(name))
+			off += 4 + 2*nameLen + 8
+			blobLen := int(be.Uint32(b[off:]))
+			off += 4 + blobLen
+		}
+		if p != 0 {
+			walk(p, depth-1)
+		}
+	}
+	walk(be.Uint32(dsdb), int(be.Uint32(dsdb[4:])))
+	if len(names) != int(be.Uint32(dsdb[8:])) {
+		t.Fatalf("DSDB records %d, tree holds %d", be.Uint32(dsdb[8:]), len(names))
+	}
+	return names
+}
+
+func TestWriteManyEntries(t *testing.T) {
+	for _, count := range []int{0, 3, 150, 2000} {
+		t.Run(fmt.Sprint(count), func(t *testing.T) {
+			ds := NewDSStore()
+			ds.SetWindow(640, 480, 100, 100)
+			ds.SetIconSize(128)
+			for i := 0; i < count; i++ {
+				ds.SetIconPos(fmt.Sprintf("Document %04d with a fairly long name.pdf", count-i), uint32(i), uint32(i))
+			}
+			ivp := ds.IconViewPreferences()
+			ivp.BackgroundType = 2
+			ivp.BackgroundImageAlias = bytes.Repeat([]byte{0xa5}, 3000)
+
+			var buf bytes.Buffer
+			if _, err := ds.WriteTo(&buf); err != nil {
+				t.Fatal(err)
+			}
+			names := walkTree(t, buf.Bytes())
+			if len(names) != len(ds.Entries) {
+				t.Fatalf("got %d records, want %d", len(names), len(ds.Entries))
+			}
+			for i := 1; i < len(names); i++ {
+				if names[i-1] > names[i] {
+					t.Fatalf("records out of order: %q before %q", names[i-1], names[i])
+				}
+			}
+		})
+	}
+}
+
+func TestEntriesOrder(t *testing.T) {
+	ds := NewDSStore()
+	ds.AddEntry(entry.NewIconLocationEntry("b", 0, 0))
+	ds.AddEntry(entry.NewIconLocationEntry("C", 0, 0))
+	ds.AddEntry(entry.NewIconLocationEntry("a", 0, 0))
+	ds.SetWindow(1, 1, 0, 0)
+	var buf bytes.Buffer
+	if _, err := ds.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	names := walkTree(t, buf.Bytes())
+	if fmt.Sprint(names) != "[. a b C]" {
+		t.Fatalf("unexpected order %v", names)
+	}
+}
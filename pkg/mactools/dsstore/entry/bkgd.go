@@ -1,17 +1,52 @@
 package entry
 
-// NewBackgroundEntry creates a new background entry.
-//func NewBackgroundEntry(filename string, opts map[string]interface{}) (*EntryItem, error) {
-//	blob := make([]byte, 12+4)
-//	binary.BigEndian.PutUint32(blob[0:], uint32(len(blob)-4))
-//	if opts["color"] != nil {
-//		copy(blob[4:], "ClrB")
-//		return nil, fmt.Errorf("not implemented")
-//	} else if opts["pictureByteLength"] != nil {
-//		copy(blob[4:], "PctB")
-//		binary.BigEndian.PutUint32(blob[8:], opts["pictureByteLength"].(uint32))
-//	} else {
-//		copy(blob[4:], "DefB")
-//	}
-//	return NewEntry(filename, TypeBackground, "blob", blob), nil
-//}
+import "encoding/binary"
+
+// Background kinds of the legacy BKGD entry.
+const (
+	BackgroundDefault = "DefB"
+	BackgroundColor   = "ClrB"
+	BackgroundPicture = "PctB"
+)
+
+// BackgroundEntry is the background used by Finder versions before icvp:
+// the default, a solid color or a picture stored in a pict entry.
+type BackgroundEntry struct {
+	Kind          string
+	Red           uint16
+	Green         uint16
+	Blue          uint16
+	PictureLength uint32
+}
+
+func (b *BackgroundEntry) Bytes() []byte {
+	blob := make([]byte, 12+4)
+	binary.BigEndian.PutUint32(blob[0:], uint32(len(blob)-4))
+	copy(blob[4:8], b.Kind)
+	switch b.Kind {
+	case BackgroundColor:
+		binary.BigEndian.PutUint16(blob[8:], b.Red)
+		binary.BigEndian.PutUint16(blob[10:], b.Green)
+		binary.BigEndian.PutUint16(blob[12:], b.Blue)
+	case BackgroundPicture:
+		binary.BigEndian.PutUint32(blob[8:], b.PictureLength)
+	}
+	return blob
+}
+
+func (b *BackgroundEntry) Filename() string {
+	return "."
+}
+
+func (b *BackgroundEntry) EntryType() string {
+	return TypeBackground
+}
+
+func (b *BackgroundEntry) DataType() string {
+	return "blob"
+}
+
+// NewBackgroundEntry creates a new background entry of the given kind.
+func NewBackgroundEntry(kind string) *BackgroundEntry {
+	return &BackgroundEntry{Kind: kind}
+}
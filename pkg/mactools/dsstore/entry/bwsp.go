@@ -23,13 +23,13 @@ type WorkspaceSettingsEntry struct {
 func (w *WorkspaceSettingsEntry) Bytes() []byte {
 	buffer := &bytes.Buffer{}
 	_ = plist.NewBinaryEncoder(buffer).Encode(map[string]any{
-		"ContainerShowSidebar": true,
-		"ShowPathbar":          false,
-		"ShowSidebar":          true,
-		"ShowStatusBar":        false,
-		"ShowTabView":          false,
-		"ShowToolbar":          false,
-		"SidebarWidth":         0,
+		"ContainerShowSidebar": w.ContainerShowSidebar,
+		"ShowPathbar":          w.ShowPathbar,
+		"ShowSidebar":          w.ShowSidebar,
+		"ShowStatusBar":        w.ShowStatusBar,
+		"ShowTabView":          w.ShowTabView,
+		"ShowToolbar":          w.ShowToolbar,
+		"SidebarWidth":         w.SidebarWidth,
 		"WindowBounds":         fmt.Sprintf("{{%d, %d}, {%d, %d}}", w.X, w.Y, w.Width, w.Height),
 	})
 	return plistWrap(buffer.Bytes())
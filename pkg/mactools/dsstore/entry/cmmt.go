@@ -0,0 +1,35 @@
+package entry
+
+import (
+	"encoding/binary"
+	"unicode/utf16"
+)
+
+// CommentEntry is the Spotlight comment of a file.
+type CommentEntry struct {
+	Comment  string
+	filename string
+}
+
+func (c *CommentEntry) Bytes() []byte {
+	u := utf16.Encode([]rune(c.Comment))
+	blob := binary.BigEndian.AppendUint32(nil, uint32(len(u)))
+	return append(blob, utf16be(c.Comment)...)
+}
+
+func (c *CommentEntry) Filename() string {
+	return c.filename
+}
+
+func (c *CommentEntry) EntryType() string {
+	return TypeComment
+}
+
+func (c *CommentEntry) DataType() string {
+	return "ustr"
+}
+
+// NewCommentEntry creates a new comment entry.
+func NewCommentEntry(filename, comment string) *CommentEntry {
+	return &CommentEntry{Comment: comment, filename: filename}
+}
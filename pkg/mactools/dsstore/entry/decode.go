@@ -0,0 +1,153 @@
+package entry
+
+import (
+	"encoding/binary"
+	"fmt"
+	"unicode/utf16"
+
+	"howett.net/plist"
+)
+
+// Decode builds the entry for a record read from a .DS_Store file. data is
+// the value following the data type code, including the length prefix of
+// blob and ustr values, so that Bytes of the result reproduces it. Records
+// without a dedicated type are returned as *EntryItem.
+func Decode(filename, entryType, dataType string, data []byte) (Entry, error) {
+	raw := NewEntry(filename, entryType, dataType, data)
+	switch dataType {
+	case "long":
+		if entryType == TypeVersion && len(data) == 4 {
+			return NewVersionEntry(binary.BigEndian.Uint32(data)), nil
+		}
+		return raw, nil
+	case "ustr":
+		if entryType == TypeComment {
+			return NewCommentEntry(filename, decodeUstr(data)), nil
+		}
+		return raw, nil
+	case "blob":
+		if len(data) < 4 {
+			return nil, fmt.Errorf("invalid %s record of %q: missing blob length", entryType, filename)
+		}
+	default:
+		return raw, nil
+	}
+
+	blob := data[4:]
+	switch entryType {
+	case TypeIconLocation:
+		if len(blob) < 8 {
+			return nil, fmt.Errorf("invalid %s record of %q: %d bytes", entryType, filename, len(blob))
+		}
+		return NewIconLocationEntry(filename, binary.BigEndian.Uint32(blob), binary.BigEndian.Uint32(blob[4:])), nil
+	case TypeBackground:
+		if len(blob) < 12 {
+			return nil, fmt.Errorf("invalid %s record of %q: %d bytes", entryType, filename, len(blob))
+		}
+		bg := NewBackgroundEntry(string(blob[:4]))
+		switch bg.Kind {
+		case BackgroundColor:
+			bg.Red = binary.BigEndian.Uint16(blob[4:])
+			bg.Green = binary.BigEndian.Uint16(blob[6:])
+			bg.Blue = binary.BigEndian.Uint16(blob[8:])
+		case BackgroundPicture:
+			bg.PictureLength = binary.BigEndian.Uint32(blob[4:])
+		}
+		return bg, nil
+	}
+
+	if !isPlist(blob) {
+		return raw, nil
+	}
+	var values map[string]any
+	if _, err := plist.Unmarshal(blob, &values); err != nil {
+		return nil, fmt.Errorf("failed to decode %s record of %q: %w", entryType, filename, err)
+	}
+	switch entryType {
+	case TypeWorkspaceSettings:
+		return decodeWorkspaceSettings(values), nil
+	case TypeIconViewPreferences:
+		return decodeIconViewPreferences(values), nil
+	}
+	return NewPlistEntry(filename, entryType, values), nil
+}
+
+func decodeWorkspaceSettings(values map[string]any) *WorkspaceSettingsEntry {
+	w := &WorkspaceSettingsEntry{
+		ContainerShowSidebar: plistBool(values["ContainerShowSidebar"]),
+		ShowPathbar:          plistBool(values["ShowPathbar"]),
+		ShowSidebar:          plistBool(values["ShowSidebar"]),
+		ShowStatusBar:        plistBool(values["ShowStatusBar"]),
+		ShowTabView:          plistBool(values["ShowTabView"]),
+		ShowToolbar:          plistBool(values["ShowToolbar"]),
+		SidebarWidth:         int(plistFloat(values["SidebarWidth"])),
+	}
+	if bounds, ok := values["WindowBounds"].(string); ok {
+		fmt.Sscanf(bounds, "{{%d, %d}, {%d, %d}}", &w.X, &w.Y, &w.Width, &w.Height)
+	}
+	return w
+}
+
+func decodeIconViewPreferences(values map[string]any) *IconViewPreferencesEntry {
+	i := &IconViewPreferencesEntry{
+		BackgroundType:       int(plistFloat(values["backgroundType"])),
+		BackgroundColorRed:   plistFloat(values["backgroundColorRed"]),
+		BackgroundColorGreen: plistFloat(values["backgroundColorGreen"]),
+		BackgroundColorBlue:  plistFloat(values["backgroundColorBlue"]),
+		ShowIconPreview:      plistBool(values["showIconPreview"]),
+		ShowItemInfo:         plistBool(values["showItemInfo"]),
+		TextSize:             plistFloat(values["textSize"]),
+		IconSize:             plistFloat(values["iconSize"]),
+		ViewOptionsVersion:   int(plistFloat(values["viewOptionsVersion"])),
+		GridSpacing:          plistFloat(values["gridSpacing"]),
+		GridOffsetX:          plistFloat(values["gridOffsetX"]),
+		GridOffsetY:          plistFloat(values["gridOffsetY"]),
+		LabelOnBottom:        plistBool(values["labelOnBottom"]),
+	}
+	i.BackgroundImageAlias, _ = values["backgroundImageAlias"].([]byte)
+	i.BackgroundImageBookmark, _ = values["backgroundImageBookmark"].([]byte)
+	i.ArrangeBy, _ = values["arrangeBy"].(string)
+	return i
+}
+
+func isPlist(blob []byte) bool {
+	return len(blob) >= 8 && string(blob[:6]) == "bplist"
+}
+
+// plistFloat returns a plist number as float64; Finder writes sizes as
+// either integers or reals.
+func plistFloat(v any) float64 {
+	switch n := v.(type) {
+	case int64:
+		return float64(n)
+	case uint64:
+		return float64(n)
+	case float64:
+		return n
+	case float32:
+		return float64(n)
+	case bool:
+		if n {
+			return 1
+		}
+	}
+	return 0
+}
+
+func plistBool(v any) bool {
+	switch b := v.(type) {
+	case bool:
+		return b
+	case int64, uint64, float64, float32:
+		return plistFloat(b) != 0
+	}
+	return false
+}
+
+func decodeUstr(data []byte) string {
+	u := make([]uint16, (len(data)-4)/2)
+	for i := range u {
+		u[i] = binary.BigEndian.Uint16(data[4+2*i:])
+	}
+	return string(utf16.Decode(u))
+}
@@ -19,6 +19,14 @@ const (
 	TypeIconViewPreferences = "icvp"
 	// TypeVersion represents the version entry type
 	TypeVersion = "vSrn"
+	// TypeComment represents the Spotlight comment entry type
+	TypeComment = "cmmt"
+	// TypeListViewPreferences represents the list view preferences entry type
+	TypeListViewPreferences = "lsvp"
+	// TypeListViewPreferencesAlt represents the newer list view preferences entry type
+	TypeListViewPreferencesAlt = "lsvP"
+	// TypeGalleryViewPreferences represents the gallery view preferences entry type
+	TypeGalleryViewPreferences = "glvp"
 )
 
 type Entry interface {
@@ -28,12 +36,25 @@ type Entry interface {
 	DataType() string
 }
 
+// EntryItem is a record whose value is kept as the raw bytes following its
+// data type code.
 type EntryItem struct {
 	filename  string
 	entryType string
+	dataType  string
 	Buffer    []byte
 }
 
+// NewEntry creates a raw entry.
+func NewEntry(filename, entryType, dataType string, buffer []byte) *EntryItem {
+	return &EntryItem{
+		filename:  filename,
+		entryType: entryType,
+		dataType:  dataType,
+		Buffer:    buffer,
+	}
+}
+
 func (e EntryItem) Filename() string {
 	return e.filename
 }
@@ -42,6 +63,10 @@ func (e EntryItem) EntryType() string {
 	return e.entryType
 }
 
+func (e EntryItem) DataType() string {
+	return e.dataType
+}
+
 func (e EntryItem) Bytes() []byte {
 	return e.Buffer
 }
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"encoding/binary"
 	"github.com/ironpark/zapp/pkg/mactools/alias"
+	"github.com/ironpark/zapp/pkg/mactools/bookmark"
 	"unicode/utf16"
 
 	"howett.net/plist"
@@ -15,16 +16,19 @@ type IconViewPreferencesEntry struct {
 	BackgroundColorGreen float64
 	BackgroundColorBlue  float64
 	BackgroundImageAlias []byte // 배경 이미지 경로 추가
-	ShowIconPreview      bool
-	ShowItemInfo         bool
-	TextSize             float64
-	IconSize             float64
-	ViewOptionsVersion   int
-	GridSpacing          float64
-	GridOffsetX          float64
-	GridOffsetY          float64
-	LabelOnBottom        bool
-	ArrangeBy            string
+	// BackgroundImageBookmark is the bookmark data of the background
+	// picture, which newer Finder versions resolve before the alias.
+	BackgroundImageBookmark []byte
+	ShowIconPreview         bool
+	ShowItemInfo            bool
+	TextSize                float64
+	IconSize                float64
+	ViewOptionsVersion      int
+	GridSpacing             float64
+	GridOffsetX             float64
+	GridOffsetY             float64
+	LabelOnBottom           bool
+	ArrangeBy               string
 }
 
 func (i *IconViewPreferencesEntry) Bytes() []byte {
@@ -49,6 +53,9 @@ func (i *IconViewPreferencesEntry) Bytes() []byte {
 	if i.BackgroundType == 2 && i.BackgroundImageAlias != nil {
 		base["backgroundImageAlias"] = i.BackgroundImageAlias
 	}
+	if i.BackgroundType == 2 && i.BackgroundImageBookmark != nil {
+		base["backgroundImageBookmark"] = i.BackgroundImageBookmark
+	}
 
 	buffer := &bytes.Buffer{}
 	err := plist.NewBinaryEncoder(buffer).Encode(base)
@@ -61,6 +68,7 @@ func (i *IconViewPreferencesEntry) Bytes() []byte {
 func (i *IconViewPreferencesEntry) SetBgToDefault() {
 	i.BackgroundType = 0
 	i.BackgroundImageAlias = nil
+	i.BackgroundImageBookmark = nil
 }
 
 func (i *IconViewPreferencesEntry) SetBgColor(r, g, b float64) {
@@ -69,14 +77,27 @@ func (i *IconViewPreferencesEntry) SetBgColor(r, g, b float64) {
 	i.BackgroundColorGreen = g
 	i.BackgroundColorBlue = b
 	i.BackgroundImageAlias = nil
+	i.BackgroundImageBookmark = nil
 }
 
 func (i *IconViewPreferencesEntry) SetBgImage(imagePath string) (err error) {
 	i.BackgroundType = 2
-	i.BackgroundImageAlias, err = alias.Create(imagePath)
+	if i.BackgroundImageAlias, err = alias.Create(imagePath); err != nil {
+		return err
+	}
+	i.BackgroundImageBookmark, err = bookmark.Create(imagePath)
 	return err
 }
 
+// SetBgAlias sets the background picture from an alias record and bookmark
+// data built ahead of time, see alias.Build and bookmark.Build. The bookmark
+// may be nil.
+func (i *IconViewPreferencesEntry) SetBgAlias(aliasData, bookmarkData []byte) {
+	i.BackgroundType = 2
+	i.BackgroundImageAlias = aliasData
+	i.BackgroundImageBookmark = bookmarkData
+}
+
 func (i *IconViewPreferencesEntry) Filename() string {
 	return "."
 }
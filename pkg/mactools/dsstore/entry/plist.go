@@ -0,0 +1,40 @@
+package entry
+
+import (
+	"bytes"
+
+	"howett.net/plist"
+)
+
+// PlistEntry is a blob entry holding a binary property list that has no
+// dedicated type, such as the list and gallery view preferences.
+type PlistEntry struct {
+	Values    map[string]any
+	filename  string
+	entryType string
+}
+
+func (p *PlistEntry) Bytes() []byte {
+	buffer := &bytes.Buffer{}
+	if err := plist.NewBinaryEncoder(buffer).Encode(p.Values); err != nil {
+		return nil
+	}
+	return plistWrap(buffer.Bytes())
+}
+
+func (p *PlistEntry) Filename() string {
+	return p.filename
+}
+
+func (p *PlistEntry) EntryType() string {
+	return p.entryType
+}
+
+func (p *PlistEntry) DataType() string {
+	return "blob"
+}
+
+// NewPlistEntry creates a new property list entry.
+func NewPlistEntry(filename, entryType string, values map[string]any) *PlistEntry {
+	return &PlistEntry{Values: values, filename: filename, entryType: entryType}
+}
@@ -1,8 +1,29 @@
 package entry
 
+import "encoding/binary"
+
+// VersionEntry records the version of the .DS_Store format in use.
+type VersionEntry struct {
+	Version uint32
+}
+
+func (v *VersionEntry) Bytes() []byte {
+	return binary.BigEndian.AppendUint32(nil, v.Version)
+}
+
+func (v *VersionEntry) Filename() string {
+	return "."
+}
+
+func (v *VersionEntry) EntryType() string {
+	return TypeVersion
+}
+
+func (v *VersionEntry) DataType() string {
+	return "long"
+}
+
 // NewVersionEntry creates a new version entry.
-//func NewVersionEntry(filename string, version uint32) (*EntryItem, error) {
-//	blob := make([]byte, 4)
-//	binary.BigEndian.PutUint32(blob, version)
-//	return NewEntry(filename, TypeVersion, "long", blob), nil
-//}
+func NewVersionEntry(version uint32) *VersionEntry {
+	return &VersionEntry{Version: version}
+}
@@ -0,0 +1,31 @@
+package dsstore
+
+import "github.com/ironpark/zapp/pkg/mactools/dsstore/entry"
+
+// CopyLayout copies the Finder window geometry of ref into ds: the window
+// bounds, the icon and label sizes, the grid and label placement, and the
+// icon positions. The background and the window chrome of ds are kept, as an
+// alias in ref points into the reference volume. rename maps file names used
+// in ref to the names in ds.
+func (ds *DSStore) CopyLayout(ref *DSStore, rename map[string]string) {
+	for _, e := range ref.Entries {
+		switch e := e.(type) {
+		case *entry.WorkspaceSettingsEntry:
+			ds.SetWindow(e.Width, e.Height, e.X, e.Y)
+		case *entry.IconViewPreferencesEntry:
+			view := ds.IconViewPreferences()
+			view.IconSize = e.IconSize
+			view.TextSize = e.TextSize
+			view.GridSpacing = e.GridSpacing
+			view.GridOffsetX = e.GridOffsetX
+			view.GridOffsetY = e.GridOffsetY
+			view.LabelOnBottom = e.LabelOnBottom
+		case *entry.IconLocationEntry:
+			name := e.Filename()
+			if newName, ok := rename[name]; ok {
+				name = newName
+			}
+			ds.SetIconPos(name, e.X, e.Y)
+		}
+	}
+}
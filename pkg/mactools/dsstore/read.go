@@ -0,0 +1,222 @@
+package dsstore
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"unicode/utf16"
+
+	"github.com/ironpark/zapp/pkg/mactools/dsstore/entry"
+)
+
+// Read parses the .DS_Store file at filePath.
+func Read(filePath string) (*DSStore, error) {
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read .DS_Store: %w", err)
+	}
+	return Decode(data)
+}
+
+// Decode parses the contents of a .DS_Store file. Entries are returned in
+// the order of the DSDB B-tree.
+func Decode(data []byte) (*DSStore, error) {
+	be := binary.BigEndian
+	if len(data) < 4+headerSize || be.Uint32(data) != 1 || be.Uint32(data[4:]) != budMagic {
+		return nil, errors.New("not a .DS_Store file")
+	}
+	r := &reader{data: data[4:]}
+	rootOffset, rootSize := be.Uint32(r.data[4:]), be.Uint32(r.data[8:])
+	if be.Uint32(r.data[12:]) != rootOffset {
+		return nil, errors.New("corrupt .DS_Store header")
+	}
+	info, err := r.slice(rootOffset, rootSize)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read allocator info: %w", err)
+	}
+	if err := r.parseBookkeeping(info); err != nil {
+		return nil, err
+	}
+	dsdbBlock, ok := r.directories["DSDB"]
+	if !ok {
+		return nil, errors.New("DSDB directory not found")
+	}
+	dsdb, err := r.block(dsdbBlock)
+	if err != nil {
+		return nil, err
+	}
+	if len(dsdb) < 20 {
+		return nil, errors.New("truncated DSDB header")
+	}
+	root, levels, count := be.Uint32(dsdb), be.Uint32(dsdb[4:]), be.Uint32(dsdb[8:])
+
+	ds := NewDSStore()
+	if err := r.walk(ds, root, int(levels)); err != nil {
+		return nil, err
+	}
+	if len(ds.Entries) != int(count) {
+		return nil, fmt.Errorf("DSDB holds %d records, expected %d", len(ds.Entries), count)
+	}
+	return ds, nil
+}
+
+// reader resolves block numbers to data through the allocator bookkeeping.
+// Offsets are relative to the allocator header, after the file magic.
+type reader struct {
+	data        []byte
+	blocks      []uint32
+	directories map[string]uint32
+	visited     map[uint32]bool
+}
+
+func (r *reader) slice(offset, size uint32) ([]byte, error) {
+	end := uint64(offset) + uint64(size)
+	if end > uint64(len(r.data)) {
+		return nil, fmt.Errorf("range %#x+%#x is outside the file", offset, size)
+	}
+	return r.data[offset:end], nil
+}
+
+func (r *reader) block(n uint32) ([]byte, error) {
+	if int(n) >= len(r.blocks) {
+		return nil, fmt.Errorf("block %d does not exist", n)
+	}
+	addr := r.blocks[n]
+	if addr&0x1f > 30 {
+		return nil, fmt.Errorf("block %d has invalid size", n)
+	}
+	return r.slice(addr&^0x1f, 1<<(addr&0x1f))
+}
+
+// parseBookkeeping decodes the block addresses and the directory of named
+// blocks. The free lists are not needed for reading.
+func (r *reader) parseBookkeeping(info []byte) error {
+	be := binary.BigEndian
+	short := errors.New("truncated allocator info")
+	if len(info) < 8 {
+		return short
+	}
+	count := int(be.Uint32(info))
+	off := 8 + 4*((count+255)/256*256)
+	if off+4 > len(info) {
+		return short
+	}
+	r.blocks = make([]uint32, count)
+	for i := range r.blocks {
+		r.blocks[i] = be.Uint32(info[8+4*i:])
+	}
+	dirs := int(be.Uint32(info[off:]))
+	off += 4
+	r.directories = make(map[string]uint32, dirs)
+	for i := 0; i < dirs; i++ {
+		if off >= len(info) {
+			return short
+		}
+		n := int(info[off])
+		if off+1+n+4 > len(info) {
+			return short
+		}
+		r.directories[string(info[off+1:off+1+n])] = be.Uint32(info[off+1+n:])
+		off += 1 + n + 4
+	}
+	return nil
+}
+
+// walk appends the records of the subtree at block n in order. depth is the
+// number of internal levels below n.
+func (r *reader) walk(ds *DSStore, n uint32, depth int) error {
+	if r.visited == nil {
+		r.visited = make(map[uint32]bool)
+	}
+	if r.visited[n] {
+		return fmt.Errorf("node %d is referenced twice", n)
+	}
+	r.visited[n] = true
+
+	b, err := r.block(n)
+	if err != nil {
+		return err
+	}
+	if len(b) < 8 {
+		return fmt.Errorf("truncated node %d", n)
+	}
+	be := binary.BigEndian
+	p, count := be.Uint32(b), int(be.Uint32(b[4:]))
+	if (p == 0) != (depth == 0) {
+		return fmt.Errorf("node %d at unexpected depth", n)
+	}
+	off := 8
+	for i := 0; i < count; i++ {
+		if p != 0 {
+			if off+4 > len(b) {
+				return fmt.Errorf("truncated node %d", n)
+			}
+			if err := r.walk(ds, be.Uint32(b[off:]), depth-1); err != nil {
+				return err
+			}
+			off += 4
+		}
+		e, size, err := parseRecord(b[off:])
+		if err != nil {
+			return fmt.Errorf("node %d record %d: %w", n, i, err)
+		}
+		ds.AddEntry(e)
+		off += size
+	}
+	if p != 0 {
+		return r.walk(ds, p, depth-1)
+	}
+	return nil
+}
+
+// parseRecord decodes the record at the start of b and returns it with its
+// encoded size.
+func parseRecord(b []byte) (entry.Entry, int, error) {
+	be := binary.BigEndian
+	short := errors.New("truncated record")
+	if len(b) < 4 {
+		return nil, 0, short
+	}
+	nameLen := int(be.Uint32(b))
+	off := 4 + 2*nameLen
+	if nameLen > len(b) || off+8 > len(b) {
+		return nil, 0, short
+	}
+	name := make([]uint16, nameLen)
+	for i := range name {
+		name[i] = be.Uint16(b[4+2*i:])
+	}
+	entryType, dataType := string(b[off:off+4]), string(b[off+4:off+8])
+	off += 8
+
+	var size int
+	switch dataType {
+	case "bool":
+		size = 1
+	case "long", "shor", "type":
+		size = 4
+	case "comp", "dutc":
+		size = 8
+	case "blob", "ustr":
+		if off+4 > len(b) {
+			return nil, 0, short
+		}
+		size = 4 + int(be.Uint32(b[off:]))
+		if dataType == "ustr" {
+			size = 4 + 2*int(be.Uint32(b[off:]))
+		}
+	default:
+		return nil, 0, fmt.Errorf("unknown data type %q", dataType)
+	}
+	if size < 0 || off+size > len(b) {
+		return nil, 0, short
+	}
+	value := make([]byte, size)
+	copy(value, b[off:off+size])
+	e, err := entry.Decode(string(utf16.Decode(name)), entryType, dataType, value)
+	if err != nil {
+		return nil, 0, err
+	}
+	return e, off + size, nil
+}
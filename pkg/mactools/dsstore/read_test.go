@@ -0,0 +1,141 @@
+package dsstore
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/ironpark/zapp/pkg/mactools/dsstore/entry"
+)
+
+func TestReadRoundTrip(t *testing.T) {
+	ds := NewDSStore()
+	ds.SetWindow(640, 480, 100, 120)
+	ds.SetSidebar(false)
+	ds.WorkspaceSettings().ShowToolbar = true
+	ds.WorkspaceSettings().SidebarWidth = 180
+	ds.SetIconSize(96)
+	ds.SetLabelSize(14)
+	ivp := ds.IconViewPreferences()
+	ivp.BackgroundType = 2
+	ivp.BackgroundImageAlias = []byte("alias data")
+	ivp.BackgroundImageBookmark = []byte("bookmark data")
+	ds.SetIconPos("App.app", 140, 200)
+	ds.SetIconPos("Applications", 500, 200)
+	ds.SetIconPos("Cafe\u0301 \U0001F600.txt", 1, 2)
+	ds.AddEntry(entry.NewVersionEntry(1))
+	bg := entry.NewBackgroundEntry(entry.BackgroundColor)
+	bg.Red, bg.Green, bg.Blue = 0xffff, 0x8000, 0
+	ds.AddEntry(bg)
+	ds.AddEntry(entry.NewCommentEntry("App.app", "Drag me"))
+	ds.AddEntry(entry.NewPlistEntry(".", entry.TypeListViewPreferences, map[string]any{
+		"textSize":   float64(12),
+		"sortColumn": "name",
+		"iconSize":   uint64(16),
+	}))
+	ds.AddEntry(entry.NewEntry("App.app", "dscl", "bool", []byte{1}))
+	ds.AddEntry(entry.NewEntry("App.app", "moDD", "dutc", []byte{0, 0, 0, 0, 0xd8, 0, 0, 0}))
+
+	var buf bytes.Buffer
+	if _, err := ds.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	got, err := Decode(buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got.Entries) != len(ds.Entries) {
+		t.Fatalf("got %d entries, want %d", len(got.Entries), len(ds.Entries))
+	}
+	for i, want := range ds.Entries {
+		if !reflect.DeepEqual(got.Entries[i], want) {
+			t.Errorf("entry %d: got %#v, want %#v", i, got.Entries[i], want)
+		}
+	}
+}
+
+func TestReadManyEntries(t *testing.T) {
+	ds := NewDSStore()
+	for i := 0; i < 2000; i++ {
+		ds.SetIconPos(fmt.Sprintf("Document %04d with a fairly long name.pdf", i), uint32(i), uint32(2*i))
+	}
+	var buf bytes.Buffer
+	if _, err := ds.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	got, err := Decode(buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, e := range got.Entries {
+		loc, ok := e.(*entry.IconLocationEntry)
+		if !ok || loc.Filename() != fmt.Sprintf("Document %04d with a fairly long name.pdf", i) || loc.Y != 2*loc.X {
+			t.Fatalf("unexpected entry %d: %#v", i, e)
+		}
+	}
+}
+
+func TestDecodeCorrupt(t *testing.T) {
+	ds := NewDSStore()
+	ds.SetWindow(640, 480, 0, 0)
+	ds.SetIconPos("App.app", 1, 2)
+	var buf bytes.Buffer
+	if _, err := ds.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+	for _, size := range []int{0, 4, 36, 100, len(data) / 2} {
+		if _, err := Decode(data[:size]); err == nil {
+			t.Errorf("truncated to %d bytes: expected an error", size)
+		}
+	}
+	// Flipping bytes must never panic.
+	for i := 0; i < len(data); i += 7 {
+		corrupt := bytes.Clone(data)
+		corrupt[i] ^= 0xff
+		Decode(corrupt)
+	}
+}
+
+// TestReadFinderFile reads testdata/clean, a .DS_Store written by the Finder
+// whose DSDB leaf was blanked out while its header still counts 7 records.
+func TestReadFinderFile(t *testing.T) {
+	data, err := os.ReadFile("testdata/clean")
+	if err != nil {
+		t.Fatal(err)
+	}
+	be := binary.BigEndian
+	r := &reader{data: data[4:]}
+	info, err := r.slice(be.Uint32(r.data[4:]), be.Uint32(r.data[8:]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := r.parseBookkeeping(info); err != nil {
+		t.Fatal(err)
+	}
+	if want := []uint32{0x200b, 0x45, 0x100c}; !reflect.DeepEqual(r.blocks, want) {
+		t.Fatalf("block addresses %#x, want %#x", r.blocks, want)
+	}
+	if !reflect.DeepEqual(r.directories, map[string]uint32{"DSDB": 1}) {
+		t.Fatalf("directories %v", r.directories)
+	}
+	dsdb, err := r.block(r.directories["DSDB"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Root node, internal levels, records, nodes and page size.
+	var hdr [5]uint32
+	for i := range hdr {
+		hdr[i] = be.Uint32(dsdb[4*i:])
+	}
+	if hdr != [5]uint32{2, 0, 7, 1, 0x1000} {
+		t.Fatalf("DSDB header %#x", hdr)
+	}
+	if _, err := Decode(data); err == nil || !strings.Contains(err.Error(), "0 records, expected 7") {
+		t.Fatalf("expected the record count mismatch, got %v", err)
+	}
+}
@@ -0,0 +1,5 @@
+package finder
+
+import "golang.org/x/sys/unix"
+
+const errNoAttr = unix.ENOATTR
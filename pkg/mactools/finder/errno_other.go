@@ -0,0 +1,7 @@
+//go:build !darwin
+
+package finder
+
+import "golang.org/x/sys/unix"
+
+const errNoAttr = unix.ENODATA
@@ -0,0 +1,143 @@
+// Package finder is a package for setting the Finder attributes of files
+// on disk: Finder info flags, creator codes, resource forks and custom
+// icons, as SetFile, Rez and DeRez do.
+package finder
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/ironpark/zapp/pkg/mactools/hfsplus"
+	"github.com/ironpark/zapp/pkg/mactools/rsrc"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	finderInfoAttr   = "com.apple.FinderInfo"
+	resourceForkAttr = "com.apple.ResourceFork"
+	// finderInfoSize is the size of the FileInfo/FolderInfo block followed
+	// by the extended Finder info.
+	finderInfoSize = 32
+	// customIconID is the resource ID of custom icons, kCustomIconResource.
+	customIconID = -16455
+)
+
+// Info returns the Finder info of the file or folder at path, zero when it
+// has none.
+func Info(path string) (hfsplus.FinderInfo, error) {
+	var info hfsplus.FinderInfo
+	data, err := finderInfo(path)
+	if err != nil {
+		return info, err
+	}
+	return decodeInfo(data), nil
+}
+
+// SetInfo writes the Finder info of the file or folder at path, keeping
+// its extended Finder info.
+func SetInfo(path string, info hfsplus.FinderInfo) error {
+	old, err := finderInfo(path)
+	if err != nil {
+		return err
+	}
+	if err := unix.Setxattr(path, finderInfoAttr, encodeInfo(info, old), 0); err != nil {
+		return fmt.Errorf("failed to write Finder info of %s: %w", path, err)
+	}
+	return nil
+}
+
+// SetFlags sets flags, such as hfsplus.FinderIsInvisible, in the Finder
+// info of the file or folder at path.
+func SetFlags(path string, flags uint16) error {
+	info, err := Info(path)
+	if err != nil {
+		return err
+	}
+	info.Flags |= flags
+	return SetInfo(path, info)
+}
+
+// SetCreator sets the creator code of the file at path.
+func SetCreator(path, creator string) error {
+	if len(creator) != 4 {
+		return fmt.Errorf("invalid creator code %q", creator)
+	}
+	info, err := Info(path)
+	if err != nil {
+		return err
+	}
+	copy(info.Creator[:], creator)
+	return SetInfo(path, info)
+}
+
+// SetResourceFork replaces the resource fork of the file at path.
+func SetResourceFork(path string, fork []byte) error {
+	if err := unix.Setxattr(path, resourceForkAttr, fork, 0); err != nil {
+		return fmt.Errorf("failed to write resource fork of %s: %w", path, err)
+	}
+	return nil
+}
+
+// IconResourceFork returns a resource fork holding icns as a custom icon.
+func IconResourceFork(icns []byte) ([]byte, error) {
+	if len(icns) < 8 || string(icns[:4]) != "icns" {
+		return nil, errors.New("not an icns file")
+	}
+	return rsrc.Encode([]rsrc.Resource{{Type: "icns", ID: customIconID, Data: icns}})
+}
+
+// SetIcon gives the file at path the custom icon read from the icns file
+// at iconPath. Folders keep their custom icon in an Icon\r file instead,
+// and volumes in .VolumeIcon.icns.
+func SetIcon(path, iconPath string) error {
+	icns, err := os.ReadFile(iconPath)
+	if err != nil {
+		return fmt.Errorf("failed to read icon: %w", err)
+	}
+	fork, err := IconResourceFork(icns)
+	if err != nil {
+		return fmt.Errorf("%s: %w", iconPath, err)
+	}
+	if err := SetResourceFork(path, fork); err != nil {
+		return err
+	}
+	return SetFlags(path, hfsplus.FinderHasCustomIcon)
+}
+
+// finderInfo returns the com.apple.FinderInfo attribute of path, or nil
+// when it is not set.
+func finderInfo(path string) ([]byte, error) {
+	buf := make([]byte, finderInfoSize)
+	n, err := unix.Getxattr(path, finderInfoAttr, buf)
+	if errors.Is(err, errNoAttr) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read Finder info of %s: %w", path, err)
+	}
+	return buf[:n], nil
+}
+
+func decodeInfo(data []byte) hfsplus.FinderInfo {
+	var info hfsplus.FinderInfo
+	if len(data) >= 16 {
+		binary.Read(bytes.NewReader(data), binary.BigEndian, &info)
+	}
+	return info
+}
+
+// encodeInfo returns the com.apple.FinderInfo attribute holding info and
+// the extended Finder info of old.
+func encodeInfo(info hfsplus.FinderInfo, old []byte) []byte {
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.BigEndian, info)
+	data := make([]byte, finderInfoSize)
+	copy(data, buf.Bytes())
+	if len(old) == finderInfoSize {
+		copy(data[16:], old[16:])
+	}
+	return data
+}
@@ -0,0 +1,49 @@
+package finder
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"github.com/ironpark/zapp/pkg/mactools/hfsplus"
+)
+
+func TestEncodeInfo(t *testing.T) {
+	old := make([]byte, finderInfoSize)
+	copy(old, "TEXTttxt")
+	old[20] = 0x7f // extended Finder info
+	info := decodeInfo(old)
+	if string(info.Type[:]) != "TEXT" || string(info.Creator[:]) != "ttxt" {
+		t.Fatalf("decoded %+v", info)
+	}
+	info.Flags |= hfsplus.FinderHasCustomIcon | hfsplus.FinderIsInvisible
+	data := encodeInfo(info, old)
+	if len(data) != finderInfoSize || !bytes.Equal(data[:8], old[:8]) || data[20] != 0x7f {
+		t.Errorf("encoded % x", data)
+	}
+	if got := binary.BigEndian.Uint16(data[8:]); got != hfsplus.FinderHasCustomIcon|hfsplus.FinderIsInvisible {
+		t.Errorf("flags = %#x", got)
+	}
+	if data := encodeInfo(hfsplus.FinderInfo{Flags: hfsplus.FinderHasCustomIcon}, nil); len(data) != finderInfoSize {
+		t.Errorf("new Finder info is %d bytes", len(data))
+	}
+}
+
+func TestIconResourceFork(t *testing.T) {
+	icns := append([]byte("icns\x00\x00\x00\x10"), "ic07...."...)
+	fork, err := IconResourceFork(icns)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The icon is the only resource, right after the fork header.
+	size := binary.BigEndian.Uint32(fork[256:])
+	if !bytes.Equal(fork[260:260+size], icns) {
+		t.Error("icon data missing from the resource fork")
+	}
+	if !bytes.Contains(fork, []byte("icns\x00\x00")) {
+		t.Error("icns type missing from the resource map")
+	}
+	if _, err := IconResourceFork([]byte("\x89PNG\r\n\x1a\n")); err == nil {
+		t.Error("IconResourceFork accepted a PNG")
+	}
+}
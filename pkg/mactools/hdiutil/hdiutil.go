@@ -16,6 +16,8 @@ const (
 	UDCO         Format = "UDCO" // Compressed (ADC)
 	UDZO         Format = "UDZO" // Compressed (zlib)
 	UDBZ         Format = "UDBZ" // Compressed (bzip2)
+	ULFO         Format = "ULFO" // Compressed (LZFSE)
+	ULMO         Format = "ULMO" // Compressed (LZMA)
 	UFBI         Format = "UFBI" // Full block, single-partition image
 	UDTO         Format = "UDTO" // DVD/CD master
 	UDSP         Format = "UDSP" // Sparse disk image
@@ -31,6 +33,8 @@ var supportedFormats = map[Format]bool{
 	UDCO:         true,
 	UDZO:         true,
 	UDBZ:         true,
+	ULFO:         true,
+	ULMO:         true,
 	UFBI:         true,
 	UDTO:         true,
 	UDSP:         true,
@@ -40,6 +44,24 @@ var supportedFormats = map[Format]bool{
 	SPARSEBUNDLE: true,
 }
 
+// compressionLevelKeys are the -imagekey names of the compression level of
+// each compressed format. LZFSE has no levels, so ULFO has no key.
+var compressionLevelKeys = map[Format]string{
+	UDZO: "zlib-level",
+	UDBZ: "bzip2-level",
+	ULMO: "lzma-level",
+}
+
+// CompressionLevelArgs returns the hdiutil arguments setting the compression
+// level of format, none when level is empty or the format has no level.
+func CompressionLevelArgs(format Format, level string) []string {
+	key, ok := compressionLevelKeys[format]
+	if !ok || level == "" {
+		return nil
+	}
+	return []string{"-imagekey", key + "=" + level}
+}
+
 // Create creates a new DMG file
 func Create(ctx context.Context, volName, srcFolder string, format Format, outputFile string) error {
 	if !supportedFormats[format] {
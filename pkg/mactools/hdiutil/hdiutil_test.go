@@ -0,0 +1,25 @@
+package hdiutil
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCompressionLevelArgs(t *testing.T) {
+	for _, tc := range []struct {
+		format Format
+		level  string
+		want   []string
+	}{
+		{UDZO, "9", []string{"-imagekey", "zlib-level=9"}},
+		{UDBZ, "5", []string{"-imagekey", "bzip2-level=5"}},
+		{ULFO, "9", nil},
+		{ULMO, "6", []string{"-imagekey", "lzma-level=6"}},
+		{UDRO, "9", nil},
+		{UDZO, "", nil},
+	} {
+		if got := CompressionLevelArgs(tc.format, tc.level); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("CompressionLevelArgs(%s, %q) = %q, want %q", tc.format, tc.level, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,276 @@
+package hfsplus
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+const nodeDescriptorSize = 14
+
+// btree reads the nodes of one of the volume's B-tree special files.
+type btree struct {
+	r      io.ReaderAt
+	header headerRecord
+}
+
+func openBTree(r io.ReaderAt) (*btree, error) {
+	buf := make([]byte, nodeDescriptorSize+106)
+	if _, err := r.ReadAt(buf, 0); err != nil {
+		return nil, fmt.Errorf("failed to read B-tree header node: %w", err)
+	}
+	var desc nodeDescriptor
+	binary.Read(bytes.NewReader(buf), binary.BigEndian, &desc)
+	if desc.Kind != nodeHeader {
+		return nil, errors.New("B-tree header node not found")
+	}
+	t := &btree{r: r}
+	binary.Read(bytes.NewReader(buf[nodeDescriptorSize:]), binary.BigEndian, &t.header)
+	if t.header.NodeSize < 512 || t.header.NodeSize&(t.header.NodeSize-1) != 0 {
+		return nil, fmt.Errorf("invalid B-tree node size %d", t.header.NodeSize)
+	}
+	return t, nil
+}
+
+// readNode returns the descriptor and the records of node n.
+func (t *btree) readNode(n uint32) (nodeDescriptor, [][]byte, error) {
+	var desc nodeDescriptor
+	size := int(t.header.NodeSize)
+	buf := make([]byte, size)
+	if _, err := t.r.ReadAt(buf, int64(n)*int64(size)); err != nil {
+		return desc, nil, fmt.Errorf("failed to read B-tree node %d: %w", n, err)
+	}
+	binary.Read(bytes.NewReader(buf), binary.BigEndian, &desc)
+	count := int(desc.NumRecords)
+	if nodeDescriptorSize+2*(count+1) > size {
+		return desc, nil, fmt.Errorf("B-tree node %d has too many records", n)
+	}
+	offsets := make([]int, count+1)
+	for i := range offsets {
+		offsets[i] = int(binary.BigEndian.Uint16(buf[size-2*(i+1):]))
+	}
+	records := make([][]byte, count)
+	for i := 0; i < count; i++ {
+		start, end := offsets[i], offsets[i+1]
+		if start < nodeDescriptorSize || end < start || end > size {
+			return desc, nil, fmt.Errorf("B-tree node %d record %d out of range", n, i)
+		}
+		records[i] = buf[start:end]
+	}
+	return desc, records, nil
+}
+
+// forEachLeafRecord visits every leaf record in key order by following the
+// leaf node chain.
+func (t *btree) forEachLeafRecord(fn func(rec []byte) error) error {
+	return t.forEachLeafRecordFrom(t.header.FirstLeafNode, fn)
+}
+
+// forEachLeafRecordFrom visits leaf records starting at leaf node n.
+func (t *btree) forEachLeafRecordFrom(n uint32, fn func(rec []byte) error) error {
+	seen := map[uint32]bool{}
+	for n != 0 {
+		if seen[n] {
+			return errors.New("B-tree leaf chain loops")
+		}
+		seen[n] = true
+		desc, records, err := t.readNode(n)
+		if err != nil {
+			return err
+		}
+		if desc.Kind != nodeLeaf {
+			return fmt.Errorf("B-tree node %d is not a leaf", n)
+		}
+		for _, rec := range records {
+			if err := fn(rec); err != nil {
+				return err
+			}
+		}
+		n = desc.FLink
+	}
+	return nil
+}
+
+// findLeaf descends the index nodes to the leaf that holds the first record
+// not sorting before the target. before reports whether a key sorts before
+// the target.
+func (t *btree) findLeaf(before func(key []byte) bool) (uint32, error) {
+	n := t.header.RootNode
+	for depth := 0; depth <= int(t.header.TreeDepth); depth++ {
+		if n == 0 {
+			break
+		}
+		desc, records, err := t.readNode(n)
+		if err != nil {
+			return 0, err
+		}
+		if desc.Kind == nodeLeaf {
+			return n, nil
+		}
+		if desc.Kind != nodeIndex || len(records) == 0 {
+			return 0, fmt.Errorf("unexpected B-tree node %d", n)
+		}
+		next := uint32(0)
+		for i, rec := range records {
+			key, data, err := splitRecord(rec)
+			if err != nil {
+				return 0, err
+			}
+			if len(data) < 4 {
+				return 0, errors.New("truncated B-tree index record")
+			}
+			if i == 0 || before(key) {
+				next = binary.BigEndian.Uint32(data)
+				continue
+			}
+			break
+		}
+		n = next
+	}
+	return t.header.FirstLeafNode, nil
+}
+
+// splitRecord separates a leaf record into its key (without the length
+// prefix) and data.
+func splitRecord(rec []byte) (key, data []byte, err error) {
+	if len(rec) < 2 {
+		return nil, nil, errors.New("truncated B-tree record")
+	}
+	keyLen := int(binary.BigEndian.Uint16(rec))
+	if 2+keyLen > len(rec) {
+		return nil, nil, errors.New("B-tree key exceeds record")
+	}
+	return rec[2 : 2+keyLen], rec[2+keyLen:], nil
+}
+
+// B-tree header attributes.
+const (
+	btBigKeys           = 0x00000002
+	btVariableIndexKeys = 0x00000004
+
+	keyCaseFolding = 0xcf // catalog keys compared with FastUnicodeCompare
+)
+
+// treeSpec describes the geometry of a B-tree special file to build.
+type treeSpec struct {
+	nodeSize       int
+	maxKeyLength   uint16
+	attributes     uint32
+	keyCompareType uint8
+}
+
+// buildBTree packs sorted leaf records into a B-tree image. Each record is a
+// key, including its length prefix, followed by the record data. The image is
+// padded to at least minNodes nodes.
+func buildBTree(spec treeSpec, records [][]byte, minNodes int) ([]byte, error) {
+	type nodeRef struct {
+		num uint32
+		key []byte
+	}
+	var nodes [][]byte
+	// pack splits the records of one level into nodes and returns the number
+	// and first key of each.
+	pack := func(records, keys [][]byte, kind int8, height uint8) ([]nodeRef, error) {
+		var refs []nodeRef
+		for start := 0; start < len(records); {
+			used := nodeDescriptorSize + 2
+			end := start
+			for end < len(records) && used+len(records[end])+2 <= spec.nodeSize {
+				used += len(records[end]) + 2
+				end++
+			}
+			if end == start {
+				return nil, fmt.Errorf("B-tree record of %d bytes does not fit a node", len(records[start]))
+			}
+			num := uint32(len(nodes) + 1)
+			node := make([]byte, spec.nodeSize)
+			desc := nodeDescriptor{Kind: kind, Height: height, NumRecords: uint16(end - start)}
+			if len(refs) > 0 {
+				// Link the previous node of the level to this one.
+				desc.BLink = refs[len(refs)-1].num
+				binary.BigEndian.PutUint32(nodes[desc.BLink-1], num)
+			}
+			putNodeDescriptor(node, desc)
+			off := nodeDescriptorSize
+			for i, rec := range records[start:end] {
+				binary.BigEndian.PutUint16(node[spec.nodeSize-2*(i+1):], uint16(off))
+				off += copy(node[off:], rec)
+			}
+			binary.BigEndian.PutUint16(node[spec.nodeSize-2*(end-start+1):], uint16(off))
+			nodes = append(nodes, node)
+			refs = append(refs, nodeRef{num: num, key: keys[start]})
+			start = end
+		}
+		return refs, nil
+	}
+
+	var hdr headerRecord
+	if len(records) > 0 {
+		keys := make([][]byte, len(records))
+		for i, rec := range records {
+			keys[i] = rec[:2+int(binary.BigEndian.Uint16(rec))]
+		}
+		refs, err := pack(records, keys, nodeLeaf, 1)
+		if err != nil {
+			return nil, err
+		}
+		hdr.FirstLeafNode = refs[0].num
+		hdr.LastLeafNode = refs[len(refs)-1].num
+		hdr.LeafRecords = uint32(len(records))
+		hdr.TreeDepth = 1
+		for len(refs) > 1 {
+			index := make([][]byte, len(refs))
+			keys := make([][]byte, len(refs))
+			for i, ref := range refs {
+				index[i] = binary.BigEndian.AppendUint32(bytes.Clone(ref.key), ref.num)
+				keys[i] = ref.key
+			}
+			hdr.TreeDepth++
+			if refs, err = pack(index, keys, nodeIndex, uint8(hdr.TreeDepth)); err != nil {
+				return nil, err
+			}
+		}
+		hdr.RootNode = refs[0].num
+	}
+
+	total := max(len(nodes)+1, minNodes)
+	mapBytes := spec.nodeSize - 256
+	if total > mapBytes*8 {
+		return nil, fmt.Errorf("B-tree needs %d nodes, more than a header node can map", total)
+	}
+	hdr.NodeSize = uint16(spec.nodeSize)
+	hdr.MaxKeyLength = spec.maxKeyLength
+	hdr.TotalNodes = uint32(total)
+	hdr.FreeNodes = uint32(total - len(nodes) - 1)
+	hdr.ClumpSize = uint32(total * spec.nodeSize)
+	hdr.Attributes = spec.attributes
+	hdr.KeyCompareType = spec.keyCompareType
+
+	out := make([]byte, total*spec.nodeSize)
+	head := out[:spec.nodeSize]
+	putNodeDescriptor(head, nodeDescriptor{Kind: nodeHeader, NumRecords: 3})
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.BigEndian, &hdr)
+	copy(head[nodeDescriptorSize:], buf.Bytes())
+	// Header record, 128 byte user data record, then the node map.
+	for i, off := range []int{nodeDescriptorSize, 120, 248, spec.nodeSize - 8} {
+		binary.BigEndian.PutUint16(head[spec.nodeSize-2*(i+1):], uint16(off))
+	}
+	for n := 0; n <= len(nodes); n++ {
+		head[248+n/8] |= 0x80 >> (n % 8)
+	}
+	for i, node := range nodes {
+		copy(out[(i+1)*spec.nodeSize:], node)
+	}
+	return out, nil
+}
+
+func putNodeDescriptor(b []byte, d nodeDescriptor) {
+	binary.BigEndian.PutUint32(b, d.FLink)
+	binary.BigEndian.PutUint32(b[4:], d.BLink)
+	b[8] = byte(d.Kind)
+	b[9] = d.Height
+	binary.BigEndian.PutUint16(b[10:], d.NumRecords)
+}
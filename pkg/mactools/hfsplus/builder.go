@@ -0,0 +1,765 @@
+package hfsplus
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf16"
+)
+
+const (
+	// DefaultBlockSize is the allocation block size of built volumes.
+	DefaultBlockSize = 4096
+	// DefaultFreeSpace is the room left on built volumes beyond their contents.
+	DefaultFreeSpace = 1 << 20
+
+	catalogNodeSize    = 8192
+	extentsNodeSize    = 4096
+	attributesNodeSize = 8192
+
+	// maxInlineAttrSize is the largest attribute value the kernel stores
+	// inline in an 8 KiB attributes node; larger values get their own fork.
+	maxInlineAttrSize = 3802
+	maxAttrNameLength = 127
+
+	volumeUnmounted    = 0x00000100
+	lastMountedVersion = 0x31302e30 // "10.0", a volume that was never journaled
+	threadExists       = 0x0002
+	hasAttributes      = 0x0004 // the record has entries in the attributes file
+	clumpSize          = 64 * 1024
+
+	// unknownID is the user and group macOS maps to the current user on
+	// volumes that ignore ownership, as disk images do by default.
+	unknownID = 99
+)
+
+// Options controls the geometry of a built volume.
+type Options struct {
+	BlockSize uint32
+	FreeSpace int64
+}
+
+type Option func(*Options)
+
+// WithBlockSize sets the allocation block size, a power of two of at least 512.
+func WithBlockSize(size uint32) Option {
+	return func(o *Options) {
+		o.BlockSize = size
+	}
+}
+
+// WithFreeSpace sets how many bytes to leave unallocated on the volume.
+func WithFreeSpace(size int64) Option {
+	return func(o *Options) {
+		o.FreeSpace = size
+	}
+}
+
+// Node is a file, folder or symbolic link to be written by a Builder.
+// Regular files take their data fork from Source, or from Data when Source
+// is empty.
+type Node struct {
+	Name       string
+	Mode       os.FileMode
+	ModTime    time.Time
+	CreateTime time.Time
+	UID        uint32
+	GID        uint32
+	Finder     FinderInfo
+
+	Data         []byte
+	Source       string
+	Target       string
+	ResourceFork []byte
+	// Xattrs holds extended attributes other than com.apple.FinderInfo and
+	// com.apple.ResourceFork, which are the Finder and ResourceFork fields.
+	Xattrs   map[string][]byte
+	Children []*Node
+
+	id       uint32
+	name     []uint16
+	size     int64
+	dataFork ForkData
+	rsrcFork ForkData
+}
+
+// IsDir reports whether n is a folder.
+func (n *Node) IsDir() bool {
+	return n.Mode.IsDir()
+}
+
+// NewNode returns a node named name with the given mode, dated now and
+// owned by the unknown user.
+func NewNode(name string, mode os.FileMode) *Node {
+	now := time.Now()
+	return &Node{
+		Name:       name,
+		Mode:       mode,
+		ModTime:    now,
+		CreateTime: now,
+		UID:        unknownID,
+		GID:        unknownID,
+	}
+}
+
+// NodeFromPath reads the file, folder or symbolic link at src, including
+// everything below a folder, into a node tree. File contents are read when
+// the volume is written.
+func NodeFromPath(src string) (*Node, error) {
+	info, err := os.Lstat(src)
+	if err != nil {
+		return nil, err
+	}
+	n := &Node{
+		Name:       filepath.Base(src),
+		Mode:       info.Mode() & (os.ModeDir | os.ModeSymlink | os.ModePerm | os.ModeSetuid | os.ModeSetgid | os.ModeSticky),
+		ModTime:    info.ModTime(),
+		CreateTime: info.ModTime(),
+		UID:        unknownID,
+		GID:        unknownID,
+	}
+	switch {
+	case info.Mode()&os.ModeSymlink != 0:
+		if n.Target, err = os.Readlink(src); err != nil {
+			return nil, err
+		}
+	case info.IsDir():
+		entries, err := os.ReadDir(src)
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			child, err := NodeFromPath(filepath.Join(src, entry.Name()))
+			if err != nil {
+				return nil, err
+			}
+			n.Children = append(n.Children, child)
+		}
+	case info.Mode().IsRegular():
+		n.Source = src
+	default:
+		return nil, fmt.Errorf("unsupported file type %s: %s", info.Mode().Type(), src)
+	}
+	return n, nil
+}
+
+// Builder assembles an HFS+ volume from a tree of nodes and writes it as a
+// raw partition image, sized to fit its contents.
+type Builder struct {
+	Root *Node
+	opts Options
+}
+
+// NewBuilder returns a builder for an empty volume named volumeName.
+func NewBuilder(volumeName string, opts ...Option) *Builder {
+	o := Options{
+		BlockSize: DefaultBlockSize,
+		FreeSpace: DefaultFreeSpace,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return &Builder{
+		Root: NewNode(volumeName, os.ModeDir|0755),
+		opts: o,
+	}
+}
+
+// Lookup returns the node at the slash separated path p, relative to the
+// volume root. Names are compared the way HFS+ does, ignoring case.
+func (b *Builder) Lookup(p string) (*Node, error) {
+	n := b.Root
+	for _, name := range strings.Split(strings.Trim(path.Clean("/"+p), "/"), "/") {
+		if name == "" {
+			continue
+		}
+		if !n.IsDir() {
+			return nil, fmt.Errorf("%s: %w", p, ErrNotExist)
+		}
+		if n = findChild(n, name); n == nil {
+			return nil, fmt.Errorf("%s: %w", p, ErrNotExist)
+		}
+	}
+	return n, nil
+}
+
+func findChild(dir *Node, name string) *Node {
+	name = decomposeName(name)
+	for _, c := range dir.Children {
+		if CompareNames(decomposeName(c.Name), name) == 0 {
+			return c
+		}
+	}
+	return nil
+}
+
+// Add places n in the folder at path dir.
+func (b *Builder) Add(dir string, n *Node) error {
+	parent, err := b.Lookup(dir)
+	if err != nil {
+		return err
+	}
+	if !parent.IsDir() {
+		return fmt.Errorf("%s is not a folder", dir)
+	}
+	if findChild(parent, n.Name) != nil {
+		return fmt.Errorf("%s already exists in %q", n.Name, dir)
+	}
+	parent.Children = append(parent.Children, n)
+	return nil
+}
+
+// SetTimes dates every node of the volume, including the root, t.
+func (b *Builder) SetTimes(t time.Time) {
+	var walk func(n *Node)
+	walk = func(n *Node) {
+		n.ModTime = t
+		n.CreateTime = t
+		for _, c := range n.Children {
+			walk(c)
+		}
+	}
+	walk(b.Root)
+}
+
+// AddStagingDir adds the contents of srcDir to the volume root and applies
+// the Finder conventions of a disk image staging folder: .background is
+// hidden, and a .VolumeIcon.icns file becomes the volume's custom icon.
+func (b *Builder) AddStagingDir(srcDir string) error {
+	entries, err := os.ReadDir(srcDir)
+	if err != nil {
+		return fmt.Errorf("failed to read staging directory: %w", err)
+	}
+	for _, entry := range entries {
+		n, err := NodeFromPath(filepath.Join(srcDir, entry.Name()))
+		if err != nil {
+			return err
+		}
+		if err := b.Add("", n); err != nil {
+			return err
+		}
+	}
+	if n := findChild(b.Root, ".background"); n != nil {
+		n.Finder.Flags |= FinderIsInvisible
+	}
+	if n := findChild(b.Root, ".VolumeIcon.icns"); n != nil {
+		n.Finder.Flags |= FinderIsInvisible
+		copy(n.Finder.Creator[:], "icnC")
+		b.Root.Finder.Flags |= FinderHasCustomIcon
+	}
+	return nil
+}
+
+// attribute is an extended attribute record to be written.
+type attribute struct {
+	fileID uint32
+	name   []uint16
+	value  []byte
+	fork   ForkData
+}
+
+// layout is the block allocation of a volume being written.
+type layout struct {
+	blockSize   int64
+	totalBlocks uint32
+	next        uint32
+	nodes       []*Node
+	attrs       []*attribute
+	files       uint32
+	folders     uint32
+
+	bitmap     ForkData
+	extents    ForkData
+	catalog    ForkData
+	attributes ForkData
+}
+
+func (l *layout) alloc(size int64) ForkData {
+	fd := ForkData{LogicalSize: uint64(size)}
+	if size == 0 {
+		return fd
+	}
+	blocks := uint32((size + l.blockSize - 1) / l.blockSize)
+	fd.TotalBlocks = blocks
+	fd.Extents[0] = Extent{StartBlock: l.next, BlockCount: blocks}
+	l.next += blocks
+	return fd
+}
+
+func (l *layout) blocks(size int64) uint32 {
+	return uint32((size + l.blockSize - 1) / l.blockSize)
+}
+
+// WriteTo writes the volume image to w.
+func (b *Builder) WriteTo(w io.Writer) (int64, error) {
+	bs := b.opts.BlockSize
+	if bs < 512 || bs&(bs-1) != 0 {
+		return 0, fmt.Errorf("invalid block size %d", bs)
+	}
+	l := &layout{blockSize: int64(bs)}
+	if err := l.prepare(b.Root); err != nil {
+		return 0, err
+	}
+
+	// The B-tree sizes only depend on the number and length of records, so
+	// measure them before the forks they describe have been placed.
+	catalog, err := l.buildCatalog()
+	if err != nil {
+		return 0, err
+	}
+	attributes, err := l.buildAttributes()
+	if err != nil {
+		return 0, err
+	}
+	extents, err := buildBTree(treeSpec{nodeSize: extentsNodeSize, maxKeyLength: 10, attributes: btBigKeys}, nil, l.minNodes(extentsNodeSize))
+	if err != nil {
+		return 0, err
+	}
+
+	reserved := l.blocks(VolumeHeaderOffset + 512)
+	tail := l.blocks(1024)
+	used := l.blocks(int64(len(extents))) + l.blocks(int64(len(catalog))) + l.blocks(int64(len(attributes)))
+	for _, a := range l.attrs {
+		if len(a.value) > maxInlineAttrSize {
+			used += l.blocks(int64(len(a.value)))
+		}
+	}
+	for _, n := range l.nodes {
+		used += l.blocks(n.size) + l.blocks(int64(len(n.ResourceFork)))
+	}
+	free := l.blocks(b.opts.FreeSpace)
+	var bitmapBlocks uint32
+	for {
+		total := uint64(reserved) + uint64(bitmapBlocks) + uint64(used) + uint64(free) + uint64(tail)
+		if total > 0xffffffff {
+			return 0, errors.New("volume contents exceed the maximum number of allocation blocks")
+		}
+		need := l.blocks(int64((total + 7) / 8))
+		l.totalBlocks = uint32(total)
+		if need == bitmapBlocks {
+			break
+		}
+		bitmapBlocks = need
+	}
+
+	l.next = reserved
+	l.bitmap = l.alloc(int64(bitmapBlocks) * l.blockSize)
+	l.extents = l.alloc(int64(len(extents)))
+	l.catalog = l.alloc(int64(len(catalog)))
+	l.attributes = l.alloc(int64(len(attributes)))
+	for _, a := range l.attrs {
+		if len(a.value) > maxInlineAttrSize {
+			a.fork = l.alloc(int64(len(a.value)))
+		}
+	}
+	for _, n := range l.nodes {
+		n.dataFork = l.alloc(n.size)
+		n.rsrcFork = l.alloc(int64(len(n.ResourceFork)))
+	}
+	firstFree := l.next
+	if catalog, err = l.buildCatalog(); err != nil {
+		return 0, err
+	}
+	if attributes, err = l.buildAttributes(); err != nil {
+		return 0, err
+	}
+
+	hdr := l.volumeHeader(b.Root, firstFree, l.totalBlocks-firstFree-tail)
+	var hdrBuf bytes.Buffer
+	binary.Write(&hdrBuf, binary.BigEndian, &hdr)
+
+	bw := &blockWriter{w: w, blockSize: l.blockSize}
+	bw.zeros(VolumeHeaderOffset)
+	bw.Write(hdrBuf.Bytes())
+	bw.pad()
+	bw.Write(l.allocationBitmap(firstFree, tail))
+	bw.pad()
+	for _, tree := range [][]byte{extents, catalog, attributes} {
+		bw.Write(tree)
+		bw.pad()
+	}
+	for _, a := range l.attrs {
+		if a.fork.TotalBlocks > 0 {
+			bw.Write(a.value)
+			bw.pad()
+		}
+	}
+	for _, n := range l.nodes {
+		if bw.err == nil && n.dataFork.TotalBlocks > 0 {
+			bw.err = n.writeData(bw)
+			bw.pad()
+		}
+		bw.Write(n.ResourceFork)
+		bw.pad()
+	}
+	if bw.err == nil && bw.n != int64(firstFree)*l.blockSize {
+		return bw.n, errors.New("volume layout mismatch")
+	}
+	end := int64(l.totalBlocks) * l.blockSize
+	bw.zeros(end - 1024 - bw.n)
+	bw.Write(hdrBuf.Bytes())
+	bw.zeros(512)
+	return bw.n, bw.err
+}
+
+// prepare numbers the nodes, validates names and collects the extended
+// attributes.
+func (l *layout) prepare(root *Node) error {
+	if !root.IsDir() {
+		return errors.New("volume root must be a folder")
+	}
+	root.id = RootFolderID
+	root.name = encodeName(root.Name)
+	if len(root.name) == 0 || len(root.name) > 255 {
+		return fmt.Errorf("invalid volume name %q", root.Name)
+	}
+	nextID := uint32(FirstUserCatalogNode)
+	var walk func(n *Node) error
+	walk = func(n *Node) error {
+		l.nodes = append(l.nodes, n)
+		switch {
+		case n.IsDir():
+			if len(n.ResourceFork) > 0 {
+				return fmt.Errorf("%s: folders cannot have a resource fork", n.Name)
+			}
+			sort.SliceStable(n.Children, func(i, j int) bool {
+				return CompareNames(decomposeName(n.Children[i].Name), decomposeName(n.Children[j].Name)) < 0
+			})
+			for _, c := range n.Children {
+				if c.Name == "" || strings.Contains(c.Name, "/") {
+					return fmt.Errorf("invalid file name %q", c.Name)
+				}
+				c.id = nextID
+				nextID++
+				if c.name = encodeName(c.Name); len(c.name) > 255 {
+					return fmt.Errorf("file name too long: %s", c.Name)
+				}
+				if c.IsDir() {
+					l.folders++
+				} else {
+					l.files++
+				}
+				if err := walk(c); err != nil {
+					return err
+				}
+			}
+		case n.Mode&os.ModeSymlink != 0:
+			n.size = int64(len(n.Target))
+		case n.Source != "":
+			info, err := os.Stat(n.Source)
+			if err != nil {
+				return err
+			}
+			n.size = info.Size()
+		default:
+			n.size = int64(len(n.Data))
+		}
+		names := make([]string, 0, len(n.Xattrs))
+		for name := range n.Xattrs {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			if name == "com.apple.FinderInfo" || name == "com.apple.ResourceFork" {
+				return fmt.Errorf("%s: %s must be set through the node fields", n.Name, name)
+			}
+			u := utf16.Encode([]rune(name))
+			if len(u) == 0 || len(u) > maxAttrNameLength {
+				return fmt.Errorf("%s: invalid attribute name %q", n.Name, name)
+			}
+			l.attrs = append(l.attrs, &attribute{fileID: n.id, name: u, value: n.Xattrs[name]})
+		}
+		return nil
+	}
+	if err := walk(root); err != nil {
+		return err
+	}
+	sort.SliceStable(l.attrs, func(i, j int) bool {
+		a, b := l.attrs[i], l.attrs[j]
+		if a.fileID != b.fileID {
+			return a.fileID < b.fileID
+		}
+		return compareBinary(a.name, b.name) < 0
+	})
+	return nil
+}
+
+// minNodes returns how many nodes of nodeSize fill one allocation block.
+func (l *layout) minNodes(nodeSize int) int {
+	return max(1, int(l.blockSize)/nodeSize)
+}
+
+type catalogRecord struct {
+	parentID uint32
+	name     []uint16
+	data     []byte
+}
+
+func catalogKey(parentID uint32, name []uint16) []byte {
+	b := make([]byte, 2, 8+2*len(name))
+	b = binary.BigEndian.AppendUint32(b, parentID)
+	b = putUnicode(b, name)
+	binary.BigEndian.PutUint16(b, uint16(len(b)-2))
+	return b
+}
+
+func threadRecord(recordType int16, parentID uint32, name []uint16) []byte {
+	b := binary.BigEndian.AppendUint16(nil, uint16(recordType))
+	b = append(b, 0, 0)
+	b = binary.BigEndian.AppendUint32(b, parentID)
+	return putUnicode(b, name)
+}
+
+func (l *layout) buildCatalog() ([]byte, error) {
+	var records []catalogRecord
+	add := func(parent, n *Node) {
+		parentID := uint32(RootParentID)
+		if parent != nil {
+			parentID = parent.id
+		}
+		perm := BSDInfo{OwnerID: n.UID, GroupID: n.GID, FileMode: bsdMode(n.Mode)}
+		var flags uint16
+		if len(n.Xattrs) > 0 {
+			flags |= hasAttributes
+		}
+		var buf bytes.Buffer
+		if n.IsDir() {
+			binary.Write(&buf, binary.BigEndian, &catalogFolder{
+				RecordType:       recordFolder,
+				Flags:            flags,
+				Valence:          uint32(len(n.Children)),
+				FolderID:         n.id,
+				CreateDate:       toHFSDate(n.CreateTime),
+				ContentModDate:   toHFSDate(n.ModTime),
+				AttributeModDate: toHFSDate(n.ModTime),
+				AccessDate:       toHFSDate(n.ModTime),
+				Permissions:      perm,
+				UserInfo:         n.Finder,
+			})
+			records = append(records, catalogRecord{n.id, nil, threadRecord(recordFolderThread, parentID, n.name)})
+		} else {
+			finder := n.Finder
+			if n.Mode&os.ModeSymlink != 0 {
+				copy(finder.Type[:], "slnk")
+				copy(finder.Creator[:], "rhap")
+			}
+			binary.Write(&buf, binary.BigEndian, &catalogFile{
+				RecordType:       recordFile,
+				Flags:            flags | threadExists,
+				FileID:           n.id,
+				CreateDate:       toHFSDate(n.CreateTime),
+				ContentModDate:   toHFSDate(n.ModTime),
+				AttributeModDate: toHFSDate(n.ModTime),
+				AccessDate:       toHFSDate(n.ModTime),
+				Permissions:      perm,
+				UserInfo:         finder,
+				DataFork:         n.dataFork,
+				ResourceFork:     n.rsrcFork,
+			})
+			records = append(records, catalogRecord{n.id, nil, threadRecord(recordFileThread, parentID, n.name)})
+		}
+		records = append(records, catalogRecord{parentID, n.name, buf.Bytes()})
+	}
+	var walk func(parent, n *Node)
+	walk = func(parent, n *Node) {
+		add(parent, n)
+		for _, c := range n.Children {
+			walk(n, c)
+		}
+	}
+	walk(nil, l.nodes[0])
+
+	sort.Slice(records, func(i, j int) bool {
+		a, b := records[i], records[j]
+		if a.parentID != b.parentID {
+			return a.parentID < b.parentID
+		}
+		return compareUnicode(a.name, b.name) < 0
+	})
+	leaves := make([][]byte, len(records))
+	for i, r := range records {
+		leaves[i] = append(catalogKey(r.parentID, r.name), r.data...)
+	}
+	return buildBTree(treeSpec{
+		nodeSize:       catalogNodeSize,
+		maxKeyLength:   516,
+		attributes:     btBigKeys | btVariableIndexKeys,
+		keyCompareType: keyCaseFolding,
+	}, leaves, l.minNodes(catalogNodeSize))
+}
+
+func (l *layout) buildAttributes() ([]byte, error) {
+	leaves := make([][]byte, len(l.attrs))
+	for i, a := range l.attrs {
+		rec := make([]byte, 4, 16+2*len(a.name)+len(a.value))
+		rec = binary.BigEndian.AppendUint32(rec, a.fileID)
+		rec = binary.BigEndian.AppendUint32(rec, 0)
+		rec = putUnicode(rec, a.name)
+		binary.BigEndian.PutUint16(rec, uint16(len(rec)-2))
+		if len(a.value) > maxInlineAttrSize {
+			rec = binary.BigEndian.AppendUint32(rec, attrForkData)
+			rec = append(rec, 0, 0, 0, 0)
+			var buf bytes.Buffer
+			binary.Write(&buf, binary.BigEndian, &a.fork)
+			rec = append(rec, buf.Bytes()...)
+		} else {
+			rec = binary.BigEndian.AppendUint32(rec, attrInlineData)
+			rec = append(rec, make([]byte, 8)...)
+			rec = binary.BigEndian.AppendUint32(rec, uint32(len(a.value)))
+			rec = append(rec, a.value...)
+			if len(rec)%2 != 0 {
+				rec = append(rec, 0)
+			}
+		}
+		leaves[i] = rec
+	}
+	return buildBTree(treeSpec{
+		nodeSize:     attributesNodeSize,
+		maxKeyLength: 266,
+		attributes:   btBigKeys | btVariableIndexKeys,
+	}, leaves, l.minNodes(attributesNodeSize))
+}
+
+func (l *layout) volumeHeader(root *Node, firstFree, freeBlocks uint32) VolumeHeader {
+	now := toHFSDate(root.ModTime)
+	// TN1150 has the volume creation date in local time, unlike every other
+	// date. It is kept in UTC so that the image, and the volume identifier
+	// derived from it below, do not depend on the time zone of the machine
+	// building it.
+	hdr := VolumeHeader{
+		Signature:          SignatureHFSPlus,
+		Version:            4,
+		Attributes:         volumeUnmounted,
+		LastMountedVersion: lastMountedVersion,
+		CreateDate:         toHFSDate(root.CreateTime),
+		ModifyDate:         now,
+		CheckedDate:        now,
+		FileCount:          l.files,
+		FolderCount:        l.folders,
+		BlockSize:          uint32(l.blockSize),
+		TotalBlocks:        l.totalBlocks,
+		FreeBlocks:         freeBlocks,
+		NextAllocation:     firstFree,
+		RsrcClumpSize:      clumpSize,
+		DataClumpSize:      clumpSize,
+		NextCatalogID:      FirstUserCatalogNode + l.files + l.folders,
+		EncodingsBitmap:    1, // MacRoman
+		AllocationFile:     l.bitmap,
+		ExtentsFile:        l.extents,
+		CatalogFile:        l.catalog,
+		AttributesFile:     l.attributes,
+	}
+	for _, fd := range []*ForkData{&hdr.AllocationFile, &hdr.ExtentsFile, &hdr.CatalogFile, &hdr.AttributesFile} {
+		fd.ClumpSize = uint32(fd.LogicalSize)
+	}
+	// The last two words hold the volume identifier used by Spotlight and
+	// the Finder; derive it from the name and creation date so that equal
+	// inputs give equal images.
+	sum := sha256.Sum256(append([]byte(root.Name), byte(hdr.CreateDate>>24), byte(hdr.CreateDate>>16), byte(hdr.CreateDate>>8), byte(hdr.CreateDate)))
+	hdr.FinderInfo[6] = binary.BigEndian.Uint32(sum[0:])
+	hdr.FinderInfo[7] = binary.BigEndian.Uint32(sum[4:])
+	return hdr
+}
+
+// allocationBitmap marks the blocks in use: everything before firstFree and
+// the tail blocks holding the alternate volume header.
+func (l *layout) allocationBitmap(firstFree, tail uint32) []byte {
+	bitmap := make([]byte, l.bitmap.LogicalSize)
+	mark := func(from, to uint32) {
+		for b := from; b < to; b++ {
+			bitmap[b/8] |= 0x80 >> (b % 8)
+		}
+	}
+	mark(0, firstFree)
+	mark(l.totalBlocks-tail, l.totalBlocks)
+	return bitmap
+}
+
+func (n *Node) writeData(w io.Writer) error {
+	var err error
+	switch {
+	case n.Mode&os.ModeSymlink != 0:
+		_, err = io.WriteString(w, n.Target)
+	case n.Source != "":
+		var f *os.File
+		if f, err = os.Open(n.Source); err != nil {
+			return err
+		}
+		defer f.Close()
+		var copied int64
+		copied, err = io.CopyN(w, f, n.size)
+		if err == io.EOF || (err == nil && copied != n.size) {
+			err = fmt.Errorf("%s changed size while writing the volume", n.Source)
+		}
+	default:
+		_, err = w.Write(n.Data)
+	}
+	return err
+}
+
+func bsdMode(m os.FileMode) uint16 {
+	mode := uint16(m.Perm())
+	if m&os.ModeSetuid != 0 {
+		mode |= 0o4000
+	}
+	if m&os.ModeSetgid != 0 {
+		mode |= 0o2000
+	}
+	if m&os.ModeSticky != 0 {
+		mode |= 0o1000
+	}
+	switch {
+	case m.IsDir():
+		mode |= modeDir
+	case m&os.ModeSymlink != 0:
+		mode |= modeSymlink
+	default:
+		mode |= modeRegular
+	}
+	return mode
+}
+
+// blockWriter tracks the write offset and remembers the first error, so the
+// volume can be streamed without checking every call.
+type blockWriter struct {
+	w         io.Writer
+	blockSize int64
+	n         int64
+	err       error
+}
+
+var zeroBlock [64 * 1024]byte
+
+func (bw *blockWriter) Write(p []byte) (int, error) {
+	if bw.err != nil {
+		return 0, bw.err
+	}
+	n, err := bw.w.Write(p)
+	bw.n += int64(n)
+	bw.err = err
+	return n, err
+}
+
+func (bw *blockWriter) zeros(n int64) {
+	for n > 0 && bw.err == nil {
+		chunk := min(n, int64(len(zeroBlock)))
+		bw.Write(zeroBlock[:chunk])
+		n -= chunk
+	}
+}
+
+// pad fills the rest of the current allocation block with zeros.
+func (bw *blockWriter) pad() {
+	if rem := bw.n % bw.blockSize; rem != 0 {
+		bw.zeros(bw.blockSize - rem)
+	}
+}
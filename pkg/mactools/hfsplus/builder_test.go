@@ -0,0 +1,237 @@
+package hfsplus
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writeStaging(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	files := map[string]string{
+		"Test.app/Contents/Info.plist":       "<plist/>",
+		"Test.app/Contents/MacOS/Test":       "#!/bin/sh\necho test\n",
+		"Test.app/Contents/Resources/a:b":    "colon",
+		"Test.app/Contents/Resources/Résumé": "accent",
+		".background/background.png":         "png",
+		".VolumeIcon.icns":                   "icns",
+		"README":                             string(bytes.Repeat([]byte("zapp"), 5000)),
+		"empty":                              "",
+	}
+	for name, data := range files {
+		p := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Chmod(filepath.Join(dir, "Test.app/Contents/MacOS/Test"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("/Applications", filepath.Join(dir, "Applications")); err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func buildVolume(t *testing.T, b *Builder) *Volume {
+	t.Helper()
+	var buf bytes.Buffer
+	n, err := b.WriteTo(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != int64(buf.Len()) || n%DefaultBlockSize != 0 {
+		t.Fatalf("wrote %d bytes, buffer holds %d", n, buf.Len())
+	}
+	v, err := Open(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
+
+func TestBuilderRoundTrip(t *testing.T) {
+	dir := writeStaging(t)
+	b := NewBuilder("Test Volume")
+	if err := b.AddStagingDir(dir); err != nil {
+		t.Fatal(err)
+	}
+	app, err := b.Lookup("test.APP")
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.Finder.Flags |= FinderHasBundle
+	err = b.Add("", &Node{
+		Name:         "Forks",
+		Mode:         0644,
+		Data:         []byte("data"),
+		ResourceFork: []byte("resource"),
+		Xattrs: map[string][]byte{
+			"com.example.small": []byte("value"),
+			"com.example.large": bytes.Repeat([]byte{1, 2, 3}, 3000),
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	v := buildVolume(t, b)
+
+	if v.VolumeName() != "Test Volume" {
+		t.Fatalf("volume name %q", v.VolumeName())
+	}
+	if v.Root().Finder.Flags&FinderHasCustomIcon == 0 {
+		t.Fatal("volume custom icon bit not set")
+	}
+	for name, data := range map[string]string{
+		"Test.app/Contents/Info.plist":       "<plist/>",
+		"Test.app/Contents/Resources/a:b":    "colon",
+		"Test.app/Contents/Resources/Résumé": "accent",
+		"README":                             string(bytes.Repeat([]byte("zapp"), 5000)),
+		"empty":                              "",
+		"Forks":                              "data",
+	} {
+		e, err := v.Lookup(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r, err := v.OpenFile(e)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, _ := io.ReadAll(r)
+		if string(got) != data {
+			t.Fatalf("%s: got %q", name, got)
+		}
+	}
+	exe, _ := v.Lookup("Test.app/Contents/MacOS/Test")
+	if exe.Mode.Perm() != 0755 {
+		t.Fatalf("executable mode %v", exe.Mode)
+	}
+	link, _ := v.Lookup("Applications")
+	if target, err := v.ReadLink(link); err != nil || target != "/Applications" {
+		t.Fatalf("Applications link: %q, %v", target, err)
+	}
+	for _, name := range []string{".background", ".VolumeIcon.icns"} {
+		if e, _ := v.Lookup(name); e.Finder.Flags&FinderIsInvisible == 0 {
+			t.Fatalf("%s is not invisible", name)
+		}
+	}
+	if e, _ := v.Lookup("Test.app"); e.Finder.Flags&FinderHasBundle == 0 {
+		t.Fatal("Finder flags of Test.app not preserved")
+	}
+
+	forks, _ := v.Lookup("Forks")
+	rsrc, _ := io.ReadAll(v.OpenResourceFork(forks))
+	if string(rsrc) != "resource" {
+		t.Fatalf("resource fork %q", rsrc)
+	}
+	attrs, err := v.ExtendedAttributes(forks)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(attrs["com.example.small"]) != "value" || !bytes.Equal(attrs["com.example.large"], bytes.Repeat([]byte{1, 2, 3}, 3000)) {
+		t.Fatalf("extended attributes not preserved: %d", len(attrs))
+	}
+	if forks.recordFlags&hasAttributes == 0 {
+		t.Fatal("attributes flag not set on a file with extended attributes")
+	}
+	if e, _ := v.Lookup("README"); e.recordFlags&hasAttributes != 0 {
+		t.Fatal("attributes flag set on a file without extended attributes")
+	}
+	if v.Header.FileCount != 10 || v.Header.FolderCount != 5 {
+		t.Fatalf("file count %d, folder count %d", v.Header.FileCount, v.Header.FolderCount)
+	}
+}
+
+// TestBuilderLargeCatalog checks that multi-level B-trees are built in key
+// order and searchable.
+func TestBuilderLargeCatalog(t *testing.T) {
+	b := NewBuilder("Many")
+	mtime := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	for i := 0; i < 3000; i++ {
+		err := b.Add("", &Node{
+			Name:    fmt.Sprintf("File %04d", 2999-i),
+			Mode:    0644,
+			ModTime: mtime,
+			Data:    []byte{byte(i)},
+			Xattrs:  map[string][]byte{"com.example.id": []byte(fmt.Sprint(2999 - i))},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	v := buildVolume(t, b)
+
+	catalog, err := openBTree(v.fork(CatalogFileID, forkData, v.Header.CatalogFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if catalog.header.TreeDepth < 2 {
+		t.Fatalf("expected an index level, depth %d", catalog.header.TreeDepth)
+	}
+	var prevParent uint32
+	var prevName []uint16
+	err = catalog.forEachLeafRecord(func(rec []byte) error {
+		key, _, err := splitRecord(rec)
+		if err != nil {
+			return err
+		}
+		parent := binary.BigEndian.Uint32(key)
+		name := make([]uint16, binary.BigEndian.Uint16(key[4:]))
+		for i := range name {
+			name[i] = binary.BigEndian.Uint16(key[6+2*i:])
+		}
+		if parent < prevParent || (parent == prevParent && compareUnicode(prevName, name) >= 0 && len(prevName) > 0) {
+			return fmt.Errorf("catalog keys out of order at %q", decodeUnicode(key[6:]))
+		}
+		prevParent, prevName = parent, name
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(v.Children(v.Root())) != 3000 {
+		t.Fatalf("got %d files", len(v.Children(v.Root())))
+	}
+	e, err := v.Lookup("File 1234")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !e.ModTime.Equal(mtime) {
+		t.Fatalf("mod time %v", e.ModTime)
+	}
+	attrs, err := v.ExtendedAttributes(e)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(attrs["com.example.id"]) != "1234" {
+		t.Fatalf("attribute of File 1234: %q", attrs["com.example.id"])
+	}
+}
+
+func TestCompareNames(t *testing.T) {
+	for _, tc := range []struct {
+		a, b string
+		want int
+	}{
+		{"abc", "ABC", 0},
+		{"a", "b", -1},
+		{"ab", "a", 1},
+		{"\x00\x00\x00\x00HFS+ Private Data", "zzz", 1},
+		{"a‍b", "ab", 0},
+		{"ΑΒΓ", "αβγ", 0},
+	} {
+		if got := CompareNames(tc.a, tc.b); got != tc.want {
+			t.Errorf("CompareNames(%q, %q) = %d, want %d", tc.a, tc.b, got, tc.want)
+		}
+	}
+}
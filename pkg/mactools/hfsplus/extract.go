@@ -0,0 +1,102 @@
+package hfsplus
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+type extractedDir struct {
+	path string
+	e    *Entry
+}
+
+// Extract copies root and everything below it into destDir, preserving
+// permissions, symbolic links and modification times. Device files and
+// other special files are skipped. It returns the number of entries written.
+func (v *Volume) Extract(root, destDir string) (int, error) {
+	base := strings.Trim(path.Clean("/"+root), "/")
+	count := 0
+	var dirs []extractedDir
+	err := v.Walk(root, func(p string, e *Entry) error {
+		rel := strings.TrimPrefix(strings.TrimPrefix(p, base), "/")
+		if base != "" {
+			rel = path.Join(path.Base(base), rel)
+		}
+		if rel == "" {
+			return os.MkdirAll(destDir, 0755)
+		}
+		if !filepath.IsLocal(filepath.FromSlash(rel)) {
+			return fmt.Errorf("refusing to extract unsafe path %q", p)
+		}
+		target := filepath.Join(destDir, filepath.FromSlash(rel))
+		if !e.IsDir() {
+			// Single files are extracted without their parent folders.
+			if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+				return err
+			}
+		}
+		switch {
+		case e.IsDir():
+			if err := os.MkdirAll(target, 0755); err != nil {
+				return err
+			}
+			// Apply folder permissions and times after the contents exist.
+			dirs = append(dirs, extractedDir{target, e})
+		case e.Mode&os.ModeSymlink != 0:
+			link, err := v.ReadLink(e)
+			if err != nil {
+				return err
+			}
+			if err := os.Symlink(link, target); err != nil {
+				return err
+			}
+		case e.Mode.IsRegular():
+			if err := v.extractFile(e, target); err != nil {
+				return fmt.Errorf("failed to extract %s: %w", p, err)
+			}
+		default:
+			return nil
+		}
+		count++
+		return nil
+	})
+	if err != nil {
+		return count, err
+	}
+	for i := len(dirs) - 1; i >= 0; i-- {
+		os.Chmod(dirs[i].path, dirs[i].e.Mode.Perm())
+		if !dirs[i].e.ModTime.IsZero() {
+			os.Chtimes(dirs[i].path, dirs[i].e.ModTime, dirs[i].e.ModTime)
+		}
+	}
+	return count, nil
+}
+
+func (v *Volume) extractFile(e *Entry, target string) error {
+	r, err := v.OpenFile(e)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, e.Mode.Perm()|0200)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(target, e.Mode.Perm()); err != nil {
+		return err
+	}
+	if !e.ModTime.IsZero() {
+		return os.Chtimes(target, e.ModTime, e.ModTime)
+	}
+	return nil
+}
@@ -0,0 +1,230 @@
+// Package hfsplus reads and writes HFS+ volumes as found inside .dmg images.
+// Structures and constants follow Apple Technical Note TN1150.
+package hfsplus
+
+import (
+	"encoding/binary"
+	"time"
+	"unicode/utf16"
+)
+
+const (
+	// VolumeHeaderOffset is the byte offset of the volume header.
+	VolumeHeaderOffset = 1024
+
+	SignatureHFSPlus = 0x482b // "H+"
+	SignatureHFSX    = 0x4858 // "HX"
+)
+
+// Reserved catalog node IDs.
+const (
+	RootParentID         = 1
+	RootFolderID         = 2
+	ExtentsFileID        = 3
+	CatalogFileID        = 4
+	BadBlockFileID       = 5
+	AllocationFileID     = 6
+	StartupFileID        = 7
+	AttributesFileID     = 8
+	RepairCatalogFileID  = 14
+	BogusExtentFileID    = 15
+	FirstUserCatalogNode = 16
+)
+
+// Catalog record types.
+const (
+	recordFolder       = 1
+	recordFile         = 2
+	recordFolderThread = 3
+	recordFileThread   = 4
+)
+
+// B-tree node kinds.
+const (
+	nodeLeaf   = -1
+	nodeIndex  = 0
+	nodeHeader = 1
+	nodeMap    = 2
+)
+
+// Finder flags stored in FileInfo/FolderInfo.
+const (
+	FinderIsAlias       = 0x8000
+	FinderIsInvisible   = 0x4000
+	FinderHasBundle     = 0x2000
+	FinderNameLocked    = 0x1000
+	FinderIsStationery  = 0x0800
+	FinderHasCustomIcon = 0x0400
+	FinderHasBeenInited = 0x0100
+)
+
+// Unix file type bits of BSDInfo.FileMode.
+const (
+	modeTypeMask = 0xf000
+	modeFIFO     = 0x1000
+	modeChar     = 0x2000
+	modeDir      = 0x4000
+	modeBlock    = 0x6000
+	modeRegular  = 0x8000
+	modeSymlink  = 0xa000
+	modeSocket   = 0xc000
+)
+
+// Extent is a contiguous run of allocation blocks.
+type Extent struct {
+	StartBlock uint32
+	BlockCount uint32
+}
+
+// ForkData describes the size and first eight extents of a fork.
+type ForkData struct {
+	LogicalSize uint64
+	ClumpSize   uint32
+	TotalBlocks uint32
+	Extents     [8]Extent
+}
+
+// VolumeHeader is the HFS+ volume header stored at VolumeHeaderOffset.
+type VolumeHeader struct {
+	Signature          uint16
+	Version            uint16
+	Attributes         uint32
+	LastMountedVersion uint32
+	JournalInfoBlock   uint32
+	CreateDate         uint32
+	ModifyDate         uint32
+	BackupDate         uint32
+	CheckedDate        uint32
+	FileCount          uint32
+	FolderCount        uint32
+	BlockSize          uint32
+	TotalBlocks        uint32
+	FreeBlocks         uint32
+	NextAllocation     uint32
+	RsrcClumpSize      uint32
+	DataClumpSize      uint32
+	NextCatalogID      uint32
+	WriteCount         uint32
+	EncodingsBitmap    uint64
+	FinderInfo         [8]uint32
+	AllocationFile     ForkData
+	ExtentsFile        ForkData
+	CatalogFile        ForkData
+	AttributesFile     ForkData
+	StartupFile        ForkData
+}
+
+// BSDInfo holds the Unix ownership and permissions of a catalog record.
+type BSDInfo struct {
+	OwnerID    uint32
+	GroupID    uint32
+	AdminFlags uint8
+	OwnerFlags uint8
+	FileMode   uint16
+	Special    uint32
+}
+
+// FinderInfo is the 16-byte FileInfo/FolderInfo block. For files Type and
+// Creator are the classic four character codes; for folders the same bytes
+// hold the window rectangle.
+type FinderInfo struct {
+	Type     [4]byte
+	Creator  [4]byte
+	Flags    uint16
+	Location [2]int16
+	Reserved uint16
+}
+
+type catalogFolder struct {
+	RecordType       int16
+	Flags            uint16
+	Valence          uint32
+	FolderID         uint32
+	CreateDate       uint32
+	ContentModDate   uint32
+	AttributeModDate uint32
+	AccessDate       uint32
+	BackupDate       uint32
+	Permissions      BSDInfo
+	UserInfo         FinderInfo
+	FinderInfo       [16]byte
+	TextEncoding     uint32
+	Reserved         uint32
+}
+
+type catalogFile struct {
+	RecordType       int16
+	Flags            uint16
+	Reserved1        uint32
+	FileID           uint32
+	CreateDate       uint32
+	ContentModDate   uint32
+	AttributeModDate uint32
+	AccessDate       uint32
+	BackupDate       uint32
+	Permissions      BSDInfo
+	UserInfo         FinderInfo
+	FinderInfo       [16]byte
+	TextEncoding     uint32
+	Reserved2        uint32
+	DataFork         ForkData
+	ResourceFork     ForkData
+}
+
+type nodeDescriptor struct {
+	FLink      uint32
+	BLink      uint32
+	Kind       int8
+	Height     uint8
+	NumRecords uint16
+	Reserved   uint16
+}
+
+type headerRecord struct {
+	TreeDepth      uint16
+	RootNode       uint32
+	LeafRecords    uint32
+	FirstLeafNode  uint32
+	LastLeafNode   uint32
+	NodeSize       uint16
+	MaxKeyLength   uint16
+	TotalNodes     uint32
+	FreeNodes      uint32
+	Reserved1      uint16
+	ClumpSize      uint32
+	BtreeType      uint8
+	KeyCompareType uint8
+	Attributes     uint32
+	Reserved3      [16]uint32
+}
+
+var epoch = time.Date(1904, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// fromHFSDate converts seconds since 1904-01-01 GMT to a time.
+func fromHFSDate(v uint32) time.Time {
+	if v == 0 {
+		return time.Time{}
+	}
+	return epoch.Add(time.Duration(v) * time.Second)
+}
+
+// toHFSDate converts t to seconds since 1904-01-01 GMT, clamped to the
+// representable range.
+func toHFSDate(t time.Time) uint32 {
+	secs := t.Unix() - epoch.Unix()
+	if t.IsZero() || secs < 0 {
+		return 0
+	}
+	if secs > 0xffffffff {
+		return 0xffffffff
+	}
+	return uint32(secs)
+}
+
+func decodeUnicode(b []byte) string {
+	u := make([]uint16, len(b)/2)
+	for i := range u {
+		u[i] = binary.BigEndian.Uint16(b[i*2:])
+	}
+	return string(utf16.Decode(u))
+}
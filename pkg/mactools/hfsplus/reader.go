@@ -0,0 +1,468 @@
+package hfsplus
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	forkData     = 0x00
+	forkResource = 0xff
+
+	// Names of the hidden folders holding hard link targets.
+	privateDataName    = "\x00\x00\x00\x00HFS+ Private Data"
+	privateDirDataName = ".HFS+ Private Directory Data\r"
+
+	ownerFlagCompressed = 0x20 // UF_COMPRESSED
+)
+
+// ErrNotExist is returned when a path is not found on the volume.
+var ErrNotExist = errors.New("file does not exist")
+
+// Entry is a file, folder or symbolic link from the catalog.
+type Entry struct {
+	ID           uint32
+	ParentID     uint32
+	Name         string
+	Mode         os.FileMode
+	Size         int64
+	CreateTime   time.Time
+	ModTime      time.Time
+	UID          uint32
+	GID          uint32
+	Finder       FinderInfo
+	DataFork     ForkData
+	ResourceFork ForkData
+
+	ownerFlags  uint8
+	recordFlags uint16
+	hardLink    uint32
+}
+
+// IsDir reports whether e is a folder.
+func (e *Entry) IsDir() bool {
+	return e.Mode.IsDir()
+}
+
+type overflowKey struct {
+	fileID   uint32
+	forkType uint8
+}
+
+// Volume is an HFS+ or HFSX volume opened for reading.
+type Volume struct {
+	Header VolumeHeader
+
+	r        io.ReaderAt
+	overflow map[overflowKey][]Extent
+	attrs    *btree
+	entries  map[uint32]*Entry
+	children map[uint32][]*Entry
+	hidden   map[uint32]bool
+}
+
+// Open reads the volume header and catalog of the volume in r.
+func Open(r io.ReaderAt) (*Volume, error) {
+	buf := make([]byte, 512)
+	if _, err := r.ReadAt(buf, VolumeHeaderOffset); err != nil {
+		return nil, fmt.Errorf("failed to read volume header: %w", err)
+	}
+	v := &Volume{
+		r:        r,
+		overflow: map[overflowKey][]Extent{},
+		entries:  map[uint32]*Entry{},
+		children: map[uint32][]*Entry{},
+		hidden:   map[uint32]bool{},
+	}
+	if err := binary.Read(bytes.NewReader(buf), binary.BigEndian, &v.Header); err != nil {
+		return nil, err
+	}
+	if v.Header.Signature != SignatureHFSPlus && v.Header.Signature != SignatureHFSX {
+		return nil, fmt.Errorf("not an HFS+ volume (signature 0x%04x)", v.Header.Signature)
+	}
+	if v.Header.BlockSize < 512 || v.Header.BlockSize&(v.Header.BlockSize-1) != 0 {
+		return nil, fmt.Errorf("invalid block size %d", v.Header.BlockSize)
+	}
+
+	extents, err := openBTree(v.fork(ExtentsFileID, forkData, v.Header.ExtentsFile))
+	if err != nil {
+		return nil, fmt.Errorf("extents overflow file: %w", err)
+	}
+	if err := v.loadOverflowExtents(extents); err != nil {
+		return nil, fmt.Errorf("extents overflow file: %w", err)
+	}
+	catalog, err := openBTree(v.fork(CatalogFileID, forkData, v.Header.CatalogFile))
+	if err != nil {
+		return nil, fmt.Errorf("catalog file: %w", err)
+	}
+	if err := v.loadCatalog(catalog); err != nil {
+		return nil, fmt.Errorf("catalog file: %w", err)
+	}
+	if v.Header.AttributesFile.LogicalSize > 0 {
+		v.attrs, err = openBTree(v.fork(AttributesFileID, forkData, v.Header.AttributesFile))
+		if err != nil {
+			return nil, fmt.Errorf("attributes file: %w", err)
+		}
+	}
+	return v, nil
+}
+
+func (v *Volume) loadOverflowExtents(t *btree) error {
+	return t.forEachLeafRecord(func(rec []byte) error {
+		key, data, err := splitRecord(rec)
+		if err != nil {
+			return err
+		}
+		if len(key) < 10 || len(data) < 64 {
+			return errors.New("truncated extent record")
+		}
+		k := overflowKey{fileID: binary.BigEndian.Uint32(key[2:]), forkType: key[0]}
+		for i := 0; i < 8; i++ {
+			e := Extent{
+				StartBlock: binary.BigEndian.Uint32(data[i*8:]),
+				BlockCount: binary.BigEndian.Uint32(data[i*8+4:]),
+			}
+			if e.BlockCount > 0 {
+				v.overflow[k] = append(v.overflow[k], e)
+			}
+		}
+		return nil
+	})
+}
+
+func (v *Volume) loadCatalog(t *btree) error {
+	var links []*Entry
+	err := t.forEachLeafRecord(func(rec []byte) error {
+		key, data, err := splitRecord(rec)
+		if err != nil {
+			return err
+		}
+		if len(key) < 6 || len(data) < 2 {
+			return errors.New("truncated catalog record")
+		}
+		parentID := binary.BigEndian.Uint32(key)
+		nameLen := int(binary.BigEndian.Uint16(key[4:]))
+		if 6+nameLen*2 > len(key) {
+			return errors.New("catalog key name exceeds key")
+		}
+		// HFS+ stores ':' as '/' so that the Carbon path separator is never
+		// part of a name; swap it back for the POSIX view.
+		name := strings.ReplaceAll(decodeUnicode(key[6:6+nameLen*2]), "/", ":")
+
+		var e *Entry
+		switch int16(binary.BigEndian.Uint16(data)) {
+		case recordFolder:
+			var f catalogFolder
+			if err := binary.Read(bytes.NewReader(data), binary.BigEndian, &f); err != nil {
+				return fmt.Errorf("folder record %q: %w", name, err)
+			}
+			e = &Entry{
+				ID:          f.FolderID,
+				Mode:        fileMode(f.Permissions.FileMode, true),
+				CreateTime:  fromHFSDate(f.CreateDate),
+				ModTime:     fromHFSDate(f.ContentModDate),
+				UID:         f.Permissions.OwnerID,
+				GID:         f.Permissions.GroupID,
+				Finder:      f.UserInfo,
+				ownerFlags:  f.Permissions.OwnerFlags,
+				recordFlags: f.Flags,
+			}
+		case recordFile:
+			var f catalogFile
+			if err := binary.Read(bytes.NewReader(data), binary.BigEndian, &f); err != nil {
+				return fmt.Errorf("file record %q: %w", name, err)
+			}
+			e = &Entry{
+				ID:           f.FileID,
+				Mode:         fileMode(f.Permissions.FileMode, false),
+				Size:         int64(f.DataFork.LogicalSize),
+				CreateTime:   fromHFSDate(f.CreateDate),
+				ModTime:      fromHFSDate(f.ContentModDate),
+				UID:          f.Permissions.OwnerID,
+				GID:          f.Permissions.GroupID,
+				Finder:       f.UserInfo,
+				DataFork:     f.DataFork,
+				ResourceFork: f.ResourceFork,
+				ownerFlags:   f.Permissions.OwnerFlags,
+				recordFlags:  f.Flags,
+			}
+			if string(f.UserInfo.Type[:]) == "hlnk" && string(f.UserInfo.Creator[:]) == "hfs+" {
+				e.hardLink = f.Permissions.Special
+				links = append(links, e)
+			}
+		default:
+			// Thread records are only needed for lookups by ID.
+			return nil
+		}
+		e.ParentID = parentID
+		e.Name = name
+		v.entries[e.ID] = e
+		v.children[parentID] = append(v.children[parentID], e)
+		if parentID == RootFolderID && (name == privateDataName || name == privateDirDataName) {
+			v.hidden[e.ID] = true
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if v.entries[RootFolderID] == nil {
+		return errors.New("root folder not found")
+	}
+	for _, link := range links {
+		v.resolveHardLink(link)
+	}
+	return nil
+}
+
+// resolveHardLink copies the data of the iNode file in the private data
+// folder into the link entry, so callers can treat it as a regular file.
+func (v *Volume) resolveHardLink(link *Entry) {
+	inodeName := fmt.Sprintf("iNode%d", link.hardLink)
+	link.hardLink = 0
+	for id := range v.hidden {
+		for _, c := range v.children[id] {
+			if c.Name != inodeName {
+				continue
+			}
+			link.Mode = c.Mode
+			link.Size = c.Size
+			link.DataFork = c.DataFork
+			link.ResourceFork = c.ResourceFork
+			link.ownerFlags = c.ownerFlags
+			link.hardLink = c.ID
+			return
+		}
+	}
+}
+
+func fileMode(mode uint16, isDir bool) os.FileMode {
+	perm := os.FileMode(mode & 0o777)
+	if mode&0o4000 != 0 {
+		perm |= os.ModeSetuid
+	}
+	if mode&0o2000 != 0 {
+		perm |= os.ModeSetgid
+	}
+	if mode&0o1000 != 0 {
+		perm |= os.ModeSticky
+	}
+	if isDir {
+		if mode == 0 {
+			perm = 0o755
+		}
+		return perm | os.ModeDir
+	}
+	switch mode & modeTypeMask {
+	case modeSymlink:
+		return perm | os.ModeSymlink
+	case modeFIFO:
+		return perm | os.ModeNamedPipe
+	case modeChar:
+		return perm | os.ModeDevice | os.ModeCharDevice
+	case modeBlock:
+		return perm | os.ModeDevice
+	case modeSocket:
+		return perm | os.ModeSocket
+	}
+	if mode == 0 {
+		perm = 0o644
+	}
+	return perm
+}
+
+// VolumeName returns the name of the root folder.
+func (v *Volume) VolumeName() string {
+	return v.entries[RootFolderID].Name
+}
+
+// Root returns the root folder.
+func (v *Volume) Root() *Entry {
+	return v.entries[RootFolderID]
+}
+
+// Children returns the visible entries of a folder in catalog order.
+func (v *Volume) Children(dir *Entry) []*Entry {
+	var out []*Entry
+	for _, c := range v.children[dir.ID] {
+		if !v.hidden[c.ID] {
+			out = append(out, c)
+		}
+	}
+	return out
+}
+
+// Lookup resolves a slash separated path relative to the volume root.
+// Names are compared case-insensitively unless the volume is HFSX.
+func (v *Volume) Lookup(p string) (*Entry, error) {
+	e := v.Root()
+	for _, name := range strings.Split(strings.Trim(path.Clean("/"+p), "/"), "/") {
+		if name == "" {
+			continue
+		}
+		if !e.IsDir() {
+			return nil, fmt.Errorf("%s: %w", p, ErrNotExist)
+		}
+		// Names are stored decomposed.
+		name = decomposeName(name)
+		var next *Entry
+		for _, c := range v.Children(e) {
+			if c.Name == name {
+				next = c
+				break
+			}
+			if next == nil && v.Header.Signature == SignatureHFSPlus && CompareNames(c.Name, name) == 0 {
+				next = c
+			}
+		}
+		if next == nil {
+			return nil, fmt.Errorf("%s: %w", p, ErrNotExist)
+		}
+		e = next
+	}
+	return e, nil
+}
+
+// WalkFunc is called by Walk for every entry. Returning fs.SkipDir from a
+// folder skips its contents.
+type WalkFunc func(p string, e *Entry) error
+
+// Walk visits root and everything below it depth first, parents before
+// children. Paths passed to fn are relative to the volume root.
+func (v *Volume) Walk(root string, fn WalkFunc) error {
+	e, err := v.Lookup(root)
+	if err != nil {
+		return err
+	}
+	err = v.walk(strings.Trim(path.Clean("/"+root), "/"), e, fn)
+	if err == fs.SkipDir || err == fs.SkipAll {
+		return nil
+	}
+	return err
+}
+
+func (v *Volume) walk(p string, e *Entry, fn WalkFunc) error {
+	if err := fn(p, e); err != nil {
+		if err == fs.SkipDir && e.IsDir() {
+			return nil
+		}
+		return err
+	}
+	if !e.IsDir() {
+		return nil
+	}
+	children := v.Children(e)
+	sort.SliceStable(children, func(i, j int) bool { return children[i].Name < children[j].Name })
+	for _, c := range children {
+		if err := v.walk(path.Join(p, c.Name), c, fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// OpenFile returns a reader for the contents of a file, transparently
+// decompressing files stored with HFS+ compression.
+func (v *Volume) OpenFile(e *Entry) (io.Reader, error) {
+	if e.IsDir() {
+		return nil, fmt.Errorf("%s is a directory", e.Name)
+	}
+	if e.ownerFlags&ownerFlagCompressed != 0 {
+		return v.openCompressed(e)
+	}
+	return io.NewSectionReader(v.fork(e.dataForkID(), forkData, e.DataFork), 0, int64(e.DataFork.LogicalSize)), nil
+}
+
+// OpenResourceFork returns a reader for the resource fork of a file.
+func (v *Volume) OpenResourceFork(e *Entry) io.Reader {
+	return io.NewSectionReader(v.fork(e.dataForkID(), forkResource, e.ResourceFork), 0, int64(e.ResourceFork.LogicalSize))
+}
+
+// ReadLink returns the target of a symbolic link.
+func (v *Volume) ReadLink(e *Entry) (string, error) {
+	if e.Mode&os.ModeSymlink == 0 {
+		return "", fmt.Errorf("%s is not a symbolic link", e.Name)
+	}
+	r, err := v.OpenFile(e)
+	if err != nil {
+		return "", err
+	}
+	target, err := io.ReadAll(r)
+	if err != nil {
+		return "", err
+	}
+	return string(target), nil
+}
+
+// dataForkID is the catalog ID owning the forks, which differs from the entry
+// ID for hard links.
+func (e *Entry) dataForkID() uint32 {
+	if e.hardLink != 0 {
+		return e.hardLink
+	}
+	return e.ID
+}
+
+// fork returns a reader over the allocation blocks of a fork.
+func (v *Volume) fork(id uint32, forkType uint8, fd ForkData) *forkReader {
+	fr := &forkReader{r: v.r, blockSize: int64(v.Header.BlockSize), size: int64(fd.LogicalSize)}
+	for _, e := range fd.Extents {
+		if e.BlockCount > 0 {
+			fr.extents = append(fr.extents, e)
+		}
+	}
+	fr.extents = append(fr.extents, v.overflow[overflowKey{fileID: id, forkType: forkType}]...)
+	return fr
+}
+
+type forkReader struct {
+	r         io.ReaderAt
+	blockSize int64
+	extents   []Extent
+	size      int64
+}
+
+func (f *forkReader) ReadAt(p []byte, off int64) (int, error) {
+	if off >= f.size {
+		return 0, io.EOF
+	}
+	total := 0
+	for len(p) > 0 && off < f.size {
+		block := off / f.blockSize
+		var physical int64 = -1
+		var avail int64
+		for _, e := range f.extents {
+			if block < int64(e.BlockCount) {
+				physical = (int64(e.StartBlock)+block)*f.blockSize + off%f.blockSize
+				avail = (int64(e.BlockCount)-block)*f.blockSize - off%f.blockSize
+				break
+			}
+			block -= int64(e.BlockCount)
+		}
+		if physical < 0 {
+			return total, errors.New("fork offset is not covered by any extent")
+		}
+		n := int64(len(p))
+		n = min(n, avail, f.size-off)
+		read, err := f.r.ReadAt(p[:n], physical)
+		total += read
+		if err != nil {
+			return total, err
+		}
+		p = p[n:]
+		off += n
+	}
+	if len(p) > 0 {
+		return total, io.EOF
+	}
+	return total, nil
+}
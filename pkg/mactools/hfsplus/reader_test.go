@@ -0,0 +1,327 @@
+package hfsplus
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func readerTestVolume(t *testing.T) *Volume {
+	t.Helper()
+	mtime := time.Date(2024, 5, 6, 7, 8, 9, 0, time.UTC)
+	ctime := time.Date(2023, 1, 2, 3, 4, 5, 0, time.UTC)
+	node := func(name string, mode os.FileMode) *Node {
+		return &Node{Name: name, Mode: mode, ModTime: mtime, CreateTime: ctime, UID: 501, GID: 20}
+	}
+	docs := node("Docs", os.ModeDir|0750)
+	docs.Finder.Location = [2]int16{10, 20}
+	notes := node("Notes.txt", 0640)
+	notes.Data = []byte("notes")
+	notes.Finder = FinderInfo{Type: [4]byte{'T', 'E', 'X', 'T'}, Creator: [4]byte{'t', 't', 'x', 't'}, Flags: FinderIsInvisible}
+	docs.Children = []*Node{notes}
+
+	forks := node("Forks", 0644)
+	forks.Data = bytes.Repeat([]byte("data"), 3000)
+	forks.ResourceFork = []byte("resource")
+	forks.Xattrs = map[string][]byte{
+		"com.example.inline": []byte("value"),
+		"com.example.fork":   bytes.Repeat([]byte{7}, 10000),
+	}
+	link := node("Link", os.ModeSymlink|0755)
+	link.Target = "Docs/Notes.txt"
+	colon := node("a:b", 0755)
+	colon.Data = []byte("colon")
+
+	b := NewBuilder("Reader")
+	for _, n := range []*Node{docs, forks, link, colon} {
+		if err := b.Add("", n); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return buildVolume(t, b)
+}
+
+func readFile(t *testing.T, v *Volume, e *Entry) string {
+	t.Helper()
+	r, err := v.OpenFile(e)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestReaderCatalog(t *testing.T) {
+	v := readerTestVolume(t)
+
+	if v.VolumeName() != "Reader" || !v.Root().IsDir() || v.Root().ID != RootFolderID {
+		t.Fatalf("root %+v", v.Root())
+	}
+	var names []string
+	for _, e := range v.Children(v.Root()) {
+		names = append(names, e.Name)
+	}
+	if want := []string{"a:b", "Docs", "Forks", "Link"}; !reflect.DeepEqual(names, want) {
+		t.Fatalf("root children %q, want %q", names, want)
+	}
+
+	docs, err := v.Lookup("docs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !docs.IsDir() || docs.Mode.Perm() != 0750 || docs.Finder.Location != [2]int16{10, 20} {
+		t.Fatalf("Docs %+v", docs)
+	}
+	notes, err := v.Lookup("/Docs/NOTES.TXT")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if notes.ParentID != docs.ID || notes.Size != 5 || notes.Mode != 0640 || notes.UID != 501 || notes.GID != 20 {
+		t.Fatalf("Notes.txt %+v", notes)
+	}
+	if !notes.ModTime.Equal(time.Date(2024, 5, 6, 7, 8, 9, 0, time.UTC)) || !notes.CreateTime.Equal(time.Date(2023, 1, 2, 3, 4, 5, 0, time.UTC)) {
+		t.Fatalf("Notes.txt times %v, %v", notes.ModTime, notes.CreateTime)
+	}
+	if string(notes.Finder.Type[:]) != "TEXT" || string(notes.Finder.Creator[:]) != "ttxt" || notes.Finder.Flags != FinderIsInvisible {
+		t.Fatalf("Notes.txt Finder info %+v", notes.Finder)
+	}
+	if got := readFile(t, v, notes); got != "notes" {
+		t.Fatalf("Notes.txt %q", got)
+	}
+	if got := readFile(t, v, mustLookup(t, v, "a:b")); got != "colon" {
+		t.Fatalf("a:b %q", got)
+	}
+
+	link := mustLookup(t, v, "Link")
+	if link.Mode&os.ModeSymlink == 0 {
+		t.Fatalf("Link mode %v", link.Mode)
+	}
+	if target, err := v.ReadLink(link); err != nil || target != "Docs/Notes.txt" {
+		t.Fatalf("Link target %q, %v", target, err)
+	}
+	if _, err := v.ReadLink(notes); err == nil {
+		t.Fatal("ReadLink of a regular file succeeded")
+	}
+	if _, err := v.OpenFile(docs); err == nil {
+		t.Fatal("OpenFile of a folder succeeded")
+	}
+	for _, p := range []string{"Missing", "Docs/Missing", "Docs/Notes.txt/x"} {
+		if _, err := v.Lookup(p); !errors.Is(err, ErrNotExist) {
+			t.Errorf("Lookup(%q): %v", p, err)
+		}
+	}
+}
+
+func TestReaderWalk(t *testing.T) {
+	v := readerTestVolume(t)
+
+	var paths []string
+	err := v.Walk("", func(p string, e *Entry) error {
+		paths = append(paths, p)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"", "Docs", "Docs/Notes.txt", "Forks", "Link", "a:b"}; !reflect.DeepEqual(paths, want) {
+		t.Fatalf("walked %q, want %q", paths, want)
+	}
+
+	paths = nil
+	err = v.Walk("/", func(p string, e *Entry) error {
+		paths = append(paths, p)
+		if e.IsDir() && p != "" {
+			return fs.SkipDir
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"", "Docs", "Forks", "Link", "a:b"}; !reflect.DeepEqual(paths, want) {
+		t.Fatalf("walked %q with SkipDir, want %q", paths, want)
+	}
+	if err := v.Walk("Missing", func(string, *Entry) error { return nil }); !errors.Is(err, ErrNotExist) {
+		t.Fatalf("Walk of a missing path: %v", err)
+	}
+}
+
+func TestReaderForksAndXattrs(t *testing.T) {
+	v := readerTestVolume(t)
+
+	forks := mustLookup(t, v, "Forks")
+	if got := readFile(t, v, forks); got != string(bytes.Repeat([]byte("data"), 3000)) {
+		t.Fatalf("data fork of %d bytes", len(got))
+	}
+	if got, _ := io.ReadAll(v.OpenResourceFork(forks)); string(got) != "resource" {
+		t.Fatalf("resource fork %q", got)
+	}
+	attrs, err := v.ExtendedAttributes(forks)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string][]byte{
+		"com.example.inline": []byte("value"),
+		"com.example.fork":   bytes.Repeat([]byte{7}, 10000),
+	}
+	if !reflect.DeepEqual(attrs, want) {
+		t.Fatalf("extended attributes %q", attrs)
+	}
+	attrs, err = v.ExtendedAttributes(mustLookup(t, v, "Docs/Notes.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(attrs) != 0 {
+		t.Fatalf("unexpected extended attributes %q", attrs)
+	}
+}
+
+// decmpfsHeader returns a com.apple.decmpfs attribute of the given type.
+func decmpfsHeader(kind uint32, size int, payload []byte) []byte {
+	hdr := make([]byte, decmpfsHeaderSize, decmpfsHeaderSize+len(payload))
+	binary.LittleEndian.PutUint32(hdr, decmpfsMagic)
+	binary.LittleEndian.PutUint32(hdr[4:], kind)
+	binary.LittleEndian.PutUint64(hdr[8:], uint64(size))
+	return append(hdr, payload...)
+}
+
+func zlibBlock(t *testing.T, data []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zlib.NewWriter(&buf)
+	zw.Write(data)
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// decmpfsFork returns a resource fork holding data compressed in blocks.
+func decmpfsFork(t *testing.T, data []byte) []byte {
+	t.Helper()
+	var blocks [][]byte
+	for len(data) > 0 {
+		n := min(len(data), decmpfsBlockSize)
+		blocks = append(blocks, zlibBlock(t, data[:n]))
+		data = data[n:]
+	}
+	const dataOffset = 0x100
+	table := make([]byte, 4+8*len(blocks))
+	binary.LittleEndian.PutUint32(table, uint32(len(blocks)))
+	off := len(table)
+	for i, b := range blocks {
+		binary.LittleEndian.PutUint32(table[4+8*i:], uint32(off))
+		binary.LittleEndian.PutUint32(table[8+8*i:], uint32(len(b)))
+		off += len(b)
+	}
+	rsrc := make([]byte, dataOffset+4)
+	binary.BigEndian.PutUint32(rsrc, dataOffset)
+	rsrc = append(rsrc, table...)
+	for _, b := range blocks {
+		rsrc = append(rsrc, b...)
+	}
+	return rsrc
+}
+
+func TestReaderCompressed(t *testing.T) {
+	large := bytes.Repeat([]byte("compressed "), 20000)
+	files := map[string]struct {
+		data  string
+		xattr []byte
+		rsrc  []byte
+	}{
+		"Inline":  {"inline", decmpfsHeader(1, 6, []byte("inline")), nil},
+		"Zlib":    {"zlib zlib zlib", decmpfsHeader(3, 14, zlibBlock(t, []byte("zlib zlib zlib"))), nil},
+		"Stored":  {"stored", decmpfsHeader(3, 6, append([]byte{0xff}, "stored"...)), nil},
+		"Blocks":  {string(large), decmpfsHeader(4, len(large), nil), decmpfsFork(t, large)},
+		"Invalid": {"", []byte("not a header"), nil},
+	}
+	b := NewBuilder("Compressed")
+	for name, f := range files {
+		err := b.Add("", &Node{
+			Name:         name,
+			Mode:         0644,
+			ResourceFork: f.rsrc,
+			Xattrs:       map[string][]byte{decmpfsAttrName: f.xattr},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	v := buildVolume(t, b)
+
+	for name, f := range files {
+		e := mustLookup(t, v, name)
+		// The builder does not write compressed files; mark the entry as
+		// one the way the catalog would.
+		e.ownerFlags |= ownerFlagCompressed
+		if name == "Invalid" {
+			if _, err := v.OpenFile(e); err == nil {
+				t.Fatal("invalid decmpfs header accepted")
+			}
+			continue
+		}
+		if got := readFile(t, v, e); got != f.data {
+			t.Fatalf("%s: got %d bytes", name, len(got))
+		}
+	}
+}
+
+func TestExtract(t *testing.T) {
+	v := readerTestVolume(t)
+	dir := t.TempDir()
+
+	n, err := v.Extract("", dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 5 {
+		t.Fatalf("extracted %d entries", n)
+	}
+	data, err := os.ReadFile(filepath.Join(dir, "Docs", "Notes.txt"))
+	if err != nil || string(data) != "notes" {
+		t.Fatalf("Docs/Notes.txt %q, %v", data, err)
+	}
+	info, err := os.Stat(filepath.Join(dir, "Docs", "Notes.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0640 || !info.ModTime().Equal(time.Date(2024, 5, 6, 7, 8, 9, 0, time.UTC)) {
+		t.Fatalf("Docs/Notes.txt mode %v, modified %v", info.Mode(), info.ModTime())
+	}
+	if info, err := os.Stat(filepath.Join(dir, "Docs")); err != nil || info.Mode().Perm() != 0750 {
+		t.Fatalf("Docs mode %v, %v", info.Mode(), err)
+	}
+	if target, err := os.Readlink(filepath.Join(dir, "Link")); err != nil || target != "Docs/Notes.txt" {
+		t.Fatalf("Link target %q, %v", target, err)
+	}
+
+	// The destination of a single file is created as needed.
+	single := filepath.Join(t.TempDir(), "new")
+	if n, err := v.Extract("Docs/Notes.txt", single); err != nil || n != 1 {
+		t.Fatalf("extracted %d entries, %v", n, err)
+	}
+	if data, err := os.ReadFile(filepath.Join(single, "Notes.txt")); err != nil || string(data) != "notes" {
+		t.Fatalf("Notes.txt %q, %v", data, err)
+	}
+}
+
+func mustLookup(t *testing.T, v *Volume, p string) *Entry {
+	t.Helper()
+	e, err := v.Lookup(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return e
+}
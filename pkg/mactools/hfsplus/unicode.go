@@ -0,0 +1,146 @@
+package hfsplus
+
+import (
+	"encoding/binary"
+	"strings"
+	"unicode/utf16"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// foldIrregular lists the case mappings of Apple's lower case table that do
+// not follow a fixed offset from the upper case letter.
+var foldIrregular = map[uint16]uint16{
+	0x00c6: 0x00e6, 0x00d0: 0x00f0, 0x00d8: 0x00f8, 0x00de: 0x00fe,
+	0x0110: 0x0111, 0x0126: 0x0127, 0x0132: 0x0133, 0x013f: 0x0140,
+	0x0141: 0x0142, 0x014a: 0x014b, 0x0152: 0x0153, 0x0166: 0x0167,
+	0x0181: 0x0253, 0x0182: 0x0183, 0x0184: 0x0185, 0x0186: 0x0254,
+	0x0187: 0x0188, 0x0189: 0x0256, 0x018a: 0x0257, 0x018b: 0x018c,
+	0x018e: 0x01dd, 0x018f: 0x0259, 0x0190: 0x025b, 0x0191: 0x0192,
+	0x0193: 0x0260, 0x0194: 0x0263, 0x0196: 0x0269, 0x0197: 0x0268,
+	0x0198: 0x0199, 0x019c: 0x026f, 0x019d: 0x0272, 0x019f: 0x0275,
+	0x01a2: 0x01a3, 0x01a4: 0x01a5, 0x01a7: 0x01a8, 0x01a9: 0x0283,
+	0x01ac: 0x01ad, 0x01ae: 0x0288, 0x01b1: 0x028a, 0x01b2: 0x028b,
+	0x01b3: 0x01b4, 0x01b5: 0x01b6, 0x01b7: 0x0292, 0x01b8: 0x01b9,
+	0x01bc: 0x01bd, 0x01c4: 0x01c6, 0x01c5: 0x01c6, 0x01c7: 0x01c9,
+	0x01c8: 0x01c9, 0x01ca: 0x01cc, 0x01cb: 0x01cc, 0x01e4: 0x01e5,
+	0x01f1: 0x01f3, 0x01f2: 0x01f3,
+	0x0402: 0x0452, 0x0404: 0x0454, 0x0405: 0x0455, 0x0406: 0x0456,
+	0x0408: 0x0458, 0x0409: 0x0459, 0x040a: 0x045a, 0x040b: 0x045b,
+	0x040f: 0x045f, 0x04c3: 0x04c4, 0x04c7: 0x04c8, 0x04cb: 0x04cc,
+}
+
+// foldChar maps c through the case folding table used by FastUnicodeCompare
+// (TN1150). Ignorable characters map to zero and NUL sorts last.
+func foldChar(c uint16) uint16 {
+	switch {
+	case c == 0:
+		return 0xffff
+	case c >= 'A' && c <= 'Z':
+		return c + 0x20
+	case c < 0x80:
+		return c
+	case c >= 0x0391 && c <= 0x03a9 && c != 0x03a2: // Greek
+		return c + 0x20
+	case c >= 0x03e2 && c <= 0x03ee && c%2 == 0: // Coptic
+		return c + 1
+	case c >= 0x0410 && c <= 0x042f && c != 0x0419: // Cyrillic
+		return c + 0x20
+	case c >= 0x0460 && c <= 0x0480 && c%2 == 0 && c != 0x0476,
+		c >= 0x0490 && c <= 0x04be && c%2 == 0:
+		return c + 1
+	case c >= 0x0531 && c <= 0x0556: // Armenian
+		return c + 0x30
+	case c >= 0x10a0 && c <= 0x10c5: // Georgian
+		return c + 0x30
+	case c >= 0x200c && c <= 0x200f, c >= 0x202a && c <= 0x202e,
+		c >= 0x206a && c <= 0x206f, c == 0xfeff:
+		return 0
+	case c >= 0x2160 && c <= 0x216f: // Roman numerals
+		return c + 0x10
+	case c >= 0xff21 && c <= 0xff3a: // fullwidth Latin
+		return c + 0x20
+	}
+	if l, ok := foldIrregular[c]; ok {
+		return l
+	}
+	return c
+}
+
+// CompareNames orders two names the way HFS+ orders catalog keys, ignoring
+// case and ignorable code points. Both names are expected in the decomposed
+// form stored on disk.
+func CompareNames(a, b string) int {
+	return compareUnicode(utf16.Encode([]rune(a)), utf16.Encode([]rune(b)))
+}
+
+func compareUnicode(a, b []uint16) int {
+	i, j := 0, 0
+	for {
+		var c1, c2 uint16
+		for c1 == 0 && i < len(a) {
+			c1 = foldChar(a[i])
+			i++
+		}
+		for c2 == 0 && j < len(b) {
+			c2 = foldChar(b[j])
+			j++
+		}
+		if c1 != c2 {
+			if c1 < c2 {
+				return -1
+			}
+			return 1
+		}
+		if c1 == 0 {
+			return 0
+		}
+	}
+}
+
+// compareBinary orders UTF-16 strings by code unit, as used for attribute
+// keys and HFSX catalogs.
+func compareBinary(a, b []uint16) int {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if a[i] != b[i] {
+			if a[i] < b[i] {
+				return -1
+			}
+			return 1
+		}
+	}
+	return len(a) - len(b)
+}
+
+// decomposeName converts a POSIX file name to the canonical decomposition
+// stored in catalog keys. Like the Mac OS X kernel it leaves the ranges
+// U+2000–U+2FFF, U+F900–U+FAFF and U+2F800–U+2FAFF untouched.
+func decomposeName(name string) string {
+	var sb strings.Builder
+	start := 0
+	for i, r := range name {
+		if (r >= 0x2000 && r <= 0x2fff) || (r >= 0xf900 && r <= 0xfaff) || (r >= 0x2f800 && r <= 0x2faff) {
+			sb.WriteString(norm.NFD.String(name[start:i]))
+			sb.WriteRune(r)
+			start = i + len(string(r))
+		}
+	}
+	sb.WriteString(norm.NFD.String(name[start:]))
+	return sb.String()
+}
+
+// encodeName returns the on-disk UTF-16 form of a POSIX file name, with ':'
+// swapped for '/' as HFS+ expects.
+func encodeName(name string) []uint16 {
+	return utf16.Encode([]rune(strings.ReplaceAll(decomposeName(name), ":", "/")))
+}
+
+// putUnicode appends a HFSUniStr255 (length followed by big-endian code
+// units) to b.
+func putUnicode(b []byte, s []uint16) []byte {
+	b = binary.BigEndian.AppendUint16(b, uint16(len(s)))
+	for _, c := range s {
+		b = binary.BigEndian.AppendUint16(b, c)
+	}
+	return b
+}
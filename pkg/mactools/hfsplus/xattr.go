@@ -0,0 +1,172 @@
+package hfsplus
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+const (
+	attrInlineData = 0x10
+	attrForkData   = 0x20
+
+	decmpfsMagic      = 0x636d7066 // "fpmc" read little-endian
+	decmpfsAttrName   = "com.apple.decmpfs"
+	decmpfsHeaderSize = 16
+	decmpfsBlockSize  = 64 * 1024
+)
+
+// ExtendedAttributes returns the extended attributes stored for e in the
+// attributes file.
+func (v *Volume) ExtendedAttributes(e *Entry) (map[string][]byte, error) {
+	attrs := map[string][]byte{}
+	if v.attrs == nil {
+		return attrs, nil
+	}
+	id := e.dataForkID()
+	// The attributes file is keyed by file ID, so start at the first leaf
+	// that may hold the entry and stop once past it.
+	start, err := v.attrs.findLeaf(func(key []byte) bool {
+		return len(key) >= 6 && binary.BigEndian.Uint32(key[2:]) < id
+	})
+	if err != nil {
+		return nil, err
+	}
+	done := errors.New("done")
+	err = v.attrs.forEachLeafRecordFrom(start, func(rec []byte) error {
+		key, data, err := splitRecord(rec)
+		if err != nil {
+			return err
+		}
+		if len(key) < 12 || len(data) < 4 {
+			return errors.New("truncated attribute record")
+		}
+		fileID := binary.BigEndian.Uint32(key[2:])
+		if fileID < id {
+			return nil
+		}
+		if fileID > id {
+			return done
+		}
+		nameLen := int(binary.BigEndian.Uint16(key[10:]))
+		if 12+nameLen*2 > len(key) {
+			return errors.New("attribute name exceeds key")
+		}
+		name := decodeUnicode(key[12 : 12+nameLen*2])
+		switch binary.BigEndian.Uint32(data) {
+		case attrInlineData:
+			if len(data) < 16 {
+				return errors.New("truncated inline attribute")
+			}
+			size := int(binary.BigEndian.Uint32(data[12:]))
+			if 16+size > len(data) {
+				return fmt.Errorf("attribute %s exceeds record", name)
+			}
+			attrs[name] = bytes.Clone(data[16 : 16+size])
+		case attrForkData:
+			var fd ForkData
+			if err := binary.Read(bytes.NewReader(data[8:]), binary.BigEndian, &fd); err != nil {
+				return fmt.Errorf("attribute %s: %w", name, err)
+			}
+			value := make([]byte, fd.LogicalSize)
+			if _, err := v.fork(0, forkData, fd).ReadAt(value, 0); err != nil && err != io.EOF {
+				return fmt.Errorf("attribute %s: %w", name, err)
+			}
+			attrs[name] = value
+		}
+		return nil
+	})
+	if err != nil && err != done {
+		return nil, err
+	}
+	return attrs, nil
+}
+
+// openCompressed decodes a file stored with HFS+ (decmpfs) compression.
+func (v *Volume) openCompressed(e *Entry) (io.Reader, error) {
+	attrs, err := v.ExtendedAttributes(e)
+	if err != nil {
+		return nil, err
+	}
+	hdr := attrs[decmpfsAttrName]
+	if len(hdr) < decmpfsHeaderSize || binary.LittleEndian.Uint32(hdr) != decmpfsMagic {
+		return nil, fmt.Errorf("%s: missing or invalid %s attribute", e.Name, decmpfsAttrName)
+	}
+	kind := binary.LittleEndian.Uint32(hdr[4:])
+	size := int64(binary.LittleEndian.Uint64(hdr[8:]))
+	payload := hdr[decmpfsHeaderSize:]
+
+	var out []byte
+	switch kind {
+	case 1:
+		out = payload
+	case 3:
+		if out, err = decmpfsBlock(payload, size); err != nil {
+			return nil, fmt.Errorf("%s: %w", e.Name, err)
+		}
+	case 4:
+		rsrc, err := io.ReadAll(v.OpenResourceFork(e))
+		if err != nil {
+			return nil, err
+		}
+		if out, err = decmpfsResourceFork(rsrc, size); err != nil {
+			return nil, fmt.Errorf("%s: %w", e.Name, err)
+		}
+	default:
+		return nil, fmt.Errorf("%s: unsupported compression type %d", e.Name, kind)
+	}
+	if int64(len(out)) != size {
+		return nil, fmt.Errorf("%s: decompressed to %d bytes, expected %d", e.Name, len(out), size)
+	}
+	return bytes.NewReader(out), nil
+}
+
+// decmpfsBlock decodes one zlib block; a leading 0x?f byte marks data that
+// was stored uncompressed.
+func decmpfsBlock(b []byte, limit int64) ([]byte, error) {
+	if len(b) == 0 {
+		return nil, nil
+	}
+	if b[0]&0x0f == 0x0f {
+		return b[1:], nil
+	}
+	zr, err := zlib.NewReader(bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+	return io.ReadAll(io.LimitReader(zr, limit))
+}
+
+// decmpfsResourceFork decodes the block table stored in a resource fork.
+func decmpfsResourceFork(rsrc []byte, size int64) ([]byte, error) {
+	if len(rsrc) < 16 {
+		return nil, errors.New("resource fork too short")
+	}
+	dataOffset := int(binary.BigEndian.Uint32(rsrc))
+	table := dataOffset + 4
+	if table+4 > len(rsrc) {
+		return nil, errors.New("invalid resource fork data offset")
+	}
+	count := int(binary.LittleEndian.Uint32(rsrc[table:]))
+	if table+4+count*8 > len(rsrc) {
+		return nil, errors.New("compressed block table exceeds resource fork")
+	}
+	out := make([]byte, 0, size)
+	for i := 0; i < count; i++ {
+		off := table + int(binary.LittleEndian.Uint32(rsrc[table+4+i*8:]))
+		n := int(binary.LittleEndian.Uint32(rsrc[table+8+i*8:]))
+		if off+n > len(rsrc) {
+			return nil, errors.New("compressed block exceeds resource fork")
+		}
+		block, err := decmpfsBlock(rsrc[off:off+n], decmpfsBlockSize)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, block...)
+	}
+	return out, nil
+}
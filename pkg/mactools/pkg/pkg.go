@@ -20,7 +20,7 @@ type Config struct {
 func CreatePKG(config Config) error {
 	// 언어 코드 유효성 검사
 	for lang := range config.LicensePaths {
-		if !isValidLanguageCode(lang) {
+		if !IsValidLanguageCode(lang) {
 			return fmt.Errorf("invalid language code: %s", lang)
 		}
 	}
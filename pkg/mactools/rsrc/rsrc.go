@@ -0,0 +1,98 @@
+// Package rsrc is a package for writing classic Mac OS resource forks, as
+// stored in the com.apple.ResourceFork extended attribute.
+package rsrc
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sort"
+)
+
+const (
+	headerSize  = 256
+	mapHeader   = 28
+	typeEntry   = 8
+	refEntry    = 12
+	noName      = 0xFFFF
+	maxDataSize = 1<<24 - 1
+)
+
+// Resource is a single resource of a resource fork.
+type Resource struct {
+	Type string
+	ID   int16
+	// Name is optional and limited to 255 bytes.
+	Name string
+	Data []byte
+}
+
+// Encode returns a resource fork holding resources. Resources are grouped
+// by type in order of first appearance.
+func Encode(resources []Resource) ([]byte, error) {
+	var types []string
+	byType := map[string][]Resource{}
+	for _, r := range resources {
+		if len(r.Type) != 4 {
+			return nil, fmt.Errorf("invalid resource type %q", r.Type)
+		}
+		if len(r.Name) > 255 {
+			return nil, errors.New("resource name too long")
+		}
+		if _, ok := byType[r.Type]; !ok {
+			types = append(types, r.Type)
+		}
+		byType[r.Type] = append(byType[r.Type], r)
+	}
+
+	var data, names []byte
+	typeList := binary.BigEndian.AppendUint16(nil, uint16(len(types)-1))
+	var refList []byte
+	refStart := 2 + typeEntry*len(types)
+	for _, typ := range types {
+		refs := byType[typ]
+		sort.SliceStable(refs, func(i, j int) bool { return refs[i].ID < refs[j].ID })
+		typeList = append(typeList, typ...)
+		typeList = binary.BigEndian.AppendUint16(typeList, uint16(len(refs)-1))
+		typeList = binary.BigEndian.AppendUint16(typeList, uint16(refStart+len(refList)))
+		for _, r := range refs {
+			if len(data) > maxDataSize {
+				return nil, errors.New("resource data too large")
+			}
+			nameOffset := uint16(noName)
+			if r.Name != "" {
+				nameOffset = uint16(len(names))
+				names = append(names, byte(len(r.Name)))
+				names = append(names, r.Name...)
+			}
+			refList = binary.BigEndian.AppendUint16(refList, uint16(r.ID))
+			refList = binary.BigEndian.AppendUint16(refList, nameOffset)
+			// One byte of attributes followed by the 3-byte data offset.
+			refList = binary.BigEndian.AppendUint32(refList, uint32(len(data)))
+			refList = binary.BigEndian.AppendUint32(refList, 0) // handle
+			data = binary.BigEndian.AppendUint32(data, uint32(len(r.Data)))
+			data = append(data, r.Data...)
+		}
+	}
+
+	mapOffset := headerSize + len(data)
+	mapLength := mapHeader + len(typeList) + len(refList) + len(names)
+	header := make([]byte, 16)
+	binary.BigEndian.PutUint32(header[0:], headerSize)
+	binary.BigEndian.PutUint32(header[4:], uint32(mapOffset))
+	binary.BigEndian.PutUint32(header[8:], uint32(len(data)))
+	binary.BigEndian.PutUint32(header[12:], uint32(mapLength))
+
+	buf := make([]byte, headerSize, mapOffset+mapLength)
+	copy(buf, header)
+	buf = append(buf, data...)
+	// The map starts with a copy of the header, the next map handle, the
+	// file reference number and the fork attributes.
+	buf = append(buf, header...)
+	buf = append(buf, make([]byte, 8)...)
+	buf = binary.BigEndian.AppendUint16(buf, mapHeader)
+	buf = binary.BigEndian.AppendUint16(buf, uint16(mapHeader+len(typeList)+len(refList)))
+	buf = append(buf, typeList...)
+	buf = append(buf, refList...)
+	return append(buf, names...), nil
+}
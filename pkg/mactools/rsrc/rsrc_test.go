@@ -0,0 +1,59 @@
+package rsrc
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+func TestEncode(t *testing.T) {
+	fork, err := Encode([]Resource{
+		{Type: "icns", ID: -16455, Data: []byte("icon")},
+		{Type: "alis", ID: 0, Name: "Applications", Data: []byte("alias record")},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	be := binary.BigEndian
+	dataOffset, mapOffset := be.Uint32(fork), be.Uint32(fork[4:])
+	if dataOffset != 256 || int(mapOffset+be.Uint32(fork[12:])) != len(fork) {
+		t.Fatalf("bad header % x", fork[:16])
+	}
+	m := fork[mapOffset:]
+	if !bytes.Equal(m[:16], fork[:16]) {
+		t.Error("map does not start with a copy of the header")
+	}
+	typeList := m[be.Uint16(m[24:]):]
+	nameList := m[be.Uint16(m[26:]):]
+	if count := be.Uint16(typeList) + 1; count != 2 {
+		t.Fatalf("%d types, want 2", count)
+	}
+
+	found := map[string]string{}
+	for i := 0; i < 2; i++ {
+		entry := typeList[2+8*i:]
+		typ := string(entry[:4])
+		ref := typeList[be.Uint16(entry[6:]):]
+		if be.Uint16(entry[4:]) != 0 {
+			t.Errorf("%s: more than one resource", typ)
+		}
+		offset := dataOffset + be.Uint32(ref[4:])&0xFFFFFF
+		size := be.Uint32(fork[offset:])
+		found[typ] = string(fork[offset+4 : offset+4+size])
+		if typ == "alis" {
+			name := nameList[be.Uint16(ref[2:]):]
+			if got := string(name[1 : 1+name[0]]); got != "Applications" {
+				t.Errorf("name = %q", got)
+			}
+		} else if int16(be.Uint16(ref)) != -16455 || be.Uint16(ref[2:]) != noName {
+			t.Errorf("icns reference % x", ref[:12])
+		}
+	}
+	if found["icns"] != "icon" || found["alis"] != "alias record" {
+		t.Errorf("resources = %q", found)
+	}
+
+	if _, err := Encode([]Resource{{Type: "bad"}}); err == nil {
+		t.Error("Encode accepted a 3-character type")
+	}
+}